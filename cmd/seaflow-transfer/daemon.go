@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/armbrustlab/seaflow-transfer/fs"
+)
+
+// requestTimeout limits how long a -connect client has to send its request
+const requestTimeout = 10 * time.Second
+
+// daemonRequest is sent by a -connect client to ask a -daemon process for a
+// run. Runs use the daemon's options.
+type daemonRequest struct {
+	Version string `json:"version"`
+}
+
+// daemonMessage is one line of JSON sent by a -daemon process to a -connect
+// client. Log and Out are log and other output of the run. The last message
+// has Exit set to the run's exit status and Error to its error, if any.
+type daemonMessage struct {
+	Log   string `json:"log,omitempty"`
+	Out   string `json:"out,omitempty"`
+	Exit  *int   `json:"exit,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// messageWriter sends each write to a -connect client as a daemonMessage.
+// Writes after the client has gone are discarded.
+type messageWriter struct {
+	mu  *sync.Mutex
+	enc *json.Encoder
+	out bool // send as Out rather than Log
+}
+
+func (w messageWriter) Write(p []byte) (int, error) {
+	msg := daemonMessage{Log: string(p)}
+	if w.out {
+		msg = daemonMessage{Out: string(p)}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.enc.Encode(msg)
+	return len(p), nil
+}
+
+// server holds the connections shared by a -daemon process's runs
+type server struct {
+	mu     sync.Mutex // serializes runs and guards shared
+	shared *fs.Transfer
+	reload int32 // 1 if credentials should be reloaded before the next run
+	logger fs.Logger
+}
+
+// serve listens for -connect requests on Unix socket path and runs a transfer
+// for each with the source and destination connections of shared, one at a
+// time, until ctx is done. Each run's logs go to the client and logger's
+// output. On SIGHUP, SSH credentials are reloaded and new connections made
+// before the next run, leaving any run in progress on the old connections.
+// shared, or the Transfer which replaced it, is closed when serve returns.
+func serve(ctx context.Context, path string, shared *fs.Transfer, logger fs.Logger) (err error) {
+	s := &server{shared: shared, logger: logger}
+	defer func() {
+		// Runs have finished once serve returns
+		if closeErr := s.shared.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	l, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	logger.Info("listening for runs", "path", path)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				atomic.StoreInt32(&s.reload, 1)
+				logger.Info("received SIGHUP, credentials will be reloaded before the next run")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not accept connection on %v: %w", path, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			s.handleRun(ctx, conn)
+		}()
+	}
+}
+
+// reconnect reloads SSH credentials and replaces the shared connections with
+// new ones using them. s.mu must be held, so no run is using the old
+// connections. If either step fails the old connections are kept, and the
+// reload is tried again before the next run.
+func (s *server) reconnect(logger fs.Logger) {
+	if err := reloadCredentials(); err != nil {
+		atomic.StoreInt32(&s.reload, 1)
+		logger.Error("could not reload credentials, keeping current connections", "error", err)
+		return
+	}
+	opts := transferOptions(s.logger, t0)
+	opts.Src, opts.Dst = sftpConfigs()
+	if opts.Src == nil && opts.Dst == nil {
+		logger.Info("no SFTP connections to reload")
+		return
+	}
+	t, err := fs.NewTransfer(opts)
+	if err != nil {
+		atomic.StoreInt32(&s.reload, 1)
+		logger.Error("could not connect with reloaded credentials, keeping current connections", "error", err)
+		return
+	}
+	if err := s.shared.Close(); err != nil {
+		logger.Error("warning: could not close old connections", "error", err)
+	}
+	s.shared = t
+	for _, cfg := range []*fs.SftpConfig{opts.Src, opts.Dst} {
+		if cfg != nil {
+			logger.Info("reconnected with reloaded credentials", "addr", cfg.Addr, "user", cfg.User)
+		}
+	}
+}
+
+// listenUnix listens on Unix socket path, which is only accessible by the
+// current user. A socket left behind by a daemon which didn't shut down
+// cleanly is replaced, but not one which is still in use.
+func listenUnix(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is listening on %v", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket %v: %w", path, err)
+		}
+		l, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %v: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not set permissions of %v: %w", path, err)
+	}
+	return l, nil
+}
+
+// handleRun reads a request from conn, waits for any other run to finish,
+// and runs a transfer, streaming its output to conn. The run is cancelled if
+// the client disconnects.
+func (s *server) handleRun(ctx context.Context, conn net.Conn) {
+	logger := s.logger
+	var req daemonRequest
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&req); err != nil {
+		logger.Error("could not read run request", "error", err)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if req.Version != versionStr {
+		logger.Error("warning: client version differs from daemon", "client", req.Version, "daemon", versionStr)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// Clients send nothing more, so a read returns when they disconnect
+		_, _ = io.Copy(ioutil.Discard, conn)
+		cancel()
+	}()
+
+	var encMu sync.Mutex
+	enc := json.NewEncoder(conn)
+	runLogger := newLogger(io.MultiWriter(os.Stderr, messageWriter{mu: &encMu, enc: enc}))
+	out := messageWriter{mu: &encMu, enc: enc, out: true}
+
+	runLogger.Info("received run request")
+	s.mu.Lock()
+	if atomic.SwapInt32(&s.reload, 0) == 1 {
+		s.reconnect(runLogger)
+	}
+	code, err := daemonRun(ctx, s.shared, runLogger, out)
+	s.mu.Unlock()
+
+	msg := daemonMessage{Exit: &code}
+	if err != nil {
+		msg.Error = err.Error()
+		logger.Error("run failed", "status", code, "error", err)
+	}
+	encMu.Lock()
+	defer encMu.Unlock()
+	_ = enc.Encode(msg)
+}
+
+// daemonRun runs a transfer as if from the command line, with the source and
+// destination connections of shared
+func daemonRun(ctx context.Context, shared *fs.Transfer, logger fs.Logger, out io.Writer) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return exitError, err // client left while waiting
+	}
+	runStart := time.Now()
+	since, err := earliest()
+	if err != nil {
+		return exitConfig, err
+	}
+	opts := transferOptions(logger, since)
+	opts.Srcfs = shared.Srcfs
+	opts.Dstfs = shared.Dstfs
+	t, err := fs.NewTransfer(opts)
+	if err != nil {
+		return exitConfig, err
+	}
+	// t isn't closed, its connections are reused by later runs
+	configure(t, logger)
+	return run(ctx, t, logger, out, runStart)
+}
+
+// runClient asks the -daemon process listening on Unix socket path for a run,
+// writing its output to stdout and its logs to stderr. It returns the run's
+// exit status and error.
+func runClient(path string, stdout, stderr io.Writer) (int, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return exitConfig, fmt.Errorf("could not connect to daemon: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(daemonRequest{Version: versionStr}); err != nil {
+		return exitConfig, fmt.Errorf("could not send run request: %w", err)
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var msg daemonMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("daemon closed the connection before the run finished")
+			}
+			return exitError, err
+		}
+		_, _ = io.WriteString(stderr, msg.Log)
+		_, _ = io.WriteString(stdout, msg.Out)
+		if msg.Exit != nil {
+			if msg.Error != "" {
+				return *msg.Exit, errors.New(msg.Error)
+			}
+			return *msg.Exit, nil
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/armbrustlab/seaflow-transfer/fs"
+	"github.com/stretchr/testify/assert"
+)
+
+// evtFiles are the source files of daemon tests
+var evtFiles = []string{
+	filepath.Join("2016_133", "2016-05-12T17-00-02+00-00"),
+	filepath.Join("2016_133", "2016-05-12T17-03-02+00-00"),
+	filepath.Join("2016_133", "2016-05-12T17-06-02+00-00"),
+}
+
+// setupDaemon creates a temp directory with source EVT files and parses
+// daemon options with args appended. It returns the directory and the
+// daemon's socket path.
+func setupDaemon(t *testing.T, args ...string) (string, string) {
+	dir, err := ioutil.TempDir("", "seaflow-transfer-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "src")
+	for _, path := range evtFiles {
+		path = filepath.Join(src, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sock := filepath.Join(dir, "daemon.sock")
+	saved := os.Args
+	defer func() { os.Args = saved }()
+	os.Args = append([]string{
+		cmdname, "-srcRoot", src, "-dstRoot", filepath.Join(dir, "dst"),
+		"-daemon", sock, "-onlyEvt", "-includeLatest",
+	}, args...)
+	parseOptions()
+	return dir, sock
+}
+
+// startDaemon serves runs on socket path until the returned function is
+// called, which returns serve's error
+func startDaemon(t *testing.T, path string) func() error {
+	logger := newLogger(ioutil.Discard)
+	shared, err := fs.NewTransfer(transferOptions(logger, t0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(ctx, path, shared, logger)
+	}()
+	// A stale socket may exist before the daemon is listening
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			break
+		}
+		select {
+		case err := <-errc:
+			cancel()
+			t.Fatalf("daemon stopped: %v", err)
+		default:
+		}
+		if time.Since(start) > 5*time.Second {
+			cancel()
+			t.Fatal("daemon didn't start listening")
+		}
+	}
+	return func() error {
+		cancel()
+		return <-errc
+	}
+}
+
+func TestDaemonRun(t *testing.T) {
+	assert := assert.New(t)
+	dir, sock := setupDaemon(t)
+	defer os.RemoveAll(dir)
+	stop := startDaemon(t, sock)
+
+	var stdout, stderr bytes.Buffer
+	code, err := runClient(sock, &stdout, &stderr)
+	assert.Nil(err)
+	assert.Equal(exitOK, code)
+	copied, _ := filepath.Glob(filepath.Join(dir, "dst", "2016_133", "*"))
+	assert.Len(copied, len(evtFiles), "run copied files")
+
+	info, err := os.Stat(sock)
+	if assert.Nil(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm(), "socket only accessible by current user")
+	}
+	_, err = listenUnix(sock)
+	assert.NotNil(err, "second daemon on the same socket refused")
+
+	assert.Nil(stop())
+	code, err = runClient(sock, &stdout, &stderr)
+	assert.NotNil(err, "no daemon listening")
+	assert.Equal(exitConfig, code)
+}
+
+func TestDaemonSerializesRuns(t *testing.T) {
+	assert := assert.New(t)
+	hookDir, err := ioutil.TempDir("", "seaflow-transfer-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hookDir)
+	// The hook fails if another copy's hook is running, stopping the run
+	lock := filepath.Join(hookDir, "lock")
+	hooks := filepath.Join(hookDir, "hooks")
+	hook := fmt.Sprintf("mkdir %[1]v || exit 1; echo >> %[2]v; sleep 0.05; rmdir %[1]v; true", lock, hooks)
+	dir, sock := setupDaemon(t, "-force", "-workers", "1", "-postHook", hook, "-hookFatal")
+	defer os.RemoveAll(dir)
+	stop := startDaemon(t, sock)
+
+	runs := 4
+	codes := make([]int, runs)
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i], errs[i] = runClient(sock, ioutil.Discard, ioutil.Discard)
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < runs; i++ {
+		assert.Nil(errs[i], "run %v", i)
+		assert.Equal(exitOK, codes[i], "run %v", i)
+	}
+	b, err := ioutil.ReadFile(hooks)
+	assert.Nil(err)
+	assert.Equal(runs*len(evtFiles), strings.Count(string(b), "\n"), "every run copied every file")
+	assert.Nil(stop())
+}
+
+func TestDaemonRestart(t *testing.T) {
+	assert := assert.New(t)
+	dir, sock := setupDaemon(t)
+	defer os.RemoveAll(dir)
+	stop := startDaemon(t, sock)
+	code, err := runClient(sock, ioutil.Discard, ioutil.Discard)
+	assert.Nil(err)
+	assert.Equal(exitOK, code)
+	assert.Nil(stop())
+
+	stop = startDaemon(t, sock)
+	code, err = runClient(sock, ioutil.Discard, ioutil.Discard)
+	assert.Nil(err, "client reconnects to restarted daemon")
+	assert.Equal(exitOK, code)
+	assert.Nil(stop())
+
+	// Leave a socket behind as a daemon which was killed would
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	_, err = os.Stat(sock)
+	assert.Nil(err, "stale socket left")
+	stop = startDaemon(t, sock)
+	code, err = runClient(sock, ioutil.Discard, ioutil.Discard)
+	assert.Nil(err, "stale socket replaced")
+	assert.Equal(exitOK, code)
+	assert.Nil(stop())
+}
+
+func TestDaemonReload(t *testing.T) {
+	assert := assert.New(t)
+	dir, sock := setupDaemon(t)
+	defer os.RemoveAll(dir)
+	stop := startDaemon(t, sock)
+	// serve handles SIGHUP once it's accepting runs
+	code, err := runClient(sock, ioutil.Discard, ioutil.Discard)
+	assert.Nil(err)
+	assert.Equal(exitOK, code)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	// SIGHUP is handled asynchronously, so the next run may not reload
+	reloaded := false
+	for i := 0; i < 50 && !reloaded; i++ {
+		var stderr bytes.Buffer
+		code, err := runClient(sock, ioutil.Discard, &stderr)
+		assert.Nil(err)
+		assert.Equal(exitOK, code)
+		reloaded = strings.Contains(stderr.String(), "no SFTP connections to reload")
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(reloaded, "credentials reloaded before a run after SIGHUP")
+	assert.Nil(stop())
+}
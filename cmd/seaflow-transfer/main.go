@@ -1,198 +1,2524 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/armbrustlab/seaflow-transfer/internal/fs"
+	"github.com/BurntSushi/toml"
+	"github.com/armbrustlab/seaflow-transfer/fs"
 	"golang.org/x/term"
 )
 
 const versionStr string = "v0.4.1"
 
 var (
-	srcRoot      string // SRCROOT
-	dstRoot      string // DSTROOT
-	srcAddress   string // SRCADDRESS
-	dstAddress   string // DSTADDRESS
-	sshPort      string // SSHPORT
-	sshUser      string // SSHUSER
-	sshPassword  string // SSHPASSWORD
-	sshPublicKey string // SSHPUBLICKEY
-	quiet        bool   // QUIET
-	start        string // START
-	verbose      bool   // VERBOSE
-	version      bool   // VERSION
+	config            string        // CONFIG
+	srcRoot           string        // SRCROOT
+	dstRoot           string        // DSTROOT
+	sflDstRoot        string        // SFLDSTROOT
+	evtDstRoot        string        // EVTDSTROOT
+	srcAddress        string        // SRCADDRESS
+	dstAddress        string        // DSTADDRESS
+	sshPort           string        // SSHPORT
+	sshUser           string        // SSHUSER
+	sshPassword       string        // SSHPASSWORD
+	sshPasswordFile   string        // SSHPASSWORDFILE
+	sshPublicKey      string        // SSHPUBLICKEY
+	srcSshPort        string        // SRCSSHPORT
+	srcSshUser        string        // SRCSSHUSER
+	srcSshPassword    string        // SRCSSHPASSWORD
+	srcSshPublicKey   string        // SRCSSHPUBLICKEY
+	dstSshPort        string        // DSTSSHPORT
+	dstSshUser        string        // DSTSSHUSER
+	dstSshPassword    string        // DSTSSHPASSWORD
+	dstSshPublicKey   string        // DSTSSHPUBLICKEY
+	jumpHost          string        // JUMPHOST
+	jumpUser          string        // JUMPUSER
+	jumpPassword      string        // JUMPPASSWORD
+	jumpKey           string        // JUMPKEY
+	knownHosts        string        // KNOWNHOSTS
+	hostKeyAlgorithms string        // HOSTKEYALGORITHMS
+	sshTimeout        time.Duration // SSHTIMEOUT
+	sshKeepalive      time.Duration // SSHKEEPALIVE
+	autoReconnect     bool          // AUTORECONNECT
+	sftpConcurrency   int           // SFTPCONCURRENCY
+	sftpPacketSize    int           // SFTPPACKETSIZE
+	dryRun            bool          // DRYRUN
+	list              bool          // LIST
+	listDays          bool          // LISTDAYS
+	probeCompression  int           // PROBECOMPRESSION
+	daemon            string        // DAEMON
+	connect           string        // CONNECT
+	allowMissingSrc   bool          // ALLOWMISSINGSRC
+	check             bool          // CHECK
+	selfTest          bool          // SELFTEST
+	verifyOnly        bool          // VERIFYONLY
+	repair            bool          // REPAIR
+	checkManifest     string        // CHECKMANIFEST
+	statusAddr        string        // STATUSADDR
+	checksumCache     string        // CHECKSUMCACHE
+	compressExisting  bool          // COMPRESSEXISTING
+	sflPattern        string        // SFLPATTERN
+	evtPattern        string        // EVTPATTERN
+	extraPatterns     string        // EXTRAPATTERNS
+	doy               string        // DOY
+	recentDays        int           // RECENTDAYS
+	followSymlinks    bool          // FOLLOWSYMLINKS
+	onlySfl           bool          // ONLYSFL
+	onlyEvt           bool          // ONLYEVT
+	opp               bool          // OPP
+	vct               bool          // VCT
+	move              bool          // MOVE
+	minAge            time.Duration // MINAGE
+	includeLatest     bool          // INCLUDELATEST
+	latestFromLastDir bool          // LATESTFROMLASTDIR
+	include           string        // INCLUDE
+	exclude           string        // EXCLUDE
+	keepGoing         bool          // KEEPGOING
+	force             bool          // FORCE
+	syncDeletes       bool          // SYNC
+	confirmDelete     bool          // CONFIRMDELETE
+	copyEmpty         bool          // COPYEMPTY
+	maxFileSize       string        // MAXFILESIZE
+	headBytes         string        // HEADBYTES
+	headSuffix        string        // HEADSUFFIX
+	checkGzip         bool          // CHECKGZIP
+	checkGzipName     bool          // CHECKGZIPNAME
+	fixGzipName       bool          // FIXGZIPNAME
+	validateSFL       bool          // VALIDATESFL
+	requireSflRecord  bool          // REQUIRESFLRECORD
+	decompress        bool          // DECOMPRESS
+	preserveTree      bool          // PRESERVETREE
+	flatten           bool          // FLATTEN
+	normalizeNames    bool          // NORMALIZENAMES
+	tzSeparator       string        // TZSEPARATOR
+	tempDir           string        // TEMPDIR
+	requireAtomic     bool          // REQUIREATOMIC
+	noClobber         bool          // NOCLOBBER
+	noDowngrade       bool          // NODOWNGRADE
+	fsync             bool          // FSYNC
+	dirMode           string        // DIRMODE
+	tempPrefix        string        // TEMPPREFIX
+	skipUnchanged     bool          // SKIPUNCHANGED
+	refreshStale      bool          // REFRESHSTALE
+	refreshGzipMtime  bool          // REFRESHGZIPMTIME
+	mtimeTolerance    time.Duration // MTIMETOLERANCE
+	gzipSFL           bool          // GZIPSFL
+	compressThreshold string        // COMPRESSTHRESHOLD
+	gzipLevel         int           // GZIPLEVEL
+	pgzip             bool          // PGZIP
+	pgzipSize         string        // PGZIPSIZE
+	gzipFlushInterval string        // GZIPFLUSHINTERVAL
+	noTempFile        bool          // NOTEMPFILE
+	appendSFL         bool          // APPENDSFL
+	rateLimit         string        // RATELIMIT
+	minFreeSpace      string        // MINFREESPACE
+	bufferSize        string        // BUFFERSIZE
+	summaryJSON       string        // SUMMARYJSON
+	destLog           bool          // DESTLOG
+	metricsFile       string        // METRICSFILE
+	pushgateway       string        // PUSHGATEWAY
+	postHook          string        // POSTHOOK
+	hookFatal         bool          // HOOKFATAL
+	manifest          string        // MANIFEST
+	manifestAlgo      string        // MANIFESTALGO
+	maxRetries        int           // MAXRETRIES
+	workers           int           // WORKERS
+	srcWorkers        int           // SRCWORKERS
+	dstWorkers        int           // DSTWORKERS
+	maxFiles          int           // MAXFILES
+	order             string        // ORDER
+	minExpected       int           // MINEXPECTED
+	retryDelay        time.Duration // RETRYDELAY
+	fileTimeout       time.Duration // FILETIMEOUT
+	stallTimeout      time.Duration // STALLTIMEOUT
+	globTimeout       time.Duration // GLOBTIMEOUT
+	totalTimeout      time.Duration // TOTALTIMEOUT
+	quiet             bool          // QUIET
+	quietSummary      bool          // QUIETSUMMARY
+	logFormat         string        // LOGFORMAT
+	start             string        // START
+	within            time.Duration // WITHIN
+	sinceFile         string        // SINCEFILE
+	stateFile         string        // STATEFILE
+	reportNewest      bool          // REPORTNEWEST
+	newestFile        string        // NEWESTFILE
+	resume            bool          // RESUME
+	end               string        // END
+	verbose           bool          // VERBOSE
+	verify            bool          // VERIFY
+	version           bool          // VERSION
 )
 var t0 time.Time
+
+// cliOptions are the names of options set on the command line
+var cliOptions = make(map[string]bool)
+var t1 time.Time
+var rateLimitBytes int64
+var minFreeSpaceBytes int64
+var compressThresholdBytes int64
+var maxFileSizeBytes int64
+var headBytesCount int64
+var pgzipSizeBytes int64
+var gzipFlushIntervalBytes int64
+var bufferSizeBytes int64
+var dirModeBits os.FileMode
+var days []string
+var srcRoots []string
+var includeRe *regexp.Regexp
+var excludeRe *regexp.Regexp
+var copyOrder fs.Order
 var cmdname string = "seaflow-transfer"
 
-func init() {
+// maxBufferSize caps -bufferSize. Three buffers of this size are allocated
+// for each concurrent copy.
+const maxBufferSize = 256 * 1000 * 1000
+
+// Exit codes
+const (
+	exitOK          = 0
+	exitError       = 1 // transfer stopped by an error
+	exitConfig      = 2 // bad configuration or connection failure, nothing copied
+	exitFilesFailed = 3 // transfer completed but some files failed
+	exitMismatch    = 4 // -verifyOnly or -checkManifest found mismatched or missing files, or -repair couldn't repair some
+	exitTooFew      = 5 // transfer succeeded but copied fewer than -minExpected files
+)
+
+// fatal logs v and exits with code
+func fatal(code int, v ...interface{}) {
+	log.Print(v...)
+	os.Exit(code)
+}
+
+// fatalf logs a formatted message and exits with code
+func fatalf(code int, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
+
+// parseOptions reads options from the command line, -config file, and ENV,
+// and exits if any are invalid
+func parseOptions() {
 	initFlags()
 	initEnvVars()
 	if version {
 		fmt.Printf("%v\n", versionStr)
 		os.Exit(0)
 	}
-	if sshPassword == "" && (srcAddress != "" || dstAddress != "") {
-		fmt.Printf("enter SSH password: ")
-		b, err := term.ReadPassword(syscall.Stdin)
+	if connect != "" {
+		if daemon != "" {
+			fatalf(exitConfig, "-daemon and -connect can't be used together")
+		}
+		return // all other options are the daemon's
+	}
+	if daemon != "" {
+		// Connections are held open between runs, so replace dropped ones
+		autoReconnect = true
+	}
+	initCredentials()
+	if err := checkAddresses(); err != nil {
+		fatal(exitConfig, err)
+	}
+	var err error
+	if within < 0 {
+		fatalf(exitConfig, "-within must not be negative")
+	}
+	cutoffs := 0
+	for _, set := range []bool{start != "", within > 0, sinceFile != ""} {
+		if set {
+			cutoffs++
+		}
+	}
+	if cutoffs > 1 {
+		fatalf(exitConfig, "only one of -start, -within, and -sinceFile can be used")
+	}
+	if resume && stateFile == "" {
+		fatalf(exitConfig, "-resume requires -stateFile")
+	}
+	t0, err = earliest()
+	if err != nil {
+		fatal(exitConfig, err)
+	}
+	if end != "" {
+		t1, err = time.Parse(time.RFC3339, end)
 		if err != nil {
-			log.Fatal(err)
+			fatalf(exitConfig, "could not parse -end RFC3339 timestamp: %v", err)
 		}
-		sshPassword = string(b)
 	}
-	if start != "" {
+	if logFormat != "text" && logFormat != "json" {
+		fatalf(exitConfig, "-logFormat must be text or json")
+	}
+	if !validManifestAlgo(manifestAlgo) {
+		fatalf(exitConfig, "-manifestAlgo must be one of %v", strings.Join(fs.ManifestAlgos(), ", "))
+	}
+	if maxRetries < 0 {
+		fatalf(exitConfig, "-maxRetries must not be negative")
+	}
+	if workers < 1 {
+		fatalf(exitConfig, "-workers must be at least 1")
+	}
+	if srcWorkers < 0 || dstWorkers < 0 {
+		fatalf(exitConfig, "-srcWorkers and -dstWorkers must not be negative")
+	}
+	if verifyOnly && checkManifest != "" {
+		fatalf(exitConfig, "-verifyOnly and -checkManifest can't be used together")
+	}
+	if repair && (verifyOnly || checkManifest != "" || dryRun) {
+		fatalf(exitConfig, "-repair can't be used with -verifyOnly, -checkManifest, or -dryRun")
+	}
+	if compressExisting && decompress {
+		fatalf(exitConfig, "-compressExisting and -decompress can't be used together")
+	}
+	if appendSFL && gzipSFL {
+		fatalf(exitConfig, "-appendSFL and -gzipSFL can't be used together")
+	}
+	if appendSFL && noClobber {
+		fatalf(exitConfig, "-appendSFL and -noClobber can't be used together")
+	}
+	if syncDeletes && move {
+		fatalf(exitConfig, "-sync and -move can't be used together, every moved file would be deleted from the destination")
+	}
+	if flatten && preserveTree {
+		fatalf(exitConfig, "-flatten and -preserveTree can't be used together")
+	}
+	if tzSeparator != "+" && tzSeparator != "-" {
+		fatalf(exitConfig, "-tzSeparator must be + or -")
+	}
+	if onlySfl && onlyEvt {
+		fatalf(exitConfig, "-onlySfl and -onlyEvt can't be used together")
+	}
+	if (onlySfl || onlyEvt) && (opp || vct || extraPatterns != "") {
+		fatalf(exitConfig, "-onlySfl and -onlyEvt can't be used with -opp, -vct, or -extraPatterns")
+	}
+	if maxFiles < 0 {
+		fatalf(exitConfig, "-maxFiles must not be negative")
+	}
+	copyOrder, err = fs.ParseOrder(order)
+	if err != nil {
+		fatalf(exitConfig, "could not parse -order: %v", err)
+	}
+	if gzipLevel < 1 || gzipLevel > 9 {
+		fatalf(exitConfig, "-gzipLevel must be from 1 to 9")
+	}
+	if probeCompression < 0 {
+		fatalf(exitConfig, "-probeCompression must not be negative")
+	}
+	if minExpected < 0 {
+		fatalf(exitConfig, "-minExpected must not be negative")
+	}
+	if sshTimeout <= 0 {
+		fatalf(exitConfig, "-sshTimeout must be positive")
+	}
+	if sshKeepalive < 0 {
+		fatalf(exitConfig, "-sshKeepalive must not be negative")
+	}
+	if sftpConcurrency < 0 {
+		fatalf(exitConfig, "-sftpConcurrency must not be negative")
+	}
+	if sftpPacketSize < 0 || sftpPacketSize > fs.MaxSftpPacketSize {
+		fatalf(exitConfig, "-sftpPacketSize must be from 0 to %v", fs.MaxSftpPacketSize)
+	}
+	if minAge < 0 {
+		fatalf(exitConfig, "-minAge must not be negative")
+	}
+	if include != "" {
+		includeRe, err = regexp.Compile(include)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -include regular expression: %v", err)
+		}
+	}
+	if exclude != "" {
+		excludeRe, err = regexp.Compile(exclude)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -exclude regular expression: %v", err)
+		}
+	}
+	if fileTimeout < 0 {
+		fatalf(exitConfig, "-fileTimeout must not be negative")
+	}
+	if stallTimeout < 0 {
+		fatalf(exitConfig, "-stallTimeout must not be negative")
+	}
+	if mtimeTolerance <= 0 {
+		fatalf(exitConfig, "-mtimeTolerance must be positive")
+	}
+	if globTimeout < 0 {
+		fatalf(exitConfig, "-globTimeout must not be negative")
+	}
+	if totalTimeout < 0 {
+		fatalf(exitConfig, "-totalTimeout must not be negative")
+	}
+	if tempPrefix == "" || strings.ContainsAny(tempPrefix, `/\`) {
+		fatalf(exitConfig, "-tempPrefix must be non-empty and not contain path separators")
+	}
+	for _, pattern := range splitList(extraPatterns) {
+		if strings.ContainsAny(pattern, `/\`) {
+			fatalf(exitConfig, "-extraPatterns must be filename patterns without path separators")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			fatalf(exitConfig, "invalid -extraPatterns pattern %q: %v", pattern, err)
+		}
+	}
+	if doy != "" {
+		days, err = parseDays(doy)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -doy: %v", err)
+		}
+	}
+	if recentDays < 0 {
+		fatalf(exitConfig, "-recentDays must not be negative")
+	}
+	srcRoots = splitList(srcRoot)
+	if len(srcRoots) == 0 {
+		srcRoots = []string{srcRoot}
+	}
+	if err := checkRoots(); err != nil {
+		fatal(exitConfig, err)
+	}
+	if rateLimit != "" {
+		rateLimitBytes, err = parseByteSize(rateLimit)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -rateLimit: %v", err)
+		}
+	}
+	if minFreeSpace != "" {
+		minFreeSpaceBytes, err = parseByteSize(minFreeSpace)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -minFreeSpace: %v", err)
+		}
+	}
+	if compressThreshold != "" {
+		compressThresholdBytes, err = parseByteSize(compressThreshold)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -compressThreshold: %v", err)
+		}
+	}
+	if maxFileSize != "" {
+		maxFileSizeBytes, err = parseByteSize(maxFileSize)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -maxFileSize: %v", err)
+		}
+	}
+	if headBytes != "" {
+		headBytesCount, err = parseByteSize(headBytes)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -headBytes: %v", err)
+		}
+		if headBytesCount > 0 && headSuffix == "" {
+			fatalf(exitConfig, "-headBytes requires a -headSuffix so partial files aren't mistaken for complete ones")
+		}
+		if headBytesCount > 0 && repair {
+			fatalf(exitConfig, "-headBytes and -repair can't be used together")
+		}
+		// Partial copies must not look like complete ones
+		if headBytesCount > 0 && (move || stateFile != "" || syncDeletes || destLog) {
+			fatalf(exitConfig, "-headBytes can't be used with -move, -stateFile, -sync, or -destLog, since only part of each file is copied")
+		}
+	}
+	if pgzipSize != "" {
+		pgzipSizeBytes, err = parseByteSize(pgzipSize)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -pgzipSize: %v", err)
+		}
+	}
+	if gzipFlushInterval != "" {
+		gzipFlushIntervalBytes, err = parseByteSize(gzipFlushInterval)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -gzipFlushInterval: %v", err)
+		}
+	}
+	if noTempFile && (tempDir != "" || requireAtomic) {
+		fatalf(exitConfig, "-noTempFile can't be used with -tempDir or -requireAtomic")
+	}
+	if noTempFile && noClobber {
+		fatalf(exitConfig, "-noTempFile and -noClobber can't be used together, files written in place can't be created atomically")
+	}
+	if dirMode != "" {
+		mode, err := strconv.ParseUint(dirMode, 8, 32)
+		if err != nil || mode == 0 || mode > 0777 {
+			fatalf(exitConfig, "-dirMode must be octal permissions from 1 to 0777, e.g. 0775")
+		}
+		dirModeBits = os.FileMode(mode)
+	}
+	if bufferSize != "" {
+		bufferSizeBytes, err = parseByteSize(bufferSize)
+		if err != nil {
+			fatalf(exitConfig, "could not parse -bufferSize: %v", err)
+		}
+		if bufferSizeBytes < 1 || bufferSizeBytes > maxBufferSize {
+			fatalf(exitConfig, "-bufferSize must be between 1B and %vMB", maxBufferSize/1000/1000)
+		}
+	}
+}
+
+// parseDays parses a comma-separated list of day-of-year directory names,
+// e.g. "2016_133", or inclusive ranges of them, e.g. "2016_133-2016_140".
+// Ranges may cross years.
+func parseDays(s string) ([]string, error) {
+	var days []string
+	for _, item := range splitList(s) {
+		parts := strings.SplitN(item, "-", 2)
+		first, err := parseDay(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(parts) == 2 {
+			if last, err = parseDay(parts[1]); err != nil {
+				return nil, err
+			}
+			if last.Before(first) {
+				return nil, fmt.Errorf("range %q ends before it starts", item)
+			}
+		}
+		for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+			days = append(days, fmt.Sprintf("%04d_%03d", d.Year(), d.YearDay()))
+		}
+	}
+	return days, nil
+}
+
+// parseDay parses a day-of-year directory name like "2016_133"
+func parseDay(s string) (time.Time, error) {
+	var year, yday int
+	if len(s) != 8 || s[4] != '_' {
+		return time.Time{}, fmt.Errorf("%q is not a day-of-year directory name like 2016_133", s)
+	}
+	year, err := strconv.Atoi(s[:4])
+	if err == nil {
+		yday, err = strconv.Atoi(s[5:])
+	}
+	if err != nil || year < 0 || yday < 1 || yday > 366 {
+		return time.Time{}, fmt.Errorf("%q is not a day-of-year directory name like 2016_133", s)
+	}
+	d := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, yday-1)
+	if d.Year() != year {
+		return time.Time{}, fmt.Errorf("%v has no day %v", year, yday)
+	}
+	return d, nil
+}
+
+// parseByteSize parses a byte count with an optional decimal unit suffix, e.g.
+// "500", "500B", "500KB", "2MB", "1GB". Suffixes are case-insensitive and the
+// trailing "B" may be omitted.
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1000 * 1000 * 1000},
+		{"MB", 1000 * 1000},
+		{"KB", 1000},
+		{"G", 1000 * 1000 * 1000},
+		{"M", 1000 * 1000},
+		{"K", 1000},
+		{"B", 1},
+	}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSuffix(upper, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("byte size %q must not be negative", s)
+	}
+	return n * mult, nil
+}
+
+// jumpConfig returns SFTP connection config for -jumpHost, or nil if not set
+func jumpConfig() *fs.SftpConfig {
+	if jumpHost == "" {
+		return nil
+	}
+	addr := jumpHost
+	if _, _, err := net.SplitHostPort(jumpHost); err != nil {
+		addr = fs.SftpAddr(jumpHost, sshPort)
+	}
+	return &fs.SftpConfig{
+		Addr:       addr,
+		User:       jumpUser,
+		Password:   jumpPassword,
+		PublicKeys: splitList(jumpKey),
+		KnownHosts: knownHosts,
+		Passphrase: keyPassphrase,
+		Timeout:    sshTimeout,
+
+		HostKeyAlgorithms: splitList(hostKeyAlgorithms),
+	}
+}
+
+// sftpConfigs returns SFTP connection configs for the source and destination,
+// nil for a local side
+func sftpConfigs() (src, dst *fs.SftpConfig) {
+	if srcAddress != "" && !destinationOnly() {
+		// No source connection is needed to check a manifest
+		src = sftpConfig(srcAddress, srcSshPort, srcSshUser, srcSshPassword, srcSshPublicKey)
+	}
+	if dstAddress != "" && !sourceOnly() {
+		// No destination connection is needed to list files or probe
+		// compression
+		dst = sftpConfig(dstAddress, dstSshPort, dstSshUser, dstSshPassword, dstSshPublicKey)
+	}
+	// Per-side passwords override the password file
+	if src != nil && src.Password == "" {
+		src.PasswordFile = sshPasswordFile
+	}
+	if dst != nil && dst.Password == "" {
+		dst.PasswordFile = sshPasswordFile
+	}
+	return src, dst
+}
+
+// sftpConfig returns SFTP connection config for one side of the transfer
+func sftpConfig(address, port, user, password, publicKey string) *fs.SftpConfig {
+	return &fs.SftpConfig{
+		Addr:       fs.SftpAddr(address, port),
+		User:       user,
+		Password:   password,
+		PublicKeys: splitList(publicKey),
+		KnownHosts: knownHosts,
+		Passphrase: keyPassphrase,
+		Timeout:    sshTimeout,
+		Keepalive:  sshKeepalive,
+		Reconnect:  autoReconnect,
+		Jump:       jumpConfig(),
+
+		Concurrency:       sftpConcurrency,
+		PacketSize:        sftpPacketSize,
+		HostKeyAlgorithms: splitList(hostKeyAlgorithms),
+	}
+}
+
+// defaultCredentials fills in per-side SSH options from the shared options.
+// -sshPasswordFile takes precedence over SSHPASSWORD.
+func defaultCredentials() {
+	if sshPasswordFile != "" {
+		sshPassword = ""
+	}
+	if srcSshPort == "" {
+		srcSshPort = sshPort
+	}
+	if srcSshUser == "" {
+		srcSshUser = sshUser
+	}
+	if srcSshPassword == "" {
+		srcSshPassword = sshPassword
+	}
+	if srcSshPublicKey == "" {
+		srcSshPublicKey = sshPublicKey
+	}
+	if dstSshPort == "" {
+		dstSshPort = sshPort
+	}
+	if dstSshUser == "" {
+		dstSshUser = sshUser
+	}
+	if dstSshPassword == "" {
+		dstSshPassword = sshPassword
+	}
+	if dstSshPublicKey == "" {
+		dstSshPublicKey = sshPublicKey
+	}
+
+	if jumpUser == "" {
+		jumpUser = sshUser
+	}
+	if jumpKey == "" {
+		jumpKey = sshPublicKey
+	}
+}
+
+// credentialOptions are the SSH password and private key options, by option
+// name, re-read by reloadCredentials. ENV names are uppercased option names.
+// sshPassword can only be set in ENV.
+var credentialOptions = []struct {
+	name string
+	val  *string
+}{
+	{"sshPassword", &sshPassword},
+	{"sshPasswordFile", &sshPasswordFile},
+	{"sshPublicKey", &sshPublicKey},
+	{"srcSshPassword", &srcSshPassword},
+	{"srcSshPublicKey", &srcSshPublicKey},
+	{"dstSshPassword", &dstSshPassword},
+	{"dstSshPublicKey", &dstSshPublicKey},
+	{"jumpPassword", &jumpPassword},
+	{"jumpKey", &jumpKey},
+}
+
+// reloadCredentials re-reads credentialOptions from the -config file and ENV,
+// which override command line values as at startup, for a -daemon reload.
+// Private key files themselves are read on each connection. There's no one
+// to prompt, so if an SFTP side would be left without a password or key, or
+// the config file can't be read, the current credentials are kept and an
+// error returned.
+func reloadCredentials() error {
+	values := make(map[string]interface{})
+	if config != "" {
+		if _, err := toml.DecodeFile(config, &values); err != nil {
+			return fmt.Errorf("could not load config: %w", err)
+		}
+	}
+	saved := make([]string, len(credentialOptions))
+	for i, opt := range credentialOptions {
+		saved[i] = *opt.val
+	}
+	for _, opt := range credentialOptions {
+		if !cliOptions[opt.name] {
+			*opt.val = ""
+			if v, ok := values[opt.name].(string); ok {
+				*opt.val = v
+			}
+		}
+		if val, ok := os.LookupEnv(strings.ToUpper(opt.name)); ok {
+			*opt.val = val
+		}
+	}
+	defaultCredentials()
+	var missing []string
+	if jumpHost != "" && jumpPassword == "" && jumpKey == "" {
+		missing = append(missing, "jump host")
+	}
+	if srcAddress != "" && srcSshPassword == "" && srcSshPublicKey == "" && sshPasswordFile == "" && !destinationOnly() {
+		missing = append(missing, "source")
+	}
+	if dstAddress != "" && dstSshPassword == "" && dstSshPublicKey == "" && sshPasswordFile == "" {
+		missing = append(missing, "destination")
+	}
+	if len(missing) > 0 {
+		for i, opt := range credentialOptions {
+			*opt.val = saved[i]
+		}
+		return fmt.Errorf("no SSH password or key for %v", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// initCredentials fills in per-side SSH options from the shared options and
+// prompts for any SFTP side which still lacks a password or public key. It
+// fails rather than prompting if stdin isn't a terminal.
+func initCredentials() {
+	defaultCredentials()
+
+	var err error
+	if jumpHost != "" && jumpPassword == "" && jumpKey == "" && ((srcAddress != "" && !destinationOnly()) || (dstAddress != "" && !sourceOnly())) {
+		jumpPassword, err = readPassword(fmt.Sprintf("enter SSH password for jump host %v@%v: ", jumpUser, jumpHost))
+		if err != nil {
+			fatalf(exitConfig, "no SSH password or key for jump host %v@%v, set JUMPPASSWORD or -jumpKey: %v", jumpUser, jumpHost, err)
+		}
+	}
+	if srcAddress != "" && srcSshPassword == "" && srcSshPublicKey == "" && sshPasswordFile == "" && !destinationOnly() {
+		srcSshPassword, err = readPassword(fmt.Sprintf("enter SSH password for source %v@%v: ", srcSshUser, srcAddress))
+		if err != nil {
+			fatalf(exitConfig, "no SSH password or key for source %v@%v, set SRCSSHPASSWORD, SSHPASSWORD, -sshPasswordFile, or -srcSshPublicKey: %v", srcSshUser, srcAddress, err)
+		}
+	}
+	if dstAddress != "" && dstSshPassword == "" && dstSshPublicKey == "" && sshPasswordFile == "" && !sourceOnly() {
+		if dstAddress == srcAddress && dstSshUser == srcSshUser {
+			// Same account on both sides, don't ask twice
+			dstSshPassword = srcSshPassword
+		} else {
+			dstSshPassword, err = readPassword(fmt.Sprintf("enter SSH password for destination %v@%v: ", dstSshUser, dstAddress))
+			if err != nil {
+				fatalf(exitConfig, "no SSH password or key for destination %v@%v, set DSTSSHPASSWORD, SSHPASSWORD, -sshPasswordFile, or -dstSshPublicKey: %v", dstSshUser, dstAddress, err)
+			}
+		}
+	}
+}
+
+// checkAddresses returns an error if an SFTP address already includes a port,
+// or if the SSH port for an SFTP side isn't a number from 1 to 65535, so
+// typos are caught before connecting
+func checkAddresses() error {
+	sides := []struct {
+		name, portFlag, addr, port string
+	}{
+		{"source", "-srcSshPort", srcAddress, srcSshPort},
+		{"destination", "-dstSshPort", dstAddress, dstSshPort},
+	}
+	for _, side := range sides {
+		if side.addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(side.addr); err == nil {
+			return fmt.Errorf("%v address %v should not include a port, use -sshPort or %v", side.name, side.addr, side.portFlag)
+		}
+		if err := checkPort(side.port); err != nil {
+			return fmt.Errorf("%v SSH port: %w", side.name, err)
+		}
+	}
+	if jumpHost != "" {
+		if _, _, err := net.SplitHostPort(jumpHost); err != nil {
+			if err := checkPort(sshPort); err != nil {
+				return fmt.Errorf("jump host SSH port: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkPort returns an error if port isn't a number from 1 to 65535
+func checkPort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a number from 1 to 65535", port)
+	}
+	return nil
+}
+
+// checkRoots returns an error if any source root and destination root are the
+// same location or a destination root is inside a source root, where files
+// written to the destination could be matched as source files by later runs
+func checkRoots() error {
+	if sourceOnly() || destinationOnly() {
+		return nil // nothing is written
+	}
+	dstRoots := []struct{ name, root string }{
+		{"dstRoot", dstRoot},
+		{"sflDstRoot", sflDstRoot},
+		{"evtDstRoot", evtDstRoot},
+	}
+	for i, dst := range dstRoots {
+		if i > 0 && dst.root == "" {
+			continue
+		}
+		for _, root := range srcRoots {
+			if err := checkRoot(root, dst.root, dst.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRoot is checkRoots for one source root and destination root dstRoot,
+// set by option name
+func checkRoot(srcRoot, dstRoot, name string) error {
+	var src, dst string
+	switch {
+	case srcAddress == "" && dstAddress == "":
 		var err error
-		t0, err = time.Parse(time.RFC3339, start)
+		if src, err = filepath.Abs(srcRoot); err != nil {
+			return fmt.Errorf("could not resolve -srcRoot: %w", err)
+		}
+		if dst, err = filepath.Abs(dstRoot); err != nil {
+			return fmt.Errorf("could not resolve -%v: %w", name, err)
+		}
+	case srcAddress != "" && fs.SftpAddr(srcAddress, srcSshPort) == fs.SftpAddr(dstAddress, dstSshPort):
+		// Relative SFTP paths are relative to the user's home directory
+		if srcSshUser != dstSshUser && !(path.IsAbs(srcRoot) && path.IsAbs(dstRoot)) {
+			return nil
+		}
+		src, dst = path.Clean(srcRoot), path.Clean(dstRoot)
+	default:
+		return nil
+	}
+	if src == dst {
+		return fmt.Errorf("-srcRoot and -%v are the same location: %v", name, src)
+	}
+	if rel, err := filepath.Rel(src, dst); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("-%v %v is inside -srcRoot %v", name, dst, src)
+	}
+	return nil
+}
+
+// sourceOnly returns true for modes which only read source files, so no
+// destination connection is made
+func sourceOnly() bool {
+	return list || listDays || probeCompression > 0
+}
+
+// destinationOnly returns true for modes which only read destination files,
+// so no source connection is made
+func destinationOnly() bool {
+	return checkManifest != ""
+}
+
+// earliest returns the earliest file timestamp to transfer from -start,
+// -within, -sinceFile, and -resume. It's called again for each -daemon run
+// since -within, -sinceFile, and -resume change over time.
+func earliest() (time.Time, error) {
+	var t time.Time
+	var err error
+	if start != "" {
+		t, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return t, fmt.Errorf("could not parse -start RFC3339 timestamp: %v", err)
+		}
+	}
+	if within > 0 {
+		t = time.Now().Add(-within)
+	}
+	if sinceFile != "" {
+		t, err = readSinceFile(sinceFile)
+		if err != nil {
+			return t, err
+		}
+	}
+	if resume {
+		// First run has no state file, transfer everything
+		last, err := fs.ReadState(stateFile)
 		if err != nil {
-			log.Fatalf("could not parse -start RFC3339 timestamp: %v", err)
+			return t, err
+		}
+		if last.After(t) {
+			t = last
 		}
 	}
+	return t, nil
 }
 
-func initFlags() {
-	flagset := flag.NewFlagSet(cmdname, flag.ExitOnError)
-	flagset.StringVar(&srcRoot, "srcRoot", "", "Root path of source")
-	flagset.StringVar(&dstRoot, "dstRoot", "", "Root path of destination")
-	flagset.StringVar(&srcAddress, "srcAddress", "", "Address of SFTP source")
-	flagset.StringVar(&dstAddress, "dstAddress", "", "Address of SFTP destination")
-	flagset.StringVar(&sshPort, "sshPort", "22", "SSH port")
-	flagset.StringVar(&sshUser, "sshUser", "", "SSH user name")
-	flagset.StringVar(&sshPublicKey, "sshPublicKey", "", "SSH public key file, overrides SSHPASSWORD")
-	flagset.BoolVar(&quiet, "quiet", false, "Suppress informational logging")
-	flagset.StringVar(&start, "start", "", "Earliest file timestamp to transfer as an RFC3339 string")
-	flagset.BoolVar(&verbose, "verbose", false, "Enable debugging logs")
-	flagset.BoolVar(&version, "version", false, "Display version and exit")
+// splitList splits a comma-separated list, dropping empty items
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
 
-	flagset.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Transfer SeaFlow files between source and destination, which can be SFTP or local.\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "Will not transfer gzipped files, but will gzip before writing to destination.\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "If using SFTP, the SSH password should be set in ENV as SSHPASSWORD.\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "Otherwise the password will be gathered from a prompt.\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "All other options can be set in ENV as well, overriding CLI options.\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "ENV variable names should be uppercased CLI option names.\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "Boolean option ENV vars should be set to 1 for true.\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", cmdname)
-		flagset.PrintDefaults()
+// readSinceFile returns the RFC3339 timestamp in the file at path for
+// -sinceFile. Unlike a -stateFile, the file must exist.
+func readSinceFile(path string) (time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read -sinceFile: %w", err)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse -sinceFile %v as an RFC3339 timestamp: %w", path, err)
+	}
+	return ts, nil
+}
+
+// validManifestAlgo returns true if algo is a supported manifest hash algorithm
+func validManifestAlgo(algo string) bool {
+	for _, a := range fs.ManifestAlgos() {
+		if algo == a {
+			return true
+		}
+	}
+	return false
+}
+
+// keyPassphrase returns the passphrase for an encrypted private key file from
+// SSHKEYPASSPHRASE or an interactive prompt.
+func keyPassphrase(keyfile string) ([]byte, error) {
+	val, ok := os.LookupEnv("SSHKEYPASSPHRASE")
+	if ok {
+		return []byte(val), nil
+	}
+	pass, err := readPassword(fmt.Sprintf("enter passphrase for SSH key %v: ", keyfile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read passphrase, set SSHKEYPASSPHRASE: %w", err)
+	}
+	return []byte(pass), nil
+}
+
+// errNoTerminal is returned by readPassword when there's no terminal to prompt
+// on, e.g. under cron or systemd
+var errNoTerminal = errors.New("stdin is not a terminal")
+
+func readPassword(prompt string) (string, error) {
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return "", errNoTerminal
+	}
+	fmt.Print(prompt)
+	b, err := term.ReadPassword(syscall.Stdin)
+	fmt.Printf("\n")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// logProgress returns a Transfer progress callback which logs megabytes read
+// from the source and the read rate since the last callback.
+func logProgress(logger fs.Logger) func(string, int64, int64) {
+	// Files may be copied concurrently, track each separately
+	type last struct {
+		bytes int64
+		time  time.Time
+	}
+	var mu sync.Mutex
+	lasts := make(map[string]last)
+	return func(path string, copied int64, total int64) {
+		now := time.Now()
+		mu.Lock()
+		prev := lasts[path]
+		if copied == total {
+			delete(lasts, path)
+		} else {
+			lasts[path] = last{copied, now}
+		}
+		mu.Unlock()
+		if copied == 0 {
+			return
+		}
+		rate := 0.0
+		if elapsed := now.Sub(prev.time).Seconds(); elapsed > 0 && !prev.time.IsZero() {
+			rate = float64(copied-prev.bytes) / 1e6 / elapsed
+		}
+		logger.Debug("progress", "path", path, "bytes", copied, "totalBytes", total, "MBps", fmt.Sprintf("%.2f", rate))
+	}
+}
+
+// runHook returns a Transfer post-copy callback which runs shell command
+// with sh -c, with the destination path and size of each copied file
+// appended as arguments, so the command may quote its own arguments. Command
+// output is included in the error if it fails.
+func runHook(command string) func(context.Context, fs.FileRecord) error {
+	return func(ctx context.Context, r fs.FileRecord) error {
+		// "$@" expands to the appended arguments, each quoted
+		cmd := exec.CommandContext(ctx, "sh", "-c", command+` "$@"`, "sh", r.Dst, strconv.FormatInt(r.BytesWritten, 10))
+		out, err := cmd.CombinedOutput()
+		if msg := strings.TrimSpace(string(out)); err != nil && msg != "" {
+			return fmt.Errorf("%v: %w: %v", command, err, msg)
+		} else if err != nil {
+			return fmt.Errorf("%v: %w", command, err)
+		}
+		return nil
+	}
+}
+
+func initFlags() {
+	flagset := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	flagset.StringVar(&config, "config", "", "TOML file of option values keyed by CLI option name, overridden by CLI options and ENV")
+	flagset.StringVar(&srcRoot, "srcRoot", "", "Root path of source, or comma-separated root paths whose files are merged")
+	flagset.StringVar(&dstRoot, "dstRoot", "", "Root path of destination")
+	flagset.StringVar(&sflDstRoot, "sflDstRoot", "", "Root path of destination for SFL files, instead of -dstRoot")
+	flagset.StringVar(&evtDstRoot, "evtDstRoot", "", "Root path of destination for EVT files, instead of -dstRoot")
+	flagset.StringVar(&srcAddress, "srcAddress", "", "Address of SFTP source")
+	flagset.StringVar(&dstAddress, "dstAddress", "", "Address of SFTP destination")
+	flagset.StringVar(&sshPort, "sshPort", "22", "SSH port")
+	flagset.StringVar(&sshUser, "sshUser", "", "SSH user name")
+	flagset.StringVar(&sshPublicKey, "sshPublicKey", "", "Comma-separated SSH private key files, tried before SSHPASSWORD")
+	flagset.StringVar(&sshPasswordFile, "sshPasswordFile", "", "File whose first line is the SSH password, used instead of SSHPASSWORD and re-read on each connection. Should only be readable by the current user")
+	flagset.StringVar(&srcSshPort, "srcSshPort", "", "SSH port for source, overrides sshPort")
+	flagset.StringVar(&srcSshUser, "srcSshUser", "", "SSH user name for source, overrides sshUser")
+	flagset.StringVar(&srcSshPassword, "srcSshPassword", "", "SSH password for source, overrides SSHPASSWORD")
+	flagset.StringVar(&srcSshPublicKey, "srcSshPublicKey", "", "Comma-separated SSH private key files for source, overrides sshPublicKey")
+	flagset.StringVar(&dstSshPort, "dstSshPort", "", "SSH port for destination, overrides sshPort")
+	flagset.StringVar(&dstSshUser, "dstSshUser", "", "SSH user name for destination, overrides sshUser")
+	flagset.StringVar(&dstSshPassword, "dstSshPassword", "", "SSH password for destination, overrides SSHPASSWORD")
+	flagset.StringVar(&dstSshPublicKey, "dstSshPublicKey", "", "Comma-separated SSH private key files for destination, overrides sshPublicKey")
+	flagset.StringVar(&jumpHost, "jumpHost", "", "SSH jump host, as host or host:port, to tunnel SFTP connections through")
+	flagset.StringVar(&jumpUser, "jumpUser", "", "SSH user name for jump host, defaults to sshUser")
+	flagset.StringVar(&jumpPassword, "jumpPassword", "", "SSH password for jump host")
+	flagset.StringVar(&jumpKey, "jumpKey", "", "Comma-separated SSH private key files for jump host, defaults to sshPublicKey")
+	flagset.StringVar(&knownHosts, "knownHosts", "", "OpenSSH known_hosts file used to verify SFTP host keys")
+	flagset.StringVar(&hostKeyAlgorithms, "hostKeyAlgorithms", "", "Comma-separated SSH host key algorithms to accept, in order of preference, e.g. ssh-rsa for older servers, Go's defaults if empty")
+	flagset.DurationVar(&sshTimeout, "sshTimeout", 10*time.Second, "SSH connection timeout")
+	flagset.DurationVar(&sshKeepalive, "sshKeepalive", 0, "Interval between SSH keepalive requests, disabled if 0")
+	flagset.IntVar(&sftpConcurrency, "sftpConcurrency", 0, "Maximum concurrent SFTP requests per file read or write larger than the packet size, library default of 64 if 0")
+	flagset.IntVar(&sftpPacketSize, "sftpPacketSize", 0, fmt.Sprintf("SFTP packet data size in bytes, up to %v, the maximum if 0", fs.MaxSftpPacketSize))
+	flagset.BoolVar(&autoReconnect, "autoReconnect", false, "Reconnect and retry once when an SFTP connection is lost")
+	flagset.BoolVar(&dryRun, "dryRun", false, "Report what would be transferred without writing to destination, listing destination files which would be created and overwritten")
+	flagset.BoolVar(&check, "check", false, "Connect to source and destination, check that source and destination roots are directories and destination roots are writable, and exit")
+	flagset.BoolVar(&selfTest, "selfTest", false, "Copy a small test file from a temp directory in the source root to the destination, check its contents and modification time survived, clean up, and exit")
+	flagset.BoolVar(&verifyOnly, "verifyOnly", false, "Compare checksums of source SFL and EVT files to existing destination files without copying anything, and exit")
+	flagset.BoolVar(&repair, "repair", false, "Compare checksums of source SFL and EVT files to destination files, copy again only those which are missing or mismatched, and exit. Unlike -force, matching files aren't rewritten")
+	flagset.StringVar(&checksumCache, "checksumCache", "", "Local file caching destination file checksums by size and modification time, so -verifyOnly and -checkManifest don't read unchanged files again")
+	flagset.StringVar(&checkManifest, "checkManifest", "", "Check destination files against this sha256sum, sha1sum, or md5sum format manifest with paths relative to dstRoot, reporting missing, extra, and mismatched files, without connecting to the source, and exit")
+	flagset.BoolVar(&compressExisting, "compressExisting", false, "Gzip uncompressed EVT files already in dstRoot, removing each original once its .gz copy is in place, and exit. The source is not accessed")
+	flagset.BoolVar(&allowMissingSrc, "allowMissingSrc", false, "Treat a missing srcRoot as having no files instead of failing")
+	flagset.BoolVar(&list, "list", false, "Print source SFL and EVT files which would be considered for transfer and exit, no destination required")
+	flagset.BoolVar(&listDays, "listDays", false, "Print the number of source SFL and EVT files in each day-of-year directory, with totals, and exit, no destination required")
+	flagset.StringVar(&daemon, "daemon", "", "Stay running, holding SFTP connections open, and run a transfer with these options for each -connect request on this Unix socket path")
+	flagset.StringVar(&connect, "connect", "", "Ask the -daemon process listening on this Unix socket path to run a transfer, print its logs, and exit with its exit status. All other options are ignored")
+	flagset.IntVar(&probeCompression, "probeCompression", 0, "Gzip this many of the largest EVT files which would be considered for transfer in memory at levels 1, 6, and 9, print the ratio and time for each level to help choose -gzipLevel, and exit, no destination required")
+	flagset.StringVar(&sflPattern, "sflPattern", fs.DefaultSFLPattern, "Glob pattern for SFL files relative to root, braces match alternatives, e.g. ????_???/*.{sfl,sflz}. Gzipped files with a .gz extension also match and are copied as-is")
+	flagset.StringVar(&evtPattern, "evtPattern", fs.DefaultEVTPattern, "Glob pattern for EVT files relative to root, braces match alternatives")
+	flagset.BoolVar(&followSymlinks, "followSymlinks", false, "Also copy from symlinks to day-of-year directories in srcRoot, e.g. a \"current\" link, writing files to the target directory's name")
+	flagset.StringVar(&doy, "doy", "", "Only copy from these comma-separated day-of-year directories or ranges, e.g. 2016_133,2016_135-2016_140")
+	flagset.IntVar(&recentDays, "recentDays", 0, "Only scan the N most recent day-of-year directories by YYYY_DOY name, skipping the most recent EVT file within them, all if 0")
+	flagset.StringVar(&extraPatterns, "extraPatterns", "", "Comma-separated glob patterns for other files in each day-of-year directory to copy uncompressed every run, e.g. *.json,*.log")
+	flagset.BoolVar(&preserveTree, "preserveTree", false, "Keep each file's full directory path relative to srcRoot at the destination, rather than only its parent directory")
+	flagset.BoolVar(&flatten, "flatten", false, "Write all files directly in dstRoot without day-of-year directories, skipping files with the same name as another")
+	flagset.BoolVar(&normalizeNames, "normalizeNames", false, "Write timestamped files with -tzSeparator as their timezone offset sign, whichever sign the source used")
+	flagset.StringVar(&tzSeparator, "tzSeparator", fs.DefaultTZSeparator, "Timezone offset sign for -normalizeNames, + or -")
+	flagset.BoolVar(&onlySfl, "onlySfl", false, "Only transfer SFL files, e.g. to run EVT transfers on a different schedule")
+	flagset.BoolVar(&onlyEvt, "onlyEvt", false, "Only transfer EVT files")
+	flagset.BoolVar(&opp, "opp", false, "Also transfer OPP files")
+	flagset.BoolVar(&vct, "vct", false, "Also transfer VCT files")
+	flagset.DurationVar(&minAge, "minAge", 0, "Skip EVT, OPP, and VCT files modified less than this long ago, e.g. 30s")
+	flagset.StringVar(&include, "include", "", "Only copy SFL, EVT, OPP, and VCT files whose names match this regular expression")
+	flagset.StringVar(&exclude, "exclude", "", "Skip SFL, EVT, OPP, and VCT files whose names match this regular expression, even if matched by -include")
+	flagset.BoolVar(&includeLatest, "includeLatest", false, "Copy the most recent EVT file, which is normally skipped as it may still be written to. Only for finished archives, never use on a live instrument directory")
+	flagset.BoolVar(&latestFromLastDir, "latestFromLastDir", false, "Only look for the most recent EVT file to skip in the last day-of-year directory, so no file is skipped if that directory has no EVT files")
+	flagset.BoolVar(&move, "move", false, "Delete source files after successful copy, except the most recent SFL and EVT files")
+	flagset.BoolVar(&checkGzip, "checkGzip", false, "Validate gzipped source files as they're copied, failing any which don't decompress")
+	flagset.BoolVar(&checkGzipName, "checkGzipName", false, "Check that gzip header names, restored by gunzip -N, match file names, failing files gzipped in transit and warning for gzipped source files which don't")
+	flagset.BoolVar(&fixGzipName, "fixGzipName", false, "Rewrite the gzip header name of gzipped source files which don't match their file name, implies -checkGzipName")
+	flagset.BoolVar(&validateSFL, "validateSFL", false, "Check that each copied SFL file ends with a newline and its last line has as many columns as its header, failing and removing truncated files. The latest SFL file, which may still be written, isn't checked")
+	flagset.BoolVar(&requireSflRecord, "requireSflRecord", false, "Only copy EVT files which are recorded in an SFL file in the same source directory, i.e. which the instrument has finished writing")
+	flagset.BoolVar(&decompress, "decompress", false, "Decompress gzipped source files instead of gzipping EVT files")
+	flagset.StringVar(&dirMode, "dirMode", "", "Octal permissions for created destination directories regardless of umask, e.g. 0775, 0755 before umask for local destinations and the server default for SFTP if empty")
+	flagset.StringVar(&tempDir, "tempDir", "", "Destination directory for temp files, instead of each file's final directory. Final writes are not atomic if this is on a different filesystem than dstRoot, unless -requireAtomic is set")
+	flagset.BoolVar(&fsync, "fsync", false, "Flush each destination file to disk before renaming it, and its directory after, so files survive a power loss. Slower. Directories are only flushed for local destinations, and SFTP files only if the server supports fsync@openssh.com")
+	flagset.BoolVar(&requireAtomic, "requireAtomic", false, "Fail copies which can't be finished with an atomic rename, instead of falling back to copying from -tempDir, and exit if the SFTP destination can't replace files atomically")
+	flagset.BoolVar(&noClobber, "noClobber", false, "Never replace existing destination files, including SFL files and with -force. Files already at the destination are skipped, and copies fail if the destination file appears meanwhile, e.g. from another run")
+	flagset.BoolVar(&noDowngrade, "noDowngrade", false, "Skip files, with a warning, whose destination copy is newer than the source by more than -mtimeTolerance, rather than replacing it with older data")
+	flagset.StringVar(&tempPrefix, "tempPrefix", fs.DefaultTempPrefix, "Filename prefix for temp files")
+	flagset.BoolVar(&copyEmpty, "copyEmpty", false, "Copy zero-byte source files instead of skipping them with a warning")
+	flagset.StringVar(&maxFileSize, "maxFileSize", "", "Skip source files larger than this size with a warning, e.g. 2GB, unlimited if not set")
+	flagset.StringVar(&headBytes, "headBytes", "", "Diagnostic mode: copy only the first N bytes of each file, e.g. 64KB, to sample data formats. Partial files are named with -headSuffix and every file is copied regardless of the destination")
+	flagset.StringVar(&headSuffix, "headSuffix", fs.DefaultHeadSuffix, "Suffix of partial files written with -headBytes")
+	flagset.BoolVar(&syncDeletes, "sync", false, "After copying, delete destination SFL and EVT files with no source file. Only logs deletions unless -confirmDelete is set")
+	flagset.BoolVar(&confirmDelete, "confirmDelete", false, "Allow -sync to delete destination files")
+	flagset.BoolVar(&force, "force", false, "Copy EVT files even if already present at destination, overwriting them")
+	flagset.BoolVar(&keepGoing, "keepGoing", false, "Log per-file copy errors and continue with remaining files")
+	flagset.BoolVar(&skipUnchanged, "skipUnchanged", false, "Skip files not gzipped in transit if destination has the same size and modification time")
+	flagset.BoolVar(&gzipSFL, "gzipSFL", false, "Gzip SFL files in transit, written as .sfl.gz. SSH transport compression isn't supported, so this is the way to compress SFL files on a slow link")
+	flagset.IntVar(&gzipLevel, "gzipLevel", 6, "Gzip compression level from 1 (fastest) to 9 (smallest), see -probeCompression")
+	flagset.BoolVar(&pgzip, "pgzip", false, "Gzip files in transit with a parallel encoder using all CPUs, for fast links where gzip is the bottleneck")
+	flagset.StringVar(&pgzipSize, "pgzipSize", "", "Use the parallel gzip encoder for files at least this size, e.g. 64MB")
+	flagset.StringVar(&gzipFlushInterval, "gzipFlushInterval", "", "Flush gzip output after this many uncompressed bytes, e.g. 1MB, so files being written can be partially decompressed. Use with -noTempFile")
+	flagset.BoolVar(&noTempFile, "noTempFile", false, "Write files directly to their final path instead of renaming a temp file, for consumers which read files as they're written. Readers may see partial files")
+	flagset.StringVar(&compressThreshold, "compressThreshold", "", "Copy files smaller than this size as-is instead of gzipping them in transit, e.g. 4KB")
+	flagset.BoolVar(&appendSFL, "appendSFL", false, "Copy only bytes appended to SFL files since the last copy, appending them to the destination file. Appends are not atomic. Files which look rewritten are copied in full")
+	flagset.BoolVar(&refreshStale, "refreshStale", false, "Re-copy EVT files already at destination if the source file is newer")
+	flagset.BoolVar(&refreshGzipMtime, "refreshGzipMtime", false, "Re-copy EVT files already at destination, gzipped in transit, if the gzip header time differs from the source file's modification time")
+	flagset.DurationVar(&mtimeTolerance, "mtimeTolerance", fs.DefaultMtimeTolerance, "How far apart source and destination modification times can be and still match for -skipUnchanged, -refreshStale, and -refreshGzipMtime")
+	flagset.StringVar(&rateLimit, "rateLimit", "", "Maximum source read rate per second, e.g. 500KB or 2MB, unlimited if not set")
+	flagset.StringVar(&bufferSize, "bufferSize", "", "Size of copy buffers, e.g. 1MB. 64KB to 4MB is reasonable, larger helps on high latency links. Default buffering if not set")
+	flagset.StringVar(&minFreeSpace, "minFreeSpace", "", "Free space to leave at destination beyond the size of files to copy, e.g. 10GB")
+	flagset.StringVar(&summaryJSON, "summaryJSON", "", "Write a JSON summary of the run to this file")
+	flagset.BoolVar(&destLog, "destLog", false, fmt.Sprintf("Append a record of each run and the files it copied to %v in dstRoot", fs.RunLogName))
+	flagset.StringVar(&metricsFile, "metricsFile", "", "Write Prometheus text format metrics for the run to this file, e.g. for the node_exporter textfile collector")
+	flagset.StringVar(&pushgateway, "pushgateway", "", "POST Prometheus metrics for the run to this Pushgateway URL, e.g. http://localhost:9091/metrics/job/seaflow-transfer")
+	flagset.StringVar(&statusAddr, "statusAddr", "", "Serve a JSON snapshot of the run's progress over HTTP on this host:port while files are copied, e.g. localhost:8080")
+	flagset.StringVar(&postHook, "postHook", "", "Shell command to run with sh -c after each file is copied, with the destination path and size in bytes appended as arguments. Quote arguments with spaces as in a shell")
+	flagset.BoolVar(&hookFatal, "hookFatal", false, "Stop the transfer if -postHook fails, rather than logging and continuing")
+	flagset.StringVar(&manifest, "manifest", "", "Append checksums of copied files to this file, checkable with e.g. sha256sum -c from dstRoot")
+	flagset.StringVar(&manifestAlgo, "manifestAlgo", fs.DefaultManifestAlgo, "Hash algorithm for -manifest, "+strings.Join(fs.ManifestAlgos(), ", "))
+	flagset.IntVar(&maxRetries, "maxRetries", 0, "Maximum number of times to retry a file copy which failed with a transient network or IO error")
+	flagset.IntVar(&workers, "workers", 1, "Number of files to copy concurrently")
+	flagset.IntVar(&srcWorkers, "srcWorkers", 0, "Maximum copies reading from the source at once, up to -workers if 0")
+	flagset.IntVar(&dstWorkers, "dstWorkers", 0, "Maximum copies writing to the destination at once, up to -workers if 0")
+	flagset.IntVar(&maxFiles, "maxFiles", 0, "Maximum files of each type to copy per run, in -order, unlimited if 0")
+	flagset.StringVar(&order, "order", "oldest-first", "Order in which to copy files of each type, by the time in their names, oldest-first or newest-first")
+	flagset.IntVar(&minExpected, "minExpected", 0, "Exit with status 5 if fewer than this many files were copied in total, e.g. to detect a stalled instrument")
+	flagset.DurationVar(&retryDelay, "retryDelay", 5*time.Second, "Delay before first retry of a failed file copy, doubled after each retry")
+	flagset.DurationVar(&fileTimeout, "fileTimeout", 0, "Maximum time to spend copying a single file, including retries, e.g. 10m (0 for no limit)")
+	flagset.DurationVar(&stallTimeout, "stallTimeout", 0, "Cancel and retry a file copy if no data is read for this long, e.g. 60s, regardless of file size (0 for no limit)")
+	flagset.DurationVar(&globTimeout, "globTimeout", 0, "Maximum time to spend listing one source or destination directory, e.g. 2m for a flaky mount (0 for no limit)")
+	flagset.DurationVar(&totalTimeout, "totalTimeout", 0, "Maximum time for the whole transfer, e.g. 1h (0 for no limit)")
+	flagset.BoolVar(&quiet, "quiet", false, "Suppress informational logging")
+	flagset.BoolVar(&quietSummary, "quietSummary", false, "Suppress informational logging but print a one line summary of the run to stdout")
+	flagset.StringVar(&logFormat, "logFormat", "text", "Log format, text or json")
+	flagset.StringVar(&start, "start", "", "Earliest file timestamp to transfer as an RFC3339 string")
+	flagset.DurationVar(&within, "within", 0, "Only transfer files with timestamps within this long before now, e.g. 2h. Uses the timestamp in the filename, i.e. acquisition time, not modification time")
+	flagset.StringVar(&sinceFile, "sinceFile", "", "Local file containing the earliest file timestamp to transfer as an RFC3339 string, e.g. maintained by downstream processing")
+	flagset.StringVar(&stateFile, "stateFile", "", "Local file recording the newest file timestamp transferred by the last successful run")
+	flagset.BoolVar(&reportNewest, "reportNewest", false, "After the run, print the newest file timestamp transferred as RFC3339, or if none were, the newest at the destination, or an empty line if there are none")
+	flagset.StringVar(&newestFile, "newestFile", "", "Local file to write the -reportNewest timestamp to after each run")
+	flagset.BoolVar(&resume, "resume", false, "Start from the timestamp in -stateFile, if it's later than -start, -within, or -sinceFile")
+	flagset.StringVar(&end, "end", "", "Transfer files with timestamps before this RFC3339 string")
+	flagset.BoolVar(&verbose, "verbose", false, "Enable debugging logs")
+	flagset.BoolVar(&verify, "verify", false, "Verify destination file checksums after copy")
+	flagset.BoolVar(&version, "version", false, "Display version and exit")
+
+	flagset.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Transfer SeaFlow files between source and destination, which can be SFTP or local.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Will not transfer gzipped files, but will gzip before writing to destination.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "If using SFTP, the SSH password should be set in ENV as SSHPASSWORD, or in a file with -sshPasswordFile.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Otherwise the password will be gathered from a prompt.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Passphrases for encrypted SSH keys can be set in ENV as SSHKEYPASSPHRASE or entered at a prompt.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "All other options can be set in ENV as well, overriding CLI options.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Options can also be set in a TOML file with -config, keyed by CLI option name.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "CLI options and ENV override config file values.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "ENV variable names should be uppercased CLI option names.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Boolean option ENV vars should be set to 1 for true.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Exit status is %d on success, %d if the transfer stopped with an error,\n", exitOK, exitError)
+		fmt.Fprintf(flag.CommandLine.Output(), "%d for configuration or connection errors before any files were copied,\n", exitConfig)
+		fmt.Fprintf(flag.CommandLine.Output(), "%d if the transfer completed but some files failed with -keepGoing,\n", exitFilesFailed)
+		fmt.Fprintf(flag.CommandLine.Output(), "%d if -verifyOnly or -checkManifest found mismatched or missing destination files,\n", exitMismatch)
+		fmt.Fprintf(flag.CommandLine.Output(), "or -repair couldn't repair some,\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "and %d if the transfer succeeded but copied fewer than -minExpected files.\n", exitTooFew)
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", cmdname)
+		flagset.PrintDefaults()
+	}
+	args := make([]string, 0)
+	if len(os.Args) > 1 {
+		args = os.Args[1:]
+	}
+	err := flagset.Parse(args)
+	if err != nil {
+		panic(err)
+	}
+	flagset.Visit(func(f *flag.Flag) {
+		cliOptions[f.Name] = true
+	})
+	if val, ok := os.LookupEnv("CONFIG"); ok {
+		config = val
+	}
+	if config != "" {
+		if err := loadConfig(flagset, config); err != nil {
+			fatalf(exitConfig, "could not load config: %v", err)
+		}
+	}
+}
+
+// loadConfig sets options in flagset from a TOML config file. Keys are CLI
+// option names. Options explicitly set on the command line are not changed.
+func loadConfig(flagset *flag.FlagSet, path string) error {
+	values := make(map[string]interface{})
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		return err
+	}
+	for name, v := range values {
+		if flagset.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown option %q in %v", name, path)
+		}
+		if cliOptions[name] {
+			continue
+		}
+		var val string
+		switch v := v.(type) {
+		case string, bool, int64, float64:
+			val = fmt.Sprint(v)
+		case time.Time:
+			val = v.Format(time.RFC3339)
+		default:
+			return fmt.Errorf("unsupported value for option %q in %v", name, path)
+		}
+		if err := flagset.Set(name, val); err != nil {
+			return fmt.Errorf("invalid value for option %q in %v: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+func initEnvVars() {
+	val, ok := os.LookupEnv("SRCROOT")
+	if ok {
+		srcRoot = val
+	}
+	val, ok = os.LookupEnv("DSTROOT")
+	if ok {
+		dstRoot = val
+	}
+	val, ok = os.LookupEnv("SFLDSTROOT")
+	if ok {
+		sflDstRoot = val
+	}
+	val, ok = os.LookupEnv("EVTDSTROOT")
+	if ok {
+		evtDstRoot = val
+	}
+	val, ok = os.LookupEnv("SRCADDRESS")
+	if ok {
+		srcAddress = val
+	}
+	val, ok = os.LookupEnv("DSTADDRESS")
+	if ok {
+		dstAddress = val
+	}
+	val, ok = os.LookupEnv("SSHPORT")
+	if ok {
+		sshPort = val
+	}
+	val, ok = os.LookupEnv("SSHUSER")
+	if ok {
+		sshUser = val
+	}
+	val, ok = os.LookupEnv("SSHPASSWORD")
+	if ok {
+		sshPassword = val
+	}
+	val, ok = os.LookupEnv("SSHPASSWORDFILE")
+	if ok {
+		sshPasswordFile = val
+	}
+	val, ok = os.LookupEnv("SSHPUBLICKEY")
+	if ok {
+		sshPublicKey = val
+	}
+	val, ok = os.LookupEnv("SRCSSHPORT")
+	if ok {
+		srcSshPort = val
+	}
+	val, ok = os.LookupEnv("SRCSSHUSER")
+	if ok {
+		srcSshUser = val
+	}
+	val, ok = os.LookupEnv("SRCSSHPASSWORD")
+	if ok {
+		srcSshPassword = val
+	}
+	val, ok = os.LookupEnv("SRCSSHPUBLICKEY")
+	if ok {
+		srcSshPublicKey = val
+	}
+	val, ok = os.LookupEnv("DSTSSHPORT")
+	if ok {
+		dstSshPort = val
+	}
+	val, ok = os.LookupEnv("DSTSSHUSER")
+	if ok {
+		dstSshUser = val
+	}
+	val, ok = os.LookupEnv("DSTSSHPASSWORD")
+	if ok {
+		dstSshPassword = val
+	}
+	val, ok = os.LookupEnv("DSTSSHPUBLICKEY")
+	if ok {
+		dstSshPublicKey = val
+	}
+	val, ok = os.LookupEnv("JUMPHOST")
+	if ok {
+		jumpHost = val
+	}
+	val, ok = os.LookupEnv("JUMPUSER")
+	if ok {
+		jumpUser = val
+	}
+	val, ok = os.LookupEnv("JUMPPASSWORD")
+	if ok {
+		jumpPassword = val
+	}
+	val, ok = os.LookupEnv("JUMPKEY")
+	if ok {
+		jumpKey = val
+	}
+	val, ok = os.LookupEnv("KNOWNHOSTS")
+	if ok {
+		knownHosts = val
+	}
+	val, ok = os.LookupEnv("HOSTKEYALGORITHMS")
+	if ok {
+		hostKeyAlgorithms = val
+	}
+	val, ok = os.LookupEnv("SSHTIMEOUT")
+	if ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse SSHTIMEOUT: %v", err)
+		}
+		sshTimeout = d
+	}
+	val, ok = os.LookupEnv("SSHKEEPALIVE")
+	if ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse SSHKEEPALIVE: %v", err)
+		}
+		sshKeepalive = d
+	}
+	val, ok = os.LookupEnv("SFTPCONCURRENCY")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse SFTPCONCURRENCY: %v", err)
+		}
+		sftpConcurrency = n
+	}
+	val, ok = os.LookupEnv("SFTPPACKETSIZE")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse SFTPPACKETSIZE: %v", err)
+		}
+		sftpPacketSize = n
+	}
+	val, ok = os.LookupEnv("AUTORECONNECT")
+	if ok && val == "1" {
+		autoReconnect = true
+	}
+	val, ok = os.LookupEnv("DRYRUN")
+	if ok && val == "1" {
+		dryRun = true
+	}
+	val, ok = os.LookupEnv("LIST")
+	if ok && val == "1" {
+		list = true
+	}
+	val, ok = os.LookupEnv("LISTDAYS")
+	if ok && val == "1" {
+		listDays = true
+	}
+	val, ok = os.LookupEnv("DAEMON")
+	if ok {
+		daemon = val
+	}
+	val, ok = os.LookupEnv("CONNECT")
+	if ok {
+		connect = val
+	}
+	val, ok = os.LookupEnv("PROBECOMPRESSION")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse PROBECOMPRESSION: %v", err)
+		}
+		probeCompression = n
+	}
+	val, ok = os.LookupEnv("ALLOWMISSINGSRC")
+	if ok && val == "1" {
+		allowMissingSrc = true
+	}
+	val, ok = os.LookupEnv("CHECK")
+	if ok && val == "1" {
+		check = true
+	}
+	val, ok = os.LookupEnv("SELFTEST")
+	if ok && val == "1" {
+		selfTest = true
+	}
+	val, ok = os.LookupEnv("VERIFYONLY")
+	if ok && val == "1" {
+		verifyOnly = true
+	}
+	val, ok = os.LookupEnv("REPAIR")
+	if ok && val == "1" {
+		repair = true
+	}
+	val, ok = os.LookupEnv("CHECKSUMCACHE")
+	if ok {
+		checksumCache = val
+	}
+	val, ok = os.LookupEnv("STATUSADDR")
+	if ok {
+		statusAddr = val
+	}
+	val, ok = os.LookupEnv("CHECKMANIFEST")
+	if ok {
+		checkManifest = val
+	}
+	val, ok = os.LookupEnv("COMPRESSEXISTING")
+	if ok && val == "1" {
+		compressExisting = true
+	}
+	val, ok = os.LookupEnv("FOLLOWSYMLINKS")
+	if ok && val == "1" {
+		followSymlinks = true
+	}
+	val, ok = os.LookupEnv("SFLPATTERN")
+	if ok {
+		sflPattern = val
+	}
+	val, ok = os.LookupEnv("EVTPATTERN")
+	if ok {
+		evtPattern = val
+	}
+	val, ok = os.LookupEnv("DOY")
+	if ok {
+		doy = val
+	}
+	val, ok = os.LookupEnv("RECENTDAYS")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse RECENTDAYS: %v", err)
+		}
+		recentDays = n
+	}
+	val, ok = os.LookupEnv("EXTRAPATTERNS")
+	if ok {
+		extraPatterns = val
+	}
+	val, ok = os.LookupEnv("ONLYSFL")
+	if ok && val == "1" {
+		onlySfl = true
+	}
+	val, ok = os.LookupEnv("ONLYEVT")
+	if ok && val == "1" {
+		onlyEvt = true
+	}
+	val, ok = os.LookupEnv("OPP")
+	if ok && val == "1" {
+		opp = true
+	}
+	val, ok = os.LookupEnv("VCT")
+	if ok && val == "1" {
+		vct = true
+	}
+	val, ok = os.LookupEnv("MOVE")
+	if ok && val == "1" {
+		move = true
+	}
+	val, ok = os.LookupEnv("REQUIRESFLRECORD")
+	if ok && val == "1" {
+		requireSflRecord = true
+	}
+	val, ok = os.LookupEnv("VALIDATESFL")
+	if ok && val == "1" {
+		validateSFL = true
+	}
+	val, ok = os.LookupEnv("CHECKGZIP")
+	if ok && val == "1" {
+		checkGzip = true
+	}
+	val, ok = os.LookupEnv("CHECKGZIPNAME")
+	if ok && val == "1" {
+		checkGzipName = true
+	}
+	val, ok = os.LookupEnv("FIXGZIPNAME")
+	if ok && val == "1" {
+		fixGzipName = true
+	}
+	val, ok = os.LookupEnv("PRESERVETREE")
+	if ok && val == "1" {
+		preserveTree = true
+	}
+	val, ok = os.LookupEnv("FLATTEN")
+	if ok && val == "1" {
+		flatten = true
+	}
+	val, ok = os.LookupEnv("NORMALIZENAMES")
+	if ok && val == "1" {
+		normalizeNames = true
+	}
+	val, ok = os.LookupEnv("TZSEPARATOR")
+	if ok {
+		tzSeparator = val
+	}
+	val, ok = os.LookupEnv("DECOMPRESS")
+	if ok && val == "1" {
+		decompress = true
+	}
+	val, ok = os.LookupEnv("TEMPDIR")
+	if ok {
+		tempDir = val
+	}
+	val, ok = os.LookupEnv("FSYNC")
+	if ok && val == "1" {
+		fsync = true
+	}
+	val, ok = os.LookupEnv("REQUIREATOMIC")
+	if ok && val == "1" {
+		requireAtomic = true
+	}
+	val, ok = os.LookupEnv("NOCLOBBER")
+	if ok && val == "1" {
+		noClobber = true
+	}
+	val, ok = os.LookupEnv("NODOWNGRADE")
+	if ok && val == "1" {
+		noDowngrade = true
+	}
+	val, ok = os.LookupEnv("DIRMODE")
+	if ok {
+		dirMode = val
+	}
+	val, ok = os.LookupEnv("TEMPPREFIX")
+	if ok {
+		tempPrefix = val
+	}
+	val, ok = os.LookupEnv("COPYEMPTY")
+	if ok && val == "1" {
+		copyEmpty = true
+	}
+	val, ok = os.LookupEnv("SYNC")
+	if ok && val == "1" {
+		syncDeletes = true
+	}
+	val, ok = os.LookupEnv("CONFIRMDELETE")
+	if ok && val == "1" {
+		confirmDelete = true
+	}
+	val, ok = os.LookupEnv("FORCE")
+	if ok && val == "1" {
+		force = true
+	}
+	val, ok = os.LookupEnv("KEEPGOING")
+	if ok && val == "1" {
+		keepGoing = true
+	}
+	val, ok = os.LookupEnv("SKIPUNCHANGED")
+	if ok && val == "1" {
+		skipUnchanged = true
+	}
+	val, ok = os.LookupEnv("GZIPSFL")
+	if ok && val == "1" {
+		gzipSFL = true
+	}
+	val, ok = os.LookupEnv("GZIPLEVEL")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse GZIPLEVEL: %v", err)
+		}
+		gzipLevel = n
+	}
+	val, ok = os.LookupEnv("PGZIP")
+	if ok && val == "1" {
+		pgzip = true
+	}
+	val, ok = os.LookupEnv("GZIPFLUSHINTERVAL")
+	if ok {
+		gzipFlushInterval = val
+	}
+	val, ok = os.LookupEnv("NOTEMPFILE")
+	if ok && val == "1" {
+		noTempFile = true
+	}
+	val, ok = os.LookupEnv("PGZIPSIZE")
+	if ok {
+		pgzipSize = val
+	}
+	val, ok = os.LookupEnv("COMPRESSTHRESHOLD")
+	if ok {
+		compressThreshold = val
+	}
+	val, ok = os.LookupEnv("MAXFILESIZE")
+	if ok {
+		maxFileSize = val
+	}
+	val, ok = os.LookupEnv("HEADBYTES")
+	if ok {
+		headBytes = val
+	}
+	val, ok = os.LookupEnv("HEADSUFFIX")
+	if ok {
+		headSuffix = val
+	}
+	val, ok = os.LookupEnv("APPENDSFL")
+	if ok && val == "1" {
+		appendSFL = true
+	}
+	val, ok = os.LookupEnv("REFRESHGZIPMTIME")
+	if ok && val == "1" {
+		refreshGzipMtime = true
+	}
+	val, ok = os.LookupEnv("REFRESHSTALE")
+	if ok && val == "1" {
+		refreshStale = true
+	}
+	val, ok = os.LookupEnv("MTIMETOLERANCE")
+	if ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse MTIMETOLERANCE: %v", err)
+		}
+		mtimeTolerance = d
+	}
+	val, ok = os.LookupEnv("RATELIMIT")
+	if ok {
+		rateLimit = val
+	}
+	val, ok = os.LookupEnv("MINFREESPACE")
+	if ok {
+		minFreeSpace = val
+	}
+	val, ok = os.LookupEnv("BUFFERSIZE")
+	if ok {
+		bufferSize = val
+	}
+	val, ok = os.LookupEnv("SUMMARYJSON")
+	if ok {
+		summaryJSON = val
+	}
+	val, ok = os.LookupEnv("DESTLOG")
+	if ok && val == "1" {
+		destLog = true
+	}
+	val, ok = os.LookupEnv("METRICSFILE")
+	if ok {
+		metricsFile = val
+	}
+	val, ok = os.LookupEnv("PUSHGATEWAY")
+	if ok {
+		pushgateway = val
+	}
+	val, ok = os.LookupEnv("POSTHOOK")
+	if ok {
+		postHook = val
+	}
+	val, ok = os.LookupEnv("HOOKFATAL")
+	if ok && val == "1" {
+		hookFatal = true
+	}
+	val, ok = os.LookupEnv("MANIFEST")
+	if ok {
+		manifest = val
+	}
+	val, ok = os.LookupEnv("MANIFESTALGO")
+	if ok {
+		manifestAlgo = val
+	}
+	val, ok = os.LookupEnv("MAXRETRIES")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse MAXRETRIES: %v", err)
+		}
+		maxRetries = n
+	}
+	val, ok = os.LookupEnv("WORKERS")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse WORKERS: %v", err)
+		}
+		workers = n
+	}
+	val, ok = os.LookupEnv("SRCWORKERS")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse SRCWORKERS: %v", err)
+		}
+		srcWorkers = n
+	}
+	val, ok = os.LookupEnv("DSTWORKERS")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse DSTWORKERS: %v", err)
+		}
+		dstWorkers = n
+	}
+	val, ok = os.LookupEnv("MAXFILES")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse MAXFILES: %v", err)
+		}
+		maxFiles = n
+	}
+	val, ok = os.LookupEnv("ORDER")
+	if ok {
+		order = val
+	}
+	val, ok = os.LookupEnv("MINEXPECTED")
+	if ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse MINEXPECTED: %v", err)
+		}
+		minExpected = n
 	}
-	args := make([]string, 0)
-	if len(os.Args) > 1 {
-		args = os.Args[1:]
+	val, ok = os.LookupEnv("RETRYDELAY")
+	if ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse RETRYDELAY: %v", err)
+		}
+		retryDelay = d
 	}
-	err := flagset.Parse(args)
-	if err != nil {
-		panic(err)
+	val, ok = os.LookupEnv("FILETIMEOUT")
+	if ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse FILETIMEOUT: %v", err)
+		}
+		fileTimeout = d
 	}
-}
-
-func initEnvVars() {
-	val, ok := os.LookupEnv("SRCROOT")
+	val, ok = os.LookupEnv("STALLTIMEOUT")
 	if ok {
-		srcRoot = val
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse STALLTIMEOUT: %v", err)
+		}
+		stallTimeout = d
 	}
-	val, ok = os.LookupEnv("DSTROOT")
+	val, ok = os.LookupEnv("GLOBTIMEOUT")
 	if ok {
-		dstRoot = val
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse GLOBTIMEOUT: %v", err)
+		}
+		globTimeout = d
 	}
-	val, ok = os.LookupEnv("SRCADDRESS")
+	val, ok = os.LookupEnv("TOTALTIMEOUT")
 	if ok {
-		srcAddress = val
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse TOTALTIMEOUT: %v", err)
+		}
+		totalTimeout = d
 	}
-	val, ok = os.LookupEnv("DSTADDRESS")
+	val, ok = os.LookupEnv("MINAGE")
 	if ok {
-		dstAddress = val
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse MINAGE: %v", err)
+		}
+		minAge = d
 	}
-	val, ok = os.LookupEnv("SSHPORT")
+	val, ok = os.LookupEnv("INCLUDE")
 	if ok {
-		sshPort = val
+		include = val
 	}
-	val, ok = os.LookupEnv("SSHUSER")
+	val, ok = os.LookupEnv("EXCLUDE")
 	if ok {
-		sshUser = val
+		exclude = val
 	}
-	val, ok = os.LookupEnv("SSHPASSWORD")
+	val, ok = os.LookupEnv("LATESTFROMLASTDIR")
+	if ok && val == "1" {
+		latestFromLastDir = true
+	}
+	val, ok = os.LookupEnv("INCLUDELATEST")
+	if ok && val == "1" {
+		includeLatest = true
+	}
+	val, ok = os.LookupEnv("LOGFORMAT")
 	if ok {
-		sshPassword = val
+		logFormat = val
 	}
 	val, ok = os.LookupEnv("QUIET")
 	if ok && val == "1" {
 		quiet = true
 	}
+	val, ok = os.LookupEnv("QUIETSUMMARY")
+	if ok && val == "1" {
+		quietSummary = true
+	}
 	val, ok = os.LookupEnv("START")
 	if ok {
 		start = val
 	}
+	val, ok = os.LookupEnv("WITHIN")
+	if ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			fatalf(exitConfig, "could not parse WITHIN: %v", err)
+		}
+		within = d
+	}
+	val, ok = os.LookupEnv("SINCEFILE")
+	if ok {
+		sinceFile = val
+	}
+	val, ok = os.LookupEnv("STATEFILE")
+	if ok {
+		stateFile = val
+	}
+	val, ok = os.LookupEnv("REPORTNEWEST")
+	if ok && val == "1" {
+		reportNewest = true
+	}
+	val, ok = os.LookupEnv("NEWESTFILE")
+	if ok {
+		newestFile = val
+	}
+	val, ok = os.LookupEnv("RESUME")
+	if ok && val == "1" {
+		resume = true
+	}
+	val, ok = os.LookupEnv("END")
+	if ok {
+		end = val
+	}
 	val, ok = os.LookupEnv("VERBOSE")
 	if ok && val == "1" {
 		verbose = true
 	}
+	val, ok = os.LookupEnv("VERIFY")
+	if ok && val == "1" {
+		verify = true
+	}
 	val, ok = os.LookupEnv("VERSION")
 	if ok && val == "1" {
 		version = true
 	}
 }
 
-func main() {
-	debugLogger := log.New(os.Stderr, "", log.Ldate|log.Ltime)
-	infoLogger := log.New(os.Stderr, "", log.Ldate|log.Ltime)
-	errorLogger := log.New(os.Stderr, "", log.Ldate|log.Ltime)
+// sourceFiles returns source SFL and EVT files which would be considered for
+// transfer, restricted by -onlySfl or -onlyEvt
+func sourceFiles(t *fs.Transfer) ([]string, error) {
+	var files []string
+	if !onlyEvt {
+		sfl, err := t.ListSFLFiles()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, sfl...)
+	}
+	if !onlySfl {
+		evt, err := t.ListEVTFiles()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, evt...)
+	}
+	return files, nil
+}
 
-	if !verbose || quiet {
-		debugLogger.SetOutput(ioutil.Discard)
+// listFiles prints source files which would be considered for transfer to
+// out, one per line
+func listFiles(t *fs.Transfer, out io.Writer) error {
+	files, err := sourceFiles(t)
+	if err != nil {
+		return err
 	}
+	for _, path := range files {
+		fmt.Fprintln(out, path)
+	}
+	return nil
+}
 
-	if quiet {
-		infoLogger.SetOutput(ioutil.Discard)
+// printDays prints a table of the number of source SFL and EVT files in each
+// day-of-year directory to out, with totals
+func printDays(t *fs.Transfer, out io.Writer) error {
+	days, err := t.ListDays()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "day\tSFL\tEVT\n")
+	var total fs.DayCount
+	for _, d := range days {
+		fmt.Fprintf(w, "%v\t%d\t%d\n", d.Day, d.SFL, d.EVT)
+		total.SFL += d.SFL
+		total.EVT += d.EVT
 	}
+	fmt.Fprintf(w, "total (%d days)\t%d\t%d\n", len(days), total.SFL, total.EVT)
+	return w.Flush()
+}
 
-	t := &fs.Transfer{
-		Srcroot:  srcRoot,
-		Dstroot:  dstRoot,
-		Debug:    debugLogger,
-		Info:     infoLogger,
-		Error:    errorLogger,
-		Earliest: t0,
+// printCompressionProbe gzips the n largest EVT files which would be
+// considered for transfer at each of fs.ProbeLevels and prints a table of the
+// results to out
+func printCompressionProbe(t *fs.Transfer, out io.Writer, n int) error {
+	probes, err := t.ProbeCompression(context.Background(), n, fs.ProbeLevels)
+	if err != nil {
+		return err
 	}
-	var err error
-	if srcAddress != "" {
-		addr := fmt.Sprintf("%v:%v", srcAddress, sshPort)
-		t.Srcfs, err = fs.NewSftpfs(addr, sshUser, sshPassword, sshPublicKey)
-		infoLogger.Printf("connected to %v as %v\n", addr, sshUser)
-	} else {
-		t.Srcfs, err = fs.NewLocalfs()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "level\tfiles\tbytes\tcompressed\tratio\ttime\tMB/s\n")
+	for _, p := range probes {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.3f\t%v\t%.1f\n", p.Level, p.Files, p.Bytes, p.CompressedBytes, p.Ratio(), p.Duration.Round(time.Millisecond), p.Throughput()/1e6)
+	}
+	return w.Flush()
+}
+
+// errMismatch is returned by verifyMirror if any destination file is
+// mismatched or missing
+var errMismatch = errors.New("destination does not match source")
+
+// verifyMirror compares source files which would be considered for transfer
+// to existing destination files and logs the counts
+func verifyMirror(t *fs.Transfer) error {
+	files, err := sourceFiles(t)
+	if err != nil {
+		return err
+	}
+	res, err := t.VerifyMirror(context.Background(), files)
+	if err != nil {
+		return err
+	}
+	t.Log.Info("verified destination", "matched", res.Matched, "mismatched", res.Mismatched, "missing", res.Missing)
+	if !res.OK() {
+		return fmt.Errorf("%w: %v mismatched, %v missing", errMismatch, res.Mismatched, res.Missing)
+	}
+	return nil
+}
+
+// repairMirror copies source files which would be considered for transfer
+// again if their destination files are missing or mismatched, and logs the
+// counts
+func repairMirror(ctx context.Context, t *fs.Transfer) error {
+	files, err := sourceFiles(t)
+	if err != nil {
+		return err
+	}
+	res, err := t.Repair(ctx, files)
+	if err != nil {
+		return err
+	}
+	t.Log.Info("repaired destination", "verified", res.Verified, "repaired", res.Repaired, "failed", res.Failed)
+	if !res.OK() {
+		return fmt.Errorf("%w: %v could not be repaired", errMismatch, res.Failed)
+	}
+	return nil
+}
+
+// checkDstManifest checks destination files against manifest file path and
+// logs the counts
+func checkDstManifest(ctx context.Context, t *fs.Transfer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open manifest: %w", err)
 	}
+	defer f.Close()
+	res, err := t.CheckManifest(ctx, f)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	t.Log.Info("checked destination against manifest", "matched", res.Matched, "mismatched", res.Mismatched, "missing", res.Missing, "extra", res.Extra)
+	if !res.OK() {
+		return fmt.Errorf("%w: %v mismatched, %v missing, %v extra", errMismatch, res.Mismatched, res.Missing, res.Extra)
+	}
+	return nil
+}
+
+// writeMetrics writes Prometheus metrics for the run to -metricsFile and
+// -pushgateway. The file is replaced atomically so a collector never reads a
+// partial file.
+func writeMetrics(t *fs.Transfer, start time.Time) error {
+	var b bytes.Buffer
+	if err := fs.WriteMetrics(&b, t.Stats.Summary(), time.Since(start)); err != nil {
+		return err
+	}
+	if metricsFile != "" {
+		f, err := ioutil.TempFile(filepath.Dir(metricsFile), "."+filepath.Base(metricsFile)+".*")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(b.Bytes())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(f.Name(), 0644)
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), metricsFile)
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return err
+		}
+	}
+	if pushgateway != "" {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Post(pushgateway, "text/plain; version=0.0.4", &b)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("pushgateway returned %v", resp.Status)
+		}
+	}
+	return nil
+}
+
+// printSummary prints a one line summary of the run to out for
+// -quietSummary
+func printSummary(t *fs.Transfer, out io.Writer, start time.Time) {
+	s := t.Stats.Summary()
+	var sfl, evt int
+	for _, f := range s.Files {
+		switch kind, _ := fs.FileKind(f.Src); kind {
+		case fs.KindSFL:
+			sfl++
+		case fs.KindEVT:
+			evt++
+		}
+	}
+	fmt.Fprintf(out, "copied %d SFL and %d EVT files of %d total, %d bytes read, %d bytes written, %d skipped, %d failed in %v\n",
+		sfl, evt, s.Copied, s.BytesRead, s.BytesWritten, s.Skipped, s.Failed, time.Since(start).Round(time.Millisecond))
+}
+
+// printPlan writes the destination files a -dryRun would create and
+// overwrite to out
+func printPlan(t *fs.Transfer, out io.Writer) {
+	plan := t.Plan()
+	for _, list := range []struct {
+		action string
+		paths  []string
+	}{
+		{"create", plan.Create},
+		{"overwrite", plan.Overwrite},
+	} {
+		fmt.Fprintf(out, "would %v %d files\n", list.action, len(list.paths))
+		for _, p := range list.paths {
+			fmt.Fprintf(out, "  %v\n", p)
+		}
+	}
+}
+
+// sourceName describes the source for -destLog, e.g. host:/data
+func sourceName() string {
+	if srcAddress == "" {
+		return srcRoot
 	}
-	if dstAddress != "" {
-		addr := fmt.Sprintf("%v:%v", dstAddress, sshPort)
-		t.Dstfs, err = fs.NewSftpfs(addr, sshUser, sshPassword, sshPublicKey)
-		infoLogger.Printf("connected to %v as %v\n", addr, sshUser)
-	} else {
-		t.Dstfs, err = fs.NewLocalfs()
+	return srcAddress + ":" + srcRoot
+}
+
+// runSummary is the JSON document written by -summaryJSON
+type runSummary struct {
+	fs.Summary
+	Start          time.Time `json:"start"`
+	ElapsedSeconds float64   `json:"elapsedSeconds"`
+}
+
+func writeSummaryJSON(path string, t *fs.Transfer, start time.Time) error {
+	b, err := json.MarshalIndent(runSummary{
+		Summary:        t.Stats.Summary(),
+		Start:          start,
+		ElapsedSeconds: time.Since(start).Seconds(),
+	}, "", "  ")
+	if err != nil {
+		return err
 	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// writeNewest prints the newest file timestamp transferred, or at the
+// destination, to out for -reportNewest and writes it to -newestFile
+func writeNewest(t *fs.Transfer, out io.Writer) error {
+	ts, err := t.NewestTime()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if reportNewest {
+		line := ""
+		if !ts.IsZero() {
+			line = ts.UTC().Format(time.RFC3339Nano)
+		}
+		fmt.Fprintln(out, line)
+	}
+	if newestFile != "" {
+		return fs.WriteNewest(newestFile, ts)
+	}
+	return nil
+}
+
+func main() {
+	parseOptions()
+	runStart := time.Now()
+	logger := newLogger(os.Stderr)
+
+	if connect != "" {
+		code, err := runClient(connect, os.Stdout, os.Stderr)
+		if err != nil {
+			fatal(code, err)
+		}
+		os.Exit(code)
 	}
 
-	err = t.CopySFLFiles()
+	if knownHosts == "" && (srcAddress != "" || dstAddress != "") {
+		logger.Error("warning: SFTP host keys will not be verified, set -knownHosts to enable verification")
+	}
+	opts := transferOptions(logger, t0)
+	opts.Src, opts.Dst = sftpConfigs()
+	t, err := fs.NewTransfer(opts)
 	if err != nil {
-		log.Fatal(err)
+		fatal(exitConfig, err)
+	}
+	for _, cfg := range []*fs.SftpConfig{opts.Src, opts.Dst} {
+		if cfg != nil {
+			logger.Info("connected", "addr", cfg.Addr, "user", cfg.User)
+		}
+	}
+
+	// Stop gracefully on SIGINT or SIGTERM
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logger.Error("stopping", "signal", sig)
+		cancel()
+	}()
+
+	if daemon != "" {
+		// serve closes t, which may be replaced by a reload
+		if err := serve(ctx, daemon, t, logger); err != nil {
+			fatal(exitError, err)
+		}
+		return
+	}
+
+	configure(t, logger)
+	code, err := run(ctx, t, logger, os.Stdout, runStart)
+	if closeErr := t.Close(); err == nil && closeErr != nil {
+		code, err = exitError, closeErr
 	}
-	err = t.CopyEVTFiles()
 	if err != nil {
-		log.Fatal(err)
+		fatal(code, err)
+	}
+}
+
+// newLogger returns a Logger writing to w in the -logFormat format at the
+// level set by -quiet, -quietSummary, and -verbose
+func newLogger(w io.Writer) fs.Logger {
+	level := fs.LevelInfo
+	if quiet || quietSummary {
+		level = fs.LevelError
+	} else if verbose {
+		level = fs.LevelDebug
+	}
+	if logFormat == "json" {
+		return fs.NewJSONLogger(w, level)
+	}
+	return fs.NewTextLogger(w, level)
+}
+
+// transferOptions returns options for a Transfer of files from earliest on,
+// without SFTP connections
+func transferOptions(logger fs.Logger, earliest time.Time) fs.TransferOptions {
+	return fs.TransferOptions{
+		Srcroot:  srcRoots[0],
+		Srcroots: srcRoots[1:],
+		Dstroot:  dstRoot,
+		Log:      logger,
+		Earliest: earliest,
+		Latest:   t1,
+	}
+}
+
+// configure sets Transfer options from the command line
+func configure(t *fs.Transfer, logger fs.Logger) {
+	t.SFLDstroot = sflDstRoot
+	t.EVTDstroot = evtDstRoot
+	t.Verify = verify
+	t.DryRun = dryRun
+	// Each run has its own Transfer, so destination listings are only
+	// reused within it
+	t.CacheDestination = true
+	t.Move = move
+
+	t.AllowMissingSrc = allowMissingSrc
+
+	t.KeepGoing = keepGoing
+	t.ConfirmDelete = confirmDelete
+	t.Force = force
+	t.MinAge = minAge
+	t.IncludeLatest = includeLatest
+	t.LatestFromLastDir = latestFromLastDir
+	t.Include = includeRe
+	t.Exclude = excludeRe
+
+	t.MaxRetries = maxRetries
+	t.RetryDelay = retryDelay
+	t.FileTimeout = fileTimeout
+	t.StallTimeout = stallTimeout
+	t.GlobTimeout = globTimeout
+	t.RateLimit = rateLimitBytes
+	t.BufferSize = int(bufferSizeBytes)
+
+	t.Workers = workers
+	t.SrcWorkers = srcWorkers
+	t.DstWorkers = dstWorkers
+	t.MaxFiles = maxFiles
+	t.Order = copyOrder
+
+	t.SFLPattern = sflPattern
+	t.EVTPattern = evtPattern
+	t.ExtraPatterns = splitList(extraPatterns)
+	t.Days = days
+	t.RecentDays = recentDays
+	t.FollowSymlinks = followSymlinks
+	t.SkipUnchanged = skipUnchanged
+	t.RefreshStale = refreshStale
+	t.RefreshGzipMtime = refreshGzipMtime
+	t.MtimeTolerance = mtimeTolerance
+	t.GzipSFL = gzipSFL
+	t.CompressThreshold = compressThresholdBytes
+	t.GzipLevel = gzipLevel
+	t.ParallelGzip = pgzip
+	t.ParallelGzipSize = pgzipSizeBytes
+	t.GzipFlushInterval = gzipFlushIntervalBytes
+	t.NoTempFile = noTempFile
+	t.AppendSFL = appendSFL
+	t.CopyEmpty = copyEmpty
+	t.MaxFileSize = maxFileSizeBytes
+	t.HeadBytes = headBytesCount
+	t.HeadSuffix = headSuffix
+	t.CheckGzip = checkGzip
+	t.CheckGzipName = checkGzipName || fixGzipName
+	t.FixGzipName = fixGzipName
+	t.ValidateSFL = validateSFL
+	t.RequireSFLRecord = requireSflRecord
+	t.Decompress = decompress
+	t.PreserveTree = preserveTree
+	t.Flatten = flatten
+	t.NormalizeNames = normalizeNames
+	t.TZSeparator = tzSeparator
+	t.TempDir = tempDir
+	t.RequireAtomic = requireAtomic
+	t.NoClobber = noClobber
+	t.NoDowngrade = noDowngrade
+	t.Fsync = fsync
+	t.DirMode = dirModeBits
+	t.TempPrefix = tempPrefix
+
+	if verbose && !quiet {
+		t.Progress = logProgress(logger)
+	}
+	if statusAddr != "" && t.Progress == nil {
+		// Copies in progress are only tracked with a progress callback
+		t.Progress = func(string, int64, int64) {}
+	}
+	if postHook != "" {
+		t.PostCopy = runHook(postHook)
+		t.PostCopyFatal = hookFatal
+	}
+}
+
+// run runs the mode selected on the command line with t, writing any output
+// other than logs, e.g. from -list, to out. It returns the exit status and
+// an error if the run failed. t isn't closed.
+func run(ctx context.Context, t *fs.Transfer, logger fs.Logger, out io.Writer, runStart time.Time) (int, error) {
+	if check {
+		if err := t.CheckAccess(); err != nil {
+			return exitError, err
+		}
+		logger.Info("check passed")
+		return exitOK, nil
+	}
+
+	if selfTest {
+		if err := t.SelfTest(ctx); err != nil {
+			return exitError, err
+		}
+		logger.Info("self-test passed")
+		return exitOK, nil
+	}
+
+	if compressExisting {
+		if err := t.CompressExisting(); err != nil {
+			return exitError, err
+		}
+		return exitOK, nil
+	}
+
+	if checksumCache != "" {
+		cache, err := fs.ReadChecksumCache(checksumCache)
+		if err != nil {
+			return exitConfig, err
+		}
+		t.ChecksumCache = cache
+		defer func() {
+			if err := fs.WriteChecksumCache(checksumCache, cache); err != nil {
+				logger.Error("could not write checksum cache", "error", err)
+				return
+			}
+			logger.Debug("wrote checksum cache", "path", checksumCache, "entries", cache.Len())
+		}()
+	}
+
+	if verifyOnly {
+		err := verifyMirror(t)
+		if errors.Is(err, errMismatch) {
+			return exitMismatch, err
+		}
+		if err != nil {
+			return exitError, err
+		}
+		return exitOK, nil
+	}
+
+	if repair {
+		err := repairMirror(ctx, t)
+		if errors.Is(err, errMismatch) {
+			return exitMismatch, err
+		}
+		if err != nil {
+			return exitError, err
+		}
+		return exitOK, nil
+	}
+
+	if checkManifest != "" {
+		// No source connection was made
+		err := checkDstManifest(ctx, t, checkManifest)
+		if errors.Is(err, errMismatch) {
+			return exitMismatch, err
+		}
+		if err != nil {
+			return exitError, err
+		}
+		return exitOK, nil
+	}
+
+	if probeCompression > 0 {
+		// No destination connection was made
+		if err := printCompressionProbe(t, out, probeCompression); err != nil {
+			return exitError, err
+		}
+		return exitOK, nil
+	}
+
+	if listDays {
+		// No destination connection was made
+		if err := printDays(t, out); err != nil {
+			return exitError, err
+		}
+		return exitOK, nil
+	}
+
+	if list {
+		// No destination connection was made
+		if err := listFiles(t, out); err != nil {
+			return exitError, err
+		}
+		return exitOK, nil
+	}
+
+	if statusAddr != "" {
+		stop, err := serveStatus(statusAddr, t, runStart, logger)
+		if err != nil {
+			return exitConfig, err
+		}
+		defer stop()
+	}
+
+	// Append to any existing manifest so interrupted runs can be resumed
+	var manifestBuf *bufio.Writer
+	if manifest != "" && !dryRun {
+		manifestFile, err := os.OpenFile(manifest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return exitConfig, fmt.Errorf("could not open manifest: %v", err)
+		}
+		defer manifestFile.Close()
+		manifestBuf = bufio.NewWriter(manifestFile)
+		t.Manifest = manifestBuf
+		t.ManifestAlgo = manifestAlgo
+	}
+
+	// Stop after -totalTimeout
+	if totalTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, totalTimeout)
+		defer cancelTimeout()
+	}
+
+	if !dryRun {
+		// Fail early rather than partway through with ENOSPC
+		if err := t.CheckFreeSpace(minFreeSpaceBytes); err != nil {
+			return exitConfig, err
+		}
+		if requireAtomic {
+			if err := t.CheckAtomicRename(); err != nil {
+				return exitConfig, err
+			}
+		}
 	}
 
-	err = t.Close()
+	var passes []func(context.Context) error
+	if !onlyEvt {
+		passes = append(passes, t.CopySFLFilesContext)
+	}
+	if !onlySfl {
+		passes = append(passes, t.CopyEVTFilesContext)
+	}
+	if len(t.ExtraPatterns) > 0 {
+		passes = append(passes, t.CopyExtraFilesContext)
+	}
+	if opp {
+		passes = append(passes, t.CopyOPPFilesContext)
+	}
+	if vct {
+		passes = append(passes, t.CopyVCTFilesContext)
+	}
+	var failed []string
+	var err error
+	for _, pass := range passes {
+		err = pass(ctx)
+		if errors.Is(err, fs.ErrFilesFailed) {
+			// Only returned with -keepGoing, move on to the next pass
+			logger.Error("pass failed", "error", err)
+			failed = append(failed, err.Error())
+			err = nil
+			continue
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && len(failed) == 0 && syncDeletes {
+		// Only mirror deletions after a fully successful copy
+		err = t.DeleteOrphansContext(ctx)
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("transfer exceeded total timeout of %v: %w", totalTimeout, err)
+	}
+	if err == nil && len(failed) > 0 {
+		err = fmt.Errorf("%w: %v", fs.ErrFilesFailed, strings.Join(failed, ", "))
+	}
+	t.LogGzipReport()
+	if manifestBuf != nil {
+		// Flush manifest entries for files copied before any failure
+		if flushErr := manifestBuf.Flush(); flushErr != nil {
+			logger.Error("could not write manifest", "error", flushErr)
+		}
+	}
+	if destLog && !dryRun {
+		// Record files copied even if copying failed
+		if logErr := t.WriteRunLog(runStart, versionStr, sourceName()); logErr != nil {
+			logger.Error("could not write destination run log", "error", logErr)
+		}
+	}
+	if summaryJSON != "" {
+		// Write summary even if copying failed
+		if jsonErr := writeSummaryJSON(summaryJSON, t, runStart); jsonErr != nil {
+			logger.Error("could not write JSON summary", "error", jsonErr)
+		}
+	}
+	if metricsFile != "" || pushgateway != "" {
+		if metricsErr := writeMetrics(t, runStart); metricsErr != nil {
+			logger.Error("could not write metrics", "error", metricsErr)
+		}
+	}
+	if dryRun {
+		printPlan(t, out)
+	}
+	if quietSummary {
+		printSummary(t, out, runStart)
+	}
+	if reportNewest || newestFile != "" {
+		// Report how current the destination is even if copying failed
+		if newestErr := writeNewest(t, out); newestErr != nil {
+			logger.Error("could not report newest file timestamp", "error", newestErr)
+		}
+	}
+	if errors.Is(err, fs.ErrFilesFailed) {
+		return exitFilesFailed, err
+	}
 	if err != nil {
-		log.Fatal(err)
+		return exitError, err
+	}
+
+	if stateFile != "" && !dryRun {
+		// Only a fully successful run advances the state
+		ts, err := t.ResumeTime()
+		if err != nil {
+			return exitError, fmt.Errorf("could not determine resume time: %v", err)
+		}
+		if !ts.IsZero() {
+			if err := fs.WriteState(stateFile, ts); err != nil {
+				return exitError, err
+			}
+			logger.Info("wrote state file", "path", stateFile, "time", ts)
+		}
+	}
+
+	// Too few files usually means acquisition stopped upstream
+	if copied := t.Stats.Summary().Copied; minExpected > 0 && !dryRun && copied < minExpected {
+		return exitTooFew, fmt.Errorf("copied %d files, fewer than -minExpected %d", copied, minExpected)
 	}
+	return exitOK, nil
 }
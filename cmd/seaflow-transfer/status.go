@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/armbrustlab/seaflow-transfer/fs"
+)
+
+// statusSnapshot is the progress of a run served by -statusAddr
+type statusSnapshot struct {
+	Started        time.Time         `json:"started"`
+	ElapsedSeconds float64           `json:"elapsedSeconds"`
+	FilesCopied    int               `json:"filesCopied"`
+	FilesSkipped   int               `json:"filesSkipped"`
+	FilesFailed    int               `json:"filesFailed"`
+	FilesRemaining int               `json:"filesRemaining"` // selected so far but not yet copied
+	Current        []fs.FileProgress `json:"current"`
+	BytesRead      int64             `json:"bytesRead"` // by completed copies
+	BytesWritten   int64             `json:"bytesWritten"`
+	MBps           float64           `json:"MBps"` // source bytes read per second, including copies in progress
+}
+
+// status returns a snapshot of the progress of t, for a run which started at
+// start
+func status(t *fs.Transfer, start, now time.Time) statusSnapshot {
+	sum := t.Stats.Summary()
+	snap := statusSnapshot{
+		Started:        start,
+		ElapsedSeconds: now.Sub(start).Seconds(),
+		FilesCopied:    sum.Copied,
+		FilesSkipped:   sum.Skipped,
+		FilesFailed:    sum.Failed,
+		FilesRemaining: t.Stats.Remaining(),
+		Current:        t.Stats.Active(),
+		BytesRead:      sum.BytesRead,
+		BytesWritten:   sum.BytesWritten,
+	}
+	read := sum.BytesRead
+	for _, p := range snap.Current {
+		read += p.BytesCopied
+	}
+	if snap.ElapsedSeconds > 0 {
+		snap.MBps = float64(read) / 1e6 / snap.ElapsedSeconds
+	}
+	return snap
+}
+
+// serveStatus serves a JSON snapshot of the progress of t, for a run which
+// started at start, over HTTP on addr. It's read-only, any path returns the
+// snapshot. The returned function shuts the server down.
+func serveStatus(addr string, t *fs.Transfer, start time.Time, logger fs.Logger) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on -statusAddr %v: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status(t, start, time.Now()))
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: requestTimeout}
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("warning: status server stopped", "error", err)
+		}
+	}()
+	logger.Info("serving status", "addr", l.Addr().String())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("warning: could not shut down status server", "error", err)
+		}
+	}, nil
+}
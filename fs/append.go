@@ -0,0 +1,184 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// appendCheckSize is the size of the windows at the start and end of an
+// existing destination file compared to the source by AppendSFL
+const appendCheckSize = 64 * 1024
+
+// Appender is implemented by Fs backends which can open files for appending
+type Appender interface {
+	// Append opens the existing file at path for writing at its end
+	Append(path string) (File, error)
+}
+
+// Append opens the existing file at path for writing at its end
+func (l Localfs) Append(path string) (File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+// appendFile appends the new tail of source file path to its existing plain
+// destination copy at outpath, for AppendSFL. The source and destination are
+// first compared at the start and end of the destination file, up to
+// appendCheckSize bytes each, to check that the source has only been appended
+// to. It returns false without writing anything if a full copy is needed
+// instead, i.e. if the destination doesn't exist or isn't smaller than the
+// source, the contents differ, or Srcfs or Dstfs can't seek or append. Since
+// the append isn't atomic a failure may leave part of the tail at the
+// destination, which the next append continues from.
+func (t *Transfer) appendFile(ctx context.Context, path string, in File, inStat os.FileInfo, outpath string) (bool, error) {
+	appender, ok := t.Dstfs.(Appender)
+	if !ok {
+		return false, nil
+	}
+	if _, ok := in.(io.Seeker); !ok {
+		return false, nil
+	}
+	outStat, err := t.Dstfs.Stat(outpath)
+	if err != nil || outStat.Size() == 0 || outStat.Size() >= inStat.Size() {
+		return false, nil
+	}
+	offset := outStat.Size()
+	existing, err := t.Dstfs.Open(outpath)
+	if err != nil {
+		return false, transferError(StageCopy, path, outpath, fmt.Errorf("could not compare %v to %v: %w", path, outpath, err))
+	}
+	if _, ok := existing.(io.Seeker); !ok {
+		existing.Close()
+		return false, nil
+	}
+	match, err := matchPrefix(in, existing, offset)
+	existing.Close()
+	if err != nil {
+		return false, transferError(StageCopy, path, outpath, fmt.Errorf("could not compare %v to %v: %w", path, outpath, err))
+	}
+	if !match {
+		t.logger().Info("source file doesn't start with destination file contents, copying in full", "path", path, "dst", outpath)
+		// Rewind for the full copy
+		if _, err := in.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			return false, transferError(StageCopy, path, outpath, fmt.Errorf("could not seek input file %v: %w", path, err))
+		}
+		return false, nil
+	}
+
+	if _, err := in.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+		return false, transferError(StageCopy, path, outpath, fmt.Errorf("could not seek input file %v: %w", path, err))
+	}
+	var src io.Reader = ctxReader{ctx: ctx, r: watchReader(ctx, io.LimitReader(in, inStat.Size()-offset))}
+	if t.RateLimit > 0 {
+		src = rateLimitedReader{ctx: ctx, r: src, lim: t.rateLimiter()}
+	}
+	var progress *progressReader
+	if t.Progress != nil {
+		progress = newProgressReader(src, path, inStat.Size()-offset, t.reportProgress)
+		src = progress
+	}
+	out, err := appender.Append(outpath)
+	if err != nil {
+		return false, transferError(StageCreate, path, outpath, fmt.Errorf("could not open output file %v for append: %w", outpath, err))
+	}
+	n, err := t.copy(out, src)
+	if err == nil && t.Fsync {
+		err = t.syncFile(out)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, transferError(StageCopy, path, outpath, fmt.Errorf("could not append %v to %v: %w", path, outpath, err))
+	}
+	if progress != nil {
+		progress.done()
+	}
+	if err := t.Dstfs.Chtimes(outpath, time.Now().Local(), inStat.ModTime()); err != nil {
+		return false, transferError(StageChtimes, path, outpath, fmt.Errorf("could not update mtime for output file %v: %w", outpath, err))
+	}
+	mtimeExact := t.checkMtime(outpath, inStat.ModTime())
+
+	if t.Verify {
+		srcSum, err := checksum(t.Srcfs, path, false)
+		if err != nil {
+			return false, transferError(StageVerify, path, outpath, fmt.Errorf("could not compute checksum for %v: %w", path, err))
+		}
+		dstSum, err := checksum(t.Dstfs, outpath, false)
+		if err != nil {
+			return false, transferError(StageVerify, path, outpath, fmt.Errorf("could not compute checksum for %v: %w", outpath, err))
+		}
+		if !bytes.Equal(srcSum, dstSum) {
+			_ = t.Dstfs.Remove(outpath) // bad file is worse than no file
+			t.updateDestination(outpath, false)
+			return false, transferError(StageVerify, path, outpath, fmt.Errorf("checksum mismatch between %v and %v", path, outpath))
+		}
+	}
+	if t.ValidateSFL {
+		if err := t.checkSFL(path, outpath, false); err != nil {
+			return false, err
+		}
+	}
+	if t.Manifest != nil {
+		h, err := t.newManifestHash()
+		if err != nil {
+			return false, err
+		}
+		sum, err := hashFile(t.Dstfs, outpath, false, h)
+		if err != nil {
+			return false, transferError(StageVerify, path, outpath, fmt.Errorf("could not compute checksum for %v: %w", outpath, err))
+		}
+		if err := t.writeManifest(outpath, sum); err != nil {
+			return false, fmt.Errorf("could not write manifest entry for %v: %w", outpath, err)
+		}
+	}
+
+	t.logger().Info("appended", "path", path, "dst", outpath, "bytes", inStat.Size(), "bytesWritten", n)
+	return true, t.recordCopy(ctx, FileRecord{
+		Src:          path,
+		Dst:          outpath,
+		Size:         inStat.Size(),
+		BytesWritten: n,
+		ModTime:      inStat.ModTime(),
+		ModTimeExact: mtimeExact,
+	})
+}
+
+// matchPrefix returns true if the first size bytes of source file in match
+// destination file out at its start and end. Both must implement io.Seeker.
+func matchPrefix(in File, out File, size int64) (bool, error) {
+	n := int64(appendCheckSize)
+	if n > size {
+		n = size
+	}
+	for _, off := range []int64{0, size - n} {
+		a, err := readWindow(in, off, n)
+		if err != nil {
+			return false, err
+		}
+		b, err := readWindow(out, off, n)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(a, b) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// readWindow reads n bytes at offset off from f, which must implement
+// io.Seeker
+func readWindow(f File, off int64, n int64) ([]byte, error) {
+	if _, err := f.(io.Seeker).Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,234 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AuditResult counts source files checked by VerifyMirror
+type AuditResult struct {
+	Matched    int // destination file has the same contents
+	Mismatched int // destination file has different contents or is unreadable
+	Missing    int // no destination file
+}
+
+// OK returns true if every source file had a matching destination file
+func (r AuditResult) OK() bool {
+	return r.Mismatched == 0 && r.Missing == 0
+}
+
+// VerifyMirror compares each source file in files, e.g. from ListSFLFiles and
+// ListEVTFiles, to its destination file without writing anything. Destination
+// files are located as in CopyEVTFiles, ignoring ".gz" extensions and
+// timezone offset sign, and gzipped files on either side are decompressed
+// before comparing SHA-256 checksums. Destination checksums are taken from
+// ChecksumCache if set. Each mismatched or missing file is logged. The
+// returned error is only for failures to list destination files or read
+// source files.
+func (t *Transfer) VerifyMirror(ctx context.Context, files []string) (AuditResult, error) {
+	var res AuditResult
+	index := dstIndex{t: t}
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		dst, ok, err := index.find(path)
+		if err != nil {
+			return res, err
+		}
+		if !ok {
+			t.logger().Error("missing at destination", "path", path)
+			res.Missing++
+			continue
+		}
+		_, srcCompressed := FileKind(path)
+		srcSum, err := checksum(t.Srcfs, path, srcCompressed)
+		if err != nil {
+			return res, fmt.Errorf("could not compute checksum for %v: %w", path, err)
+		}
+		_, dstCompressed := FileKind(dst)
+		dstSum, err := t.dstChecksum(dst, dstCompressed)
+		if err != nil {
+			t.logger().Error("could not read destination file", "path", path, "dst", dst, "error", err)
+			res.Mismatched++
+			continue
+		}
+		if !bytes.Equal(srcSum, dstSum) {
+			t.logger().Error("checksum mismatch", "path", path, "dst", dst)
+			res.Mismatched++
+			continue
+		}
+		t.logger().Debug("verified", "path", path, "dst", dst)
+		res.Matched++
+	}
+	return res, nil
+}
+
+// dstIndex finds the destination files of source files, globbing each
+// destination directory once for consecutive files in the same directory
+type dstIndex struct {
+	t       *Transfer
+	dir     string
+	present map[string]string // canonical name to destination path
+}
+
+// find returns the destination file of source file path, located as in
+// CopyEVTFiles ignoring ".gz" extensions and timezone offset sign, and
+// whether there is one
+func (x *dstIndex) find(path string) (string, bool, error) {
+	kind, _ := FileKind(filepath.Base(path))
+	dstDir := x.t.dstDir(filepath.Dir(path), kind)
+	if x.present == nil || dstDir != x.dir {
+		matches, err := x.t.glob("destination", x.t.Dstfs, filepath.Join(dstDir, "*"))
+		if err != nil {
+			return "", false, fmt.Errorf("could not match destination files in %v: %w", dstDir, err)
+		}
+		x.present = make(map[string]string, len(matches))
+		for _, m := range matches {
+			x.present[canonicalName(m)] = m
+		}
+		x.dir = dstDir
+	}
+	dst, ok := x.present[canonicalName(path)]
+	return dst, ok, nil
+}
+
+// ManifestResult counts files checked by CheckManifest
+type ManifestResult struct {
+	Matched    int // destination file has the listed checksum
+	Mismatched int // destination file has a different checksum or is unreadable
+	Missing    int // listed file is not at the destination
+	Extra      int // destination SFL or EVT file is not listed
+}
+
+// OK returns true if the destination has exactly the files listed, with the
+// listed checksums
+func (r ManifestResult) OK() bool {
+	return r.Mismatched == 0 && r.Missing == 0 && r.Extra == 0
+}
+
+// CheckManifest checks destination files against manifest, e.g. one written
+// by the instrument or by Manifest, without accessing the source. The
+// manifest is in the format produced by sha256sum, md5sum, or sha1sum, with
+// paths relative to Dstroot. A listed file is found as-is or, if it was
+// gzipped in transit, with a ".gz" extension, in which case its decompressed
+// contents are checked. SHA-256 checksums are taken from ChecksumCache if set.
+// Destination SFL and EVT files which aren't listed, ignoring ".gz"
+// extensions and timezone offset sign, are extra. Each mismatched, missing,
+// or extra file is logged. The returned error is only for an invalid
+// manifest, wrapping ErrUnsafePath if a path is absolute or has a ".."
+// element, or failures to list destination files.
+func (t *Transfer) CheckManifest(ctx context.Context, manifest io.Reader) (ManifestResult, error) {
+	var res ManifestResult
+	entries, err := readManifest(manifest)
+	if err != nil {
+		return res, fmt.Errorf("could not read manifest: %w", err)
+	}
+	for _, e := range entries {
+		if err := checkManifestPath(e.path); err != nil {
+			return res, fmt.Errorf("could not read manifest: %w", err)
+		}
+	}
+	listed := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		path := filepath.Join(t.Dstroot, filepath.FromSlash(e.path))
+		listed[canonicalPath(path)] = true
+		dst, gzipped, err := t.manifestFile(path)
+		if err != nil {
+			t.logger().Error("missing at destination", "path", e.path, "error", err)
+			res.Missing++
+			continue
+		}
+		var sum []byte
+		if e.algo == "sha256" {
+			sum, err = t.dstChecksum(dst, gzipped)
+		} else {
+			sum, err = hashFile(t.Dstfs, dst, gzipped, manifestHashes[e.algo]())
+		}
+		if err != nil {
+			t.logger().Error("could not read destination file", "path", e.path, "dst", dst, "error", err)
+			res.Mismatched++
+			continue
+		}
+		if !bytes.Equal(sum, e.sum) {
+			t.logger().Error("checksum mismatch", "path", e.path, "dst", dst, "algo", e.algo)
+			res.Mismatched++
+			continue
+		}
+		t.logger().Debug("verified", "path", e.path, "dst", dst)
+		res.Matched++
+	}
+
+	files, err := t.dstFiles()
+	if err != nil {
+		return res, err
+	}
+	for _, path := range files {
+		if !listed[canonicalPath(path)] {
+			t.logger().Error("not in manifest", "dst", path)
+			res.Extra++
+		}
+	}
+	return res, nil
+}
+
+// checkManifestPath returns an error wrapping ErrUnsafePath if manifest path is
+// absolute or has a ".." element, since it could name a file outside Dstroot
+func checkManifestPath(path string) error {
+	if strings.HasPrefix(path, "/") || filepath.IsAbs(filepath.FromSlash(path)) {
+		return fmt.Errorf("%v: %w: absolute path", path, ErrUnsafePath)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return fmt.Errorf("%v: %w: \"..\" in path", path, ErrUnsafePath)
+		}
+	}
+	return nil
+}
+
+// manifestFile returns the destination file for manifest path, which is path
+// itself or path with a ".gz" extension, and whether it was gzipped in
+// transit
+func (t *Transfer) manifestFile(path string) (string, bool, error) {
+	_, err := t.Dstfs.Stat(path)
+	if err == nil {
+		return path, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", false, err
+	}
+	if _, gzErr := t.Dstfs.Stat(path + ".gz"); gzErr == nil {
+		return path + ".gz", true, nil
+	}
+	return "", false, err
+}
+
+// dstFiles returns sorted destination SFL and EVT files, gzipped or not
+func (t *Transfer) dstFiles() ([]string, error) {
+	var files []string
+	for _, kind := range []Kind{KindSFL, KindEVT} {
+		patterns := expandBraces(t.sflPattern())
+		if kind == KindEVT {
+			patterns = expandBraces(t.evtPattern())
+		}
+		for _, pattern := range patterns {
+			for _, p := range gzipVariants(t.dstPattern(pattern, kind)) {
+				matches, err := t.glob("destination", t.Dstfs, p)
+				if err != nil {
+					return nil, fmt.Errorf("could not match destination %v files: %w", kind, err)
+				}
+				files = append(files, matches...)
+			}
+		}
+	}
+	return sortUnique(files), nil
+}
@@ -0,0 +1,78 @@
+package fs
+
+// expandBraces expands shell-style brace alternatives in a glob pattern, which
+// filepath.Match and the Fs Glob methods don't support, e.g. "*.{sfl,sflz}"
+// becomes "*.sfl" and "*.sflz". Braces may be nested. As in the shell, braces
+// without a top-level comma, e.g. "{}" or "{a}", unmatched braces, and
+// backslash escaped braces are left as-is. Duplicate expansions are removed,
+// keeping the first.
+func expandBraces(pattern string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, p := range expand(pattern) {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func expand(pattern string) []string {
+	open, end, alts := findBraces(pattern)
+	if open < 0 {
+		return []string{pattern}
+	}
+	var out []string
+	for _, alt := range alts {
+		out = append(out, expand(pattern[:open]+alt+pattern[end+1:])...)
+	}
+	return out
+}
+
+// findBraces returns the positions of the first expandable pair of braces in
+// pattern and the alternatives between them, or -1 if there are none
+func findBraces(pattern string) (open int, end int, alts []string) {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++ // skip escaped character
+		case '{':
+			if end, commas := matchBrace(pattern, i); end >= 0 && len(commas) > 0 {
+				start := i + 1
+				for _, c := range commas {
+					alts = append(alts, pattern[start:c])
+					start = c + 1
+				}
+				alts = append(alts, pattern[start:end])
+				return i, end, alts
+			}
+		}
+	}
+	return -1, -1, nil
+}
+
+// matchBrace returns the position of the brace closing the one at open in
+// pattern, or -1 if there isn't one, and the positions of commas within it
+// which aren't inside nested braces
+func matchBrace(pattern string, open int) (end int, commas []int) {
+	depth := 0
+	for i := open; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, commas
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+	return -1, nil
+}
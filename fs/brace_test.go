@@ -0,0 +1,35 @@
+package fs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_expandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"????_???/*.sfl", []string{"????_???/*.sfl"}},
+		{"*.{sfl,sflz}", []string{"*.sfl", "*.sflz"}},
+		{"{a,b}/{c,d}", []string{"a/c", "a/d", "b/c", "b/d"}},
+		{"*.{sfl,{gz,bz2}}", []string{"*.sfl", "*.gz", "*.bz2"}},
+		{"x{a,b{c,d}e}y", []string{"xay", "xbcey", "xbdey"}},
+		{"*.sfl{,.gz}", []string{"*.sfl", "*.sfl.gz"}},
+		{"{a,a,b}", []string{"a", "b"}},
+		{"{}", []string{"{}"}},
+		{"{a}", []string{"{a}"}},
+		{"{{a,b}}", []string{"{a}", "{b}"}},
+		{"{a,b", []string{"{a,b"}},
+		{"a,b}", []string{"a,b}"}},
+		{`\{a,b}`, []string{`\{a,b}`}},
+		{`{a\,b,c}`, []string{`a\,b`, "c"}},
+		{"", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			assert.Equal(t, tt.want, expandBraces(tt.pattern))
+		})
+	}
+}
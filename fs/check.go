@@ -0,0 +1,69 @@
+package fs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CheckAccess checks that Srcroot, any Srcroots, Dstroot, and any SFLDstroot
+// and EVTDstroot exist and are directories, and that a file can be created and
+// removed in each destination root. No other files are read or written. Each
+// check which passes is logged. The returned error describes all failed checks.
+func (t *Transfer) CheckAccess() error {
+	var failed []string
+	for _, root := range t.srcroots() {
+		if err := checkDir(t.Srcfs, root); err != nil {
+			failed = append(failed, "source: "+err.Error())
+		} else {
+			t.logger().Info("source directory ok", "path", root)
+		}
+	}
+	for _, root := range t.dstroots() {
+		if err := checkDir(t.Dstfs, root); err != nil {
+			failed = append(failed, "destination: "+err.Error())
+		} else if err := t.checkWritable(root); err != nil {
+			failed = append(failed, "destination: "+err.Error())
+		} else {
+			t.logger().Info("destination directory ok", "path", root, "writable", true)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("access check failed: %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// checkDir returns an error if dir doesn't exist in fsys or isn't a directory
+func checkDir(fsys Fs, dir string) error {
+	info, err := fsys.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("could not stat %v: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	return nil
+}
+
+// checkWritable returns an error if a temp file can't be created, written, and
+// removed in Dstfs directory dir
+func (t *Transfer) checkWritable(dir string) error {
+	path := filepath.Join(dir, t.tempName("check"))
+	f, err := t.Dstfs.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create file in %v: %w", dir, err)
+	}
+	_, err = f.Write([]byte("seaflow-transfer access check\n"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = t.Dstfs.Remove(path)
+		return fmt.Errorf("could not write file in %v: %w", dir, err)
+	}
+	if err := t.Dstfs.Remove(path); err != nil {
+		return fmt.Errorf("could not remove test file %v: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package fs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ChecksumCache holds SHA-256 checksums of destination files, keyed by path
+// and valid while the file's size and modification time are unchanged, so
+// files which haven't changed aren't read again by VerifyMirror or
+// CheckManifest. Its methods are safe for concurrent use.
+type ChecksumCache struct {
+	mu      sync.Mutex
+	entries map[string]checksumEntry
+}
+
+// checksumEntry is the checksum of one destination file. Checksums of gzipped
+// files are of their decompressed contents.
+type checksumEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// NewChecksumCache returns an empty ChecksumCache
+func NewChecksumCache() *ChecksumCache {
+	return &ChecksumCache{entries: make(map[string]checksumEntry)}
+}
+
+// ReadChecksumCache returns the checksum cache in the local file at path
+// written by WriteChecksumCache. An empty cache is returned if path doesn't
+// exist, e.g. on a first run.
+func ReadChecksumCache(path string) (*ChecksumCache, error) {
+	c := NewChecksumCache()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read checksum cache: %w", err)
+	}
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		return nil, fmt.Errorf("could not parse checksum cache %v: %w", path, err)
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]checksumEntry) // file was "null"
+	}
+	return c, nil
+}
+
+// WriteChecksumCache writes c to the local file at path. The file is written
+// to a temp file and renamed into place so a crash never leaves a partial
+// cache.
+func WriteChecksumCache(path string, c *ChecksumCache) error {
+	c.mu.Lock()
+	b, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not write checksum cache: %w", err)
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".*")
+	if err != nil {
+		return fmt.Errorf("could not create checksum cache: %w", err)
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("could not write checksum cache: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of cached checksums
+func (c *ChecksumCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// lookup returns the cached checksum of path if its size and modification
+// time match info
+func (c *ChecksumCache) lookup(path string, info os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	c.mu.Unlock()
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	sum, err := hex.DecodeString(e.SHA256)
+	if err != nil {
+		return nil, false
+	}
+	return sum, true
+}
+
+// store caches sum as the checksum of path with info's size and modification
+// time, replacing any entry for an earlier version of the file
+func (c *ChecksumCache) store(path string, info os.FileInfo, sum []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = checksumEntry{Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: hex.EncodeToString(sum)}
+}
+
+// dstChecksum is like checksum for destination file path, but uses
+// ChecksumCache if set
+func (t *Transfer) dstChecksum(path string, gzipped bool) ([]byte, error) {
+	if t.ChecksumCache == nil {
+		return checksum(t.Dstfs, path, gzipped)
+	}
+	info, err := t.Dstfs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if sum, ok := t.ChecksumCache.lookup(path, info); ok {
+		t.logger().Debug("using cached checksum", "dst", path)
+		return sum, nil
+	}
+	sum, err := checksum(t.Dstfs, path, gzipped)
+	if err != nil {
+		return nil, err
+	}
+	t.ChecksumCache.store(path, info, sum)
+	return sum, nil
+}
+
+// cacheChecksum records sum, just computed from the contents of destination
+// file path, in ChecksumCache if set
+func (t *Transfer) cacheChecksum(path string, sum []byte) {
+	if t.ChecksumCache == nil {
+		return
+	}
+	info, err := t.Dstfs.Stat(path)
+	if err != nil {
+		t.logger().Debug("could not cache checksum", "dst", path, "error", err)
+		return
+	}
+	t.ChecksumCache.store(path, info, sum)
+}
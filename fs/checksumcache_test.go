@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumCache(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "fs-test-checksum-cache")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checksums.json")
+
+	cache, err := ReadChecksumCache(path)
+	assert.Nil(err, "missing cache file is not an error")
+	assert.Equal(0, cache.Len())
+
+	tr, src, dst := newMemTransfer()
+	tr.ChecksumCache = cache
+	mtime := time.Date(2016, 5, 12, 17, 0, 2, 123456789, time.UTC)
+	files := []string{
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-06-02+00-00",
+	}
+	for _, path := range files {
+		assert.Nil(src.WriteFile(path, []byte(path), mtime))
+	}
+	assert.Nil(tr.CopyEVTFiles())
+	res, err := tr.VerifyMirror(context.Background(), files[:2])
+	assert.Nil(err)
+	assert.Equal(AuditResult{Matched: 2}, res)
+	assert.Equal(2, cache.Len())
+
+	assert.Nil(WriteChecksumCache(path, cache))
+	cache, err = ReadChecksumCache(path)
+	assert.Nil(err)
+	assert.Equal(2, cache.Len())
+	entries, _ := ioutil.ReadDir(dir)
+	assert.Equal(1, len(entries), "no temp files left behind")
+
+	// Changes which keep the size and modification time aren't seen
+	tr.ChecksumCache = cache
+	a := "/dst/2016_133/2016-05-12T17-00-02+00-00.gz"
+	info, err := dst.Stat(a)
+	assert.Nil(err)
+	assert.Nil(dst.WriteFile(a, make([]byte, info.Size()), info.ModTime()))
+	res, err = tr.VerifyMirror(context.Background(), files[:2])
+	assert.Nil(err)
+	assert.Equal(AuditResult{Matched: 2}, res, "cached checksum used")
+
+	// Other changes invalidate the cached checksum
+	assert.Nil(dst.Chtimes(a, time.Now(), time.Now()))
+	res, err = tr.VerifyMirror(context.Background(), files[:2])
+	assert.Nil(err)
+	assert.Equal(AuditResult{Matched: 1, Mismatched: 1}, res)
+
+	assert.Nil(ioutil.WriteFile(path, []byte("garbage\n"), 0644))
+	_, err = ReadChecksumCache(path)
+	assert.NotNil(err)
+}
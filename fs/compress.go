@@ -0,0 +1,124 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// CompressExisting gzips uncompressed EVT files already at the destination,
+// e.g. from transfers made before EVT files were gzipped in transit, to
+// reclaim space. Each file is written to "<name>.gz" atomically with the
+// original's modification time, checked against the original, and only then
+// is the original removed. Files which already have a ".gz" copy or are
+// smaller than CompressThreshold are skipped. The source is not accessed.
+func (t *Transfer) CompressExisting() error {
+	return t.CompressExistingContext(context.Background())
+}
+
+// CompressExistingContext is like CompressExisting but stops early if ctx is
+// cancelled.
+func (t *Transfer) CompressExistingContext(ctx context.Context) error {
+	var files []string
+	for _, pattern := range expandBraces(t.evtPattern()) {
+		matches, err := t.glob("destination", t.Dstfs, t.dstPattern(pattern, KindEVT))
+		if err != nil {
+			return fmt.Errorf("could not match destination EVT files: %w", err)
+		}
+		files = append(files, matches...)
+	}
+	files = sortUnique(files)
+	compressed := 0
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if t.early(path) || t.late(path) {
+			continue
+		}
+		if _, err := t.Dstfs.Stat(path + ".gz"); err == nil {
+			t.logger().Error("warning: not compressing, gzipped copy already exists", "path", path)
+			continue
+		}
+		if t.CompressThreshold > 0 {
+			if info, err := t.Dstfs.Stat(path); err == nil && info.Size() < t.CompressThreshold {
+				t.logger().Debug("not compressing file smaller than threshold", "path", path, "bytes", info.Size())
+				continue
+			}
+		}
+		if t.DryRun {
+			t.logger().Info("would compress", "path", path)
+			continue
+		}
+		if err := t.compressFile(ctx, path); err != nil {
+			return err
+		}
+		compressed++
+	}
+	t.logger().Info("compressed destination files", "kind", "EVT", "count", compressed, "found", len(files))
+	return nil
+}
+
+// compressFile replaces destination file path with a gzipped copy at
+// "<path>.gz"
+func (t *Transfer) compressFile(ctx context.Context, path string) error {
+	dir, filename := filepath.Split(path)
+	tempdir := dir
+	if t.TempDir != "" {
+		tempdir = t.TempDir
+		if err := t.mkdirAll(tempdir); err != nil {
+			return fmt.Errorf("could not create temp dir %v: %w", tempdir, err)
+		}
+	}
+	outpath := path + ".gz"
+	outpathtemp := filepath.Join(tempdir, t.tempName(filename)) + ".gz"
+
+	in, err := t.Dstfs.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open %v: %w", path, err)
+	}
+	defer in.Close()
+	inStat, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat %v: %w", path, err)
+	}
+	mtime := inStat.ModTime()
+	h := sha256.New()
+	src := io.TeeReader(ctxReader{ctx: ctx, r: in}, h)
+	n, err := t.writeTemp(outpathtemp, src, true, filename, mtime, inStat.Size(), nil)
+	if err != nil {
+		return transferError(StageCopy, path, outpath, fmt.Errorf("could not compress %v: %w", path, err))
+	}
+
+	// Check the gzipped copy before the original is removed
+	sum, err := checksum(t.Dstfs, outpathtemp, true)
+	if err != nil {
+		_ = t.Dstfs.Remove(outpathtemp)
+		return fmt.Errorf("could not compute checksum for %v: %w", outpathtemp, err)
+	}
+	if !bytes.Equal(sum, h.Sum(nil)) {
+		_ = t.Dstfs.Remove(outpathtemp)
+		return fmt.Errorf("checksum mismatch between %v and %v", path, outpathtemp)
+	}
+
+	err = t.Dstfs.Rename(outpathtemp, outpath)
+	if err != nil && tempdir != dir && crossDevice(err) {
+		err = t.renameFallback(ctx, outpathtemp, outpath, mtime, err)
+	}
+	if err != nil {
+		_ = t.Dstfs.Remove(outpathtemp)
+		return fmt.Errorf("could not perform final rename from %v to %v: %w", outpathtemp, outpath, err)
+	}
+	t.updateDestination(outpath, true)
+	t.syncDir(dir)
+	t.checkMtime(outpath, mtime)
+	if err := t.Dstfs.Remove(path); err != nil {
+		return fmt.Errorf("could not remove %v after compressing: %w", path, err)
+	}
+	t.updateDestination(path, false)
+	t.logger().Info("compressed", "path", path, "dst", outpath, "bytes", inStat.Size(), "bytesWritten", n)
+	return nil
+}
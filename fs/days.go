@@ -0,0 +1,89 @@
+package fs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DayCount is the number of source files of each kind in a day-of-year
+// directory
+type DayCount struct {
+	Day string // directory name, e.g. 2016_133
+	SFL int
+	EVT int
+}
+
+// ListDays returns the number of source SFL and EVT files in each source
+// directory matched by the SFL and EVT patterns, sorted by directory name,
+// which for day-of-year directories is chronological. Directories with the
+// same name in different source roots are combined. Unlike ListEVTFiles,
+// every matching file is counted regardless of Earliest, Latest, and MinAge.
+// The destination is not accessed.
+func (t *Transfer) ListDays() ([]DayCount, error) {
+	sflPatterns, evtPatterns := t.sflPatterns(), t.evtPatterns()
+	patterns := append(append([]string{}, sflPatterns...), evtPatterns...)
+	dirs, err := t.sourceDirs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]*DayCount)
+	for _, dir := range dirs {
+		// Glob all patterns in a directory concurrently to save round trips
+		// over SFTP
+		var jobs []globJob
+		for _, pattern := range patterns {
+			_, filePattern := filepath.Split(pattern)
+			jobs = append(jobs, globJob{"source", t.Srcfs, filepath.Join(dir, filePattern)})
+		}
+		matches, err := t.globConcurrently(jobs)
+		if err != nil {
+			return nil, err
+		}
+		var sfl, evt []string
+		for i, m := range matches {
+			if i < len(sflPatterns) {
+				sfl = append(sfl, m...)
+			} else {
+				evt = append(evt, m...)
+			}
+		}
+		day := filepath.Base(t.realDir(dir))
+		c, ok := counts[day]
+		if !ok {
+			c = &DayCount{Day: day}
+			counts[day] = c
+		}
+		c.SFL += len(sortUnique(sfl))
+		c.EVT += len(sortUnique(evt))
+	}
+	days := make([]DayCount, 0, len(counts))
+	for _, c := range counts {
+		days = append(days, *c)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+	return days, nil
+}
+
+// parseDayDir parses a day-of-year directory name like "2016_133", returning
+// the start of the day in UTC
+func parseDayDir(name string) (time.Time, error) {
+	if len(name) != 8 || name[4] != '_' {
+		return time.Time{}, fmt.Errorf("%q is not a day-of-year directory name like 2016_133", name)
+	}
+	year, err := strconv.Atoi(name[:4])
+	var yday int
+	if err == nil {
+		yday, err = strconv.Atoi(name[5:])
+	}
+	if err != nil || year < 0 || yday < 1 || yday > 366 {
+		return time.Time{}, fmt.Errorf("%q is not a day-of-year directory name like 2016_133", name)
+	}
+	d := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, yday-1)
+	if d.Year() != year {
+		return time.Time{}, fmt.Errorf("%v has no day %v", year, yday)
+	}
+	return d, nil
+}
@@ -0,0 +1,94 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DirModer is implemented by Fs backends which can create directories with
+// specific permissions
+type DirModer interface {
+	// MkdirAllMode is like MkdirAll but sets the permissions of any
+	// directories it creates to mode, regardless of umask. Existing
+	// directories are unchanged.
+	MkdirAllMode(path string, mode os.FileMode) error
+}
+
+// MkdirAllMode creates path and any missing parents, setting the permissions
+// of those created to mode
+func (l Localfs) MkdirAllMode(path string, mode os.FileMode) error {
+	return mkdirAllMode(path, mode, os.Stat, l.MkdirAll, os.Chmod)
+}
+
+// mkdirAllMode creates path with mkdirAll, then sets the permissions of each
+// directory which didn't exist before to mode with chmod, parents first
+func mkdirAllMode(path string, mode os.FileMode, stat func(string) (os.FileInfo, error), mkdirAll func(string) error, chmod func(string, os.FileMode) error) error {
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := stat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if err := mkdirAll(path); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := chmod(missing[i], mode.Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates destination directory path and any missing parents, with
+// permissions DirMode if set. During a copy pass each directory is only
+// created once, saving round trips over SFTP for each file copied to the same
+// directory.
+func (t *Transfer) mkdirAll(path string) error {
+	path = filepath.Clean(path)
+	t.madeDirsMu.Lock()
+	made := t.madeDirs[path]
+	t.madeDirsMu.Unlock()
+	if made {
+		return nil
+	}
+	if err := t.makeDir(path); err != nil {
+		return err
+	}
+	t.madeDirsMu.Lock()
+	defer t.madeDirsMu.Unlock()
+	if t.madeDirs != nil {
+		t.madeDirs[path] = true
+	}
+	return nil
+}
+
+// cacheDirs starts recording directories made by mkdirAll if on is true, or
+// stops and forgets them
+func (t *Transfer) cacheDirs(on bool) {
+	t.madeDirsMu.Lock()
+	defer t.madeDirsMu.Unlock()
+	t.madeDirs = nil
+	if on {
+		t.madeDirs = make(map[string]bool)
+	}
+}
+
+// makeDir creates destination directory path for mkdirAll
+func (t *Transfer) makeDir(path string) error {
+	if t.DirMode == 0 {
+		return t.Dstfs.MkdirAll(path)
+	}
+	moder, ok := t.Dstfs.(DirModer)
+	if !ok {
+		t.dirModeOnce.Do(func() {
+			t.logger().Error("warning: destination can't set directory permissions, using its default")
+		})
+		return t.Dstfs.MkdirAll(path)
+	}
+	return moder.MkdirAllMode(path, t.DirMode)
+}
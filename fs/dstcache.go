@@ -0,0 +1,83 @@
+package fs
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// dstCache holds destination directory listings for CacheDestination
+type dstCache struct {
+	mu   sync.Mutex
+	dirs map[string]map[string]bool // directory to file names
+}
+
+// cachedDestination returns the cached names of files in destination
+// directory dir, or false if it hasn't been listed
+func (t *Transfer) cachedDestination(dir string) ([]string, bool) {
+	t.dstCache.mu.Lock()
+	defer t.dstCache.mu.Unlock()
+	files, ok := t.dstCache.dirs[filepath.Clean(dir)]
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// cacheDestination caches paths listed in destination directory dir and
+// returns their names
+func (t *Transfer) cacheDestination(dir string, paths []string) []string {
+	files := make(map[string]bool)
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		files[name] = true
+		names = append(names, name)
+	}
+	t.dstCache.mu.Lock()
+	defer t.dstCache.mu.Unlock()
+	if t.dstCache.dirs == nil {
+		t.dstCache.dirs = make(map[string]map[string]bool)
+	}
+	t.dstCache.dirs[filepath.Clean(dir)] = files
+	return names
+}
+
+// updateDestination records that destination file path was written, or
+// removed if present is false, in any cached listing of its directory
+func (t *Transfer) updateDestination(path string, present bool) {
+	if !t.CacheDestination {
+		return
+	}
+	dir, name := filepath.Split(path)
+	t.dstCache.mu.Lock()
+	defer t.dstCache.mu.Unlock()
+	files, ok := t.dstCache.dirs[filepath.Clean(dir)]
+	if !ok {
+		return
+	}
+	if present {
+		files[name] = true
+	} else {
+		delete(files, name)
+	}
+}
+
+// matchesFilePattern returns true if name matches the file part of any of
+// patterns, with or without a ".gz" extension
+func matchesFilePattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		_, filePattern := filepath.Split(pattern)
+		for _, p := range gzipVariants(filePattern) {
+			if ok, _ := filepath.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
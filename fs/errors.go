@@ -0,0 +1,61 @@
+package fs
+
+import "errors"
+
+// Stage is the step of a file copy at which it failed
+type Stage int
+
+const (
+	StageOpen    Stage = iota + 1 // opening or stating the source file
+	StageCreate                   // creating destination directories or files
+	StageCopy                     // reading, converting, or writing file data
+	StageChtimes                  // setting the destination modification time
+	StageRename                   // moving the temp file to its final path
+	StageVerify                   // checking the copy, e.g. with Verify or CheckGzip
+)
+
+var stageNames = map[Stage]string{
+	StageOpen:    "open",
+	StageCreate:  "create",
+	StageCopy:    "copy",
+	StageChtimes: "chtimes",
+	StageRename:  "rename",
+	StageVerify:  "verify",
+}
+
+func (s Stage) String() string {
+	if name, ok := stageNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// TransferError is returned by CopyFile when copying a file fails, so callers
+// can tell at which Stage it failed with errors.As. Err describes the failure
+// and wraps the underlying error, so errors.Is works as usual, e.g. with
+// os.ErrNotExist or ErrInvalidGzip.
+type TransferError struct {
+	Stage Stage
+	Src   string // source path
+	Dst   string // final destination path
+	Err   error
+}
+
+func (e *TransferError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// transferError returns a TransferError for a failed copy of src to dst at
+// stage. If err already wraps a TransferError, e.g. from writeTemp, its more
+// specific stage is kept.
+func transferError(stage Stage, src, dst string, err error) error {
+	var inner *TransferError
+	if errors.As(err, &inner) {
+		stage = inner.Stage
+	}
+	return &TransferError{Stage: stage, Src: src, Dst: dst, Err: err}
+}
@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"context"
+	"time"
+)
+
+// FileResult is the outcome of one file's copy, sent on Transfer.Events
+type FileResult struct {
+	Src string
+	// Dst, Bytes, BytesWritten, and Gzipped are only set for files which were
+	// copied, even if a later PostCopy hook failed
+	Dst          string
+	Bytes        int64 // source file size
+	BytesWritten int64
+	Gzipped      bool // gzipped in transit
+	// Duration includes all attempts and the delays between them
+	Duration time.Duration
+	// Skipped is the reason the file wasn't copied, if it was skipped
+	Skipped string
+	Err     error // non-nil if the copy failed
+}
+
+// resultKey is the context key of the *copyResult which recordCopy fills in
+// for the copy's FileResult
+type resultKey struct{}
+
+// copyResult holds the FileRecord of a successful copy
+type copyResult struct {
+	rec    FileRecord
+	copied bool
+}
+
+func (r *copyResult) get() (FileRecord, bool) {
+	return r.rec, r.copied
+}
+
+// withResult returns a context in which recordCopy stores the FileRecord of
+// a successful copy in the returned copyResult
+func withResult(ctx context.Context) (context.Context, *copyResult) {
+	res := &copyResult{}
+	return context.WithValue(ctx, resultKey{}, res), res
+}
+
+// storeResult stores rec for the copy's FileResult, if ctx is from withResult
+func storeResult(ctx context.Context, rec FileRecord) {
+	if res, ok := ctx.Value(resultKey{}).(*copyResult); ok {
+		res.rec, res.copied = rec, true
+	}
+}
+
+// sendResult sends r on Events without blocking. If the channel is full the
+// result is dropped and counted in Stats as EventsDropped.
+func (t *Transfer) sendResult(r FileResult) {
+	if t.Events == nil {
+		return
+	}
+	select {
+	case t.Events <- r:
+	default:
+		t.Stats.addEventDropped()
+		t.logger().Debug("dropped file result event, channel full", "path", r.Src)
+	}
+}
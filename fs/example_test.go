@@ -0,0 +1,126 @@
+package fs_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/armbrustlab/seaflow-transfer/fs"
+)
+
+// memfs is a minimal in-memory fs.Fs
+type memfs map[string]*memfile
+
+type memfile struct {
+	bytes.Buffer
+	name    string
+	modTime time.Time
+}
+
+func (f *memfile) Close() error               { return nil }
+func (f *memfile) Stat() (os.FileInfo, error) { return f, nil }
+func (f *memfile) Name() string               { return filepath.Base(f.name) }
+func (f *memfile) Size() int64                { return int64(f.Len()) }
+func (f *memfile) Mode() os.FileMode          { return 0644 }
+func (f *memfile) ModTime() time.Time         { return f.modTime }
+func (f *memfile) IsDir() bool                { return false }
+func (f *memfile) Sys() interface{}           { return nil }
+
+func (m memfs) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	f, ok := m[path]
+	if !ok {
+		return os.ErrNotExist
+	}
+	f.modTime = mtime
+	return nil
+}
+
+func (m memfs) Close() error { return nil }
+
+func (m memfs) Create(path string) (fs.File, error) {
+	m[path] = &memfile{name: path, modTime: time.Now()}
+	return m[path], nil
+}
+
+func (m memfs) Glob(pattern string) ([]string, error) {
+	var matches []string
+	for path := range m {
+		if ok, err := filepath.Match(pattern, path); err != nil {
+			return nil, err
+		} else if ok {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (m memfs) MkdirAll(path string) error { return nil }
+
+func (m memfs) Open(path string) (fs.File, error) {
+	f, ok := m[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	// Read from a copy so the stored contents aren't consumed
+	return &memfile{Buffer: *bytes.NewBuffer(f.Bytes()), name: f.name, modTime: f.modTime}, nil
+}
+
+func (m memfs) Remove(path string) error {
+	if _, ok := m[path]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m, path)
+	return nil
+}
+
+func (m memfs) Rename(oldname, newname string) error {
+	f, ok := m[oldname]
+	if !ok {
+		return os.ErrNotExist
+	}
+	f.name = newname
+	m[newname] = f
+	delete(m, oldname)
+	return nil
+}
+
+func (m memfs) Stat(path string) (os.FileInfo, error) {
+	f, ok := m[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
+
+// A custom Fs can be used as the source or destination of a Transfer
+func ExampleFs() {
+	src := memfs{}
+	f, _ := src.Create("/src/2016_133/2016-05-12T17-00-02+00-00.sfl")
+	f.Write([]byte("sfl data"))
+	dst := memfs{}
+	discard := log.New(ioutil.Discard, "", 0)
+	t := &fs.Transfer{
+		Srcfs:   src,
+		Srcroot: "/src",
+		Dstfs:   dst,
+		Dstroot: "/dst",
+		Debug:   discard,
+		Info:    discard,
+		Error:   discard,
+	}
+
+	err := t.CopyFile("/src/2016_133/2016-05-12T17-00-02+00-00.sfl", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for path, f := range dst {
+		fmt.Printf("%v: %v\n", path, f.String())
+	}
+	// Output: /dst/2016_133/2016-05-12T17-00-02+00-00.sfl: sfl data
+}
@@ -0,0 +1,106 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotSupported is returned by optional Fs operations which a backend
+// can't perform
+var ErrNotSupported = errors.New("operation not supported")
+
+// FreeSpacer is implemented by Fs backends which can report free space
+type FreeSpacer interface {
+	// FreeSpace returns the number of bytes available on the filesystem
+	// containing path
+	FreeSpace(path string) (int64, error)
+}
+
+// EstimateBytes returns the total size of the SFL and EVT source files which
+// would be copied by CopySFLFiles and CopyEVTFiles. Since EVT files are
+// gzipped in transit this is an upper bound on the bytes written, except in
+// Decompress mode. Files larger than MaxFileSize aren't counted.
+func (t *Transfer) EstimateBytes() (int64, error) {
+	sfl, err := t.ListSFLFiles()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	add := func(files []string) error {
+		for _, path := range files {
+			info, err := t.Srcfs.Stat(path)
+			if err != nil {
+				return fmt.Errorf("could not stat input file %v: %w", path, err)
+			}
+			if t.MaxFileSize > 0 && info.Size() > t.MaxFileSize {
+				continue
+			}
+			total += info.Size()
+		}
+		return nil
+	}
+	if err := add(sfl); err != nil {
+		return 0, err
+	}
+	patterns := t.evtPatterns()
+	dirs, err := t.sourceDirs(patterns)
+	if err != nil {
+		return 0, err
+	}
+	latest, err := t.latestFiles(dirs, patterns, t.LatestFromLastDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, dir := range dirs {
+		evt, _, err := t.selectNewFiles(dir, KindEVT, patterns, latest)
+		if err != nil {
+			return 0, err
+		}
+		if err := add(evt); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// CheckFreeSpace returns an error if the destination doesn't have room for
+// the files to be copied plus margin bytes. The check is skipped with a log
+// message if Dstfs can't report free space.
+func (t *Transfer) CheckFreeSpace(margin int64) error {
+	spacer, ok := t.Dstfs.(FreeSpacer)
+	if !ok {
+		t.logger().Info("skipping free space check, not supported by destination")
+		return nil
+	}
+	free, err := freeSpace(spacer, t.Dstroot)
+	if errors.Is(err, ErrNotSupported) {
+		t.logger().Info("skipping free space check, not supported by destination", "error", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get free space for %v: %w", t.Dstroot, err)
+	}
+	need, err := t.EstimateBytes()
+	if err != nil {
+		return err
+	}
+	t.logger().Info("checked destination free space", "free", free, "need", need, "margin", margin)
+	if free < need+margin {
+		return fmt.Errorf("not enough free space at %v: %v bytes available, need %v bytes plus %v bytes margin", t.Dstroot, free, need, margin)
+	}
+	return nil
+}
+
+// freeSpace returns free space for path, or its closest existing parent since
+// the destination root may not have been created yet
+func freeSpace(spacer FreeSpacer, path string) (int64, error) {
+	for {
+		free, err := spacer.FreeSpace(path)
+		if !errors.Is(err, os.ErrNotExist) || filepath.Dir(path) == path {
+			return free, err
+		}
+		path = filepath.Dir(path)
+	}
+}
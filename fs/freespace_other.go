@@ -0,0 +1,9 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package fs
+
+// FreeSpace is not supported on this platform
+func (l Localfs) FreeSpace(path string) (int64, error) {
+	return 0, ErrNotSupported
+}
@@ -0,0 +1,16 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package fs
+
+import "syscall"
+
+// FreeSpace returns the number of bytes available to unprivileged users on
+// the filesystem containing path
+func (l Localfs) FreeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
@@ -0,0 +1,2838 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/pgzip"
+	"golang.org/x/time/rate"
+)
+
+// Default glob patterns for SeaFlow files, relative to a root directory.
+// Files are organized in day-of-year directories named like 2016_133.
+const (
+	DefaultSFLPattern = "????_???/*.sfl"
+	DefaultEVTPattern = "????_???/????-??-??T??-??-??[\\-\\+]??-??"
+	OPPPattern        = "????_???/*.opp"
+	DefaultTempPrefix = "._seaflow-transfer_"
+	DefaultHeadSuffix = ".head"
+	VCTPattern        = "????_???/*.vct"
+)
+
+// PartialSuffix is appended to a destination file's name for a marker file
+// which exists while it's written with Transfer.NoTempFile
+const PartialSuffix = ".partial"
+
+// ErrInvalidGzip is wrapped by errors for gzip source files which fail
+// Transfer.CheckGzip validation
+var ErrInvalidGzip = errors.New("invalid gzip data")
+
+// ErrFilesFailed is wrapped by errors returned from copy passes when
+// Transfer.KeepGoing is set and one or more files could not be copied.
+var ErrFilesFailed = errors.New("some files could not be copied")
+
+// ErrPostCopy is wrapped by errors for files whose Transfer.PostCopy hook
+// failed when Transfer.PostCopyFatal is set
+var ErrPostCopy = errors.New("post-copy hook failed")
+
+// ErrUnsafePath is wrapped by errors for source files whose destination path
+// would be outside Transfer.Dstroot, e.g. because a directory name reported by
+// an untrusted SFTP server is "..".
+var ErrUnsafePath = errors.New("destination path escapes destination root")
+
+// File is an open file in an Fs. Files returned by Fs.Open are only read and
+// files returned by Fs.Create are only written.
+type File interface {
+	Close() error
+	Read(b []byte) (int, error)
+	Stat() (os.FileInfo, error)
+	Write(b []byte) (int, error)
+}
+
+// Fs represents an abstract filesytem. Methods should behave like their
+// counterparts in the os and path/filepath packages, and errors for missing
+// files should satisfy errors.Is(err, os.ErrNotExist). Methods may be called
+// concurrently. Alternative backends can implement Fs and be used as
+// Transfer.Srcfs or Transfer.Dstfs.
+type Fs interface {
+	Chtimes(path string, atime time.Time, mtime time.Time) error
+	// Close releases any resources, e.g. network connections, held by the Fs
+	Close() error
+	// Create creates or truncates the file at path for writing
+	Create(path string) (File, error)
+	Glob(pattern string) (matches []string, err error)
+	MkdirAll(path string) error
+	// Open opens the file at path for reading
+	Open(path string) (File, error)
+	Remove(path string) error
+	// Rename renames oldname to newname, replacing newname if it exists
+	Rename(oldname, newname string) error
+	Stat(path string) (os.FileInfo, error)
+}
+
+// Localfs provides methods to manipulate files local filesystem
+type Localfs struct{}
+
+// NewLocalfs creates a new Localfs struct
+func NewLocalfs() (Localfs, error) {
+	return Localfs{}, nil
+}
+
+func (l Localfs) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+func (l Localfs) Close() error {
+	return nil
+}
+
+func (l Localfs) Create(path string) (File, error) {
+	return os.Create(path)
+}
+
+func (l Localfs) Glob(pattern string) (matches []string, err error) {
+	return filepath.Glob(pattern)
+}
+
+func (l Localfs) MkdirAll(path string) error {
+	return os.MkdirAll(path, os.ModeDir|0755)
+}
+
+func (l Localfs) Open(path string) (File, error) {
+	return os.Open(path)
+}
+
+func (l Localfs) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (l Localfs) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (l Localfs) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// Transfer provides methods to copy SeaFlow data from a source to a destination
+// location
+type Transfer struct {
+	Srcfs    Fs
+	Srcroot  string
+	Dstfs    Fs
+	Dstroot  string
+	Log      Logger      // if nil, messages are written to Debug, Info, and Error
+	Debug    *log.Logger // Deprecated: use Log
+	Info     *log.Logger // Deprecated: use Log
+	Error    *log.Logger // Deprecated: use Log
+	rand     *rand.Rand  // for temp file names
+	Earliest time.Time   // earliest file time to transfer
+	Latest   time.Time   // transfer files before this time
+	Verify   bool        // compare source and destination checksums after copy
+	DryRun   bool        // log what would be copied without writing anything, see Plan
+	// Copies which fail with transient network or IO errors, e.g. a reset
+	// connection or a short read, are retried up to MaxRetries times,
+	// waiting RetryDelay before the first retry and doubling the wait after
+	// each attempt
+	MaxRetries int
+	RetryDelay time.Duration
+	// FileTimeout limits the time spent copying a single file, including
+	// retries. A copy blocked in a read or write when it times out returns
+	// once that call does. 0 means no limit.
+	FileTimeout time.Duration
+	// StallTimeout cancels a copy attempt, removing its temp file, if no
+	// source bytes are read for this long, e.g. on a half-dead connection,
+	// and fails it with ErrStalled, which is retried. Unlike FileTimeout it
+	// doesn't depend on file size. Only reading and writing file data is
+	// watched, so RateLimit must allow a read within StallTimeout. 0 means
+	// no limit.
+	StallTimeout time.Duration
+	// GlobTimeout limits the time spent on each directory listing, so a
+	// hung mount or stalled SFTP server fails with ErrScanTimeout rather
+	// than blocking forever. 0 means no limit.
+	GlobTimeout time.Duration
+	// Move deletes source files after they've been successfully copied
+	Move bool
+	// MinAge skips EVT, OPP, and VCT source files modified less than MinAge
+	// ago, which may still be open for writing. The most recent EVT file is
+	// always skipped regardless, unless IncludeLatest is set.
+	MinAge time.Duration
+	// Include and Exclude, if set, filter SFL, EVT, OPP, and VCT source
+	// files by base filename, e.g. to skip one instrument's files by a serial
+	// number in their names. Files matching Exclude are skipped, as are files
+	// not matching Include. Filters are applied after the most recent EVT
+	// file is chosen, so they can't make an older file the one skipped.
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+	// IncludeLatest copies the most recent EVT file, which is normally
+	// skipped since it may still be open for writing. Only use this for
+	// static archives which are no longer being written to.
+	IncludeLatest bool
+	// LatestFromLastDir looks for the most recent EVT file, which is skipped,
+	// only in the last day-of-year directory of each source root. If that
+	// directory has no EVT files, e.g. because it was just created, no file
+	// is skipped and earlier days are copied entirely. By default the most
+	// recent file in the last directory with any EVT files is skipped, which
+	// may be the last file of an earlier day.
+	LatestFromLastDir bool
+	// Force copies EVT, OPP, and VCT files even if they're already present
+	// at the destination
+	Force bool
+	// ConfirmDelete arms DeleteOrphans. Without it files which would be
+	// deleted are only logged.
+	ConfirmDelete bool
+	// KeepGoing logs per-file copy errors and continues with remaining files
+	// rather than stopping at the first error. Copy passes then return an
+	// error wrapping ErrFilesFailed if any file failed.
+	KeepGoing bool
+	// SFLPattern and EVTPattern override DefaultSFLPattern and
+	// DefaultEVTPattern if not empty. See CopyFile for how the destination
+	// path is derived from a matched source path. Patterns may use shell-style
+	// braces for alternatives, e.g. "????_???/*.{sfl,sflz}", as may
+	// ExtraPatterns. SFL patterns also match gzipped files with a ".gz"
+	// extension.
+	SFLPattern string
+	EVTPattern string
+	// AllowMissingSrc treats a missing source root as having no files.
+	// Otherwise copy passes fail if a source root doesn't exist, e.g.
+	// because of a typo or an unmounted volume, rather than silently finding
+	// no files.
+	AllowMissingSrc bool
+	// Srcroots are additional source roots whose files are merged with those
+	// in Srcroot, e.g. data directories for two instruments on one host.
+	// Destination paths are derived from each file's own parent directory as
+	// usual, and the most recent file is found separately in each root.
+	Srcroots []string
+	// ExtraPatterns are filename patterns for other files, e.g. instrument
+	// metadata, copied by CopyExtraFiles. They're matched in the same source
+	// directories as SFL files.
+	ExtraPatterns []string
+	// Days, if not empty, restricts copying to source day-of-year directories
+	// with these names, e.g. "2016_133". Only these directories are globbed,
+	// in place of the last directory element of each pattern. A warning is
+	// logged for days with no source directory. Patterns without a directory
+	// part are unaffected.
+	Days     []string
+	daysOnce sync.Once
+	// RecentDays, if > 0, restricts copying to the RecentDays most recent
+	// source day-of-year directories, chronologically by their YYYY_DOY
+	// names. Directories with the same name in different source roots count
+	// as one day. Directories whose names aren't valid day-of-year names are
+	// skipped with a warning. The most recent EVT file and all other
+	// filtering apply only within these directories.
+	RecentDays     int
+	recentDaysOnce sync.Once
+	// FollowSymlinks also copies from symlinks to day-of-year directories
+	// whose own names don't match the directory pattern, e.g. a "current"
+	// symlink to a directory outside Srcroot. Files are written to the
+	// destination directory named for the symlink's target, not the symlink.
+	// Srcfs must implement SymlinkEvaluator.
+	FollowSymlinks bool
+	symlinkOnce    sync.Once
+	symlinkMu      sync.Mutex
+	symlinkDirs    map[string]string // symlink to path with target's name
+	// PreserveTree keeps the full directory path from Srcroot to each source
+	// file at the destination, for patterns with more than one directory
+	// level. Otherwise only the file's parent directory is kept.
+	PreserveTree bool
+	// SFLDstroot and EVTDstroot, if set, are destination roots for SFL and
+	// EVT files instead of Dstroot, e.g. to keep SFL files on a faster
+	// volume. Other files and the run log are still written to Dstroot,
+	// manifest paths are relative to it, and CheckFreeSpace only checks it.
+	SFLDstroot string
+	EVTDstroot string
+	// Flatten writes all files directly in Dstroot, without their source
+	// directories. If two source files copied by one pass have the same
+	// name, only the first is copied and a warning is logged. Flatten
+	// overrides PreserveTree.
+	Flatten bool
+	// NormalizeNames writes timestamped files with TZSeparator as their
+	// timezone offset sign, whichever sign the source used, so the
+	// destination is consistently named. Files are matched to destination
+	// files regardless of sign, so both variants are never copied.
+	NormalizeNames bool
+	// TZSeparator is the timezone offset sign for NormalizeNames, "+" or "-".
+	// DefaultTZSeparator is used if empty.
+	TZSeparator string
+	// SkipUnchanged skips files whose destination already exists with the
+	// same size and modification time as the source, within MtimeTolerance.
+	// Only applies to files which aren't gzipped in transit, since gzipped
+	// sizes never match.
+	SkipUnchanged bool
+	// RefreshStale re-copies EVT, OPP, and VCT files already present at the
+	// destination if the source file was modified after the destination
+	// copy, by more than MtimeTolerance. The destination's modification time
+	// is the later of its file mtime and, for ".gz" files, its gzip header
+	// ModTime. Otherwise files are matched by name only.
+	RefreshStale bool
+	// RefreshGzipMtime re-copies EVT, OPP, and VCT files already present at
+	// the destination as ".gz" files which were gzipped in transit, if the
+	// gzip header ModTime differs from the source file's modification time
+	// by more than MtimeTolerance, e.g. after a run copied an older version
+	// of a file which was later corrected at the source. Only the gzip header
+	// is read.
+	RefreshGzipMtime bool
+	// MtimeTolerance is how far apart source and destination modification
+	// times can be and still count as the same for SkipUnchanged and
+	// RefreshStale, since SFTP servers, gzip headers, and some filesystems
+	// only keep whole seconds. DefaultMtimeTolerance is used if 0.
+	MtimeTolerance time.Duration
+	// GzipSFL gzips SFL files in transit like EVT files, so they're written
+	// as "<name>.sfl.gz". SFL files which are already gzipped are copied
+	// as-is. Ignored in Decompress mode.
+	GzipSFL bool
+	// GzipLevel is the gzip compression level for files gzipped in transit,
+	// from gzip.BestSpeed (1) to gzip.BestCompression (9). The default level
+	// is used if 0. See ProbeCompression to choose one.
+	GzipLevel int
+	// ParallelGzip gzips files in transit with a parallel encoder which
+	// compresses 1MB blocks on all CPUs at once, for when a single core
+	// can't keep up with the network. It's also used for files of at least
+	// ParallelGzipSize bytes if that's > 0. Output is a standard gzip stream
+	// with the same header. Each parallel copy buffers a block per CPU, so
+	// memory use grows with Workers.
+	ParallelGzip     bool
+	ParallelGzipSize int64
+	// GzipFlushInterval, if > 0, flushes the gzip stream to the destination
+	// file after every GzipFlushInterval uncompressed bytes, so a consumer
+	// tailing the file can decompress what's been written so far. Each flush
+	// slightly reduces the compression ratio. This is only useful with
+	// NoTempFile, since temp files aren't renamed to their final path until
+	// they're complete.
+	GzipFlushInterval int64
+	// NoTempFile writes files directly to their final path rather than to a
+	// temp file which is renamed once complete, for consumers which read
+	// files as they're written. Readers may see partial files, and a
+	// partial file is removed if its copy fails. TempDir is ignored. While a
+	// file is written an empty marker file named with PartialSuffix is kept
+	// next to it, so a file left partial by a crash is copied again by the
+	// next run rather than skipped as present. A destination file which
+	// already exists, e.g. one copied again with Force or Repair, is still
+	// replaced through a temp file so a failed copy doesn't lose it. Atomic
+	// NoClobber isn't possible.
+	NoTempFile bool
+	// CompressThreshold, if > 0, is the source file size in bytes below
+	// which files are copied as-is instead of being gzipped in transit,
+	// since gzipping tiny files saves little and can even make them larger.
+	// Such files are written without ".gz" and are still recognized as
+	// present at the destination by later transfers. CompressExisting also
+	// skips them.
+	CompressThreshold int64
+	// AppendSFL copies only the new bytes of SFL files which have been
+	// appended to since they were last copied, appending them to the
+	// destination file, if Srcfs files can seek and Dstfs implements
+	// Appender. Files which don't start with the destination file's contents,
+	// e.g. because they were rewritten, are copied in full. Appends aren't
+	// atomic, see appendFile. Ignored for gzipped SFL files and with GzipSFL.
+	AppendSFL bool
+	// CheckGzip validates ".gz" source files, which are copied as-is, by
+	// decompressing them as they're copied. Invalid files are not copied.
+	CheckGzip bool
+	// CheckGzipName checks the gzip header Name, which gunzip -N restores,
+	// against the original file name. Files gzipped in transit with a
+	// different Name fail before being renamed into place. For ".gz" source
+	// files copied as-is a warning is logged if the header has a Name other
+	// than the file name without ".gz". Files whose header can't be read
+	// fail.
+	CheckGzipName bool
+	// FixGzipName rewrites the header Name of ".gz" source files copied
+	// as-is when it differs from the file name without ".gz", instead of
+	// only warning. Any header CRC is dropped. Implies CheckGzipName.
+	FixGzipName bool
+	// ValidateSFL checks each SFL file after it's copied, failing and
+	// removing it if it doesn't end with a newline or its last line has a
+	// different number of columns than its header, e.g. after a short read
+	// which didn't return an error. Only the header and the end of the file
+	// are read. The latest SFL file isn't checked, since it may still be
+	// written. Failed files are retried like other short reads.
+	ValidateSFL bool
+	// ChecksumCache, if set, holds destination file checksums so VerifyMirror
+	// and CheckManifest don't read files again which haven't changed since
+	// they were last checked. Files checked by Verify are always read, and
+	// their checksums added.
+	ChecksumCache *ChecksumCache
+	// RequireSFLRecord only copies EVT files which are recorded in an SFL
+	// file in the same source directory, which the instrument does once it
+	// has finished writing them. This is more reliable than skipping the
+	// latest file or MinAge when acquisition may stop uncleanly.
+	RequireSFLRecord bool
+	// Decompress inverts the usual handling of gzip files. ".gz" source files
+	// are decompressed in transit and written without the extension, with
+	// modification time taken from the gzip header. Other files, including
+	// EVT files, are copied as-is. The EVT pass also matches ".gz" source
+	// files.
+	Decompress bool
+	// TempDir, if set, is a directory in Dstfs where temp files are written
+	// instead of the final file's directory. If the final rename from TempDir
+	// fails because it's on a different filesystem, the temp file is copied
+	// to its final path, synced, and removed, which isn't atomic, unless
+	// RequireAtomic is set.
+	TempDir string
+	// RequireAtomic fails copies whose final rename fails because TempDir is
+	// on a different filesystem, rather than falling back to a non-atomic
+	// copy. Without TempDir, files are renamed within their directory, which
+	// is atomic for Localfs and for Sftpfs if the server supports
+	// posix-rename@openssh.com, see CheckAtomicRename.
+	RequireAtomic bool
+	// NoClobber never replaces existing destination files, e.g. SFL files
+	// or files copied with Force. Files already at the destination are
+	// skipped. A file which appears while a copy is in progress, e.g. from
+	// another run sharing the destination, fails the copy with an error
+	// wrapping ErrClobber. The final rename only fails atomically if Dstfs is
+	// an ExclusiveRenamer, and not with NoTempFile.
+	NoClobber bool
+	// NoDowngrade skips copying a file, with a warning, if the destination
+	// file's modification time is more than MtimeTolerance after the
+	// source's, so an older source, e.g. after clock skew or when copying
+	// again from an old backup, never replaces newer data. For files
+	// decompressed in transit the time in the gzip header is compared.
+	NoDowngrade bool
+	// Fsync flushes each destination file to stable storage before it's
+	// renamed to its final path, and the final directory after, so a file
+	// with its final name is complete even after a power loss. Without it a
+	// crash soon after a copy can leave an empty or partial file with its
+	// final name. Directories are only synced for Localfs. SFTP files are
+	// only synced if the server advertises fsync@openssh.com, as OpenSSH
+	// does, which is checked on connecting. There's no portable way to sync
+	// SFTP directories.
+	Fsync bool
+	// DirMode, if not 0, sets the permissions of destination directories
+	// created by a transfer, regardless of umask, e.g. 0775 for group
+	// writable directories. Otherwise Localfs uses 0755 before umask and
+	// Sftpfs the server default. Dstfs must implement DirModer.
+	DirMode     os.FileMode
+	dirModeOnce sync.Once
+	mtimeOnce   sync.Once
+	// destination directories created or found by mkdirAll in the current
+	// copy pass, which aren't created again since a pass never removes
+	// directories
+	madeDirsMu sync.Mutex
+	madeDirs   map[string]bool
+	// TempPrefix overrides DefaultTempPrefix as the start of temp file names
+	// if not empty
+	TempPrefix string
+	// CopyEmpty copies zero-byte source files, which are otherwise skipped
+	// with a warning
+	CopyEmpty bool
+	// MaxFileSize, if > 0, is the largest source file in bytes which is
+	// copied. Larger files, e.g. a runaway EVT file from a misbehaving
+	// instrument, are skipped with a warning so one file can't fill the
+	// destination.
+	MaxFileSize int64
+	// HeadBytes, if > 0, copies only the first HeadBytes bytes of each file,
+	// decompressed bytes if decompressing, to sample data formats over a slow
+	// link. This is for diagnostics, not mirroring. Partial files are written
+	// with HeadSuffix appended to their names so they're never mistaken for
+	// complete files, and every selected source file is copied regardless of
+	// what's at the destination. AppendSFL, SkipUnchanged, CheckGzip,
+	// ValidateSFL, and Manifest are ignored. Move never removes sources of
+	// partial files, and partial files are counted in Stats but not listed in
+	// Summary.Files, so they don't advance ResumeTime or appear in run logs.
+	// The PostCopy hook isn't called for them.
+	HeadBytes int64
+	// HeadSuffix overrides DefaultHeadSuffix as the suffix of HeadBytes files
+	HeadSuffix string
+	// BufferSize sets the size of copy buffers and the destination write
+	// buffer. If > 0, reads from the source are pipelined with writes to the
+	// destination, which keeps both ends busy when both are high latency SFTP
+	// connections. Default buffering is used if 0. 64KB to 4MB is reasonable,
+	// with larger sizes helping on links with a high bandwidth-delay product.
+	BufferSize int
+	// MaxFiles, if > 0, is the maximum number of files each copy pass copies,
+	// e.g. to limit how much new data downstream processing receives per run.
+	// Files are selected in Order, leaving the rest for later runs. Since
+	// all SFL files are copied every run, a MaxFiles below the number of SFL
+	// files means the newest SFL files are never copied, or the oldest with
+	// OrderNewestFirst.
+	MaxFiles int
+	// Order is the order in which each copy pass copies files, by the time
+	// in their names, one source directory at a time. With more than one
+	// Worker, copies finish in roughly this order.
+	Order Order
+	// Workers is the number of files copied concurrently by each copy pass,
+	// 1 if 0. SrcWorkers and DstWorkers further limit the number of copies
+	// reading from Srcfs and writing to Dstfs at once, e.g. to avoid
+	// overloading a small instrument SFTP server while writing to a larger
+	// one in parallel. They're unlimited if 0.
+	Workers    int
+	SrcWorkers int
+	DstWorkers int
+	srcSem     chan struct{}
+	dstSem     chan struct{}
+	semOnce    sync.Once
+	// Progress, if set, is called as each file is copied with the number of
+	// bytes read from the source so far and the source file size. These are
+	// input bytes, i.e. uncompressed bytes for files gzipped in transit. It's
+	// called once when a copy starts, at most every progressInterval as it
+	// proceeds, and once when the copy completes. It's called concurrently for
+	// different files if Workers > 1.
+	Progress func(path string, bytesCopied, totalBytes int64)
+	// PostCopy, if set, is called after each file is copied, e.g. to register
+	// new files elsewhere as soon as they land. It's not called in DryRun
+	// mode. Errors are logged and ignored unless PostCopyFatal is set, in
+	// which case the copy pass stops, even if KeepGoing is set. It's called
+	// concurrently for different files if Workers > 1.
+	PostCopy      func(ctx context.Context, r FileRecord) error
+	PostCopyFatal bool
+	// Events, if set, receives a FileResult after each file is copied,
+	// skipped, or fails, e.g. for a live dashboard. Results are sent without
+	// blocking copy workers, so if the channel is full a result is dropped
+	// and counted in Stats as EventsDropped. Use a buffered channel sized for
+	// bursts and keep receiving until the copy passes return. Results aren't
+	// sent for copies planned in DryRun mode. The channel isn't closed.
+	Events chan<- FileResult
+	// RateLimit caps the total rate of reads from the source in bytes per
+	// second across all copies. 0 means unlimited.
+	RateLimit   int64
+	limiter     *rate.Limiter
+	limiterOnce sync.Once
+	// Stats accumulates statistics across all copy passes
+	Stats Stats
+	// CacheDestination lists each destination directory once and shares the
+	// listing between the EVT, OPP, and VCT passes, rather than matching each
+	// pattern separately in every pass, to save round trips over SFTP. The
+	// cache is updated as files are copied and deleted, but not for changes
+	// made by anything else, so it should only be set for a Transfer used
+	// for one run.
+	CacheDestination bool
+	dstCache         dstCache
+	// Manifest, if set, receives an entry for each copied file. See
+	// writeManifest for the format.
+	Manifest io.Writer
+	// ManifestAlgo is the hash algorithm of Manifest entries, one of
+	// ManifestAlgos. The default is "sha256".
+	ManifestAlgo string
+	manifestMu   sync.Mutex
+	// source files which may still be open for writing and should never be
+	// removed by Move
+	live map[string]bool
+	// dry-run totals for the current copy pass, and files for all passes
+	planMu            sync.Mutex
+	plannedFiles      int
+	plannedBytes      int64
+	plannedOverwrites int
+	plan              DryRunPlan
+	randMu            sync.Mutex
+}
+
+// CopySFLFiles copies SFL files from source to destination. Files are
+// identifed as <root>/<day-of-year-directory>/<filename>. All SFL files are
+// copied since they may have been appended to, unless SkipUnchanged is set and
+// the destination file has the same size and modification time. Files are
+// gzipped in transit if GzipSFL is set. SFL files already gzipped at the
+// source, e.g. "*.sfl.gz", are copied as-is, unless the same file is also
+// present uncompressed.
+func (t *Transfer) CopySFLFiles() error {
+	return t.CopySFLFilesContext(context.Background())
+}
+
+// CopySFLFilesContext is like CopySFLFiles but stops early if ctx is
+// cancelled, removing any partially written temp file.
+func (t *Transfer) CopySFLFilesContext(ctx context.Context) error {
+	// Copy all SFL files, see SkipUnchanged
+	patterns := t.sflPatterns()
+	dirs, err := t.sourceDirs(patterns)
+	if err != nil {
+		return err
+	}
+	// The most recent SFL file may still be appended to, so it should never
+	// be moved.
+	latest, err := t.latestFiles(dirs, patterns, false)
+	if err != nil {
+		return err
+	}
+	for path := range latest {
+		t.markLive(path)
+	}
+	t.resetPlan()
+	found := 0
+	pool := t.newCopyPool(ctx, t.GzipSFL)
+dirs:
+	for _, dir := range t.orderDirs(dirs) {
+		srcFiles, err := t.globDir(dir, patterns)
+		if err != nil {
+			_, _ = pool.wait()
+			return err
+		}
+		srcFiles = t.dropCompressedDups(srcFiles)
+		t.orderFiles(srcFiles)
+		found += len(srcFiles)
+		for _, path := range srcFiles {
+			if t.early(path) || t.late(path) || t.filtered(path) {
+				t.Stats.addSkipped(1)
+				continue
+			}
+			if !pool.add(path) {
+				break dirs
+			}
+		}
+	}
+	failed, err := pool.wait()
+	if err != nil {
+		return err
+	}
+	t.logger().Info("found source files", "kind", "SFL", "count", found)
+	t.logPlan("SFL")
+	pool.logCounts("SFL")
+	return t.passFailed("SFL", failed)
+}
+
+// CopyEVTFiles copies EVT files from source to destination. Files are gzip
+// compressed before copying to desination. Source files are identifed as
+// <root>/<day-of-year-directory>/<filename>, with no extension. Files present
+// in both source and destination are not copied. ".gz" extensions are stripped
+// from destination files before matching to source file names. The most recent
+// EVT file by filename timestamp is not copied since it may still be open for
+// writing, unless IncludeLatest is set.
+func (t *Transfer) CopyEVTFiles() error {
+	return t.CopyEVTFilesContext(context.Background())
+}
+
+// CopyEVTFilesContext is like CopyEVTFiles but stops early if ctx is
+// cancelled, removing any partially written temp file.
+func (t *Transfer) CopyEVTFilesContext(ctx context.Context) error {
+	// Transfer all EVT files except last (most recent)
+	return t.copyNewFiles(ctx, KindEVT, t.evtPatterns(), true)
+}
+
+// CopyOPPFiles copies OPP files from source to destination. Source files are
+// identified as <root>/<day-of-year-directory>/<filename>.opp[.gz]. Like EVT
+// files, OPP files are gzip compressed in transit if necessary and files
+// already present at the destination are not copied. OPP files are written
+// atomically so the most recent file is copied.
+func (t *Transfer) CopyOPPFiles() error {
+	return t.CopyOPPFilesContext(context.Background())
+}
+
+// CopyOPPFilesContext is like CopyOPPFiles but stops early if ctx is
+// cancelled, removing any partially written temp file.
+func (t *Transfer) CopyOPPFilesContext(ctx context.Context) error {
+	return t.copyNewFiles(ctx, KindOPP, gzipVariants(OPPPattern), false)
+}
+
+// CopyExtraFiles copies files matching ExtraPatterns from source to
+// destination. Like SFL files, all matching files are copied as-is every time.
+// Files also matched by the SFL or EVT patterns are left to CopySFLFiles and
+// CopyEVTFiles so they're copied once per run.
+func (t *Transfer) CopyExtraFiles() error {
+	return t.CopyExtraFilesContext(context.Background())
+}
+
+// CopyExtraFilesContext is like CopyExtraFiles but stops early if ctx is
+// cancelled, removing any partially written temp file.
+func (t *Transfer) CopyExtraFilesContext(ctx context.Context) error {
+	if len(t.ExtraPatterns) == 0 {
+		return nil
+	}
+	var patterns []string
+	for _, sflPattern := range expandBraces(t.sflPattern()) {
+		dayPattern, _ := filepath.Split(sflPattern)
+		for _, pattern := range t.ExtraPatterns {
+			for _, p := range expandBraces(pattern) {
+				patterns = append(patterns, filepath.Join(dayPattern, p))
+			}
+		}
+	}
+	dirs, err := t.sourceDirs(patterns)
+	if err != nil {
+		return err
+	}
+	t.resetPlan()
+	found := 0
+	pool := t.newCopyPool(ctx, false)
+dirs:
+	for _, dir := range t.orderDirs(dirs) {
+		srcFiles, err := t.globDir(dir, patterns)
+		if err != nil {
+			_, _ = pool.wait()
+			return err
+		}
+		t.orderFiles(srcFiles)
+		for _, path := range srcFiles {
+			if t.copiedByPass(path) {
+				t.logger().Debug("skipping extra file matched by SFL or EVT pattern", "path", path)
+				continue
+			}
+			found++
+			if !pool.add(path) {
+				break dirs
+			}
+		}
+	}
+	failed, err := pool.wait()
+	if err != nil {
+		return err
+	}
+	t.logger().Info("found source files", "kind", "extra", "count", found)
+	t.logPlan("extra")
+	pool.logCounts("extra")
+	return t.passFailed("extra", failed)
+}
+
+// copiedByPass returns true if source file path matches the SFL or EVT
+// patterns
+func (t *Transfer) copiedByPass(path string) bool {
+	for _, root := range t.srcroots() {
+		for _, pattern := range append(t.sflPatterns(), t.evtPatterns()...) {
+			if ok, _ := filepath.Match(filepath.Join(root, pattern), path); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CopyVCTFiles copies VCT files from source to destination. Source files are
+// identified as <root>/<day-of-year-directory>/<filename>.vct[.gz]. Like EVT
+// files, VCT files are gzip compressed in transit if necessary and files
+// already present at the destination are not copied. VCT files are written
+// atomically so the most recent file is copied.
+func (t *Transfer) CopyVCTFiles() error {
+	return t.CopyVCTFilesContext(context.Background())
+}
+
+// CopyVCTFilesContext is like CopyVCTFiles but stops early if ctx is
+// cancelled, removing any partially written temp file.
+func (t *Transfer) CopyVCTFilesContext(ctx context.Context) error {
+	return t.copyNewFiles(ctx, KindVCT, gzipVariants(VCTPattern), false)
+}
+
+// copyNewFiles copies kind files matching patterns relative to root which are
+// not already present at the destination, gzipping them in transit. If
+// skipLatest is true the most recent file is never copied, unless
+// IncludeLatest is set.
+func (t *Transfer) copyNewFiles(ctx context.Context, kind Kind, patterns []string, skipLatest bool) error {
+	skipLatest = skipLatest && !t.IncludeLatest
+	dirs, err := t.sourceDirs(patterns)
+	if err != nil {
+		return err
+	}
+	var latest map[string]bool
+	if skipLatest {
+		// Copy all but the latest file in each root since it's most likely
+		// currently being appended to
+		latest, err = t.latestFiles(dirs, patterns, t.LatestFromLastDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Select and copy files one directory at a time so only one directory's
+	// file list is held in memory
+	t.resetPlan()
+	var total selection
+	pool := t.newCopyPool(ctx, true)
+dirs:
+	for _, dir := range t.orderDirs(dirs) {
+		files, sel, err := t.selectNewFiles(dir, kind, patterns, latest)
+		if err != nil {
+			_, _ = pool.wait()
+			return err
+		}
+		t.orderFiles(files)
+		total.add(sel)
+		t.Stats.addSkipped(sel.dups + sel.early + sel.late + sel.fresh + sel.filtered + sel.unrecorded)
+		for _, path := range files {
+			if !pool.add(path) {
+				break dirs
+			}
+		}
+	}
+	failed, err := pool.wait()
+	if err != nil {
+		return err
+	}
+	if skipLatest && total.found > 1 {
+		t.Stats.addSkipped(len(latest))
+	}
+	t.logSelection(kind.String(), total, skipLatest)
+	t.logPlan(kind.String())
+	pool.logCounts(kind.String())
+
+	return t.passFailed(kind.String(), failed)
+}
+
+// logSelection logs counts of source files found and skipped in a copy pass
+func (t *Transfer) logSelection(kind string, sel selection, skipLatest bool) {
+	t.logger().Info("found source files", "kind", kind, "count", sel.found)
+	if sel.found == 0 || (skipLatest && sel.found == 1) {
+		return
+	}
+	if t.Force {
+		t.logger().Info("force mode, not skipping files already at destination", "kind", kind, "count", sel.forced)
+	} else {
+		t.logger().Info("skipped files already at destination", "kind", kind, "count", sel.dups)
+		if t.RefreshStale {
+			t.logger().Info("re-copying files newer than destination", "kind", kind, "count", sel.stale)
+		}
+		if t.RefreshGzipMtime {
+			t.logger().Info("re-copying files with a different gzip header time", "kind", kind, "count", sel.gzipMtime)
+		}
+	}
+	if !t.Earliest.IsZero() {
+		t.logger().Info("skipped files earlier than start", "kind", kind, "count", sel.early, "earliest", t.Earliest)
+	}
+	if !t.Latest.IsZero() {
+		t.logger().Info("skipped files not earlier than end", "kind", kind, "count", sel.late, "latest", t.Latest)
+	}
+	if t.MinAge > 0 {
+		t.logger().Info("skipped recently modified files", "kind", kind, "count", sel.fresh, "minAge", t.MinAge)
+	}
+	if t.Include != nil || t.Exclude != nil {
+		t.logger().Info("skipped files by include or exclude filter", "kind", kind, "count", sel.filtered)
+	}
+	if t.RequireSFLRecord && kind == KindEVT.String() {
+		t.logger().Info("skipped files not yet recorded in an SFL file", "kind", kind, "count", sel.unrecorded)
+	}
+	if skipLatest {
+		t.logger().Info("skipped the most recent file", "kind", kind)
+	}
+}
+
+// selection counts source files considered by selectNewFiles
+type selection struct {
+	found    int // source files matched
+	dups     int // skipped as already present at the destination
+	forced   int // already present at the destination but selected by Force
+	early    int // skipped as earlier than Earliest
+	late     int // skipped as not earlier than Latest
+	fresh    int // skipped as modified less than MinAge ago
+	filtered int // skipped by Include or Exclude
+	stale    int // already present at the destination but selected by RefreshStale
+	// already present at the destination but selected by RefreshGzipMtime
+	gzipMtime int
+	// EVT files skipped as not yet recorded in an SFL file with
+	// RequireSFLRecord
+	unrecorded int
+}
+
+func (s *selection) add(o selection) {
+	s.found += o.found
+	s.dups += o.dups
+	s.forced += o.forced
+	s.early += o.early
+	s.late += o.late
+	s.fresh += o.fresh
+	s.filtered += o.filtered
+	s.stale += o.stale
+	s.gzipMtime += o.gzipMtime
+	s.unrecorded += o.unrecorded
+}
+
+// sourceDirs returns the sorted source directories which may contain files
+// matching patterns, i.e. matches for the directory part of each pattern in
+// each source root. Patterns with no directory part, e.g. "*.sfl", are matched
+// in the root itself.
+func (t *Transfer) sourceDirs(patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var dirs []string
+	foundDays := make(map[string]bool)
+	for _, root := range t.srcroots() {
+		if ok, err := t.checkSrcroot(root); !ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, pattern := range patterns {
+			dirPattern, _ := filepath.Split(pattern)
+			if dirPattern == "" {
+				if !seen[root] {
+					seen[root] = true
+					dirs = append(dirs, root)
+				}
+				continue
+			}
+			dirPatterns := []string{dirPattern}
+			if len(t.Days) > 0 {
+				dirPatterns = dayPatterns(dirPattern, t.Days)
+			}
+			for _, p := range dirPatterns {
+				matches, err := t.glob("source", t.Srcfs, filepath.Join(root, p))
+				if err != nil {
+					return nil, fmt.Errorf("could not match source directories with %v: %w", p, err)
+				}
+				if t.FollowSymlinks {
+					links, err := t.linkedDirs(root, p)
+					if err != nil {
+						return nil, err
+					}
+					matches = append(matches, links...)
+				}
+				for _, dir := range matches {
+					real := t.realDir(dir)
+					foundDays[filepath.Base(real)] = true
+					// A symlink to a directory also found by name is
+					// skipped
+					if !seen[dir] && !seen[real] {
+						seen[dir] = true
+						dirs = append(dirs, dir)
+					}
+				}
+			}
+		}
+	}
+	if len(t.Days) > 0 {
+		t.daysOnce.Do(func() {
+			for _, day := range t.Days {
+				if !foundDays[day] {
+					t.logger().Error("warning: day-of-year directory not found at source", "day", day)
+				}
+			}
+		})
+	}
+	// Sort by real name so symlinked directories are in time order
+	sort.Slice(dirs, func(i, j int) bool {
+		return t.realDir(dirs[i]) < t.realDir(dirs[j])
+	})
+	if t.RecentDays > 0 {
+		dirs = t.recentDirs(dirs)
+	}
+	return dirs, nil
+}
+
+// recentDirs returns the source directories in sorted dirs which are in the
+// RecentDays most recent day-of-year directories. Source roots, for patterns
+// without a directory part, are always kept.
+func (t *Transfer) recentDirs(dirs []string) []string {
+	roots := make(map[string]bool)
+	for _, root := range t.srcroots() {
+		roots[root] = true
+	}
+	var invalid []string
+	var days []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if roots[dir] {
+			continue
+		}
+		day := filepath.Base(t.realDir(dir))
+		if _, err := parseDayDir(day); err != nil {
+			invalid = append(invalid, dir)
+			continue
+		}
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	t.recentDaysOnce.Do(func() {
+		for _, dir := range invalid {
+			t.logger().Error("warning: skipping source directory without a day-of-year name", "path", dir)
+		}
+	})
+	sort.Strings(days)
+	if len(days) > t.RecentDays {
+		days = days[len(days)-t.RecentDays:]
+	}
+	keep := make(map[string]bool, len(days))
+	for _, day := range days {
+		keep[day] = true
+	}
+	var recent []string
+	for _, dir := range dirs {
+		if roots[dir] || keep[filepath.Base(t.realDir(dir))] {
+			recent = append(recent, dir)
+		}
+	}
+	return recent
+}
+
+// checkSrcroot returns false and an error if source root doesn't exist or
+// isn't a directory. If root doesn't exist and AllowMissingSrc is set it
+// returns false and no error, i.e. there are no source files in root.
+func (t *Transfer) checkSrcroot(root string) (bool, error) {
+	err := checkDir(t.Srcfs, root)
+	if err == nil {
+		return true, nil
+	}
+	if t.AllowMissingSrc && errors.Is(err, os.ErrNotExist) {
+		t.logger().Info("source root not found, no files to copy", "path", root)
+		return false, nil
+	}
+	return false, fmt.Errorf("source root: %w", err)
+}
+
+// srcroots returns Srcroot followed by Srcroots
+func (t *Transfer) srcroots() []string {
+	return append([]string{t.Srcroot}, t.Srcroots...)
+}
+
+// srcrootOf returns the source root containing source directory dir, the
+// most specific if roots are nested
+func (t *Transfer) srcrootOf(dir string) string {
+	root := t.Srcroot
+	best := -1
+	for _, r := range t.srcroots() {
+		rel, err := filepath.Rel(r, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(filepath.Clean(r)) > best {
+			root, best = r, len(filepath.Clean(r))
+		}
+	}
+	return root
+}
+
+// dayPatterns returns dirPattern with its last element replaced by each of
+// days which it matches
+func dayPatterns(dirPattern string, days []string) []string {
+	parent, last := filepath.Split(filepath.Clean(dirPattern))
+	var patterns []string
+	for _, day := range days {
+		if ok, _ := filepath.Match(last, day); ok {
+			patterns = append(patterns, filepath.Join(parent, day))
+		}
+	}
+	return patterns
+}
+
+// globDir returns sorted source files in dir, a directory returned by
+// sourceDirs, which match the filename part of patterns
+func (t *Transfer) globDir(dir string, patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		_, filePattern := filepath.Split(pattern)
+		matches, err := t.glob("source", t.Srcfs, filepath.Join(dir, filePattern))
+		if err != nil {
+			return nil, fmt.Errorf("could not match source files with %v: %w", pattern, err)
+		}
+		files = append(files, matches...)
+	}
+	return sortUnique(files), nil
+}
+
+// dropCompressedDups returns files without gzipped files which are also
+// present uncompressed, e.g. "x.sfl.gz" if "x.sfl" is present, since they're
+// the same logical file and would be copied to the same destination with
+// GzipSFL. The uncompressed file is kept since it may have been appended to.
+// Dropped files are counted as skipped.
+func (t *Transfer) dropCompressedDups(files []string) []string {
+	present := make(map[string]bool, len(files))
+	for _, path := range files {
+		present[path] = true
+	}
+	kept := files[:0]
+	for _, path := range files {
+		if _, compressed := FileKind(path); compressed && present[trimGzip(path)] {
+			t.logger().Debug("skipping gzipped file also present uncompressed", "path", path)
+			t.Stats.addSkipped(1)
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+// sortUnique sorts paths and removes duplicates, e.g. files matched by more
+// than one pattern
+func sortUnique(paths []string) []string {
+	sort.Strings(paths)
+	out := paths[:0]
+	for i, path := range paths {
+		if i == 0 || path != paths[i-1] {
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+// latestFile returns the last source file matching patterns in dirs, or "" if
+// there are none. A lexicographical sort orders timestamped SeaFlow files
+// chronologically, so this is the most recent file. Directories are searched
+// from last to first, so normally only the last directory is globbed. If
+// lastDirOnly is true only the last directory is searched.
+func (t *Transfer) latestFile(dirs []string, patterns []string, lastDirOnly bool) (string, error) {
+	last := 0
+	if lastDirOnly && len(dirs) > 0 {
+		last = len(dirs) - 1
+	}
+	for i := len(dirs) - 1; i >= last; i-- {
+		files, err := t.globDir(dirs[i], patterns)
+		if err != nil {
+			return "", err
+		}
+		if len(files) > 0 {
+			return files[len(files)-1], nil
+		}
+	}
+	return "", nil
+}
+
+// latestFiles returns the latest file, as found by latestFile, among dirs in
+// each source root, since each root may have its own file being written
+func (t *Transfer) latestFiles(dirs []string, patterns []string, lastDirOnly bool) (map[string]bool, error) {
+	byRoot := make(map[string][]string)
+	for _, dir := range dirs {
+		root := t.srcrootOf(dir)
+		byRoot[root] = append(byRoot[root], dir)
+	}
+	latest := make(map[string]bool)
+	for _, rootDirs := range byRoot {
+		path, err := t.latestFile(rootDirs, patterns, lastDirOnly)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			latest[path] = true
+		}
+	}
+	return latest, nil
+}
+
+// globJob is a glob to run with globConcurrently. kind describes the Fs in
+// error messages.
+type globJob struct {
+	kind    string
+	fsys    Fs
+	pattern string
+}
+
+// globConcurrently runs globs concurrently and returns matches for each job
+// in order, or the first job's error if any failed
+func (t *Transfer) globConcurrently(jobs []globJob) ([][]string, error) {
+	matches := make([][]string, len(jobs))
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job globJob) {
+			defer wg.Done()
+			matches[i], errs[i] = t.glob(job.kind, job.fsys, job.pattern)
+		}(i, job)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("could not match %v files with %v: %w", jobs[i].kind, jobs[i].pattern, err)
+		}
+	}
+	return matches, nil
+}
+
+// dstDir returns the destination directory for kind files in source directory
+// dir. See CopyFile.
+func (t *Transfer) dstDir(dir string, kind Kind) string {
+	if t.FollowSymlinks {
+		dir = t.realDir(dir)
+	}
+	root := t.srcrootOf(dir)
+	dstroot := t.dstroot(kind)
+	if t.Flatten || filepath.Clean(dir) == filepath.Clean(root) {
+		return dstroot // flat layout
+	}
+	if t.PreserveTree {
+		if rel, err := filepath.Rel(root, dir); err == nil {
+			return filepath.Join(dstroot, rel)
+		}
+	}
+	return filepath.Join(dstroot, filepath.Base(dir))
+}
+
+// dstroot returns the destination root for kind files, SFLDstroot or
+// EVTDstroot if set, otherwise Dstroot
+func (t *Transfer) dstroot(kind Kind) string {
+	switch {
+	case kind == KindSFL && t.SFLDstroot != "":
+		return t.SFLDstroot
+	case kind == KindEVT && t.EVTDstroot != "":
+		return t.EVTDstroot
+	}
+	return t.Dstroot
+}
+
+// dstroots returns Dstroot and any different SFLDstroot and EVTDstroot
+func (t *Transfer) dstroots() []string {
+	roots := []string{t.Dstroot}
+	seen := map[string]bool{filepath.Clean(t.Dstroot): true}
+	for _, root := range []string{t.SFLDstroot, t.EVTDstroot} {
+		if root != "" && !seen[filepath.Clean(root)] {
+			seen[filepath.Clean(root)] = true
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// selectNewFiles returns source files in dir matching patterns which are not
+// already present at the destination and are within the Earliest to Latest
+// range. Files in latest are excluded. Destination files are looked for in
+// the destination root for kind files.
+func (t *Transfer) selectNewFiles(dir string, kind Kind, patterns []string, latest map[string]bool) ([]string, selection, error) {
+	var sel selection
+	// Glob source and destination files concurrently to save round trips
+	// over SFTP
+	var jobs []globJob
+	dstDir := t.dstDir(dir, kind)
+	for _, pattern := range patterns {
+		_, filePattern := filepath.Split(pattern)
+		jobs = append(jobs, globJob{"source", t.Srcfs, filepath.Join(dir, filePattern)})
+	}
+	nsrc := len(jobs)
+	// With CacheDestination each destination directory is listed once for
+	// all patterns and passes
+	var dstNames []string
+	cached := false
+	if t.CacheDestination {
+		dstNames, cached = t.cachedDestination(dstDir)
+		if !cached {
+			jobs = append(jobs, globJob{"destination", t.Dstfs, filepath.Join(dstDir, "*")})
+		}
+	} else {
+		for _, pattern := range patterns {
+			_, filePattern := filepath.Split(pattern)
+			for _, p := range gzipVariants(filePattern) {
+				jobs = append(jobs, globJob{"destination", t.Dstfs, filepath.Join(dstDir, p)})
+			}
+		}
+	}
+	ndst := len(jobs)
+	// Files written with NoTempFile have a marker until they're complete
+	if t.NoTempFile && !t.CacheDestination {
+		jobs = append(jobs, globJob{"destination", t.Dstfs, filepath.Join(dstDir, "*"+PartialSuffix)})
+	}
+	matches, err := t.globConcurrently(jobs)
+	if err != nil {
+		return nil, sel, err
+	}
+	var srcFiles []string
+	for _, m := range matches[:nsrc] {
+		srcFiles = append(srcFiles, m...)
+	}
+	srcFiles = sortUnique(srcFiles)
+	sel.found = len(srcFiles)
+	if t.CacheDestination && !cached {
+		dstNames = t.cacheDestination(dstDir, matches[nsrc])
+	}
+	if len(srcFiles) == 0 {
+		return nil, sel, nil
+	}
+
+	// Skip files already present in destination. Names are compared without
+	// ".gz" so a file matches whether it was gzipped, decompressed, or copied
+	// as-is, and regardless of timezone offset sign.
+	present := make(map[string]string)
+	switch {
+	case t.HeadBytes > 0:
+		// Head copies sample every file regardless of the destination
+	case t.CacheDestination:
+		for _, name := range dstNames {
+			if matchesFilePattern(patterns, name) {
+				present[canonicalName(name)] = filepath.Join(dstDir, name)
+			}
+		}
+	default:
+		for _, m := range matches[nsrc:ndst] {
+			for _, path := range m {
+				present[canonicalName(path)] = path
+			}
+		}
+	}
+	if t.NoTempFile {
+		var markers []string
+		if t.CacheDestination {
+			for _, name := range dstNames {
+				if strings.HasSuffix(name, PartialSuffix) {
+					markers = append(markers, filepath.Join(dstDir, name))
+				}
+			}
+		} else {
+			markers = matches[ndst]
+		}
+		for _, marker := range markers {
+			name := canonicalName(strings.TrimSuffix(marker, PartialSuffix))
+			if dst, ok := present[name]; ok {
+				t.logger().Info("copying again file left partial by an interrupted copy", "dst", dst)
+				delete(present, name)
+			}
+		}
+	}
+	nodups := make([]string, 0)
+	for _, path := range srcFiles {
+		if latest[path] {
+			continue
+		}
+		if dst, ok := present[canonicalName(path)]; !ok {
+			nodups = append(nodups, path)
+		} else if t.Force {
+			t.logger().Debug("forcing copy of file already at destination", "path", path)
+			nodups = append(nodups, path)
+			sel.forced++
+		} else if t.RefreshStale && t.stale(path, dst) {
+			t.logger().Debug("re-copying file newer than destination", "path", path, "dst", dst)
+			nodups = append(nodups, path)
+			sel.stale++
+		} else if t.RefreshGzipMtime && t.gzipMtimeChanged(path, dst) {
+			t.logger().Debug("re-copying file whose gzip header time differs from the source", "path", path, "dst", dst)
+			nodups = append(nodups, path)
+			sel.gzipMtime++
+		} else {
+			sel.dups++
+		}
+	}
+	// Skip files that are before t.Earliest or not before t.Latest, or which
+	// were modified too recently
+	files := make([]string, 0)
+	for _, path := range nodups {
+		if t.early(path) {
+			sel.early++
+			continue
+		}
+		if t.late(path) {
+			sel.late++
+			continue
+		}
+		if t.fresh(path) {
+			sel.fresh++
+			continue
+		}
+		if t.filtered(path) {
+			sel.filtered++
+			continue
+		}
+		files = append(files, path)
+	}
+
+	if t.RequireSFLRecord && kind == KindEVT && len(files) > 0 {
+		recorded, err := t.recordedEVT(dir)
+		if err != nil {
+			return nil, sel, err
+		}
+		n := 0
+		for _, path := range files {
+			if recorded[canonicalName(path)] {
+				files[n] = path
+				n++
+			}
+		}
+		sel.unrecorded = len(files) - n
+		files = files[:n]
+	}
+
+	return files, sel, nil
+}
+
+// fileFailed records a failure to copy path. It returns an error if the copy
+// pass should stop, or nil if KeepGoing is set and the error was logged.
+// Cancellation and fatal PostCopy failures always stop the pass.
+func (t *Transfer) fileFailed(path string, err error) error {
+	t.Stats.addFailed(err)
+	if !t.KeepGoing || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrPostCopy) {
+		return fmt.Errorf("error while copying %v: %w", path, err)
+	}
+	t.logger().Error("copy failed", "path", path, "error", err)
+	return nil
+}
+
+// passFailed returns an error wrapping ErrFilesFailed if any of a pass's kind
+// files failed to copy.
+func (t *Transfer) passFailed(kind string, failed int) error {
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v %v files failed: %w", failed, kind, ErrFilesFailed)
+}
+
+// ListSFLFiles returns the source SFL files CopySFLFiles would consider for
+// copying, in sorted order. Files outside the Earliest to Latest range are
+// excluded. The destination is not accessed, so files already present there
+// are included.
+func (t *Transfer) ListSFLFiles() ([]string, error) {
+	return t.listFiles(t.sflPatterns(), false)
+}
+
+// ListEVTFiles returns the source EVT files CopyEVTFiles would consider for
+// copying, in sorted order. Files outside the Earliest to Latest range, files
+// modified less than MinAge ago, and the most recent file unless IncludeLatest
+// is set are excluded. The destination is not accessed, so files already
+// present there are included.
+func (t *Transfer) ListEVTFiles() ([]string, error) {
+	return t.listFiles(t.evtPatterns(), true)
+}
+
+// listFiles returns sorted source files matching patterns relative to root
+// within the Earliest to Latest range. If skipLatest is true the most recent
+// file and files modified less than MinAge ago are excluded, though the most
+// recent file is kept if IncludeLatest is set.
+func (t *Transfer) listFiles(patterns []string, skipLatest bool) ([]string, error) {
+	dirs, err := t.sourceDirs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	var latest map[string]bool
+	if skipLatest && !t.IncludeLatest {
+		latest, err = t.latestFiles(dirs, patterns, t.LatestFromLastDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	files := make([]string, 0)
+	for _, dir := range dirs {
+		srcFiles, err := t.globDir(dir, patterns)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range srcFiles {
+			if latest[path] || t.early(path) || t.late(path) || (skipLatest && t.fresh(path)) || t.filtered(path) {
+				continue
+			}
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// rootFiles returns source files in root matching pattern, and in Decompress
+// mode their gzipped versions, for deleteOrphans
+func (t *Transfer) rootFiles(root string, kind string, pattern string) ([]string, error) {
+	// Decompressed destination files may come from gzipped sources
+	patterns := []string{pattern}
+	if t.Decompress {
+		patterns = gzipVariants(pattern)
+	}
+	var srcFiles []string
+	for _, p := range patterns {
+		matches, err := t.glob("source", t.Srcfs, filepath.Join(root, p))
+		if err != nil {
+			return nil, fmt.Errorf("could not match source %v files: %w", kind, err)
+		}
+		srcFiles = append(srcFiles, matches...)
+	}
+	if dirPattern, filePattern := filepath.Split(pattern); t.FollowSymlinks && dirPattern != "" {
+		// Files in symlinked directories aren't orphans
+		links, err := t.linkedDirs(root, dirPattern)
+		if err != nil {
+			return nil, err
+		}
+		filePatterns := []string{filePattern}
+		if t.Decompress {
+			filePatterns = gzipVariants(filePattern)
+		}
+		for _, dir := range links {
+			linked, err := t.globDir(dir, filePatterns)
+			if err != nil {
+				return nil, err
+			}
+			srcFiles = append(srcFiles, linked...)
+		}
+	}
+	return srcFiles, nil
+}
+
+// DeleteOrphans deletes destination SFL and EVT files which have no
+// corresponding source file, so the destination mirrors deletions at the
+// source. ".gz" extensions are ignored when matching. Only files matching the
+// SFL and EVT patterns are considered. As a guard against deleting everything
+// if the source is unavailable, nothing of a kind, SFL or EVT, is deleted if
+// its patterns match no source files. Nothing is deleted unless ConfirmDelete
+// is set and DryRun is not. It returns an error if Move is set, since
+// moved files have no source file and would all be deleted.
+func (t *Transfer) DeleteOrphans() error {
+	return t.DeleteOrphansContext(context.Background())
+}
+
+// DeleteOrphansContext is like DeleteOrphans but stops early if ctx is
+// cancelled.
+func (t *Transfer) DeleteOrphansContext(ctx context.Context) error {
+	if t.Move {
+		return errors.New("can't delete orphans with Move, every moved file would be deleted")
+	}
+	if err := t.deleteOrphans(ctx, KindSFL, t.sflPatterns()); err != nil {
+		return err
+	}
+	return t.deleteOrphans(ctx, KindEVT, expandBraces(t.evtPattern()))
+}
+
+// deleteOrphans deletes destination kind files matching patterns with no
+// corresponding source file. All of a kind's patterns are matched together,
+// since a destination file matched by one may come from a source file
+// matched by another, e.g. "*.sfl" and "*.sfl.gz".
+func (t *Transfer) deleteOrphans(ctx context.Context, kind Kind, patterns []string) error {
+	var srcFiles []string
+	for _, root := range t.srcroots() {
+		// Every root must be present, or its files would look like
+		// orphans
+		if ok, err := t.checkSrcroot(root); !ok {
+			return err
+		}
+		for _, pattern := range patterns {
+			files, err := t.rootFiles(root, kind.String(), pattern)
+			if err != nil {
+				return err
+			}
+			srcFiles = append(srcFiles, files...)
+		}
+	}
+	if len(srcFiles) == 0 {
+		t.logger().Info("no source files found, not deleting destination files", "kind", kind.String())
+		return nil
+	}
+	present := make(map[string]bool)
+	for _, path := range srcFiles {
+		present[canonicalPath(t.relDst(path))] = true
+	}
+	var dstFiles []string
+	for _, pattern := range patterns {
+		for _, p := range gzipVariants(t.dstPattern(pattern, kind)) {
+			matches, err := t.glob("destination", t.Dstfs, p)
+			if err != nil {
+				return fmt.Errorf("could not match destination %v files: %w", kind, err)
+			}
+			dstFiles = append(dstFiles, matches...)
+		}
+	}
+	dstFiles = sortUnique(dstFiles)
+
+	orphans := 0
+	for _, path := range dstFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(t.dstroot(kind), path)
+		if err != nil {
+			return err
+		}
+		if present[canonicalPath(rel)] {
+			continue
+		}
+		orphans++
+		if !t.ConfirmDelete || t.DryRun {
+			t.logger().Info("would delete", "path", path)
+			continue
+		}
+		if err := t.Dstfs.Remove(path); err != nil {
+			return fmt.Errorf("could not delete %v: %w", path, err)
+		}
+		t.updateDestination(path, false)
+		t.Stats.addDeleted()
+		t.logger().Info("deleted", "path", path)
+	}
+	t.logger().Info("found destination files with no source file", "kind", kind.String(), "count", orphans)
+	return nil
+}
+
+// dstPattern returns source pattern relative to Srcroot for kind files as a
+// pattern for the corresponding destination files
+func (t *Transfer) dstPattern(pattern string, kind Kind) string {
+	if t.Flatten {
+		_, filePattern := filepath.Split(pattern)
+		return filepath.Join(t.dstroot(kind), filePattern)
+	}
+	return filepath.Join(t.dstroot(kind), pattern)
+}
+
+// relDst returns the destination path for source path relative to its
+// destination root, without any ".gz" extension. See CopyFile.
+func (t *Transfer) relDst(path string) string {
+	dir, filename := filepath.Split(path)
+	filename = t.dstName(trimGzip(filename))
+	kind, _ := FileKind(filename)
+	rel, err := filepath.Rel(t.dstroot(kind), filepath.Join(t.dstDir(dir, kind), filename))
+	if err != nil {
+		return filename
+	}
+	return rel
+}
+
+// markLive marks a source file as possibly still open for writing
+func (t *Transfer) markLive(path string) {
+	if t.live == nil {
+		t.live = make(map[string]bool)
+	}
+	t.live[path] = true
+}
+
+func (t *Transfer) resetPlan() {
+	t.plannedFiles = 0
+	t.plannedBytes = 0
+	t.plannedOverwrites = 0
+}
+
+func (t *Transfer) logPlan(kind string) {
+	if t.DryRun {
+		t.logger().Info("would copy files", "kind", kind, "count", t.plannedFiles, "bytes", t.plannedBytes,
+			"create", t.plannedFiles-t.plannedOverwrites, "overwrite", t.plannedOverwrites)
+	}
+}
+
+func (t *Transfer) sflPattern() string {
+	if t.SFLPattern != "" {
+		return t.SFLPattern
+	}
+	return DefaultSFLPattern
+}
+
+func (t *Transfer) evtPattern() string {
+	if t.EVTPattern != "" {
+		return t.EVTPattern
+	}
+	return DefaultEVTPattern
+}
+
+// sflPatterns returns source patterns for SFL files, with any braces
+// expanded, including gzipped SFL files, which are copied as-is
+func (t *Transfer) sflPatterns() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	add := func(pattern string) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			patterns = append(patterns, pattern)
+		}
+	}
+	for _, pattern := range expandBraces(t.sflPattern()) {
+		for _, p := range gzipVariants(pattern) {
+			add(p)
+		}
+	}
+	return patterns
+}
+
+// evtPatterns returns source patterns for EVT files, with any braces
+// expanded, including gzipped EVT files in Decompress mode
+func (t *Transfer) evtPatterns() []string {
+	var patterns []string
+	for _, pattern := range expandBraces(t.evtPattern()) {
+		if t.Decompress {
+			patterns = append(patterns, gzipVariants(pattern)...)
+		} else {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// early returns true if path has a filename timestamp before t.Earliest. Files
+// without parseable timestamps are never early.
+func (t *Transfer) early(path string) bool {
+	if t.Earliest.IsZero() {
+		return false
+	}
+	filetime, err := timeFromFilename(path)
+	if err == nil && filetime.Before(t.Earliest) {
+		t.logger().Debug("skipping file earlier than start", "path", path, "time", filetime, "earliest", t.Earliest)
+		return true
+	}
+	return false
+}
+
+// late returns true if path has a filename timestamp at or after t.Latest.
+// Files without parseable timestamps are never late.
+func (t *Transfer) late(path string) bool {
+	if t.Latest.IsZero() {
+		return false
+	}
+	filetime, err := timeFromFilename(path)
+	if err == nil && !filetime.Before(t.Latest) {
+		t.logger().Debug("skipping file not earlier than end", "path", path, "time", filetime, "latest", t.Latest)
+		return true
+	}
+	return false
+}
+
+// fresh returns true if path was modified less than t.MinAge ago. Files which
+// can't be stat'd are not considered fresh, so the error surfaces when they're
+// copied.
+func (t *Transfer) fresh(path string) bool {
+	if t.MinAge <= 0 {
+		return false
+	}
+	info, err := t.Srcfs.Stat(path)
+	if err == nil && time.Since(info.ModTime()) < t.MinAge {
+		t.logger().Debug("skipping recently modified file", "path", path, "modTime", info.ModTime())
+		return true
+	}
+	return false
+}
+
+// filtered returns true if the base name of path matches Exclude or doesn't
+// match Include
+func (t *Transfer) filtered(path string) bool {
+	name := filepath.Base(path)
+	if t.Exclude != nil && t.Exclude.MatchString(name) {
+		t.logger().Debug("skipping excluded file", "path", path)
+		return true
+	}
+	if t.Include != nil && !t.Include.MatchString(name) {
+		t.logger().Debug("skipping file not included", "path", path)
+		return true
+	}
+	return false
+}
+
+// stale returns true if source file path was modified after its destination
+// copy dst, by more than MtimeTolerance. Files which can't be checked are not
+// stale.
+func (t *Transfer) stale(path string, dst string) bool {
+	info, err := t.Srcfs.Stat(path)
+	if err != nil {
+		t.logger().Error("warning: could not stat source file", "path", path, "error", err)
+		return false
+	}
+	dstTime, err := t.dstModTime(dst)
+	if err != nil {
+		t.logger().Error("warning: could not get destination modification time", "path", dst, "error", err)
+		return false
+	}
+	return info.ModTime().After(dstTime) && !mtimeClose(info.ModTime(), dstTime, t.mtimeTolerance())
+}
+
+// DefaultMtimeTolerance is the default Transfer.MtimeTolerance
+const DefaultMtimeTolerance = time.Second
+
+// mtimeTolerance returns MtimeTolerance, or DefaultMtimeTolerance if it's 0
+func (t *Transfer) mtimeTolerance() time.Duration {
+	if t.MtimeTolerance == 0 {
+		return DefaultMtimeTolerance
+	}
+	return t.MtimeTolerance
+}
+
+// mtimeClose returns true if modification times a and b are at most tol
+// apart, so they count as the same when deciding whether to skip or refresh
+// a copy
+func mtimeClose(a, b time.Time, tol time.Duration) bool {
+	d := a.Sub(b)
+	return d <= tol && d >= -tol
+}
+
+// checkDowngrade returns a skipError if destination file path exists with a
+// modification time more than MtimeTolerance after mtime, the modification
+// time of the file which would replace it
+func (t *Transfer) checkDowngrade(path string, mtime time.Time) error {
+	info, err := t.Dstfs.Stat(path)
+	if err != nil {
+		return nil // nothing to replace, or the copy will fail anyway
+	}
+	if info.ModTime().Sub(mtime) > t.mtimeTolerance() {
+		return skipError{reason: fmt.Sprintf("destination modification time %v is newer than source %v", info.ModTime(), mtime), warn: true}
+	}
+	return nil
+}
+
+// dstModTime returns the effective modification time of destination file
+// path, the later of its mtime and, for ".gz" files, the gzip header ModTime.
+// Only the gzip header is read.
+func (t *Transfer) dstModTime(path string) (time.Time, error) {
+	info, err := t.Dstfs.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	mtime := info.ModTime()
+	if _, compressed := FileKind(path); !compressed {
+		return mtime, nil
+	}
+	gzTime, err := gzipHeaderTime(t.Dstfs, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if gzTime.After(mtime) {
+		mtime = gzTime
+	}
+	return mtime, nil
+}
+
+// gzipHeaderSize is the read size for gzipHeaderTime, enough for the fixed
+// header and a typical SeaFlow filename, so over SFTP the header is usually
+// read in one request
+const gzipHeaderSize = 64
+
+// gzipHeaderTime returns the ModTime in the header of gzip file path in fsys,
+// the zero time if it has none. Only the header is read.
+func gzipHeaderTime(fsys Fs, path string) (time.Time, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(bufio.NewReaderSize(f, gzipHeaderSize))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return gzr.Header.ModTime, nil
+}
+
+// gzipMtimeChanged returns true if destination file dst, gzipped in transit
+// from source file path, has a gzip header ModTime more than MtimeTolerance
+// from the source file's modification time. Files which can't be checked,
+// weren't gzipped in transit, or have no header time haven't changed.
+func (t *Transfer) gzipMtimeChanged(path string, dst string) bool {
+	if _, compressed := FileKind(path); compressed {
+		return false // copied as-is with its own header
+	}
+	if _, compressed := FileKind(dst); !compressed {
+		return false
+	}
+	info, err := t.Srcfs.Stat(path)
+	if err != nil {
+		t.logger().Error("warning: could not stat source file", "path", path, "error", err)
+		return false
+	}
+	gzTime, err := gzipHeaderTime(t.Dstfs, dst)
+	if err != nil {
+		t.logger().Error("warning: could not read destination gzip header", "path", dst, "error", err)
+		return false
+	}
+	if gzTime.IsZero() {
+		return false
+	}
+	return !mtimeClose(info.ModTime(), gzTime, t.mtimeTolerance())
+}
+
+// logger returns t.Log, or a Logger writing to t.Debug, t.Info, and t.Error
+// if t.Log is nil
+func (t *Transfer) logger() Logger {
+	if t.Log != nil {
+		return t.Log
+	}
+	return NewStdLogger(t.Debug, t.Info, t.Error)
+}
+
+// tempName returns a temp file name for filename. It's safe for concurrent use
+// by copy workers. The process ID is included so concurrent runs writing to
+// the same destination can't collide even if their random sources do.
+func (t *Transfer) tempName(filename string) string {
+	t.randMu.Lock()
+	if t.rand == nil {
+		t.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, 7)
+	for i := range b {
+		b[i] = charset[t.rand.Intn(len(charset))]
+	}
+	t.randMu.Unlock()
+	prefix := t.TempPrefix
+	if prefix == "" {
+		prefix = DefaultTempPrefix
+	}
+	return prefix + strconv.Itoa(os.Getpid()) + "-" + string(b) + "." + filename + "_"
+}
+
+// headSuffix returns the suffix of partial files written with HeadBytes
+func (t *Transfer) headSuffix() string {
+	if t.HeadSuffix == "" {
+		return DefaultHeadSuffix
+	}
+	return t.HeadSuffix
+}
+
+// CopyFile copies one file from source to destination. The destination path is
+// <Dstroot>/<parent>/<filename>, where <parent> is the name of the source
+// file's parent directory, normally the day-of-year directory. If
+// t.PreserveTree is set <parent> is instead the full path of the parent
+// directory relative to its source root, e.g. <year>/<day-of-year>. Files
+// directly in a source root, e.g. matched by a flat SFLPattern like "*.sfl",
+// are copied directly to Dstroot, as are all files if t.Flatten is set. SFL
+// and EVT files use t.SFLDstroot or t.EVTDstroot in place of Dstroot if set.
+// Copies which fail with errors that may be transient are retried according
+// to t.MaxRetries and t.RetryDelay. Copy failures are returned as a
+// *TransferError identifying the failed Stage. Files whose destination would
+// be outside Dstroot, e.g. because a source directory is named "..", are
+// refused with an error wrapping ErrUnsafePath.
+func (t *Transfer) CopyFile(path string, gzipFlag bool) error {
+	return t.CopyFileContext(context.Background(), path, gzipFlag)
+}
+
+// CopyFileContext is like CopyFile but stops early if ctx is cancelled,
+// removing any partially written temp file.
+func (t *Transfer) CopyFileContext(ctx context.Context, path string, gzipFlag bool) error {
+	defer t.Stats.endCopy(path)
+	if t.FileTimeout > 0 {
+		// One deadline covers all attempts
+		fileCtx, cancel := context.WithTimeout(ctx, t.FileTimeout)
+		defer cancel()
+		err := t.copyFileRetry(fileCtx, path, gzipFlag)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return fmt.Errorf("copy of %v exceeded file timeout of %v: %w", path, t.FileTimeout, err)
+		}
+		return err
+	}
+	return t.copyFileRetry(ctx, path, gzipFlag)
+}
+
+// copyFileRetry copies a file, retrying failures as configured by MaxRetries
+// and RetryDelay
+func (t *Transfer) copyFileRetry(ctx context.Context, path string, gzipFlag bool) error {
+	start := time.Now()
+	var res *copyResult
+	if t.Events != nil {
+		ctx, res = withResult(ctx)
+	}
+	err := t.copyFileAttempts(ctx, path, gzipFlag)
+	var skip skipError
+	if errors.As(err, &skip) {
+		err = nil
+	}
+	if res != nil {
+		rec, copied := res.get()
+		// Nothing is recorded for copies planned in DryRun mode
+		if copied || err != nil || skip.reason != "" {
+			t.sendResult(FileResult{
+				Src:          path,
+				Dst:          rec.Dst,
+				Bytes:        rec.Size,
+				BytesWritten: rec.BytesWritten,
+				Gzipped:      rec.Gzipped,
+				Duration:     time.Since(start),
+				Skipped:      skip.reason,
+				Err:          err,
+			})
+		}
+	}
+	return err
+}
+
+// copyFileAttempts copies path, retrying as described by copyFileRetry.
+// Skipped files are logged and counted, and returned as a skipError.
+func (t *Transfer) copyFileAttempts(ctx context.Context, path string, gzipFlag bool) error {
+	delay := t.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := t.copyFileWait(ctx, path, gzipFlag)
+		var skip skipError
+		if errors.As(err, &skip) {
+			if skip.warn {
+				t.logger().Error("warning: skipped", "path", path, "reason", skip.reason)
+			} else {
+				t.logger().Info("skipped", "path", path, "reason", skip.reason)
+			}
+			t.Stats.addSkipped(1)
+			return skip
+		}
+		if err == nil {
+			return t.removeSource(path)
+		}
+		if attempt >= t.MaxRetries || !retryable(err) {
+			return err
+		}
+		// The abandoned attempt isn't in progress while waiting to retry
+		t.Stats.endCopy(path)
+		t.logger().Error("retrying", "path", path, "retry", attempt+1, "maxRetries", t.MaxRetries, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// copyFileWait is like copyFile but cancels the copy if it stalls for
+// t.StallTimeout. A cancelled copy, e.g. one which exceeded FileTimeout, only
+// returns once a blocked read or write does and its temp file is removed, so
+// a retry never runs alongside it.
+func (t *Transfer) copyFileWait(ctx context.Context, path string, gzipFlag bool) error {
+	if t.StallTimeout <= 0 {
+		return t.copyFile(ctx, path, gzipFlag)
+	}
+	copyCtx, watchdog := t.watchStall(ctx)
+	defer watchdog.close()
+	err := t.copyFile(copyCtx, path, gzipFlag)
+	if err != nil && watchdog.stalled() && ctx.Err() == nil {
+		return fmt.Errorf("copy of %v made no progress for %v: %w", path, t.StallTimeout, ErrStalled)
+	}
+	return err
+}
+
+// checkWithin returns an error wrapping ErrUnsafePath unless path is a
+// descendant of root after cleaning
+func checkWithin(root string, path string) error {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%v is not within %v: %w", path, root, ErrUnsafePath)
+	}
+	return nil
+}
+
+// skipError is returned by copyFile when a file is deliberately not copied.
+// If warn is true the skip is logged as a warning on the Error logger.
+type skipError struct {
+	reason string
+	warn   bool
+}
+
+func (e skipError) Error() string {
+	return "skipped: " + e.reason
+}
+
+// removeSource deletes a successfully copied source file if t.Move is set.
+// Files which may still be open for writing, or which were only partially
+// copied with HeadBytes, are never removed.
+func (t *Transfer) removeSource(path string) error {
+	if !t.Move || t.DryRun || t.HeadBytes > 0 || t.live[path] {
+		return nil
+	}
+	err := t.Srcfs.Remove(path)
+	if err != nil {
+		return fmt.Errorf("could not remove source file %v: %w", path, err)
+	}
+	t.logger().Debug("removed source file", "path", path)
+	return nil
+}
+
+// retryable returns true if err may be caused by a transient network or IO
+// problem: a lost or reset connection, a network error, a short read, a
+// truncated SFL copy, or a stalled copy. Anything else, e.g. a missing file,
+// a permission problem, invalid data, or a failed check of the copy, would
+// fail the same way again.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrInvalidGzip) {
+		return false
+	}
+	// A truncated SFL copy is usually from a short read without an error
+	if errors.Is(err, ErrInvalidSFL) {
+		return true
+	}
+	var te *TransferError
+	if errors.As(err, &te) && te.Stage == StageVerify {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, ErrStalled) ||
+		connLost(err) ||
+		errors.As(err, &netErr)
+}
+
+// ctxReader is an io.Reader which fails with ctx.Err() once ctx is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// rateLimiter returns a token bucket limiter for t.RateLimit shared by all
+// copies
+func (t *Transfer) rateLimiter() *rate.Limiter {
+	t.limiterOnce.Do(func() {
+		burst := int(t.RateLimit)
+		if int64(burst) != t.RateLimit || burst < 0 {
+			burst = math.MaxInt32
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(t.RateLimit), burst)
+	})
+	return t.limiter
+}
+
+// rateLimitedReader is an io.Reader which waits on a rate limiter after each
+// read
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *rate.Limiter
+}
+
+func (r rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.lim.Burst() {
+		p = p[:r.lim.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.lim.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// progressInterval is the minimum time between progress callbacks
+const progressInterval = 500 * time.Millisecond
+
+// progressReader counts bytes read and periodically reports them to a
+// progress callback
+type progressReader struct {
+	r     io.Reader
+	path  string
+	n     int64
+	total int64
+	last  time.Time
+	fn    func(path string, bytesCopied, totalBytes int64)
+}
+
+func newProgressReader(r io.Reader, path string, total int64, fn func(string, int64, int64)) *progressReader {
+	p := &progressReader{r: r, path: path, total: total, last: time.Now(), fn: fn}
+	fn(path, 0, total)
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.fn(p.path, p.n, p.total)
+	}
+	return n, err
+}
+
+// reportProgress records progress of the copy of path in Stats and reports it
+// to Progress
+func (t *Transfer) reportProgress(path string, copied, total int64) {
+	t.Stats.setProgress(path, copied, total)
+	t.Progress(path, copied, total)
+}
+
+// done reports final progress
+func (p *progressReader) done() {
+	p.fn(p.path, p.n, p.total)
+}
+
+func (t *Transfer) copyFile(ctx context.Context, path string, gzipFlag bool) error {
+	// Parse file path parts, handle gzip properly
+	dir, filename := filepath.Split(path)
+	kind, compressed := FileKind(filename)
+	outdir := t.dstDir(dir, kind)
+	// In Decompress mode gzipped files are written without ".gz" and nothing
+	// is gzipped
+	decompress := t.Decompress && compressed
+	outname := t.dstName(filename)
+	if decompress {
+		outname = trimGzip(outname)
+	}
+	outpath := filepath.Join(outdir, outname)
+	// To guarantee atomic file writes, create a temporary output file with
+	// a name that won't get matched as an EVT file but with the final
+	// target named embedded. This will get moved to the final path once
+	// data is flushed.
+	tempdir := outdir
+	if t.TempDir != "" && !t.NoTempFile {
+		tempdir = t.TempDir
+	}
+	outpathtemp := filepath.Join(tempdir, t.tempName(outname))
+	if compressed || t.Decompress {
+		gzipFlag = false
+	}
+	if gzipFlag {
+		outpath = outpath + ".gz"
+		outpathtemp = outpathtemp + ".gz"
+	}
+	if err := checkWithin(t.dstroot(kind), outpath); err != nil {
+		return transferError(StageCreate, path, outpath, err)
+	}
+	if err := checkWithin(tempdir, outpathtemp); err != nil {
+		return transferError(StageCreate, path, outpath, err)
+	}
+
+	// Limit concurrent reads from the source and writes to the destination
+	srcSem, dstSem := t.semaphores()
+	releaseSrc, err := acquire(ctx, srcSem)
+	if err != nil {
+		return err
+	}
+	defer releaseSrc()
+
+	// Open input file
+	in, err := t.Srcfs.Open(path)
+	if err != nil {
+		return transferError(StageOpen, path, outpath, fmt.Errorf("could not open input file %v: %w", path, err))
+	}
+	defer in.Close()
+	inStat, err := in.Stat()
+	if err != nil {
+		return transferError(StageOpen, path, outpath, fmt.Errorf("could not stat input file %v: %w", path, err))
+	}
+
+	// Empty files are usually left by an acquisition crash
+	if inStat.Size() == 0 && !t.CopyEmpty {
+		return skipError{reason: "file is empty", warn: true}
+	}
+	if t.MaxFileSize > 0 && inStat.Size() > t.MaxFileSize {
+		return skipError{reason: fmt.Sprintf("file size %v is larger than maximum %v", inStat.Size(), t.MaxFileSize), warn: true}
+	}
+
+	// Small files aren't worth gzipping
+	if gzipFlag && inStat.Size() < t.CompressThreshold {
+		gzipFlag = false
+		outpath = strings.TrimSuffix(outpath, ".gz")
+		outpathtemp = strings.TrimSuffix(outpathtemp, ".gz")
+	}
+
+	// Mark partial files, after any ".gz" so they don't match patterns
+	head := t.HeadBytes > 0
+	if head {
+		outpath += t.headSuffix()
+		outpathtemp += t.headSuffix()
+	}
+
+	if t.SkipUnchanged && !gzipFlag && !decompress && !head {
+		outStat, err := t.Dstfs.Stat(outpath)
+		if err == nil && outStat.Size() == inStat.Size() && mtimeClose(outStat.ModTime(), inStat.ModTime(), t.mtimeTolerance()) {
+			return skipError{reason: "destination has same size and modification time"}
+		}
+	}
+
+	if t.NoClobber {
+		if _, err := t.Dstfs.Stat(outpath); err == nil {
+			return skipError{reason: "destination exists, not replacing with NoClobber"}
+		}
+	}
+
+	if t.DryRun {
+		return t.planCopy(path, outpath, inStat.Size())
+	}
+
+	releaseDst, err := acquire(ctx, dstSem)
+	if err != nil {
+		return err
+	}
+	defer releaseDst()
+
+	if t.NoDowngrade && !decompress {
+		if err := t.checkDowngrade(outpath, inStat.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	if t.AppendSFL && kind == KindSFL && !gzipFlag && !compressed && !head {
+		if appended, err := t.appendFile(ctx, path, in, inStat, outpath); appended || err != nil {
+			return err
+		}
+	}
+
+	// Make sure dir tree is ready to go
+	err = t.mkdirAll(outdir)
+	if err != nil {
+		return transferError(StageCreate, path, outpath, fmt.Errorf("could not create dir %v: %w", outdir, err))
+	}
+	if tempdir != outdir {
+		err = t.mkdirAll(tempdir)
+		if err != nil {
+			return transferError(StageCreate, path, outpath, fmt.Errorf("could not create temp dir %v: %w", tempdir, err))
+		}
+	}
+
+	// Check for cancellation between each read
+	var src io.Reader = ctxReader{ctx: ctx, r: watchReader(ctx, in)}
+	if t.RateLimit > 0 {
+		src = rateLimitedReader{ctx: ctx, r: src, lim: t.rateLimiter()}
+	}
+	var progress *progressReader
+	if t.Progress != nil {
+		progress = newProgressReader(src, path, inStat.Size(), t.reportProgress)
+		src = progress
+	}
+	// Check the header name of gzip files copied as-is, before anything else
+	// sees the bytes written
+	if (t.CheckGzipName || t.FixGzipName) && compressed && !decompress {
+		src, err = t.checkGzipName(src, path, trimGzip(outname))
+		if err != nil {
+			return transferError(StageVerify, path, outpath, err)
+		}
+	}
+	// Check that gzip files copied as-is decompress cleanly
+	var gzCheck *gzipValidator
+	if t.CheckGzip && compressed && !decompress && !head {
+		gzCheck = newGzipValidator()
+		defer gzCheck.Close()
+		src = io.TeeReader(src, gzCheck)
+	}
+	// Use the original file's mod time from the gzip header if decompressing
+	mtime := inStat.ModTime()
+	if decompress {
+		gzr, err := gzip.NewReader(src)
+		if err != nil {
+			return transferError(StageCopy, path, outpath, fmt.Errorf("could not decompress %v: %w: %v", path, ErrInvalidGzip, err))
+		}
+		defer gzr.Close()
+		if !gzr.Header.ModTime.IsZero() {
+			mtime = gzr.Header.ModTime
+		}
+		if t.NoDowngrade {
+			if err := t.checkDowngrade(outpath, mtime); err != nil {
+				return err
+			}
+		}
+		src = gzr
+	}
+	if head {
+		src = io.LimitReader(src, t.HeadBytes)
+	}
+	// Hash source bytes as they're read for later verification. These are
+	// decompressed bytes if decompressing.
+	var srcHash hash.Hash
+	if t.Verify {
+		srcHash = sha256.New()
+		src = io.TeeReader(src, srcHash)
+	}
+	// Hash the bytes written for the manifest, which lists files as they
+	// are at the destination
+	var manifestHash hash.Hash
+	if t.Manifest != nil && !head {
+		manifestHash, err = t.newManifestHash()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Mark a file written in place until it's complete, since an interrupted
+	// copy would otherwise look present. Failed copies remove the file, so
+	// complete files are replaced through a temp file instead.
+	marker := outpath + PartialSuffix
+	if t.NoTempFile {
+		if t.writableInPlace(outpath, marker) {
+			outpathtemp = outpath
+		} else {
+			t.logger().Debug("destination may exist, writing a temp file", "path", path, "dst", outpath)
+		}
+	}
+	if outpathtemp == outpath {
+		if err := t.writeMarker(marker); err != nil {
+			return transferError(StageCreate, path, outpath, fmt.Errorf("could not create marker file %v: %w", marker, err))
+		}
+		defer func() {
+			if err := t.Dstfs.Remove(marker); err != nil {
+				t.logger().Error("warning: could not remove marker file", "path", marker, "error", err)
+			}
+		}()
+	}
+
+	// Copy file
+	n, err := t.writeTemp(outpathtemp, src, gzipFlag, outname, mtime, inStat.Size(), manifestHash)
+	if err != nil {
+		return transferError(StageCopy, path, outpath, fmt.Errorf("could not copy %v to %v: %w", path, outpath, err))
+	}
+
+	if progress != nil {
+		progress.done()
+	}
+
+	if gzCheck != nil {
+		if err := gzCheck.Close(); err != nil {
+			_ = t.Dstfs.Remove(outpathtemp)
+			return transferError(StageVerify, path, outpath, fmt.Errorf("could not copy %v: %w", path, err))
+		}
+	}
+
+	if t.CheckGzipName && gzipFlag {
+		if name, err := gzipHeaderName(t.Dstfs, outpathtemp); err != nil || name != outname {
+			_ = t.Dstfs.Remove(outpathtemp)
+			if err == nil {
+				err = fmt.Errorf("%w: header name is %q, want %q", ErrGzipName, name, outname)
+			}
+			return transferError(StageVerify, path, outpath, fmt.Errorf("could not check gzip header of %v: %w", outpathtemp, err))
+		}
+	}
+
+	// Record the size on disk for the compaction report
+	var compressedSize int64
+	if gzipFlag {
+		compressedSize = n
+		if outStat, err := t.Dstfs.Stat(outpathtemp); err == nil {
+			compressedSize = outStat.Size()
+		}
+	}
+
+	// Don't complete a copy which has been cancelled
+	if err := ctx.Err(); err != nil {
+		_ = t.Dstfs.Remove(outpathtemp)
+		return err
+	}
+
+	// Rename from temp to final path
+	if outpathtemp != outpath {
+		if t.NoClobber {
+			err = t.renameNoClobber(outpathtemp, outpath)
+		} else {
+			err = t.Dstfs.Rename(outpathtemp, outpath)
+		}
+		if err != nil && !errors.Is(err, ErrClobber) && tempdir != outdir && crossDevice(err) {
+			err = t.renameFallback(ctx, outpathtemp, outpath, mtime, err)
+		}
+	}
+	if err != nil {
+		_ = t.Dstfs.Remove(outpathtemp)
+		return transferError(StageRename, path, outpath, fmt.Errorf("could not perform final rename from %v to %v: %w", outpathtemp, outpath, err))
+	}
+	t.updateDestination(outpath, true)
+	t.syncDir(outdir)
+	mtimeExact := t.checkMtime(outpath, mtime)
+
+	if t.Verify {
+		dstSum, err := checksum(t.Dstfs, outpath, gzipFlag)
+		if err != nil {
+			return transferError(StageVerify, path, outpath, fmt.Errorf("could not compute checksum for %v: %w", outpath, err))
+		}
+		if !bytes.Equal(srcHash.Sum(nil), dstSum) {
+			_ = t.Dstfs.Remove(outpath) // bad file is worse than no file
+			t.updateDestination(outpath, false)
+			return transferError(StageVerify, path, outpath, fmt.Errorf("checksum mismatch between %v and %v", path, outpath))
+		}
+		t.cacheChecksum(outpath, dstSum)
+	}
+
+	if t.ValidateSFL && kind == KindSFL && !head {
+		if err := t.checkSFL(path, outpath, gzipFlag || (compressed && !decompress)); err != nil {
+			return err
+		}
+	}
+
+	if manifestHash != nil {
+		err = t.writeManifest(outpath, manifestHash.Sum(nil))
+		if err != nil {
+			return fmt.Errorf("could not write manifest entry for %v: %w", outpath, err)
+		}
+	}
+
+	t.logger().Info("copied", "path", path, "dst", outpath, "bytes", inStat.Size(), "bytesWritten", n)
+
+	return t.recordCopy(ctx, FileRecord{
+		Src:            path,
+		Dst:            outpath,
+		Size:           inStat.Size(),
+		BytesWritten:   n,
+		Gzipped:        gzipFlag,
+		Decompressed:   decompress,
+		CompressedSize: compressedSize,
+		ModTime:        mtime,
+		ModTimeExact:   mtimeExact,
+	})
+}
+
+// writableInPlace returns true if destination file path can be written in
+// place with NoTempFile because it doesn't exist or was left partial by an
+// interrupted copy, according to marker.
+func (t *Transfer) writableInPlace(path, marker string) bool {
+	if _, err := t.Dstfs.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	_, err := t.Dstfs.Stat(marker)
+	return err == nil
+}
+
+// writeMarker creates empty file path in Dstfs
+func (t *Transfer) writeMarker(path string) error {
+	f, err := t.Dstfs.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeTemp writes src to a new temp file at path in Dstfs, gzipping it in
+// transit if gzipFlag is set, then sets its modification time to mtime. name
+// and mtime are also recorded in the gzip header. size is the size of the
+// source file, used to choose the gzip encoder. If written isn't nil it also
+// receives the bytes written to the file, e.g. to hash them. It returns the
+// number of bytes written. The temp file is removed on failure.
+func (t *Transfer) writeTemp(path string, src io.Reader, gzipFlag bool, name string, mtime time.Time, size int64, written io.Writer) (int64, error) {
+	out, err := t.Dstfs.Create(path)
+	if err != nil {
+		return 0, transferError(StageCreate, "", path, fmt.Errorf("could not create output file %v: %w", path, err))
+	}
+	// Don't leave partial temp files behind on failure
+	abort := func(err error) (int64, error) {
+		_ = out.Close() // free open file, don't care about errors
+		_ = t.Dstfs.Remove(path)
+		return 0, err
+	}
+	counter := &countingWriter{w: out}
+	if written != nil {
+		counter.w = io.MultiWriter(out, written)
+	}
+	outbuf := bufio.NewWriter(counter)
+	if t.BufferSize > 0 {
+		outbuf = bufio.NewWriterSize(counter, t.BufferSize)
+	}
+	if gzipFlag {
+		outgz, err := t.newGzipWriter(outbuf, name, mtime, size, t.gzipLevel())
+		if err != nil {
+			return abort(err)
+		}
+		var w io.Writer = outgz
+		if t.GzipFlushInterval > 0 {
+			w = &flushingWriter{w: outgz, interval: t.GzipFlushInterval, flush: func() error {
+				if err := outgz.(flusher).Flush(); err != nil {
+					return err
+				}
+				return outbuf.Flush()
+			}}
+		}
+		if _, err := t.copy(w, src); err != nil {
+			return abort(err)
+		}
+		if err := outgz.Close(); err != nil {
+			return abort(err)
+		}
+	} else if _, err := t.copy(outbuf, src); err != nil {
+		return abort(err)
+	}
+
+	// Flush and close everything
+	if err := outbuf.Flush(); err != nil {
+		return abort(err)
+	}
+	if t.Fsync {
+		if err := t.syncFile(out); err != nil {
+			return abort(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return abort(err)
+	}
+
+	// Set modtime
+	if err := t.Dstfs.Chtimes(path, time.Now().Local(), mtime); err != nil {
+		_ = t.Dstfs.Remove(path)
+		return 0, transferError(StageChtimes, "", path, fmt.Errorf("could not update mtime for output file %v: %w", path, err))
+	}
+	return counter.n, nil
+}
+
+// newGzipWriter returns a gzip writer to w at compression level for a file of
+// size bytes, parallel if ParallelGzip or ParallelGzipSize call for it, with
+// name and mtime in the header
+func (t *Transfer) newGzipWriter(w io.Writer, name string, mtime time.Time, size int64, level int) (io.WriteCloser, error) {
+	if t.ParallelGzip || (t.ParallelGzipSize > 0 && size >= t.ParallelGzipSize) {
+		gzw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		gzw.Name = name
+		gzw.ModTime = mtime
+		return gzw, nil
+	}
+	gzw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	gzw.Name = name
+	// Set mod time for original file
+	gzw.ModTime = mtime
+	return gzw, nil
+}
+
+// gzipLevel returns GzipLevel, or gzip.DefaultCompression if it's 0
+func (t *Transfer) gzipLevel() int {
+	if t.GzipLevel == 0 {
+		return gzip.DefaultCompression
+	}
+	return t.GzipLevel
+}
+
+// recordCopy runs the PostCopy hook for a completed copy and adds it to Stats.
+// A copy whose hook fails with PostCopyFatal is counted as failed by the
+// caller instead. Partial copies with HeadBytes are only counted, since they
+// aren't real copies.
+func (t *Transfer) recordCopy(ctx context.Context, rec FileRecord) error {
+	if t.HeadBytes > 0 {
+		t.Stats.addCopied(rec, true)
+		return nil
+	}
+	storeResult(ctx, rec)
+	if t.PostCopy != nil {
+		if err := t.PostCopy(ctx, rec); err != nil {
+			if t.PostCopyFatal {
+				return fmt.Errorf("%v: %w: %v", rec.Dst, ErrPostCopy, err)
+			}
+			t.logger().Error("post-copy hook failed", "path", rec.Src, "dst", rec.Dst, "error", err)
+		}
+	}
+	t.Stats.addCopied(rec, false)
+	return nil
+}
+
+// LogGzipReport logs the total uncompressed and compressed sizes of files
+// gzipped in transit so far and the overall compression ratio. Nothing is
+// logged if no files were gzipped.
+func (t *Transfer) LogGzipReport() {
+	s := t.Stats.Summary()
+	if s.Gzipped == 0 {
+		return
+	}
+	t.logger().Info("gzip compaction", "files", s.Gzipped, "bytes", s.GzipBytesIn, "compressedBytes", s.GzipBytesOut, "saved", s.GzipBytesIn-s.GzipBytesOut, "ratio", fmt.Sprintf("%.3f", s.GzipRatio()))
+}
+
+// checkMtime logs a warning if the modification time of destination file path
+// differs from mtime by more than a second. Some servers round or ignore
+// mtimes, which breaks SkipUnchanged and RefreshStale on later runs. It
+// returns true if the destination kept mtime exactly, including sub-second
+// precision, which e.g. SFTP can't set.
+func (t *Transfer) checkMtime(path string, mtime time.Time) bool {
+	info, err := t.Dstfs.Stat(path)
+	if err != nil {
+		t.logger().Error("warning: could not stat destination file to check modification time", "path", path, "error", err)
+		return false
+	}
+	if d := info.ModTime().Sub(mtime); d > time.Second || d < -time.Second {
+		t.logger().Error("warning: destination modification time differs from source", "path", path, "mtime", info.ModTime(), "srcMtime", mtime)
+		return false
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.mtimeOnce.Do(func() {
+			t.logger().Info("destination doesn't keep sub-second modification times, exact source times are in copy records", "path", path, "mtime", info.ModTime(), "srcMtime", mtime)
+		})
+		return false
+	}
+	return true
+}
+
+// copyRemove moves from to to within Dstfs by copying, syncing, and removing
+// from, for when from can't be renamed. A partially written to is removed on
+// failure.
+func (t *Transfer) copyRemove(ctx context.Context, from, to string, mtime time.Time) error {
+	in, err := t.Dstfs.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := t.Dstfs.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = t.copy(out, ctxReader{ctx: ctx, r: in})
+	if err == nil {
+		// from is removed next, so to must be durable first
+		err = t.syncFile(out)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = t.Dstfs.Chtimes(to, time.Now().Local(), mtime)
+	}
+	if err != nil {
+		_ = t.Dstfs.Remove(to)
+		return err
+	}
+	if err := t.Dstfs.Remove(from); err != nil {
+		t.logger().Error("warning: could not remove temp file", "path", from, "error", err)
+	}
+	return nil
+}
+
+// gzipValidator is an io.Writer which checks that the bytes written to it are
+// a valid gzip stream. Writes fail with an error wrapping ErrInvalidGzip as
+// soon as invalid data is detected.
+type gzipValidator struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+	err    error
+}
+
+func newGzipValidator() *gzipValidator {
+	pr, pw := io.Pipe()
+	v := &gzipValidator{pw: pw, done: make(chan error, 1)}
+	go func() {
+		zr, err := gzip.NewReader(pr)
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, zr)
+		}
+		if err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidGzip, err)
+		}
+		pr.CloseWithError(err) // unblock and fail writers
+		v.done <- err
+	}()
+	return v
+}
+
+func (v *gzipValidator) Write(p []byte) (int, error) {
+	return v.pw.Write(p)
+}
+
+// Close signals the end of the stream and returns the validation result. It
+// may be called more than once.
+func (v *gzipValidator) Close() error {
+	if !v.closed {
+		v.pw.Close()
+		v.err = <-v.done
+		v.closed = true
+	}
+	return v.err
+}
+
+// countingWriter counts bytes written
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// flusher is implemented by gzip writers which can flush pending compressed
+// data
+type flusher interface {
+	Flush() error
+}
+
+// flushingWriter calls flush after every interval bytes written, splitting
+// writes which cross an interval boundary
+type flushingWriter struct {
+	w        io.Writer
+	interval int64
+	flush    func() error
+	n        int64 // bytes since the last flush
+}
+
+func (f *flushingWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if remaining := f.interval - f.n; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := f.w.Write(chunk)
+		written += n
+		f.n += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if f.n >= f.interval {
+			f.n = 0
+			if err := f.flush(); err != nil {
+				return written, err
+			}
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// copy copies src to dst, pipelining reads and writes if t.BufferSize is set
+func (t *Transfer) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if t.BufferSize > 0 {
+		return pipeCopy(dst, src, t.BufferSize)
+	}
+	return io.Copy(dst, src)
+}
+
+// pipeCopy copies from src to dst like io.Copy, but reads the next chunk from
+// src while the previous chunk is being written to dst. When src and dst are
+// both high latency, e.g. SFTP to SFTP, throughput approaches that of the
+// slower side rather than the sum of both sides' per-chunk latency, up to
+// roughly double the throughput of io.Copy in the best case.
+func pipeCopy(dst io.Writer, src io.Reader, size int) (written int64, err error) {
+	free := make(chan []byte, 2)
+	full := make(chan []byte, 2)
+	free <- make([]byte, size)
+	free <- make([]byte, size)
+	done := make(chan struct{}) // closed if the writer stops early
+	var readErr error
+
+	go func() {
+		defer close(full)
+		for {
+			var buf []byte
+			select {
+			case buf = <-free:
+			case <-done:
+				return
+			}
+			n, err := src.Read(buf)
+			if n > 0 {
+				full <- buf[:n]
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+		}
+	}()
+
+	for buf := range full {
+		n, err := dst.Write(buf)
+		written += int64(n)
+		if err == nil && n < len(buf) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			close(done)
+			for range full {
+				// wait for reader to exit
+			}
+			return written, err
+		}
+		free <- buf[:cap(buf)]
+	}
+	return written, readErr
+}
+
+// checksum returns the SHA-256 hash of the file at path in fsys. If gzipped is
+// true, the hash is computed over the decompressed contents.
+func checksum(fsys Fs, path string, gzipped bool) ([]byte, error) {
+	return hashFile(fsys, path, gzipped, sha256.New())
+}
+
+// hashFile is like checksum but returns the hash computed by h
+func hashFile(fsys Fs, path string, gzipped bool, h hash.Hash) ([]byte, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if gzipped {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Close releases any resources held
+func (t *Transfer) Close() (err error) {
+	srcerr := t.Srcfs.Close()
+	dsterr := t.Dstfs.Close()
+	switch {
+	case srcerr != nil:
+		err = srcerr
+	case dsterr != nil:
+		err = dsterr
+	}
+	return err
+}
+
+// timeFromFilename parses a SeaFlow timestamped filename. This function assumes
+// all times are UTC, even if they have non-UTC timezone designator.
+func timeFromFilename(fn string) (time.Time, error) {
+	fnbase := filepath.Base(fn)
+	re := regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2})-(\d{2})-(\d{2}(?:\.\d+)?)(?:.+)?$`)
+	subs := re.FindStringSubmatch(fnbase)
+	if len(subs) != 4 {
+		return time.Time{}, fmt.Errorf("file timtestamp could not be parsed for %v", fn)
+	}
+	ts := subs[1] + ":" + subs[2] + ":" + subs[3] + "Z"
+	return time.Parse(time.RFC3339, ts)
+}
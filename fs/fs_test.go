@@ -0,0 +1,2812 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	mrand "math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const nanoseconds = 1000000000
+
+type StorageTestSuite struct {
+	suite.Suite
+	tmpDir string
+	srcDir string
+	dstDir string
+	t      *Transfer
+}
+
+func (suite *StorageTestSuite) SetupTest() {
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	suite.tmpDir = tmpDir
+	suite.srcDir = filepath.Join(tmpDir, "src")
+	err = os.Mkdir(suite.srcDir, os.ModeDir|0755)
+	if err != nil {
+		panic(err)
+	}
+	suite.dstDir = filepath.Join(tmpDir, "dst")
+	srcfs, _ := NewLocalfs()
+	dstfs, _ := NewLocalfs()
+	suite.t = &Transfer{
+		Srcroot: suite.srcDir,
+		Dstroot: suite.dstDir,
+		Srcfs:   srcfs,
+		Dstfs:   dstfs,
+		Debug:   log.New(ioutil.Discard, "", 0),
+		Info:    log.New(ioutil.Discard, "", 0),
+		Error:   log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func (suite *StorageTestSuite) TeardownTest() {
+	os.RemoveAll(suite.tmpDir)
+}
+
+func TestStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(StorageTestSuite))
+}
+
+func (suite *StorageTestSuite) TestCopyFileLocalLocal() {
+	testCopyFile(suite)
+}
+
+func testCopyFile(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.FileExists(filepath.Join(suite.dstDir, a), a+" copied")
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.Equal(
+		mtime(filepath.Join(suite.srcDir, a)).UnixNano()/nanoseconds,
+		mtime(filepath.Join(suite.dstDir, a)).UnixNano()/nanoseconds,
+		a+" modtime updated",
+	)
+}
+
+func (suite *StorageTestSuite) TestCopyFilegzLocalLocal() {
+	testCopyFilegz(suite)
+}
+
+func testCopyFilegz(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), true)
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.FileExists(filepath.Join(suite.dstDir, a+".gz"), a+" copied")
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" content is correct")
+	assert.Equal(
+		mtime(filepath.Join(suite.srcDir, a)).UnixNano(),
+		mtime(filepath.Join(suite.dstDir, a+".gz")).UnixNano(),
+		a+" modtime updated",
+	)
+	assert.Equal(
+		mtime(filepath.Join(suite.srcDir, a)).UnixNano()/nanoseconds,
+		mtimegz(filepath.Join(suite.dstDir, a+".gz")).UnixNano()/nanoseconds,
+		a+" gzipped content modtime updated",
+	)
+}
+
+func (suite *StorageTestSuite) TestCopyFileAlreadygzLocalLocal() {
+	testCopyFileAlreadygz(suite)
+}
+
+func testCopyFileAlreadygz(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00.gz")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.srcDir, a), "a")
+
+	// Asking to gzip an already gzipped file shouldn't gzip it again
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), true)
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.FileExists(filepath.Join(suite.dstDir, a), a+" copied")
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.True(
+		mtime(filepath.Join(suite.srcDir, a)).Equal(mtime(filepath.Join(suite.dstDir, a))),
+		a+" modtime updated",
+	)
+}
+
+func (suite *StorageTestSuite) TestCopyFileVerifyLocalLocal() {
+	testCopyFileVerify(suite)
+}
+
+func testCopyFileVerify(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Verify = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+	assert.Nil(err)
+	err = suite.t.CopyFile(filepath.Join(suite.srcDir, b), true)
+	assert.Nil(err)
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" content is correct")
+}
+
+func (suite *StorageTestSuite) TestCopyFileVerifyMismatchLocalLocal() {
+	testCopyFileVerifyMismatch(suite)
+}
+
+func testCopyFileVerifyMismatch(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Verify = true
+	suite.t.Dstfs = truncatingfs{}
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "abc")
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+
+	assert.NotNil(err)
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a)), a+" bad copy removed")
+}
+
+// truncatingfs is a Localfs which silently drops the last byte of every write
+type truncatingfs struct {
+	Localfs
+}
+
+func (l truncatingfs) Create(path string) (File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return truncatingFile{f: f}, nil
+}
+
+// truncatingFile wraps os.File without exposing ReadFrom, so that all writes go
+// through Write
+type truncatingFile struct {
+	f *os.File
+}
+
+func (f truncatingFile) Close() error               { return f.f.Close() }
+func (f truncatingFile) Read(b []byte) (int, error) { return f.f.Read(b) }
+func (f truncatingFile) Stat() (os.FileInfo, error) { return f.f.Stat() }
+
+func (f truncatingFile) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := f.f.Write(b[:len(b)-1])
+	if err != nil {
+		return n, err
+	}
+	return len(b), nil
+}
+
+func (suite *StorageTestSuite) TestCopyFileRetryLocalLocal() {
+	testCopyFileRetry(suite)
+}
+
+func testCopyFileRetry(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	flaky := &flakyfs{failures: 2}
+	suite.t.Dstfs = flaky
+	suite.t.MaxRetries = 2
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+
+	assert.Nil(err)
+	assert.Equal(3, flaky.creates, "create attempted 3 times")
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+
+	// Missing source files should not be retried
+	flaky.creates = 0
+	err = suite.t.CopyFile(filepath.Join(suite.srcDir, "2016_133", "missing"), false)
+	assert.NotNil(err)
+	assert.Equal(0, flaky.creates, "missing source not retried")
+}
+
+// flakyfs is a Localfs where the first failures calls to create fail
+type flakyfs struct {
+	Localfs
+	failures int
+	creates  int
+}
+
+func (l *flakyfs) Create(path string) (File, error) {
+	l.creates++
+	if l.creates <= l.failures {
+		return nil, fmt.Errorf("connection lost: %w", syscall.ECONNRESET)
+	}
+	return l.Localfs.Create(path)
+}
+
+func (suite *StorageTestSuite) TestCopyFileTimeoutLocalLocal() {
+	testCopyFileTimeout(suite)
+}
+
+func testCopyFileTimeout(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	hung := &hangingfs{release: make(chan struct{})}
+	suite.t.Srcfs = hung
+	suite.t.FileTimeout = 50 * time.Millisecond
+	suite.t.MaxRetries = 2
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	time.AfterFunc(200*time.Millisecond, func() { close(hung.release) })
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), true)
+
+	assert.True(errors.Is(err, context.DeadlineExceeded), "deadline error returned")
+	assert.Contains(err.Error(), "file timeout")
+	// The cancelled copy returns once the hung read does, after cleaning up
+	files, _ := ioutil.ReadDir(filepath.Join(suite.dstDir, "2016_133"))
+	assert.Equal(0, len(files), "temp file removed")
+}
+
+// hangingfs is a Localfs where reads block until release is closed
+type hangingfs struct {
+	Localfs
+	release chan struct{}
+}
+
+func (l *hangingfs) Open(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return hangingFile{f, l.release}, nil
+}
+
+type hangingFile struct {
+	*os.File
+	release chan struct{}
+}
+
+func (f hangingFile) Read(b []byte) (int, error) {
+	<-f.release
+	return f.File.Read(b)
+}
+
+func (suite *StorageTestSuite) TestCopyFileCancelLocalLocal() {
+	testCopyFileCancel(suite)
+}
+
+func testCopyFileCancel(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.MaxRetries = 2
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := suite.t.CopyFileContext(ctx, filepath.Join(suite.srcDir, a), true)
+
+	assert.True(errors.Is(err, context.Canceled), "cancellation error returned")
+	files, _ := ioutil.ReadDir(filepath.Join(suite.dstDir, "2016_133"))
+	assert.Equal(0, len(files), "temp file removed")
+
+	makeFile(filepath.Join(suite.srcDir, "2016_133", "2016-05-12T17-00-05-00-00"), "b")
+	err = suite.t.CopyEVTFilesContext(ctx)
+	assert.True(errors.Is(err, context.Canceled), "cancellation error returned")
+}
+
+func (suite *StorageTestSuite) TestCopyFileProgressLocalLocal() {
+	testCopyFileProgress(suite)
+}
+
+func testCopyFileProgress(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "abc")
+	var calls [][2]int64
+	suite.t.Progress = func(path string, copied, total int64) {
+		calls = append(calls, [2]int64{copied, total})
+	}
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), true)
+
+	assert.Nil(err)
+	if assert.True(len(calls) >= 2, "progress reported at start and end") {
+		assert.Equal([2]int64{0, 3}, calls[0], "start progress")
+		assert.Equal([2]int64{3, 3}, calls[len(calls)-1], "final progress counts uncompressed input bytes")
+	}
+}
+
+func (suite *StorageTestSuite) TestCopyFileRateLimitLocalLocal() {
+	testCopyFileRateLimit(suite)
+}
+
+func testCopyFileRateLimit(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.RateLimit = 1000
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), strings.Repeat("a", 1500))
+
+	// Initial burst allows 1000 bytes, remaining 500 should take ~0.5s
+	start := time.Now()
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+	elapsed := time.Since(start)
+
+	assert.Nil(err)
+	assert.True(elapsed >= 400*time.Millisecond, "copy was rate limited")
+	assert.Equal(1500, len(readFile(filepath.Join(suite.dstDir, a))), a+" content is correct")
+}
+
+func (suite *StorageTestSuite) TestCopySFLFilesNoMatchesLocalLocal() {
+	testCopySFLFilesNoMatches(suite)
+}
+func testCopySFLFilesNoMatches(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	err := suite.t.CopySFLFiles()
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.True(dirNotExists(suite.dstDir), "dest directory not created")
+}
+
+func (suite *StorageTestSuite) TestCopyEVTFilesNoMatchesLocalLocal() {
+	testCopyEVTFilesNoMatches(suite)
+}
+
+func testCopyEVTFilesNoMatches(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	err := suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.True(dirNotExists(suite.dstDir), "dest directory not created")
+}
+
+func (suite *StorageTestSuite) TestCopySFLFilesLocalLocal() {
+	testCopySFLFiles(suite)
+}
+
+func testCopySFLFiles(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "a.sfl")
+	b := filepath.Join("2016_134", "b.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+
+	err := suite.t.CopySFLFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.FileExists(filepath.Join(suite.dstDir, a), a+" copied")
+	assert.FileExists(filepath.Join(suite.dstDir, b), b+" copied")
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.Equal("b", readFile(filepath.Join(suite.dstDir, b)), b+" content is correct")
+
+	// Change source files
+	makeFile(filepath.Join(suite.srcDir, a), "aa")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+
+	err = suite.t.CopySFLFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.Equal("aa", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.Equal("bb", readFile(filepath.Join(suite.dstDir, b)), b+" content is correct")
+}
+
+func (suite *StorageTestSuite) TestCopySFLFilesAlreadygzLocalLocal() {
+	testCopySFLFilesAlreadygz(suite)
+}
+
+func testCopySFLFilesAlreadygz(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl.gz")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00.sfl")
+	c := filepath.Join("2016_134", "2016-05-13T17-00-02+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	makeFilegz(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFilegz(filepath.Join(suite.srcDir, c+".gz"), "old c") // same file as c
+
+	files, err := suite.t.ListSFLFiles()
+	assert.Nil(err)
+	assert.Contains(files, filepath.Join(suite.srcDir, a), a+" listed")
+
+	suite.t.GzipSFL = true
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a)), a+" copied, not gzipped again")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" not gzipped again")
+	assert.True(
+		mtime(filepath.Join(suite.srcDir, a)).Equal(mtime(filepath.Join(suite.dstDir, a))),
+		a+" modtime updated",
+	)
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" gzipped")
+	assert.Equal("c", readFilegz(filepath.Join(suite.dstDir, c+".gz")), c+" copied rather than its gzipped copy")
+	sum := suite.t.Stats.Summary()
+	assert.Equal(3, sum.Copied)
+	assert.Equal(1, sum.Skipped, c+".gz skipped")
+
+	// Gzipped source files aren't orphans
+	suite.t.ConfirmDelete = true
+	assert.Nil(suite.t.DeleteOrphans())
+	assert.FileExists(filepath.Join(suite.dstDir, a), a+" not deleted")
+	assert.FileExists(filepath.Join(suite.dstDir, b+".gz"), b+" not deleted")
+}
+
+func (suite *StorageTestSuite) TestCopySFLFilesWithTimeLocalLocal() {
+	testCopySFLFilesWithTime(suite)
+}
+
+func testCopySFLFilesWithTime(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Earliest, _ = time.Parse(time.RFC3339, "2016-05-12T04:00:00Z")
+	a := filepath.Join("2016_133", "a.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T03-00-00-00-00.sfl") // early file, should not get copied
+	c := filepath.Join("2016_133", "2016-05-12T04-00-00-00-00.sfl")
+	d := filepath.Join("2016_133", "2016-05-12T05-00-00-00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, d), "d")
+
+	err := suite.t.CopySFLFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.FileExists(filepath.Join(suite.dstDir, a), a+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b)), b+" early file not copied")
+	assert.FileExists(filepath.Join(suite.dstDir, c), c+" copied")
+	assert.FileExists(filepath.Join(suite.dstDir, d), d+" copied")
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.Equal("c", readFile(filepath.Join(suite.dstDir, c)), c+" content is correct")
+	assert.Equal("d", readFile(filepath.Join(suite.dstDir, d)), d+" content is correct")
+
+	// Change source files
+	makeFile(filepath.Join(suite.srcDir, a), "aa")
+	makeFile(filepath.Join(suite.srcDir, c), "cc")
+	makeFile(filepath.Join(suite.srcDir, d), "dd")
+
+	err = suite.t.CopySFLFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.Equal("aa", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b)), b+" early file not copied")
+	assert.Equal("cc", readFile(filepath.Join(suite.dstDir, c)), c+" content is correct")
+	assert.Equal("dd", readFile(filepath.Join(suite.dstDir, d)), d+" content is correct")
+}
+
+func (suite *StorageTestSuite) TestCopyEVTFilesLocalLocal() {
+	testCopyEVTFiles(suite)
+}
+
+func testCopyEVTFiles(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05-00-00")
+	c := filepath.Join("2016_134", "2016-05-13T00-00-35-00-00") // last file, should not get copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.FileExists(filepath.Join(suite.dstDir, a+".gz"), a+" copied")
+	assert.FileExists(filepath.Join(suite.dstDir, b+".gz"), b+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" (last file) not copied")
+	assert.Equal(
+		"a",
+		readFilegz(filepath.Join(suite.dstDir, a+".gz")),
+		a+" content is correct and file was not gzipped (again) in transit",
+	)
+	assert.Equal(
+		"b",
+		readFilegz(filepath.Join(suite.dstDir, b+".gz")),
+		b+" content is correct and have been gzipped in transit",
+	)
+
+	// Change source files
+	makeFile(filepath.Join(suite.srcDir, a), "aa")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+
+	err = suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" content was not updated because it already exists")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" content was not updated because it already exists")
+}
+
+func (suite *StorageTestSuite) TestCopyEVTFilesWithTimeLocalLocal() {
+	testCopyEVTFilesWithTime(suite)
+}
+
+func testCopyEVTFilesWithTime(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Earliest, _ = time.Parse(time.RFC3339, "2016-05-12T04:00:00Z")
+	a := filepath.Join("2016_133", "2016-05-12T03-00-02-00-00") // early file, should not get copied
+	b := filepath.Join("2016_133", "2016-05-12T04-00-05-00-00")
+	c := filepath.Join("2016_133", "2016-05-12T05-00-05-00-00")
+	d := filepath.Join("2016_133", "2016-05-12T06-00-05-00-00") // last file, should not get copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, d), "d")
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" early file not copied")
+	assert.FileExists(filepath.Join(suite.dstDir, b+".gz"), b+" copied")
+	assert.FileExists(filepath.Join(suite.dstDir, c+".gz"), c+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, d+".gz")), d+" last file not copied")
+	assert.Equal(
+		"b",
+		readFilegz(filepath.Join(suite.dstDir, b+".gz")),
+		b+" content is correct and have been gzipped in transit",
+	)
+	assert.Equal(
+		"c",
+		readFilegz(filepath.Join(suite.dstDir, c+".gz")),
+		c+" content is correct and have been gzipped in transit",
+	)
+
+	// Change source files
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+	makeFile(filepath.Join(suite.srcDir, c), "cc")
+
+	err = suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" early file not copied")
+	assert.Equal(
+		"b",
+		readFilegz(filepath.Join(suite.dstDir, b+".gz")),
+		b+" content was not updated because it already exists",
+	)
+	assert.Equal(
+		"c",
+		readFilegz(filepath.Join(suite.dstDir, c+".gz")),
+		c+" content was not updated because it already exists",
+	)
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, d+".gz")), d+" last file not copied")
+}
+
+func (suite *StorageTestSuite) TestCopySFLFilesWithEndTimeLocalLocal() {
+	testCopySFLFilesWithEndTime(suite)
+}
+
+func testCopySFLFilesWithEndTime(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Latest, _ = time.Parse(time.RFC3339, "2016-05-12T05:00:00Z")
+	a := filepath.Join("2016_133", "a.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T04-00-00-00-00.sfl")
+	c := filepath.Join("2016_133", "2016-05-12T05-00-00-00-00.sfl") // at end time, should not get copied
+	d := filepath.Join("2016_133", "2016-05-12T06-00-00-00-00.sfl") // late file, should not get copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, d), "d")
+
+	err := suite.t.CopySFLFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.FileExists(filepath.Join(suite.dstDir, a), a+" copied")
+	assert.FileExists(filepath.Join(suite.dstDir, b), b+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c)), c+" file at end time not copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, d)), d+" late file not copied")
+}
+
+func (suite *StorageTestSuite) TestCopyEVTFilesWithEndTimeLocalLocal() {
+	testCopyEVTFilesWithEndTime(suite)
+}
+
+func testCopyEVTFilesWithEndTime(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Earliest, _ = time.Parse(time.RFC3339, "2016-05-12T04:00:00Z")
+	suite.t.Latest, _ = time.Parse(time.RFC3339, "2016-05-12T05:00:00Z")
+	a := filepath.Join("2016_133", "2016-05-12T03-00-02-00-00") // early file, should not get copied
+	b := filepath.Join("2016_133", "2016-05-12T04-00-05-00-00")
+	c := filepath.Join("2016_133", "2016-05-12T05-00-00-00-00") // at end time, should not get copied
+	d := filepath.Join("2016_133", "2016-05-12T06-00-05-00-00") // last file, should not get copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, d), "d")
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" early file not copied")
+	assert.FileExists(filepath.Join(suite.dstDir, b+".gz"), b+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" file at end time not copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, d+".gz")), d+" last file not copied")
+}
+
+func (suite *StorageTestSuite) TestCopyEVTFilesAllAfterEndTimeLocalLocal() {
+	testCopyEVTFilesAllAfterEndTime(suite)
+}
+
+func testCopyEVTFilesAllAfterEndTime(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Latest, _ = time.Parse(time.RFC3339, "2016-05-12T04:00:00Z")
+	a := filepath.Join("2016_133", "2016-05-12T04-00-02-00-00")
+	b := filepath.Join("2016_133", "2016-05-12T05-00-05-00-00")
+	c := filepath.Join("2016_133", "2016-05-12T06-00-05-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	if err != nil {
+		return
+	}
+	assert.True(dirNotExists(suite.dstDir), "dest directory not created")
+}
+
+func (suite *StorageTestSuite) TestDryRunLocalLocal() {
+	testDryRun(suite)
+}
+
+func testDryRun(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.DryRun = true
+	a := filepath.Join("2016_133", "a.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-05-00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+	makeFile(filepath.Join(suite.srcDir, c), "ccc")
+
+	err := suite.t.CopySFLFiles()
+	assert.Nil(err)
+	assert.Equal(1, suite.t.plannedFiles, "planned SFL file count")
+	assert.Equal(int64(1), suite.t.plannedBytes, "planned SFL byte count")
+
+	err = suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal(1, suite.t.plannedFiles, "planned EVT file count excludes most recent file")
+	assert.Equal(int64(2), suite.t.plannedBytes, "planned EVT byte count")
+
+	assert.True(dirNotExists(suite.dstDir), "dest directory not created")
+}
+
+func (suite *StorageTestSuite) TestMoveLocalLocal() {
+	testMove(suite)
+}
+
+func testMove(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Move = true
+	suite.t.Verify = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T18-00-02-00-00.sfl") // most recent SFL, should not be removed
+	c := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	d := filepath.Join("2016_133", "2016-05-12T17-00-05-00-00") // last file, should not be copied or removed
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, d), "d")
+
+	err := suite.t.CopySFLFiles()
+	assert.Nil(err)
+	err = suite.t.CopyEVTFiles()
+	assert.Nil(err)
+
+	assert.True(fileNotExists(filepath.Join(suite.srcDir, a)), a+" source removed")
+	assert.FileExists(filepath.Join(suite.srcDir, b), b+" most recent SFL source not removed")
+	assert.True(fileNotExists(filepath.Join(suite.srcDir, c)), c+" source removed")
+	assert.FileExists(filepath.Join(suite.srcDir, d), d+" last file source not removed")
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+	assert.Equal("b", readFile(filepath.Join(suite.dstDir, b)), b+" content is correct")
+	assert.Equal("c", readFilegz(filepath.Join(suite.dstDir, c+".gz")), c+" content is correct")
+
+	// Source should be kept when verification fails
+	suite.t.Dstfs = truncatingfs{}
+	e := filepath.Join("2016_133", "2016-05-12T17-00-03-00-00")
+	makeFile(filepath.Join(suite.srcDir, e), "e")
+	err = suite.t.CopyFile(filepath.Join(suite.srcDir, e), false)
+	assert.NotNil(err)
+	assert.FileExists(filepath.Join(suite.srcDir, e), e+" source not removed after failed verification")
+}
+
+func (suite *StorageTestSuite) TestStatsLocalLocal() {
+	testStats(suite)
+}
+
+func testStats(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "a.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-05-00-00") // last file, should not get copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+	makeFile(filepath.Join(suite.srcDir, c), "ccc")
+	bTime := time.Date(2016, 5, 12, 17, 0, 2, 123456789, time.Local)
+	chtimes(filepath.Join(suite.srcDir, b), bTime, bTime)
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Nil(suite.t.CopyEVTFiles())
+
+	sum := suite.t.Stats.Summary()
+	assert.Equal(2, sum.Copied)
+	assert.Equal(1, sum.Skipped, "most recent EVT file skipped")
+	assert.Equal(0, sum.Failed)
+	assert.Equal(int64(3), sum.BytesRead)
+	assert.Equal(int64(1)+fileSize(filepath.Join(suite.dstDir, b+".gz")), sum.BytesWritten)
+	gzSize := fileSize(filepath.Join(suite.dstDir, b+".gz"))
+	assert.Equal(1, sum.Gzipped)
+	assert.Equal(int64(2), sum.GzipBytesIn, "only gzipped files counted")
+	assert.Equal(gzSize, sum.GzipBytesOut)
+	assert.InDelta(float64(gzSize)/2, sum.GzipRatio(), 1e-9)
+	if assert.Equal(2, len(sum.Files)) {
+		assert.Equal(FileRecord{
+			Src:            filepath.Join(suite.srcDir, b),
+			Dst:            filepath.Join(suite.dstDir, b+".gz"),
+			Size:           2,
+			BytesWritten:   gzSize,
+			Gzipped:        true,
+			CompressedSize: gzSize,
+			ModTime:        bTime,
+			ModTimeExact:   true,
+		}, sum.Files[1])
+	}
+	assert.Equal(0.0, Summary{}.GzipRatio(), "no ratio without gzipped files")
+}
+
+func (suite *StorageTestSuite) TestSkipUnchangedLocalLocal() {
+	testSkipUnchanged(suite)
+}
+
+func testSkipUnchanged(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.SkipUnchanged = true
+	a := filepath.Join("2016_133", "a.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal(1, suite.t.Stats.Summary().Copied)
+
+	// Same size and mtime, not copied
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal(1, suite.t.Stats.Summary().Copied, "unchanged file not copied")
+	assert.Equal(1, suite.t.Stats.Summary().Skipped, "unchanged file skipped")
+
+	// Same size but different mtime, copied
+	makeFile(filepath.Join(suite.srcDir, a), "b")
+	past := time.Now().Add(-time.Hour)
+	chtimes(filepath.Join(suite.srcDir, a), past, past)
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal(2, suite.t.Stats.Summary().Copied, "changed file copied")
+	assert.Equal("b", readFile(filepath.Join(suite.dstDir, a)), a+" content is correct")
+}
+
+func (suite *StorageTestSuite) TestGzipSFLLocalLocal() {
+	testGzipSFL(suite)
+}
+
+func testGzipSFL(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.GzipSFL = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" gzipped")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" gzipped")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a)), a+" not written uncompressed")
+	assert.Equal(mtime(filepath.Join(suite.srcDir, b)).Unix(), mtimegz(filepath.Join(suite.dstDir, b+".gz")).Unix(), b+" gzip header has source mtime")
+
+	// All SFL files are still copied, replacing the gzipped copy
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("bb", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" gzipped copy updated")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b)), b+" not written uncompressed")
+	assert.Equal(4, suite.t.Stats.Summary().Copied)
+
+	// Already gzipped SFL files aren't gzipped again
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00.sfl")
+	makeFilegz(filepath.Join(suite.srcDir, c+".gz"), "c")
+	suite.t.SFLPattern = "????_???/*.sfl.gz"
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("c", readFilegz(filepath.Join(suite.dstDir, c+".gz")), c+" not double gzipped")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz.gz")), c+" not double gzipped")
+}
+
+func (suite *StorageTestSuite) TestExtraPatternsLocalLocal() {
+	testExtraPatterns(suite)
+}
+
+func testExtraPatterns(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.ExtraPatterns = []string{"*.json", "*.log", "*"}
+	j := filepath.Join("2016_133", "instrument.json")
+	l := filepath.Join("2016_133", "acquisition.log")
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	s := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, j), "j")
+	makeFile(filepath.Join(suite.srcDir, l), "l")
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, s), "s")
+
+	assert.Nil(suite.t.CopyExtraFiles())
+	assert.Equal("j", readFile(filepath.Join(suite.dstDir, j)), j+" copied uncompressed")
+	assert.Equal("l", readFile(filepath.Join(suite.dstDir, l)), l+" copied uncompressed")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a)), a+" EVT file left to EVT pass")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, s)), s+" SFL file left to SFL pass")
+	assert.Equal(2, suite.t.Stats.Summary().Copied, "files matched by more than one pattern copied once")
+
+	// Always copied, like SFL files
+	makeFile(filepath.Join(suite.srcDir, l), "ll")
+	assert.Nil(suite.t.CopyExtraFiles())
+	assert.Equal("ll", readFile(filepath.Join(suite.dstDir, l)), l+" overwritten")
+	assert.Equal(4, suite.t.Stats.Summary().Copied)
+}
+
+func (suite *StorageTestSuite) TestDaysLocalLocal() {
+	testDays(suite)
+}
+
+func testDays(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Days = []string{"2016_133", "2016_135"}
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // most recent in selected days
+	c := filepath.Join("2016_134", "2016-05-13T17-00-02+00-00")
+	s1 := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	s2 := filepath.Join("2016_134", "2016-05-13T17-00-02+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	for _, f := range []string{a, b, c, s1, s2} {
+		makeFile(filepath.Join(suite.srcDir, f), "x")
+	}
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("x", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" most recent in selected days not copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" in other day not copied")
+	assert.Equal("x", readFile(filepath.Join(suite.dstDir, s1)), s1+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, s2)), s2+" in other day not copied")
+}
+
+func (suite *StorageTestSuite) TestTimezoneSignLocalLocal() {
+	testTimezoneSign(suite)
+}
+
+func testTimezoneSign(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	aMinus := filepath.Join("2016_133", "2016-05-12T17-00-02-00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	mkdir(suite.dstDir)
+	mkdir(filepath.Join(suite.dstDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.dstDir, aMinus+".gz"), "a")
+
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" not copied when present with other timezone sign")
+	assert.Equal(2, suite.t.Stats.Summary().Skipped, "duplicate and most recent files skipped")
+
+	suite.t.ConfirmDelete = true
+	assert.Nil(suite.t.DeleteOrphans())
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, aMinus+".gz")), aMinus+" not deleted as orphan")
+}
+
+func (suite *StorageTestSuite) TestFlattenLocalLocal() {
+	testFlatten(suite)
+}
+
+func testFlatten(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Flatten = true
+	suite.t.SFLPattern = "????_???/*.sfl"
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_134", "2016-05-13T17-00-02+00-00")
+	c := filepath.Join("2016_134", "2016-05-13T17-00-05+00-00") // most recent, not copied
+	s1 := filepath.Join("2016_133", "a.sfl")
+	s2 := filepath.Join("2016_134", "a.sfl") // same flattened name
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	for _, f := range []string{a, b, c, s1, s2} {
+		makeFile(filepath.Join(suite.srcDir, f), f)
+	}
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal(a, readFilegz(filepath.Join(suite.dstDir, filepath.Base(a)+".gz")), a+" flattened")
+	assert.Equal(b, readFilegz(filepath.Join(suite.dstDir, filepath.Base(b)+".gz")), b+" flattened")
+	assert.Equal(s1, readFile(filepath.Join(suite.dstDir, "a.sfl")), "first of colliding files copied")
+	assert.True(dirNotExists(filepath.Join(suite.dstDir, "2016_133")), "no day-of-year directory")
+	assert.Equal(3, suite.t.Stats.Summary().Copied)
+
+	// Flattened destination files are recognized as already copied
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal(3, suite.t.Stats.Summary().Copied, "flattened files not copied again")
+
+	// and aren't orphans
+	suite.t.ConfirmDelete = true
+	assert.Nil(suite.t.DeleteOrphans())
+	assert.Equal(0, suite.t.Stats.Summary().Deleted)
+	os.Remove(filepath.Join(suite.srcDir, a))
+	assert.Nil(suite.t.DeleteOrphans())
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, filepath.Base(a)+".gz")), a+" orphan deleted")
+}
+
+func (suite *StorageTestSuite) TestIncludeLatestLocalLocal() {
+	testIncludeLatest(suite)
+}
+
+func testIncludeLatest(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_134", "2016-05-13T17-00-05+00-00") // most recent
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+
+	suite.t.IncludeLatest = true
+	files, err := suite.t.ListEVTFiles()
+	assert.Nil(err)
+	assert.Equal([]string{filepath.Join(suite.srcDir, a), filepath.Join(suite.srcDir, b)}, files)
+
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" copied")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" most recent copied")
+	assert.Equal(0, suite.t.Stats.Summary().Skipped, "no files skipped")
+}
+
+func (suite *StorageTestSuite) TestAppendSFLLocalLocal() {
+	testAppendSFL(suite)
+}
+
+func testAppendSFL(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.AppendSFL = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "header\nline1\n")
+
+	// First copy is a full copy
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("header\nline1\n", readFile(filepath.Join(suite.dstDir, a)))
+
+	// Only appended bytes are written
+	makeFile(filepath.Join(suite.srcDir, a), "header\nline1\nline2\n")
+	chtimes(filepath.Join(suite.srcDir, a), time.Now(), time.Now().Add(time.Hour))
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("header\nline1\nline2\n", readFile(filepath.Join(suite.dstDir, a)), a+" appended")
+	assert.Equal(mtime(filepath.Join(suite.srcDir, a)).Unix(), mtime(filepath.Join(suite.dstDir, a)).Unix(), a+" mtime updated")
+	files := suite.t.Stats.Summary().Files
+	assert.Equal(int64(len("line2\n")), files[len(files)-1].BytesWritten, "only new bytes written")
+
+	// Rewritten files are copied in full
+	makeFile(filepath.Join(suite.srcDir, a), "HEADER\nline1\nline2\nline3\n")
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("HEADER\nline1\nline2\nline3\n", readFile(filepath.Join(suite.dstDir, a)), a+" rewritten file copied")
+	files = suite.t.Stats.Summary().Files
+	assert.Equal(int64(len("HEADER\nline1\nline2\nline3\n")), files[len(files)-1].BytesWritten, "full file written")
+}
+
+func (suite *StorageTestSuite) TestFollowSymlinksLocalLocal() {
+	testFollowSymlinks(suite)
+}
+
+func testFollowSymlinks(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_134", "2016-05-13T17-00-02+00-00") // reached through "current"
+	c := filepath.Join("2016_134", "2016-05-13T17-00-05+00-00") // most recent
+	staging := filepath.Join(suite.tmpDir, "staging")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(staging)
+	mkdir(filepath.Join(staging, "2016_134"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(staging, b), "b")
+	makeFile(filepath.Join(staging, c), "c")
+	if err := os.Symlink(filepath.Join(staging, "2016_134"), filepath.Join(suite.srcDir, "current")); err != nil {
+		panic(err)
+	}
+
+	// Not followed by default
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" not copied without FollowSymlinks")
+
+	suite.t.FollowSymlinks = true
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" copied")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" copied to target's day directory")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" most recent in symlinked directory not copied")
+	assert.True(dirNotExists(filepath.Join(suite.dstDir, "current")), "symlink name not used at destination")
+
+	// Files in symlinked directories aren't orphans
+	suite.t.ConfirmDelete = true
+	assert.Nil(suite.t.DeleteOrphans())
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" not deleted as orphan")
+}
+
+func (suite *StorageTestSuite) TestBracePatternsLocalLocal() {
+	testBracePatterns(suite)
+}
+
+func testBracePatterns(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.SFLPattern = filepath.Join("????_???", "*.{sfl,sflz,s{f,}l}")
+	a := filepath.Join("2016_133", "a.sfl")
+	b := filepath.Join("2016_133", "b.sflz")
+	c := filepath.Join("2016_133", "c.txt") // doesn't match any alternative
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+
+	files, err := suite.t.ListSFLFiles()
+	assert.Nil(err)
+	assert.Equal([]string{filepath.Join(suite.srcDir, a), filepath.Join(suite.srcDir, b)}, files, "each file listed once")
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" copied")
+	assert.Equal("b", readFile(filepath.Join(suite.dstDir, b)), b+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c)), c+" not matched")
+	assert.Equal(2, suite.t.Stats.Summary().Copied, "each file copied once")
+}
+
+func (suite *StorageTestSuite) TestDirModeLocalLocal() {
+	testDirMode(suite)
+}
+
+func testDirMode(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.DirMode = 0775
+	suite.t.TempDir = filepath.Join(suite.tmpDir, "tmp", "a")
+	a := filepath.Join("2016_133", "a.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)))
+	for _, dir := range []string{suite.dstDir, filepath.Join(suite.dstDir, "2016_133"), filepath.Join(suite.tmpDir, "tmp"), suite.t.TempDir} {
+		info, err := os.Stat(dir)
+		if assert.Nil(err) {
+			assert.Equal(os.FileMode(0775), info.Mode().Perm(), dir+" created with DirMode")
+		}
+	}
+	info, err := os.Stat(suite.tmpDir)
+	if assert.Nil(err) {
+		assert.Equal(os.FileMode(0700), info.Mode().Perm(), "existing directory unchanged")
+	}
+}
+
+func (suite *StorageTestSuite) TestMultipleSrcrootsLocalLocal() {
+	testMultipleSrcroots(suite)
+}
+
+func testMultipleSrcroots(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	srcDir2 := filepath.Join(suite.tmpDir, "src2")
+	suite.t.Srcroots = []string{srcDir2}
+	a := filepath.Join("2016_133", "a.sfl")
+	b := filepath.Join("2016_133", "b.sfl")
+	a1 := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	a2 := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // latest in first root
+	b1 := filepath.Join("2016_133", "2016-05-12T17-00-03+00-00")
+	b2 := filepath.Join("2016_134", "2016-05-13T00-00-00+00-00") // latest in second root
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(srcDir2)
+	mkdir(filepath.Join(srcDir2, "2016_133"))
+	mkdir(filepath.Join(srcDir2, "2016_134"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(srcDir2, b), "b")
+	makeFile(filepath.Join(suite.srcDir, a1), "a1")
+	makeFile(filepath.Join(suite.srcDir, a2), "a2")
+	makeFile(filepath.Join(srcDir2, b1), "b1")
+	makeFile(filepath.Join(srcDir2, b2), "b2")
+
+	files, err := suite.t.ListEVTFiles()
+	assert.Nil(err)
+	assert.Equal([]string{filepath.Join(suite.srcDir, a1), filepath.Join(srcDir2, b1)}, files, "latest file in each root excluded")
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)))
+	assert.Equal("b", readFile(filepath.Join(suite.dstDir, b)), "second root merged into same day directory")
+	assert.Equal("a1", readFilegz(filepath.Join(suite.dstDir, a1+".gz")))
+	assert.Equal("b1", readFilegz(filepath.Join(suite.dstDir, b1+".gz")))
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a2+".gz")), a2+" latest in first root not copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b2+".gz")), b2+" latest in second root not copied")
+
+	// Files from every root count as present
+	suite.t.ConfirmDelete = true
+	assert.Nil(suite.t.DeleteOrphans())
+	assert.Equal("b", readFile(filepath.Join(suite.dstDir, b)), "second root file not deleted")
+	assert.Equal("a1", readFilegz(filepath.Join(suite.dstDir, a1+".gz")), "first root file not deleted")
+}
+
+func (suite *StorageTestSuite) TestCustomPatternsLocalLocal() {
+	testCustomPatterns(suite)
+}
+
+func testCustomPatterns(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.SFLPattern = "*.sfl"
+	suite.t.EVTPattern = filepath.Join("????-??-??", "????-??-??T??-??-??[\\-\\+]??-??")
+	a := "a.sfl" // flat layout
+	b := filepath.Join("2016-05-12", "2016-05-12T17-00-02-00-00")
+	c := filepath.Join("2016-05-12", "2016-05-12T17-00-05-00-00") // last file, should not get copied
+	d := filepath.Join("2016_133", "b.sfl")                       // doesn't match custom pattern
+	mkdir(filepath.Join(suite.srcDir, "2016-05-12"))
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, d), "d")
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Nil(suite.t.CopyEVTFiles())
+
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" copied directly to dstRoot")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" copied to date directory")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" last file not copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, d)), d+" not matched")
+
+	// Destination duplicate check uses the custom EVT pattern
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" not copied again")
+}
+
+func (suite *StorageTestSuite) TestCopyOPPFilesLocalLocal() {
+	testCopyOPPFiles(suite)
+}
+
+func testCopyOPPFiles(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.opp")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00.opp.gz") // already gzipped
+	c := filepath.Join("2016_134", "2016-05-13T00-00-35+00-00.opp")    // most recent, still copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFilegz(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+
+	err := suite.t.CopyOPPFiles()
+
+	assert.Nil(err)
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" content is correct")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b)), b+" content is correct")
+	assert.Equal("c", readFilegz(filepath.Join(suite.dstDir, c+".gz")), c+" content is correct")
+
+	// Change source files
+	makeFile(filepath.Join(suite.srcDir, a), "aa")
+	makeFilegz(filepath.Join(suite.srcDir, b), "bb")
+
+	err = suite.t.CopyOPPFiles()
+
+	assert.Nil(err)
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" content was not updated because it already exists")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b)), b+" content was not updated because it already exists")
+}
+
+func (suite *StorageTestSuite) TestCopyVCTFilesLocalLocal() {
+	testCopyVCTFiles(suite)
+}
+
+func testCopyVCTFiles(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.vct")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+
+	err := suite.t.CopyVCTFiles()
+
+	assert.Nil(err)
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" content is correct")
+}
+
+func (suite *StorageTestSuite) TestKeepGoingLocalLocal() {
+	testKeepGoing(suite)
+}
+
+func testKeepGoing(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	suite.t.Dstfs = &flakyfs{failures: 1}
+
+	// Fail fast by default
+	err := suite.t.CopyEVTFiles()
+
+	assert.NotNil(err)
+	assert.False(errors.Is(err, ErrFilesFailed), "fail fast error returned")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" not copied")
+
+	suite.t.Dstfs = &flakyfs{failures: 1}
+	suite.t.KeepGoing = true
+
+	err = suite.t.CopyEVTFiles()
+
+	assert.True(errors.Is(err, ErrFilesFailed), "ErrFilesFailed returned")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" not copied")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" content is correct")
+	files, _ := ioutil.ReadDir(filepath.Join(suite.dstDir, "2016_133"))
+	assert.Equal(1, len(files), "no temp files left behind")
+	assert.Equal(2, suite.t.Stats.Summary().Failed)
+}
+
+func (suite *StorageTestSuite) TestManifestLocalLocal() {
+	testManifest(suite)
+}
+
+func testManifest(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	var manifest bytes.Buffer
+	suite.t.Manifest = &manifest
+	suite.t.Verify = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+	assert.Nil(err)
+	err = suite.t.CopyFile(filepath.Join(suite.srcDir, b), true)
+	assert.Nil(err)
+
+	// Files are listed as they are at the destination, gzipped or not
+	paths := checkManifestFiles(assert, manifest.String(), suite.dstDir, sha256.New)
+	assert.Equal([]string{"2016_133/2016-05-12T17-00-02+00-00.sfl", "2016_133/2016-05-12T17-00-05+00-00.gz"}, paths)
+	res, err := suite.t.CheckManifest(context.Background(), strings.NewReader(manifest.String()))
+	assert.Nil(err)
+	assert.Equal(ManifestResult{Matched: 2}, res)
+}
+
+// checkManifestFiles checks each entry in manifest against the file at its
+// path in dir as "sha256sum -c" would, using newHash, and returns the paths
+func checkManifestFiles(assert *assert.Assertions, manifest string, dir string, newHash func() hash.Hash) []string {
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSuffix(manifest, "\n"), "\n") {
+		fields := strings.SplitN(line, "  ", 2)
+		if !assert.Len(fields, 2, line) {
+			continue
+		}
+		paths = append(paths, fields[1])
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(fields[1])))
+		if assert.Nil(err, line) {
+			h := newHash()
+			h.Write(data)
+			assert.Equal(fmt.Sprintf("%x", h.Sum(nil)), fields[0], line)
+		}
+	}
+	return paths
+}
+
+func (suite *StorageTestSuite) TestManifestAlgoLocalLocal() {
+	testManifestAlgo(suite)
+}
+
+func testManifestAlgo(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+
+	tests := []struct {
+		algo    string
+		newHash func() hash.Hash
+		sflLine string
+	}{
+		{"md5", md5.New, "0cc175b9c0f1b6a831c399e269772661  2016_133/2016-05-12T17-00-02+00-00.sfl"},
+		{"sha1", sha1.New, "86f7e437faa5a7fce15d1ddcb9eaeaea377667b8  2016_133/2016-05-12T17-00-02+00-00.sfl"},
+		{"sha256", sha256.New, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  2016_133/2016-05-12T17-00-02+00-00.sfl"},
+	}
+	// md5sum -c and BagIt payload manifest lines
+	format := regexp.MustCompile(`^[0-9a-f]+  [^ ]\S*$`)
+	for _, tt := range tests {
+		var manifest bytes.Buffer
+		suite.t.Manifest = &manifest
+		suite.t.ManifestAlgo = tt.algo
+		suite.t.Verify = true
+		os.RemoveAll(suite.dstDir)
+
+		err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+		assert.Nil(err)
+		err = suite.t.CopyFile(filepath.Join(suite.srcDir, b), true)
+		assert.Nil(err)
+
+		lines := strings.Split(strings.TrimSuffix(manifest.String(), "\n"), "\n")
+		if assert.Len(lines, 2, tt.algo) {
+			assert.Equal(tt.sflLine, lines[0], tt.algo)
+		}
+		for _, line := range lines {
+			assert.Regexp(format, line, tt.algo)
+		}
+		checkManifestFiles(assert, manifest.String(), suite.dstDir, tt.newHash)
+	}
+
+	suite.t.ManifestAlgo = "crc32"
+	os.RemoveAll(suite.dstDir)
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "unknown manifest algorithm")
+	}
+}
+
+func (suite *StorageTestSuite) TestSkipEmptyLocalLocal() {
+	testSkipEmpty(suite)
+}
+
+func testSkipEmpty(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "")
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" empty file skipped")
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" content is correct")
+	assert.Equal(2, suite.t.Stats.Summary().Skipped, "empty file and most recent file skipped")
+
+	suite.t.CopyEmpty = true
+
+	err = suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	assert.Equal("", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" empty file copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" most recent file not copied")
+}
+
+func (suite *StorageTestSuite) TestMaxFileSizeLocalLocal() {
+	testMaxFileSize(suite)
+}
+
+func testMaxFileSize(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	s := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "aaaaa")
+	makeFile(filepath.Join(suite.srcDir, b), "bbbb")
+	makeFile(filepath.Join(suite.srcDir, c), "cccccc")
+	makeFile(filepath.Join(suite.srcDir, s), "sssss")
+	suite.t.MaxFileSize = 4
+
+	n, err := suite.t.EstimateBytes()
+	assert.Nil(err)
+	assert.Equal(int64(4), n, "files larger than maximum not counted")
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, s)), s+" larger than maximum skipped")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" larger than maximum skipped")
+	assert.Equal("bbbb", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" at maximum copied")
+	sum := suite.t.Stats.Summary()
+	assert.Equal(1, sum.Copied)
+	assert.Equal(3, sum.Skipped, "large files and most recent file skipped")
+
+	suite.t.MaxFileSize = 0
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("aaaaa", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" copied without maximum")
+}
+
+func (suite *StorageTestSuite) TestNoDowngradeLocalLocal() {
+	testNoDowngrade(suite)
+}
+
+func testNoDowngrade(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl") // destination newer
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00.sfl") // same mtime
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00.sfl") // destination older
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(suite.dstDir)
+	mkdir(filepath.Join(suite.dstDir, "2016_133"))
+	srcTime := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+	dstTimes := map[string]time.Time{
+		a: srcTime.Add(time.Hour),
+		b: srcTime,
+		c: srcTime.Add(-time.Hour),
+	}
+	for path, t := range dstTimes {
+		makeFile(filepath.Join(suite.srcDir, path), "new")
+		chtimes(filepath.Join(suite.srcDir, path), srcTime, srcTime)
+		makeFile(filepath.Join(suite.dstDir, path), "old")
+		chtimes(filepath.Join(suite.dstDir, path), t, t)
+	}
+	suite.t.NoDowngrade = true
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("old", readFile(filepath.Join(suite.dstDir, a)), a+" not replaced with older file")
+	assert.True(mtime(filepath.Join(suite.dstDir, a)).Equal(dstTimes[a]), a+" modtime unchanged")
+	assert.Equal("new", readFile(filepath.Join(suite.dstDir, b)), b+" replaced")
+	assert.Equal("new", readFile(filepath.Join(suite.dstDir, c)), c+" replaced")
+	sum := suite.t.Stats.Summary()
+	assert.Equal(2, sum.Copied)
+	assert.Equal(1, sum.Skipped)
+
+	// Within the tolerance counts as the same time
+	suite.t.MtimeTolerance = 2 * time.Hour
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Equal("new", readFile(filepath.Join(suite.dstDir, a)), a+" replaced within tolerance")
+}
+
+func (suite *StorageTestSuite) TestListFilesLocalLocal() {
+	testListFiles(suite)
+}
+
+func testListFiles(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_134", "2016-05-13T00-00-35+00-00") // most recent
+	s := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, s), "s")
+	suite.t.Earliest = time.Date(2016, 5, 12, 17, 0, 3, 0, time.UTC)
+
+	sfl, err := suite.t.ListSFLFiles()
+	assert.Nil(err)
+	assert.Equal([]string{}, sfl, "SFL file before earliest excluded")
+
+	evt, err := suite.t.ListEVTFiles()
+	assert.Nil(err)
+	assert.Equal([]string{filepath.Join(suite.srcDir, b)}, evt, "early and most recent EVT files excluded")
+	assert.True(dirNotExists(filepath.Join(suite.dstDir, "2016_133")), "nothing written to destination")
+}
+
+func (suite *StorageTestSuite) TestMinAgeLocalLocal() {
+	testMinAge(suite)
+}
+
+func testMinAge(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.MinAge = time.Minute
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // still being written
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	old := time.Now().Add(-2 * time.Minute)
+	chtimes(filepath.Join(suite.srcDir, a), old, old)
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" content is correct")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" too recent to copy")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" most recent not copied")
+	assert.Equal(2, suite.t.Stats.Summary().Skipped)
+}
+
+func (suite *StorageTestSuite) TestIncludeExcludeLocalLocal() {
+	testIncludeExclude(suite)
+}
+
+func testIncludeExclude(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Include = regexp.MustCompile(`T17-00-0[259]`)
+	suite.t.Exclude = regexp.MustCompile(`^2016-05-12T17-00-05|SN99`)
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // excluded
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // not included
+	d := filepath.Join("2016_133", "2016-05-12T17-00-09+00-00") // most recent, included but not copied
+	s1 := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	s2 := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.SN99.sfl") // excluded
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, d), "d")
+	makeFile(filepath.Join(suite.srcDir, s1), "s1")
+	makeFile(filepath.Join(suite.srcDir, s2), "s2")
+
+	err := suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	err = suite.t.CopySFLFiles()
+	assert.Nil(err)
+
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" content is correct")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" excluded")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" not included")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, d+".gz")), d+" most recent not copied")
+	assert.Equal("s1", readFile(filepath.Join(suite.dstDir, s1)), s1+" content is correct")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, s2)), s2+" excluded")
+	assert.Equal(2, suite.t.Stats.Summary().Copied)
+	assert.Equal(4, suite.t.Stats.Summary().Skipped)
+
+	files, err := suite.t.ListEVTFiles()
+	assert.Nil(err)
+	assert.Equal([]string{filepath.Join(suite.srcDir, a)}, files)
+}
+
+func (suite *StorageTestSuite) TestCompressThresholdLocalLocal() {
+	testCompressThreshold(suite)
+}
+
+func testCompressThreshold(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.CompressThreshold = 10
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00") // below threshold
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "bbbbbbbbbb")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" copied as-is")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+" not gzipped")
+	assert.Equal("bbbbbbbbbb", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" gzipped")
+	assert.Equal(2, suite.t.Stats.Summary().Copied)
+
+	// Uncompressed small files are already present on the next run
+	suite.t.Stats = Stats{}
+	err = suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal(0, suite.t.Stats.Summary().Copied)
+
+	// and aren't compressed after the fact
+	err = suite.t.CompressExisting()
+	assert.Nil(err)
+	assert.Equal("a", readFile(filepath.Join(suite.dstDir, a)), a+" not compressed")
+}
+
+func (suite *StorageTestSuite) TestOverlappingSrcrootsLocalLocal() {
+	testOverlappingSrcroots(suite)
+}
+
+func testOverlappingSrcroots(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	files := []string{
+		filepath.Join("2016_133", "2016-05-12T17-00-02+00-00"),
+		filepath.Join("2016_133", "2016-05-12T17-03-02+00-00"),
+		filepath.Join("2016_133", "2016-05-12T17-06-02+00-00"),
+		filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl"),
+	}
+	for _, f := range files {
+		makeFile(filepath.Join(suite.srcDir, f), f)
+	}
+	// The same root relative and absolute, with overlapping patterns
+	cwd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	rel, err := filepath.Rel(cwd, suite.srcDir)
+	if err != nil {
+		panic(err)
+	}
+	suite.t.Srcroots = []string{suite.srcDir, rel}
+	suite.t.EVTPattern = "{" + DefaultEVTPattern + ",????_???/2016-05-12T17-0[03]-02+00-00}"
+	suite.t.Workers = 4
+	var mu sync.Mutex
+	copies := make(map[string]int)
+	suite.t.PostCopy = func(ctx context.Context, r FileRecord) error {
+		mu.Lock()
+		defer mu.Unlock()
+		copies[filepath.Base(r.Dst)]++
+		return nil
+	}
+
+	assert.Nil(suite.t.CopySFLFiles())
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal(map[string]int{
+		"2016-05-12T17-00-02+00-00.sfl": 1,
+		"2016-05-12T17-00-02+00-00.gz":  1,
+		"2016-05-12T17-03-02+00-00.gz":  1,
+	}, copies, "each file copied once, latest EVT file skipped")
+	assert.Equal(3, suite.t.Stats.Summary().Copied)
+}
+
+func (suite *StorageTestSuite) TestParallelGzipLocalLocal() {
+	testParallelGzip(suite)
+}
+
+func testParallelGzip(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	// Several pgzip blocks
+	data := bytes.Repeat([]byte("seaflow event data "), 200000)
+	if err := ioutil.WriteFile(filepath.Join(suite.srcDir, a), data, 0644); err != nil {
+		panic(err)
+	}
+	aTime := time.Date(2016, 5, 12, 17, 0, 2, 0, time.UTC)
+	chtimes(filepath.Join(suite.srcDir, a), aTime, aTime)
+
+	tests := []struct {
+		name     string
+		parallel bool
+		size     int64
+	}{
+		{"ParallelGzip", true, 0},
+		{"ParallelGzipSize", false, int64(len(data))},
+	}
+	for _, tt := range tests {
+		os.RemoveAll(suite.dstDir)
+		suite.t.ParallelGzip = tt.parallel
+		suite.t.ParallelGzipSize = tt.size
+
+		err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), true)
+		assert.Nil(err, tt.name)
+
+		// A standard gzip stream with the usual header
+		f, err := os.Open(filepath.Join(suite.dstDir, a+".gz"))
+		if !assert.Nil(err, tt.name) {
+			continue
+		}
+		gzr, err := gzip.NewReader(f)
+		if assert.Nil(err, tt.name) {
+			got, err := ioutil.ReadAll(gzr)
+			assert.Nil(err, tt.name)
+			assert.True(bytes.Equal(data, got), tt.name+" content is correct")
+			assert.Equal(filepath.Base(a), gzr.Name, tt.name)
+			assert.True(aTime.Equal(gzr.ModTime), tt.name+" header mtime is source mtime")
+		}
+		f.Close()
+	}
+}
+
+func (suite *StorageTestSuite) TestUnsafePathLocalLocal() {
+	testUnsafePath(suite)
+}
+
+func testUnsafePath(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	name := "2016-05-12T17-00-02+00-00"
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.tmpDir, name), "a")
+	// Not cleaned, as paths built from names reported by a server
+	tests := []struct {
+		path         string
+		preserveTree bool
+	}{
+		{suite.srcDir + "/2016_133/../../" + name, false},
+		{suite.srcDir + "/2016_133/../../" + name, true},
+	}
+
+	for _, tt := range tests {
+		suite.t.PreserveTree = tt.preserveTree
+		err := suite.t.CopyFile(tt.path, true)
+		assert.True(errors.Is(err, ErrUnsafePath), "%v refused, preserveTree=%v", tt.path, tt.preserveTree)
+		var te *TransferError
+		if assert.True(errors.As(err, &te)) {
+			assert.Equal(StageCreate, te.Stage)
+		}
+	}
+	assert.True(fileNotExists(filepath.Join(suite.tmpDir, name+".gz")), "nothing written outside dstRoot")
+	assert.True(fileNotExists(suite.dstDir), "nothing written to dstRoot")
+}
+
+func (suite *StorageTestSuite) TestFsyncLocalLocal() {
+	testFsync(suite)
+}
+
+func testFsync(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-00+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-03-00+00-00")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	suite.t.Fsync = true
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), "synced file content is correct")
+	_, ok := suite.t.Dstfs.(DirSyncer)
+	assert.True(ok, "destination directories can be synced")
+}
+
+func (suite *StorageTestSuite) TestDeleteOrphansLocalLocal() {
+	testDeleteOrphans(suite)
+}
+
+func testDeleteOrphans(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // deleted at source
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, never copied
+	s := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	other := filepath.Join("2016_133", "notes.txt") // not a SeaFlow file
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(suite.dstDir)
+	mkdir(filepath.Join(suite.dstDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	makeFile(filepath.Join(suite.srcDir, s), "s")
+	makeFilegz(filepath.Join(suite.dstDir, a+".gz"), "a")
+	makeFilegz(filepath.Join(suite.dstDir, b+".gz"), "b")
+	makeFile(filepath.Join(suite.dstDir, s), "s")
+	makeFile(filepath.Join(suite.dstDir, other), "other")
+
+	// Not armed
+	err := suite.t.DeleteOrphans()
+
+	assert.Nil(err)
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" not deleted without ConfirmDelete")
+
+	suite.t.ConfirmDelete = true
+	err = suite.t.DeleteOrphans()
+
+	assert.Nil(err)
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" deleted")
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" kept")
+	assert.Equal("s", readFile(filepath.Join(suite.dstDir, s)), s+" kept")
+	assert.Equal("other", readFile(filepath.Join(suite.dstDir, other)), other+" kept")
+	assert.Equal(1, suite.t.Stats.Summary().Deleted)
+
+	// Missing source files are not treated as deletions
+	assert.Nil(os.RemoveAll(filepath.Join(suite.srcDir, "2016_133")))
+	err = suite.t.DeleteOrphans()
+
+	assert.Nil(err)
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" kept when source is empty")
+	assert.Equal("s", readFile(filepath.Join(suite.dstDir, s)), s+" kept when source is empty")
+}
+
+func (suite *StorageTestSuite) TestForceLocalLocal() {
+	testForce(suite)
+}
+
+func testForce(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	mkdir(suite.dstDir)
+	mkdir(filepath.Join(suite.dstDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.dstDir, a+".gz"), "corrupt")
+
+	err := suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	assert.Equal("corrupt", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" not overwritten without Force")
+
+	suite.t.Force = true
+	err = suite.t.CopyEVTFiles()
+
+	assert.Nil(err)
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" overwritten with Force")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" most recent still not copied")
+}
+
+func (suite *StorageTestSuite) TestRefreshStaleLocalLocal() {
+	testRefreshStale(suite)
+}
+
+func testRefreshStale(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	mkdir(suite.dstDir)
+	mkdir(filepath.Join(suite.dstDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.dstDir, a+".gz"), "old")
+	past := time.Now().Add(-time.Hour)
+	chtimes(filepath.Join(suite.srcDir, a), past, past)
+
+	// Source older than destination, not copied
+	suite.t.RefreshStale = true
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("old", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" older source not copied")
+
+	// Source newer than destination, only copied with RefreshStale
+	future := time.Now().Add(time.Hour)
+	chtimes(filepath.Join(suite.srcDir, a), future, future)
+	suite.t.RefreshStale = false
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("old", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" not copied without RefreshStale")
+	suite.t.RefreshStale = true
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" newer source copied")
+	assert.Equal(future.Unix(), mtimegz(filepath.Join(suite.dstDir, a+".gz")).Unix(), a+" gzip header has source mtime")
+
+	// Now up to date, not copied again
+	copied := suite.t.Stats.Summary().Copied
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal(copied, suite.t.Stats.Summary().Copied, a+" up to date copy not copied again")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" most recent still not copied")
+}
+
+func (suite *StorageTestSuite) TestRefreshGzipMtimeLocalLocal() {
+	testRefreshGzipMtime(suite)
+}
+
+func testRefreshGzipMtime(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "a")
+	makeFile(filepath.Join(suite.srcDir, b), "b")
+	makeFile(filepath.Join(suite.srcDir, c), "c")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	chtimes(filepath.Join(suite.srcDir, a), past, past)
+	chtimes(filepath.Join(suite.srcDir, b), past, past)
+	suite.t.RefreshGzipMtime = true
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("b", readFilegz(filepath.Join(suite.dstDir, b+".gz")))
+
+	// Written from an older version of the source file, header time differs
+	makeFilegz(filepath.Join(suite.dstDir, a+".gz"), "old")
+	assert.NotEqual(past.Unix(), mtimegz(filepath.Join(suite.dstDir, a+".gz")).Unix())
+	suite.t.RefreshGzipMtime = false
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("old", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" not copied without RefreshGzipMtime")
+	suite.t.RefreshGzipMtime = true
+	copied := suite.t.Stats.Summary().Copied
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal("a", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" copied again")
+	assert.Equal(past.Unix(), mtimegz(filepath.Join(suite.dstDir, a+".gz")).Unix(), a+" gzip header has source mtime")
+	assert.Equal(copied+1, suite.t.Stats.Summary().Copied, "only "+a+" copied again, "+b+" header matches")
+
+	// Now up to date, not copied again
+	assert.Nil(suite.t.CopyEVTFiles())
+	assert.Equal(copied+1, suite.t.Stats.Summary().Copied, a+" up to date copy not copied again")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c+".gz")), c+" most recent still not copied")
+}
+
+func (suite *StorageTestSuite) TestCheckFreeSpaceLocalLocal() {
+	testCheckFreeSpace(suite)
+}
+
+func testCheckFreeSpace(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	s := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "aaaa")
+	makeFile(filepath.Join(suite.srcDir, b), "bbbb")
+	makeFile(filepath.Join(suite.srcDir, c), "cccc")
+	makeFile(filepath.Join(suite.srcDir, s), "ss")
+	mkdir(suite.dstDir)
+	mkdir(filepath.Join(suite.dstDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.dstDir, b+".gz"), "bbbb") // already copied
+
+	n, err := suite.t.EstimateBytes()
+	assert.Nil(err)
+	assert.Equal(int64(6), n, "SFL file and new EVT file counted")
+
+	suite.t.Dstfs = smallfs{free: 10}
+	assert.Nil(suite.t.CheckFreeSpace(4), "enough space with margin")
+	assert.NotNil(suite.t.CheckFreeSpace(5), "not enough space with margin")
+
+	suite.t.Dstfs = smallfs{free: 10, err: ErrNotSupported}
+	assert.Nil(suite.t.CheckFreeSpace(100), "check skipped when not supported")
+}
+
+// smallfs is a Localfs which reports a fixed amount of free space
+type smallfs struct {
+	Localfs
+	free int64
+	err  error
+}
+
+func (l smallfs) FreeSpace(path string) (int64, error) {
+	return l.free, l.err
+}
+
+func (suite *StorageTestSuite) TestCheckGzipLocalLocal() {
+	testCheckGzip(suite)
+}
+
+func testCheckGzip(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.CheckGzip = true
+	suite.t.MaxRetries = 2
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.opp.gz")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00.opp.gz") // truncated
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.srcDir, a), "aaaaaaaaaaaaaaaaaaaa")
+	makeFilegz(filepath.Join(suite.srcDir, b), "bbbbbbbbbbbbbbbbbbbb")
+	size := fileSize(filepath.Join(suite.srcDir, b))
+	if err := os.Truncate(filepath.Join(suite.srcDir, b), size-4); err != nil {
+		panic(err)
+	}
+
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), true)
+	assert.Nil(err)
+	assert.Equal("aaaaaaaaaaaaaaaaaaaa", readFilegz(filepath.Join(suite.dstDir, a)), a+" content is correct")
+
+	err = suite.t.CopyFile(filepath.Join(suite.srcDir, b), true)
+	assert.True(errors.Is(err, ErrInvalidGzip), "ErrInvalidGzip returned")
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), b)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(suite.dstDir, "2016_133"))
+	assert.Equal(1, len(files), "invalid file and temp file not left behind")
+}
+
+func (suite *StorageTestSuite) TestDecompressLocalLocal() {
+	testDecompress(suite)
+}
+
+func testDecompress(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.Decompress = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // latest
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.srcDir, a+".gz"), "aa")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+	makeFile(filepath.Join(suite.srcDir, c), "cc")
+	headerTime := mtime(filepath.Join(suite.srcDir, a+".gz"))
+	chtimes(filepath.Join(suite.srcDir, a+".gz"), time.Now(), time.Now()) // differ from header
+
+	err := suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal("aa", readFile(filepath.Join(suite.dstDir, a)), a+" was decompressed")
+	assert.Equal(headerTime.Unix(), mtime(filepath.Join(suite.dstDir, a)).Unix(), a+" mtime is from gzip header")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, a+".gz")), a+".gz not copied")
+	assert.Equal("bb", readFile(filepath.Join(suite.dstDir, b)), b+" copied as-is")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, b+".gz")), b+" not gzipped")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, c)), c+" not copied")
+	assert.Equal(2, suite.t.Stats.Summary().Copied)
+
+	// Decompressed files count as present on later runs
+	err = suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal(2, suite.t.Stats.Summary().Copied, "files not copied again")
+}
+
+func (suite *StorageTestSuite) TestTempDirLocalLocal() {
+	testTempDir(suite)
+}
+
+func testTempDir(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	dstfs := &crossfs{}
+	suite.t.Dstfs = dstfs
+	suite.t.TempDir = filepath.Join(suite.tmpDir, "scratch")
+	suite.t.TempPrefix = ".tmp_"
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00.sfl")
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	makeFile(filepath.Join(suite.srcDir, a), "aa")
+	makeFile(filepath.Join(suite.srcDir, b), "bb")
+
+	// Renames work
+	err := suite.t.CopyFile(filepath.Join(suite.srcDir, a), false)
+	assert.Nil(err)
+	assert.Equal("aa", readFile(filepath.Join(suite.dstDir, a)))
+	if assert.Equal(1, len(dstfs.creates)) {
+		dir, name := filepath.Split(dstfs.creates[0])
+		assert.Equal(suite.t.TempDir, filepath.Clean(dir), "temp file in TempDir")
+		assert.True(strings.HasPrefix(name, ".tmp_"), "temp file has TempPrefix")
+	}
+
+	// Renames fail, fall back to copy and remove
+	dstfs.noRename = true
+	err = suite.t.CopyFile(filepath.Join(suite.srcDir, b), false)
+	assert.Nil(err)
+	assert.Equal("bb", readFile(filepath.Join(suite.dstDir, b)))
+	assert.Equal(mtime(filepath.Join(suite.srcDir, b)), mtime(filepath.Join(suite.dstDir, b)))
+	files, _ := ioutil.ReadDir(suite.t.TempDir)
+	assert.Equal(0, len(files), "temp files removed")
+}
+
+// crossfs is a Localfs which records created files and can fail renames as if
+// across filesystems
+type crossfs struct {
+	Localfs
+	noRename bool
+	creates  []string
+}
+
+func (c *crossfs) Create(path string) (File, error) {
+	c.creates = append(c.creates, path)
+	return c.Localfs.Create(path)
+}
+
+func (c *crossfs) Rename(oldname, newname string) error {
+	if c.noRename {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return c.Localfs.Rename(oldname, newname)
+}
+
+func (suite *StorageTestSuite) TestScanByDirLocalLocal() {
+	testScanByDir(suite)
+}
+
+func testScanByDir(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	dstfs := &globfs{}
+	suite.t.Dstfs = dstfs
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_134", "2016-05-13T17-00-02+00-00")
+	d := filepath.Join("2016_134", "2016-05-13T17-00-05+00-00") // latest
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	mkdir(filepath.Join(suite.srcDir, "2016_134"))
+	for _, f := range []string{a, b, c, d} {
+		makeFile(filepath.Join(suite.srcDir, f), "data")
+	}
+	mkdir(suite.dstDir)
+	mkdir(filepath.Join(suite.dstDir, "2016_133"))
+	makeFilegz(filepath.Join(suite.dstDir, a+".gz"), "data")
+
+	err := suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal("data", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" copied")
+	assert.Equal("data", readFilegz(filepath.Join(suite.dstDir, c+".gz")), c+" copied")
+	assert.True(fileNotExists(filepath.Join(suite.dstDir, d+".gz")), "latest file not copied")
+	sum := suite.t.Stats.Summary()
+	assert.Equal(2, sum.Copied)
+	assert.Equal(2, sum.Skipped)
+	for _, pattern := range dstfs.globs {
+		dir := filepath.Base(filepath.Dir(pattern))
+		assert.True(dir == "2016_133" || dir == "2016_134", "destination glob %v within one directory", pattern)
+	}
+}
+
+// globfs is a Localfs which records glob patterns
+type globfs struct {
+	Localfs
+	mu    sync.Mutex
+	globs []string
+}
+
+func (g *globfs) Glob(pattern string) ([]string, error) {
+	g.mu.Lock()
+	g.globs = append(g.globs, pattern)
+	g.mu.Unlock()
+	return g.Localfs.Glob(pattern)
+}
+
+func (suite *StorageTestSuite) TestPreserveTreeLocalLocal() {
+	testPreserveTree(suite)
+}
+
+func testPreserveTree(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	suite.t.EVTPattern = "????/???/????-??-??T??-??-??[\\-\\+]??-??"
+	suite.t.PreserveTree = true
+	a := filepath.Join("2016", "133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016", "134", "2016-05-13T17-00-02+00-00")
+	c := filepath.Join("2016", "134", "2016-05-13T17-00-05+00-00") // latest
+	mkdir(filepath.Join(suite.srcDir, "2016"))
+	mkdir(filepath.Join(suite.srcDir, "2016", "133"))
+	mkdir(filepath.Join(suite.srcDir, "2016", "134"))
+	for _, f := range []string{a, b, c} {
+		makeFile(filepath.Join(suite.srcDir, f), "data")
+	}
+
+	err := suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal("data", readFilegz(filepath.Join(suite.dstDir, a+".gz")), a+" copied with full path")
+	assert.Equal("data", readFilegz(filepath.Join(suite.dstDir, b+".gz")), b+" copied with full path")
+	assert.True(dirNotExists(filepath.Join(suite.dstDir, "133")), "not flattened")
+
+	// Files at the preserved path count as present
+	err = suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal(2, suite.t.Stats.Summary().Copied)
+}
+
+func (suite *StorageTestSuite) TestWorkersLocalLocal() {
+	testWorkers(suite)
+}
+
+func testWorkers(suite *StorageTestSuite) {
+	assert := assert.New(suite.T())
+	src := &concurrentfs{}
+	dst := &concurrentfs{}
+	suite.t.Srcfs = src
+	suite.t.Dstfs = dst
+	suite.t.Workers = 4
+	suite.t.DstWorkers = 2
+	mkdir(filepath.Join(suite.srcDir, "2016_133"))
+	var files []string
+	for i := 0; i < 9; i++ {
+		f := filepath.Join("2016_133", fmt.Sprintf("2016-05-12T17-00-%02d+00-00", i))
+		makeFile(filepath.Join(suite.srcDir, f), f)
+		files = append(files, f)
+	}
+
+	err := suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	for _, f := range files[:len(files)-1] {
+		assert.Equal(f, readFilegz(filepath.Join(suite.dstDir, f+".gz")), f+" copied")
+	}
+	assert.Equal(8, suite.t.Stats.Summary().Copied)
+	assert.True(src.max > 2, "source reads concurrent up to Workers")
+	assert.True(src.max <= 4, "source reads limited by Workers")
+	assert.Equal(2, dst.max, "destination writes limited by DstWorkers")
+
+	src.max = 0
+	suite.t = &Transfer{
+		Srcroot:    suite.srcDir,
+		Dstroot:    suite.dstDir,
+		Srcfs:      src,
+		Dstfs:      dst,
+		Log:        NewTextLogger(ioutil.Discard, LevelNone),
+		Force:      true,
+		Workers:    4,
+		SrcWorkers: 1,
+	}
+	err = suite.t.CopyEVTFiles()
+	assert.Nil(err)
+	assert.Equal(8, suite.t.Stats.Summary().Copied)
+	assert.Equal(1, src.max, "source reads limited by SrcWorkers")
+}
+
+// concurrentfs is a Localfs which records the maximum number of files open at
+// once, with slow reads and writes to make copies overlap
+type concurrentfs struct {
+	Localfs
+	mu   sync.Mutex
+	open int
+	max  int
+}
+
+func (c *concurrentfs) track(f File, err error) (File, error) {
+	if err != nil {
+		return f, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open++
+	if c.open > c.max {
+		c.max = c.open
+	}
+	return &concurrentFile{File: f, fs: c}, nil
+}
+
+func (c *concurrentfs) Open(path string) (File, error) {
+	return c.track(c.Localfs.Open(path))
+}
+
+func (c *concurrentfs) Create(path string) (File, error) {
+	return c.track(c.Localfs.Create(path))
+}
+
+type concurrentFile struct {
+	File
+	fs *concurrentfs
+}
+
+func (f *concurrentFile) Read(b []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return f.File.Read(b)
+}
+
+func (f *concurrentFile) Close() error {
+	time.Sleep(10 * time.Millisecond)
+	f.fs.mu.Lock()
+	f.fs.open--
+	f.fs.mu.Unlock()
+	return f.File.Close()
+}
+
+func chtimes(path string, atime time.Time, mtime time.Time) {
+	err := os.Chtimes(path, atime, mtime)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func dirNotExists(path string) bool {
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
+func fileNotExists(path string) bool {
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		panic(err)
+	}
+	return info.Size()
+}
+
+func mkdir(path string) {
+	err := os.Mkdir(path, os.ModeDir|0755)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mtime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		panic(err)
+	}
+	return info.ModTime()
+}
+
+func mtimegz(path string) time.Time {
+	r, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+	gzipr, err := gzip.NewReader(r)
+	if err != nil {
+		panic(err)
+	}
+	return gzipr.ModTime
+}
+
+func makeFile(path string, text string) {
+	// for tests  set modification time back one second to properly test gzip
+	// header modification time
+	err := ioutil.WriteFile(path, []byte(text), 0755)
+	if err != nil {
+		panic(err)
+	}
+	mtime := mtime(path)
+	mtime = mtime.Add(-1 * time.Second)
+	chtimes(path, mtime, mtime)
+}
+
+func makeFilegz(path string, text string) {
+	// for tests  set modification time back one second to properly test gzip
+	// header modification time
+	w, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	mtime := mtime(path)
+	mtime = mtime.Add(-1 * time.Second)
+
+	gzipw := gzip.NewWriter(w)
+	gzipw.ModTime = mtime
+	_, err = gzipw.Write([]byte(text))
+	if err != nil {
+		panic(err)
+	}
+
+	err = gzipw.Close()
+	if err != nil {
+		panic(err)
+	}
+	err = w.Close()
+	if err != nil {
+		panic(err)
+	}
+	chtimes(path, mtime, mtime)
+}
+
+func readFile(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+func readFilegz(path string) string {
+	r, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+	gzipr, err := gzip.NewReader(r)
+	if err != nil {
+		panic(err)
+	}
+	data := make([]byte, 100)
+	n, err := gzipr.Read(data)
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
+	return string(data[:n])
+}
+
+func Test_timeFromFilename(t *testing.T) {
+	timeAnswer, _ := time.Parse(time.RFC3339, "2019-12-06T22:58:10Z")
+	timeAnswerFrac, _ := time.Parse(time.RFC3339, "2019-12-06T22:58:10.3Z")
+	type args struct {
+		fn string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:    "correct with no gz",
+			args:    args{fn: "2019-12-06T22-58-10+00-00"},
+			want:    timeAnswer,
+			wantErr: false,
+		},
+		{
+			name:    "correct SFL",
+			args:    args{fn: "2019-12-06T22-58-10+00-00.sfl"},
+			want:    timeAnswer,
+			wantErr: false,
+		},
+		{
+			name:    "correct with gz",
+			args:    args{fn: "2019-12-06T22-58-10+00-00.gz"},
+			want:    timeAnswer,
+			wantErr: false,
+		},
+		{
+			name:    "correct with folders",
+			args:    args{fn: "some/directory/path/2019-12-06T22-58-10+00-00"},
+			want:    timeAnswer,
+			wantErr: false,
+		},
+		{
+			name:    "correct with non-UTC TZ",
+			args:    args{fn: "2019-12-06T22-58-10+07-00"},
+			want:    timeAnswer,
+			wantErr: false,
+		},
+		{
+			name:    "correct with fractional seconds",
+			args:    args{fn: "2019-12-06T22-58-10.3+00-00"},
+			want:    timeAnswerFrac,
+			wantErr: false,
+		},
+		{
+			name:    "incorrect with no gz",
+			args:    args{fn: "2019-12-06Ta22-58-10+00-00"},
+			wantErr: true,
+		},
+		{
+			name:    "incorrect with gz",
+			args:    args{fn: "2019-12-06Ta22-58-10+00-00.gz"},
+			wantErr: true,
+		},
+		{
+			name:    "incorrect with folders",
+			args:    args{fn: "some/directory/path/201a9-12-06T22-58-10+00-00"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := timeFromFilename(tt.args.fn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("timeFromFilename() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("timeFromFilename() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mtimeClose(t *testing.T) {
+	base := time.Date(2016, 5, 12, 17, 0, 2, 0, time.UTC)
+	tests := []struct {
+		name string
+		a, b time.Time
+		tol  time.Duration
+		want bool
+	}{
+		{"equal", base, base, 0, true},
+		{"sub-second exact", base.Add(500 * time.Millisecond), base, 0, false},
+		{"sub-second", base.Add(500 * time.Millisecond), base, time.Second, true},
+		{"sub-second reversed", base, base.Add(500 * time.Millisecond), time.Second, true},
+		{"at tolerance", base.Add(time.Second), base, time.Second, true},
+		{"past tolerance", base.Add(1500 * time.Millisecond), base, time.Second, false},
+		{"past tolerance reversed", base, base.Add(1500 * time.Millisecond), time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mtimeClose(tt.a, tt.b, tt.tol))
+		})
+	}
+}
+
+func Test_orderFiles(t *testing.T) {
+	files := []string{
+		"/src/2016_133/notes.sfl",
+		"/src/2016_133/2016-05-12T17-00-05+00-00",
+		"/src/2016_133/2016-05-12T17-00-04.5-07-00",
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-00-02+00-00.gz",
+	}
+	oldest := []string{
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-00-02+00-00.gz",
+		"/src/2016_133/2016-05-12T17-00-04.5-07-00",
+		"/src/2016_133/2016-05-12T17-00-05+00-00",
+		"/src/2016_133/notes.sfl",
+	}
+	tests := []struct {
+		name  string
+		order Order
+		want  []string
+	}{
+		{"oldest first", OrderOldestFirst, oldest},
+		{"newest first", OrderNewestFirst, []string{oldest[4], oldest[3], oldest[2], oldest[1], oldest[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Transfer{Order: tt.order}
+			got := append([]string{}, files...)
+			tr.orderFiles(got)
+			assert.Equal(t, tt.want, got)
+			o, err := ParseOrder(tt.order.String())
+			assert.Nil(t, err)
+			assert.Equal(t, tt.order, o)
+		})
+	}
+	_, err := ParseOrder("random")
+	assert.NotNil(t, err)
+}
+
+func TestLocalfsRenameNoReplace(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "fs-test-rename")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b, c := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")
+	makeFile(a, "a")
+	makeFile(b, "b")
+	var l Localfs
+	err = l.RenameNoReplace(a, b)
+	assert.True(errors.Is(err, os.ErrExist))
+	assert.Equal("a", readFile(a), "old file kept")
+	assert.Equal("b", readFile(b), "existing file not replaced")
+	assert.Nil(l.RenameNoReplace(a, c))
+	assert.True(fileNotExists(a), "old name removed")
+	assert.Equal("a", readFile(c))
+}
+
+func Test_checkWithin(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"/dst/2016_133/file", true},
+		{"/dst/file", true},
+		{"/dst/a/../file", true},
+		{"/dst", false},
+		{"/dst/..", false},
+		{"/dst/../file", false},
+		{"/dst/2016_133/../../file", false},
+		{"/dstx/file", false},
+		{"/other/file", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := checkWithin("/dst", tt.path)
+			if tt.ok {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrUnsafePath))
+			}
+		})
+	}
+}
+
+func Test_retryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"short read", transferError(StageCopy, "a", "b", io.ErrUnexpectedEOF), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"broken pipe", fmt.Errorf("write: %w", syscall.EPIPE), true},
+		{"sftp connection lost", transferError(StageCreate, "a", "b", sftp.ErrSSHFxConnectionLost), true},
+		{"stalled", fmt.Errorf("copy made no progress: %w", ErrStalled), true},
+		{"truncated SFL", transferError(StageVerify, "a", "b", ErrInvalidSFL), true},
+		{"missing", &os.PathError{Op: "open", Path: "a", Err: os.ErrNotExist}, false},
+		{"permission", &os.PathError{Op: "open", Path: "a", Err: os.ErrPermission}, false},
+		{"cancelled", context.Canceled, false},
+		{"deadline", fmt.Errorf("file timeout: %w", context.DeadlineExceeded), false},
+		{"invalid gzip", transferError(StageCopy, "a", "b", fmt.Errorf("%w: %v", ErrInvalidGzip, io.ErrUnexpectedEOF)), false},
+		{"checksum mismatch", transferError(StageVerify, "a", "b", errors.New("checksum mismatch")), false},
+		{"verify short read", transferError(StageVerify, "a", "b", io.ErrUnexpectedEOF), false},
+		{"clobber", transferError(StageRename, "a", "b", ErrClobber), false},
+		{"unsafe path", transferError(StageCreate, "a", "b", ErrUnsafePath), false},
+		{"other", errors.New("something else"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryable(tt.err))
+		})
+	}
+}
+
+func Test_validateSFL(t *testing.T) {
+	header := "FILE\tDATE\tFILE_DURATION\n"
+	rows := strings.Repeat("a.evt\t2016-05-12\t180\n", 1000)
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"complete", header + "a.evt\t2016-05-12\t180\n", true},
+		{"header only", header, true},
+		{"empty", "", true},
+		{"crlf", "FILE\tDATE\r\na.evt\t2016-05-12\r\n", true},
+		{"long file", header + rows, true},
+		{"no newline", header + "a.evt\t2016-05-12\t180", false},
+		{"header no newline", "FILE\tDATE", false},
+		{"short line", header + "a.evt\t2016-05-12\n", false},
+		{"long line", header + "a.evt\t2016-05-12\t180\t1\n", false},
+		{"long file truncated", header + rows + "a.evt\t2016-05-12\n", false},
+		{"last line too long", header + rows + strings.Repeat("a", sflTailSize) + "\t2016-05-12\t180\n", false},
+	}
+	for _, tt := range tests {
+		for _, gzipped := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%v gzipped=%v", tt.name, gzipped), func(t *testing.T) {
+				m, _ := NewMemfs()
+				data := []byte(tt.data)
+				if gzipped {
+					var b bytes.Buffer
+					gzw := gzip.NewWriter(&b)
+					_, _ = gzw.Write(data)
+					_ = gzw.Close()
+					data = b.Bytes()
+				}
+				assert.Nil(t, m.WriteFile("/a.sfl", data, time.Now()))
+				err := validateSFL(m, "/a.sfl", gzipped)
+				if tt.ok {
+					assert.Nil(t, err)
+				} else {
+					assert.True(t, errors.Is(err, ErrInvalidSFL), "%v", err)
+				}
+			})
+		}
+	}
+}
+
+func Test_pipeCopy(t *testing.T) {
+	assert := assert.New(t)
+	data := make([]byte, 100000)
+	_, _ = rand.Read(data)
+
+	var out bytes.Buffer
+	n, err := pipeCopy(&out, bytes.NewReader(data), 1000)
+	assert.Nil(err)
+	assert.Equal(int64(len(data)), n)
+	assert.Equal(data, out.Bytes(), "content is correct")
+
+	readErr := errors.New("read error")
+	_, err = pipeCopy(&out, io.MultiReader(bytes.NewReader(data), errReader{readErr}), 1000)
+	assert.Equal(readErr, err, "read error returned")
+
+	writeErr := errors.New("write error")
+	_, err = pipeCopy(errWriter{writeErr}, bytes.NewReader(data), 1000)
+	assert.Equal(writeErr, err, "write error returned")
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+type errWriter struct{ err error }
+
+func (w errWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+// latencyReader and latencyWriter simulate a high latency connection which
+// delays each read or write call
+type latencyReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r latencyReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p)
+}
+
+type latencyWriter struct {
+	w     io.Writer
+	delay time.Duration
+}
+
+func (w latencyWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.w.Write(p)
+}
+
+// BenchmarkCopy compares io.Copy and pipeCopy between a simulated high
+// latency source and destination. pipeCopy should approach twice the
+// throughput of io.Copy.
+func BenchmarkCopy(b *testing.B) {
+	data := make([]byte, 1<<20)
+	size := 32 * 1024
+	delay := 100 * time.Microsecond
+	b.Run("io.Copy", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			src := latencyReader{bytes.NewReader(data), delay}
+			dst := latencyWriter{ioutil.Discard, delay}
+			_, _ = io.CopyBuffer(dst, src, make([]byte, size))
+		}
+	})
+	b.Run("pipeCopy", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			src := latencyReader{bytes.NewReader(data), delay}
+			dst := latencyWriter{ioutil.Discard, delay}
+			_, _ = pipeCopy(dst, src, size)
+		}
+	})
+}
+
+// BenchmarkGzip compares the gzip and parallel gzip encoders on a synthetic
+// EVT file with different numbers of CPUs. pgzip throughput should scale with
+// CPUs.
+func BenchmarkGzip(b *testing.B) {
+	// EVT-like data, little-endian uint16 values with some repetition
+	data := make([]byte, 32<<20)
+	rnd := mrand.New(mrand.NewSource(1))
+	for i := 0; i < len(data); i += 2 {
+		v := uint16(rnd.Intn(4096))
+		data[i], data[i+1] = byte(v), byte(v>>8)
+	}
+	cpus := []int{1}
+	for n := 2; n < runtime.NumCPU(); n *= 2 {
+		cpus = append(cpus, n)
+	}
+	if runtime.NumCPU() > 1 {
+		cpus = append(cpus, runtime.NumCPU())
+	}
+	for _, tt := range []struct {
+		name     string
+		parallel bool
+	}{{"gzip", false}, {"pgzip", true}} {
+		for _, n := range cpus {
+			b.Run(fmt.Sprintf("%v/cpus=%v", tt.name, n), func(b *testing.B) {
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(n))
+				t := &Transfer{ParallelGzip: tt.parallel}
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					w, err := t.newGzipWriter(ioutil.Discard, "2016-05-12T17-00-02+00-00", time.Now(), int64(len(data)), gzip.DefaultCompression)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := w.Write(data); err != nil {
+						b.Fatal(err)
+					}
+					if err := w.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package fs
+
+import (
+	"errors"
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// DirSyncer is implemented by Fs backends which can flush a directory's
+// entries to stable storage, making renames into it durable
+type DirSyncer interface {
+	SyncDir(path string) error
+}
+
+// SyncDir flushes directory path to stable storage with fsync(2)
+func (l Localfs) SyncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// FileSyncer is implemented by Fs backends which can report whether their
+// Files can be flushed to stable storage
+type FileSyncer interface {
+	SyncFiles() bool
+}
+
+// syncer is implemented by Files which can be flushed to stable storage
+type syncer interface {
+	Sync() error
+}
+
+// syncFile flushes destination file f to stable storage if it's a syncer,
+// unless Dstfs reports that its files can't be. Servers without the
+// fsync@openssh.com extension can't, which isn't an error.
+func (t *Transfer) syncFile(f File) error {
+	if fsyncer, ok := t.Dstfs.(FileSyncer); ok && !fsyncer.SyncFiles() {
+		return nil
+	}
+	s, ok := f.(syncer)
+	if !ok {
+		return nil
+	}
+	err := s.Sync()
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return nil
+	}
+	return err
+}
+
+// syncDir flushes destination directory dir to stable storage for Fsync, if
+// Dstfs is a DirSyncer. The rename into it has already happened, so failure
+// is only logged.
+func (t *Transfer) syncDir(dir string) {
+	if !t.Fsync {
+		return
+	}
+	syncer, ok := t.Dstfs.(DirSyncer)
+	if !ok {
+		return
+	}
+	if err := syncer.SyncDir(dir); err != nil {
+		t.logger().Error("warning: could not sync destination directory, last rename may not be durable", "path", dir, "error", err)
+	}
+}
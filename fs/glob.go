@@ -0,0 +1,37 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrScanTimeout is returned when matching files takes longer than
+// GlobTimeout, e.g. because of a hung network mount
+var ErrScanTimeout = errors.New("scan timed out")
+
+// glob matches pattern in fsys, kind "source" or "destination", failing with
+// ErrScanTimeout after GlobTimeout. Fs Glob can't be cancelled, so a timed out
+// glob is left to finish in the background.
+func (t *Transfer) glob(kind string, fsys Fs, pattern string) ([]string, error) {
+	if t.GlobTimeout <= 0 {
+		return fsys.Glob(pattern)
+	}
+	type result struct {
+		matches []string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		matches, err := fsys.Glob(pattern)
+		done <- result{matches, err}
+	}()
+	timer := time.NewTimer(t.GlobTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.matches, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%v %w after %v matching %v", kind, ErrScanTimeout, t.GlobTimeout, pattern)
+	}
+}
@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrGzipName is wrapped by errors for files gzipped in transit whose gzip
+// header Name isn't the original file name, see Transfer.CheckGzipName
+var ErrGzipName = errors.New("gzip header name mismatch")
+
+// gzip header flags, RFC 1952
+const (
+	gzipFlagHCRC    = 1 << 1
+	gzipFlagExtra   = 1 << 2
+	gzipFlagName    = 1 << 3
+	gzipFlagComment = 1 << 4
+)
+
+// gzipHeader is the raw header of the first member of a gzip stream
+type gzipHeader struct {
+	fixed   []byte // ID, method, flags, mtime, extra flags, and OS
+	extra   []byte // FEXTRA length and data, if present
+	name    []byte // without the terminating zero, nil if not present
+	comment []byte // with the terminating zero, if present
+	raw     []byte // the header as read, including any header CRC
+}
+
+// readGzipHeader reads the header of the gzip stream in r, leaving r at the
+// start of the compressed data
+func readGzipHeader(r *bufio.Reader) (gzipHeader, error) {
+	var h gzipHeader
+	var raw bytes.Buffer
+	read := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		raw.Write(b)
+		return b, nil
+	}
+	readString := func() ([]byte, error) {
+		b, err := r.ReadBytes(0)
+		if err != nil {
+			return nil, err
+		}
+		raw.Write(b)
+		return b, nil
+	}
+	// Read errors other than running out of data aren't the file's fault
+	headerErr := func(err error) error {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: truncated header", ErrInvalidGzip)
+		}
+		return err
+	}
+	var err error
+	if h.fixed, err = read(10); err != nil {
+		return h, headerErr(err)
+	}
+	if h.fixed[0] != 0x1f || h.fixed[1] != 0x8b || h.fixed[2] != 8 {
+		return h, fmt.Errorf("%w: bad header", ErrInvalidGzip)
+	}
+	flags := h.fixed[3]
+	if flags&gzipFlagExtra != 0 {
+		xlen, err := read(2)
+		if err != nil {
+			return h, headerErr(err)
+		}
+		data, err := read(int(xlen[0]) | int(xlen[1])<<8)
+		if err != nil {
+			return h, headerErr(err)
+		}
+		h.extra = append(xlen, data...)
+	}
+	if flags&gzipFlagName != 0 {
+		name, err := readString()
+		if err != nil {
+			return h, headerErr(err)
+		}
+		h.name = name[:len(name)-1]
+	}
+	if flags&gzipFlagComment != 0 {
+		if h.comment, err = readString(); err != nil {
+			return h, headerErr(err)
+		}
+	}
+	if flags&gzipFlagHCRC != 0 {
+		if _, err := read(2); err != nil {
+			return h, headerErr(err)
+		}
+	}
+	h.raw = raw.Bytes()
+	return h, nil
+}
+
+// withName returns the header with Name set to name. Any header CRC is
+// dropped rather than recomputed.
+func (h gzipHeader) withName(name string) []byte {
+	var b bytes.Buffer
+	b.Write(h.fixed[:3])
+	b.WriteByte((h.fixed[3] | gzipFlagName) &^ gzipFlagHCRC)
+	b.Write(h.fixed[4:])
+	b.Write(h.extra)
+	b.WriteString(name)
+	b.WriteByte(0)
+	b.Write(h.comment)
+	return b.Bytes()
+}
+
+// checkGzipName checks the gzip header Name of gzip source file path, copied
+// as-is, which is read from src, against its name without ".gz". A file
+// without a Name is fine, since gunzip -N uses the file name. A mismatch is
+// logged, and with FixGzipName the returned reader has the header Name
+// replaced. The returned reader yields the same stream as src otherwise.
+func (t *Transfer) checkGzipName(src io.Reader, path, name string) (io.Reader, error) {
+	br := bufio.NewReaderSize(src, gzipHeaderSize)
+	h, err := readGzipHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not read gzip header of %v: %w", path, err)
+	}
+	if h.name == nil || string(h.name) == name {
+		return io.MultiReader(bytes.NewReader(h.raw), br), nil
+	}
+	if !t.FixGzipName {
+		t.logger().Error("warning: gzip header name differs from file name", "path", path, "headerName", string(h.name), "want", name)
+		return io.MultiReader(bytes.NewReader(h.raw), br), nil
+	}
+	t.logger().Info("rewriting gzip header name", "path", path, "headerName", string(h.name), "name", name)
+	return io.MultiReader(bytes.NewReader(h.withName(name)), br), nil
+}
+
+// gzipHeaderName returns the Name in the header of gzip file path in fsys,
+// "" if it has none. Only the header is read.
+func gzipHeaderName(fsys Fs, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h, err := readGzipHeader(bufio.NewReaderSize(f, gzipHeaderSize))
+	if err != nil {
+		return "", err
+	}
+	return string(h.name), nil
+}
@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Kind is a type of SeaFlow file
+type Kind int
+
+// File kinds returned by FileKind
+const (
+	KindUnknown Kind = iota
+	KindSFL
+	KindEVT
+	KindOPP
+	KindVCT
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindSFL:
+		return "SFL"
+	case KindEVT:
+		return "EVT"
+	case KindOPP:
+		return "OPP"
+	case KindVCT:
+		return "VCT"
+	}
+	return "unknown"
+}
+
+// evtRe matches EVT filenames, which are a timestamp with optional fractional
+// seconds and a timezone offset, e.g. 2016-05-12T17-00-02+00-00
+var evtRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}(?:\.\d+)?[+-]\d{2}-\d{2}$`)
+
+// FileKind returns the kind of SeaFlow file at path based on its filename, and
+// whether it's gzip compressed, i.e. has a ".gz" extension. SFL, OPP, and VCT
+// files are identified by extension. EVT files have no extension, only a
+// timestamp name.
+func FileKind(path string) (kind Kind, compressed bool) {
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, ".gz") {
+		name = name[:len(name)-len(".gz")]
+		compressed = true
+	}
+	if evtRe.MatchString(name) {
+		return KindEVT, compressed
+	}
+	switch filepath.Ext(name) {
+	case ".sfl":
+		return KindSFL, compressed
+	case ".opp":
+		return KindOPP, compressed
+	case ".vct":
+		return KindVCT, compressed
+	}
+	return KindUnknown, compressed
+}
+
+// gzipVariants returns pattern and, unless FileKind says it's already for
+// compressed files, pattern with a ".gz" extension
+func gzipVariants(pattern string) []string {
+	if _, compressed := FileKind(pattern); compressed {
+		return []string{pattern}
+	}
+	return []string{pattern, pattern + ".gz"}
+}
+
+// trimGzip returns path without its ".gz" extension if FileKind says it's
+// compressed
+func trimGzip(path string) string {
+	if _, compressed := FileKind(path); compressed {
+		return path[:len(path)-len(".gz")]
+	}
+	return path
+}
+
+// tzSignRe matches a timestamped SeaFlow filename, capturing the parts before
+// and after the timezone offset sign
+var tzSignRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}(?:\.\d+)?)[+-](\d{2}-\d{2}.*)$`)
+
+// canonicalName returns the filename of path without any ".gz" extension and
+// with "+" as the timezone offset sign, for matching source and destination
+// files which may have been named with either sign
+func canonicalName(path string) string {
+	name := trimGzip(filepath.Base(path))
+	return tzSignRe.ReplaceAllString(name, "$1+$2")
+}
+
+// canonicalPath is like canonicalName but keeps the directory part of path
+func canonicalPath(path string) string {
+	dir, _ := filepath.Split(path)
+	return dir + canonicalName(path)
+}
+
+// DefaultTZSeparator is the timezone offset sign of destination filenames
+// with NormalizeNames if Transfer.TZSeparator is empty
+const DefaultTZSeparator = "+"
+
+// normalizeName returns filename with sep as its timezone offset sign if it's
+// a timestamped SeaFlow filename, keeping any extensions, e.g.
+// 2016-05-12T17-00-02-00-00.gz becomes 2016-05-12T17-00-02+00-00.gz for "+".
+// Other names are returned unchanged.
+func normalizeName(filename, sep string) string {
+	if _, err := timeFromFilename(filename); err != nil {
+		return filename
+	}
+	subs := tzSignRe.FindStringSubmatch(filename)
+	if subs == nil {
+		return filename
+	}
+	return subs[1] + sep + subs[2]
+}
+
+// dstName returns the destination filename for source filename, with its
+// timezone offset sign normalized if NormalizeNames is set
+func (t *Transfer) dstName(filename string) string {
+	if !t.NormalizeNames {
+		return filename
+	}
+	sep := t.TZSeparator
+	if sep == "" {
+		sep = DefaultTZSeparator
+	}
+	return normalizeName(filename, sep)
+}
@@ -0,0 +1,104 @@
+package fs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileKind(t *testing.T) {
+	tests := []struct {
+		path       string
+		kind       Kind
+		compressed bool
+	}{
+		{"2016-05-12T17-00-02+00-00.sfl", KindSFL, false},
+		{"2016-05-12T17-00-02+00-00.sfl.gz", KindSFL, true},
+		{"/data/2016_133/2016-05-12T17-00-02+00-00.sfl", KindSFL, false},
+		{"a.sfl", KindSFL, false},
+		{"2016-05-12T17-00-02+00-00", KindEVT, false},
+		{"2016-05-12T17-00-02-07-00", KindEVT, false},
+		{"2016-05-12T17-00-02+00-00.gz", KindEVT, true},
+		{"2016-05-12T17-00-02.123+00-00", KindEVT, false},
+		{"2016-05-12T17-00-02.123-07-00.gz", KindEVT, true},
+		{"/data/2016_133/2016-05-12T17-00-02+00-00", KindEVT, false},
+		{"2016-05-12T17-00-02+00-00.opp", KindOPP, false},
+		{"2016-05-12T17-00-02+00-00.opp.gz", KindOPP, true},
+		{"2016-05-12T17-00-02+00-00.vct", KindVCT, false},
+		{"2016-05-12T17-00-02+00-00.vct.gz", KindVCT, true},
+		{"2016-05-12T17-00-02", KindUnknown, false},           // no timezone
+		{"2016-05-12T17-00-02+00-00.txt", KindUnknown, false}, // unknown extension
+		{"2016-05-12T17-00-02+00-00.gz.tmp", KindUnknown, false},
+		{"._seaflow-transfer_abcdefg.2016-05-12T17-00-02+00-00_.gz", KindUnknown, true},
+		{"notes.gz", KindUnknown, true},
+		{"", KindUnknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			kind, compressed := FileKind(tt.path)
+			assert.Equal(t, tt.kind, kind)
+			assert.Equal(t, tt.compressed, compressed)
+		})
+	}
+}
+
+func Test_canonicalName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"2016-05-12T17-00-02+00-00", "2016-05-12T17-00-02+00-00"},
+		{"2016-05-12T17-00-02-00-00", "2016-05-12T17-00-02+00-00"},
+		{"/dst/2016_133/2016-05-12T17-00-02-00-00.gz", "2016-05-12T17-00-02+00-00"},
+		{"2016-05-12T17-00-02.123-07-00", "2016-05-12T17-00-02.123+07-00"},
+		{"2016-05-12T17-00-02-00-00.sfl.gz", "2016-05-12T17-00-02+00-00.sfl"},
+		{"instrument.json", "instrument.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, canonicalName(tt.path))
+		})
+	}
+	assert.Equal(t, "2016_133/2016-05-12T17-00-02+00-00", canonicalPath("2016_133/2016-05-12T17-00-02-00-00.gz"))
+}
+
+func Test_gzipVariants(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"????_???/*.sfl", []string{"????_???/*.sfl", "????_???/*.sfl.gz"}},
+		{"????_???/*.sfl.gz", []string{"????_???/*.sfl.gz"}},
+		{DefaultEVTPattern, []string{DefaultEVTPattern, DefaultEVTPattern + ".gz"}},
+		{"*", []string{"*", "*.gz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			assert.Equal(t, tt.want, gzipVariants(tt.pattern))
+		})
+	}
+	assert.Equal(t, "2016_133/a.sfl", trimGzip("2016_133/a.sfl.gz"))
+	assert.Equal(t, "2016_133/a.sfl", trimGzip("2016_133/a.sfl"))
+}
+
+func Test_normalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		sep  string
+		want string
+	}{
+		{"2016-05-12T17-00-02-00-00", "+", "2016-05-12T17-00-02+00-00"},
+		{"2016-05-12T17-00-02+00-00", "-", "2016-05-12T17-00-02-00-00"},
+		{"2016-05-12T17-00-02+00-00", "+", "2016-05-12T17-00-02+00-00"},
+		{"2016-05-12T17-00-02-00-00.gz", "+", "2016-05-12T17-00-02+00-00.gz"},
+		{"2016-05-12T17-00-02+00-00.gz", "-", "2016-05-12T17-00-02-00-00.gz"},
+		{"2016-05-12T17-00-02.123-07-00.sfl", "+", "2016-05-12T17-00-02.123+07-00.sfl"},
+		{"2016-13-12T17-00-02-00-00", "+", "2016-13-12T17-00-02-00-00"},
+		{"instrument.json", "+", "instrument.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.sep, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeName(tt.name, tt.sep))
+		})
+	}
+}
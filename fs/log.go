@@ -0,0 +1,213 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is a leveled, structured logger used by Transfer. keyvals are
+// alternating keys and values which add context to msg, e.g.
+//
+//	logger.Info("copied", "path", path, "bytes", n)
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// Level is a logging level. Messages below a logger's level are discarded.
+type Level int
+
+// Logging levels in increasing order of severity. LevelNone discards all
+// messages.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+	LevelNone
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelError:
+		return "error"
+	}
+	return "none"
+}
+
+// NewTextLogger returns a Logger which writes lines like
+//
+//	2016/05/12 17:00:02 copied path=/data/2016_133/file bytes=1024
+//
+// to w for messages at or above level
+func NewTextLogger(w io.Writer, level Level) Logger {
+	return &textLogger{l: log.New(w, "", log.Ldate|log.Ltime), level: level}
+}
+
+type textLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+func (t *textLogger) Debug(msg string, keyvals ...interface{}) {
+	t.log(LevelDebug, msg, keyvals)
+}
+
+func (t *textLogger) Info(msg string, keyvals ...interface{}) {
+	t.log(LevelInfo, msg, keyvals)
+}
+
+func (t *textLogger) Error(msg string, keyvals ...interface{}) {
+	t.log(LevelError, msg, keyvals)
+}
+
+func (t *textLogger) log(level Level, msg string, keyvals []interface{}) {
+	if level < t.level {
+		return
+	}
+	t.l.Print(formatText(msg, keyvals))
+}
+
+// NewJSONLogger returns a Logger which writes one JSON object per message to w
+// for messages at or above level, e.g.
+//
+//	{"time":"2016-05-12T17:00:02Z","level":"info","msg":"copied","path":"/data/2016_133/file","bytes":1024}
+func NewJSONLogger(w io.Writer, level Level) Logger {
+	return &jsonLogger{w: w, level: level}
+}
+
+type jsonLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+func (j *jsonLogger) Debug(msg string, keyvals ...interface{}) {
+	j.log(LevelDebug, msg, keyvals)
+}
+
+func (j *jsonLogger) Info(msg string, keyvals ...interface{}) {
+	j.log(LevelInfo, msg, keyvals)
+}
+
+func (j *jsonLogger) Error(msg string, keyvals ...interface{}) {
+	j.log(LevelError, msg, keyvals)
+}
+
+func (j *jsonLogger) log(level Level, msg string, keyvals []interface{}) {
+	if level < j.level {
+		return
+	}
+	// Build the object by hand to keep time, level, and msg first
+	var b strings.Builder
+	b.WriteString("{")
+	writeJSONField(&b, "time", time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(",")
+	writeJSONField(&b, "level", level.String())
+	b.WriteString(",")
+	writeJSONField(&b, "msg", msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, val := keyval(keyvals, i)
+		b.WriteString(",")
+		writeJSONField(&b, key, val)
+	}
+	b.WriteString("}\n")
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, _ = io.WriteString(j.w, b.String())
+}
+
+func writeJSONField(b *strings.Builder, key string, val interface{}) {
+	k, _ := json.Marshal(key)
+	switch v := val.(type) {
+	case error:
+		val = v.Error()
+	case time.Duration:
+		val = v.String()
+	case fmt.Stringer:
+		if _, ok := v.(time.Time); !ok {
+			val = v.String()
+		}
+	}
+	v, err := json.Marshal(val)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(val))
+	}
+	b.Write(k)
+	b.WriteString(":")
+	b.Write(v)
+}
+
+// NewStdLogger returns a Logger which writes text formatted messages to a
+// separate log.Logger for each level. Messages for nil loggers are discarded.
+// This adapts the Debug, Info, and Error log.Logger values used by earlier
+// versions of Transfer.
+func NewStdLogger(debug, info, errorLog *log.Logger) Logger {
+	return stdLogger{debug: debug, info: info, err: errorLog}
+}
+
+type stdLogger struct {
+	debug *log.Logger
+	info  *log.Logger
+	err   *log.Logger
+}
+
+func (s stdLogger) Debug(msg string, keyvals ...interface{}) {
+	if s.debug != nil {
+		s.debug.Print(formatText(msg, keyvals))
+	}
+}
+
+func (s stdLogger) Info(msg string, keyvals ...interface{}) {
+	if s.info != nil {
+		s.info.Print(formatText(msg, keyvals))
+	}
+}
+
+func (s stdLogger) Error(msg string, keyvals ...interface{}) {
+	if s.err != nil {
+		s.err.Print(formatText(msg, keyvals))
+	}
+}
+
+// formatText formats msg followed by space separated key=value pairs. Values
+// containing spaces, quotes, or '=' are quoted.
+func formatText(msg string, keyvals []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, val := keyval(keyvals, i)
+		s := fmt.Sprint(val)
+		if t, ok := val.(time.Time); ok {
+			s = t.Format(time.RFC3339)
+		}
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = fmt.Sprintf("%q", s)
+		}
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// keyval returns the key and value starting at keyvals[i]. A missing final
+// value is reported as "MISSING".
+func keyval(keyvals []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(keyvals[i])
+	if i+1 >= len(keyvals) {
+		return key, "MISSING"
+	}
+	return key, keyvals[i+1]
+}
@@ -0,0 +1,55 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextLogger(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	l := NewTextLogger(&buf, LevelInfo)
+	l.(*textLogger).l.SetFlags(0)
+
+	l.Debug("hidden")
+	l.Info("copied", "path", "/a/b", "bytes", 10)
+	l.Error("failed", "error", errors.New("no such file"), "dangling")
+
+	assert.Equal("copied path=/a/b bytes=10\nfailed error=\"no such file\" dangling=MISSING\n", buf.String())
+}
+
+func TestJSONLogger(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	l := NewJSONLogger(&buf, LevelDebug)
+
+	l.Debug("retrying", "path", "/a/b", "delay", time.Second, "error", errors.New("lost"))
+
+	var got map[string]interface{}
+	assert.Nil(json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal("debug", got["level"])
+	assert.Equal("retrying", got["msg"])
+	assert.Equal("/a/b", got["path"])
+	assert.Equal("1s", got["delay"])
+	assert.Equal("lost", got["error"])
+	assert.Contains(got, "time")
+}
+
+func TestStdLogger(t *testing.T) {
+	assert := assert.New(t)
+	var info, errs bytes.Buffer
+	l := NewStdLogger(nil, log.New(&info, "", 0), log.New(&errs, "", 0))
+
+	l.Debug("discarded")
+	l.Info("copied", "path", "/a/b")
+	l.Error("failed", "path", "/a/c")
+
+	assert.Equal("copied path=/a/b\n", info.String())
+	assert.Equal("failed path=/a/c\n", errs.String())
+}
@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultManifestAlgo is the hash algorithm used for Manifest entries if
+// Transfer.ManifestAlgo is empty
+const DefaultManifestAlgo = "sha256"
+
+var manifestHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// ManifestAlgos returns the names of supported manifest hash algorithms,
+// sorted. MD5 and SHA-1 are for compatibility with existing tools, e.g.
+// BagIt manifest-md5.txt validators.
+func ManifestAlgos() []string {
+	var algos []string
+	for algo := range manifestHashes {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	return algos
+}
+
+// newManifestHash returns a new hash.Hash for ManifestAlgo
+func (t *Transfer) newManifestHash() (hash.Hash, error) {
+	newHash, ok := manifestHashes[t.manifestAlgo()]
+	if !ok {
+		return nil, fmt.Errorf("unknown manifest algorithm %q", t.ManifestAlgo)
+	}
+	return newHash(), nil
+}
+
+func (t *Transfer) manifestAlgo() string {
+	if t.ManifestAlgo == "" {
+		return DefaultManifestAlgo
+	}
+	return t.ManifestAlgo
+}
+
+// writeManifest writes a manifest entry for destination file outpath with
+// hash sum of its contents as written, gzipped or not. Entries are a single
+// line in the format produced by sha256sum, md5sum, or sha1sum for
+// ManifestAlgo, with the path relative to Dstroot, so a manifest can be
+// checked with e.g. "sha256sum -c" from Dstroot and is also a valid BagIt
+// payload manifest.
+func (t *Transfer) writeManifest(outpath string, sum []byte) error {
+	rel, err := filepath.Rel(t.Dstroot, outpath)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	entry := fmt.Sprintf("%x  %s\n", sum, rel)
+
+	t.manifestMu.Lock()
+	defer t.manifestMu.Unlock()
+	_, err = io.WriteString(t.Manifest, entry)
+	return err
+}
+
+// manifestEntry is one file listed in a checksum manifest
+type manifestEntry struct {
+	sum  []byte
+	path string // relative path, with slashes
+	algo string
+}
+
+// manifestSumAlgos are manifest hash algorithms by checksum size in bytes
+var manifestSumAlgos = map[int]string{
+	md5.Size:    "md5",
+	sha1.Size:   "sha1",
+	sha256.Size: "sha256",
+}
+
+// readManifest parses a manifest in the format produced by sha256sum, md5sum,
+// or sha1sum, as written by writeManifest. The algorithm of each entry is
+// chosen by its checksum length. Blank lines and "#" comments are skipped.
+func readManifest(r io.Reader) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// "<sum>  <path>", or "<sum> *<path>" for binary mode
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || i+2 > len(line) || (line[i+1] != ' ' && line[i+1] != '*') {
+			return nil, fmt.Errorf("line %d: invalid manifest entry", n)
+		}
+		sum, err := hex.DecodeString(line[:i])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid checksum: %w", n, err)
+		}
+		algo, ok := manifestSumAlgos[len(sum)]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown checksum length %d", n, len(line[:i]))
+		}
+		entries = append(entries, manifestEntry{sum: sum, path: line[i+2:], algo: algo})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
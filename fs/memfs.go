@@ -0,0 +1,379 @@
+package fs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memfs is an in-memory Fs, useful for testing. Paths are cleaned before use
+// and parent directories must exist before files can be created in them, as
+// with a real filesystem. Errors can be injected for specific operations with
+// FailOn. It's safe for concurrent use.
+type Memfs struct {
+	mu    sync.Mutex
+	files map[string]*memEntry
+	dirs  map[string]time.Time
+	fails map[memOp]error
+}
+
+type memEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memOp struct {
+	op   string
+	path string
+}
+
+// NewMemfs creates a new empty Memfs containing only the root directory
+func NewMemfs() (*Memfs, error) {
+	return &Memfs{
+		files: make(map[string]*memEntry),
+		dirs:  map[string]time.Time{string(filepath.Separator): time.Now()},
+		fails: make(map[memOp]error),
+	}, nil
+}
+
+// FailOn causes operation op on path to return err. op is the name of an Fs
+// method, e.g. "Create" or "Rename", "Write" for writes to a file opened by
+// Create or Append, or "Read" for reads after the first from a file opened by
+// Open. Rename failures are matched against the old name. An empty path
+// matches all paths. A nil err removes the failure.
+func (m *Memfs) FailOn(op string, path string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	if err == nil {
+		delete(m.fails, memOp{op, path})
+		return
+	}
+	m.fails[memOp{op, path}] = err
+}
+
+// WriteFile creates the file at path with data and mtime, creating parent
+// directories as needed
+func (m *Memfs) WriteFile(path string, data []byte, mtime time.Time) error {
+	path = filepath.Clean(path)
+	if err := m.MkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.dirs[path]; ok {
+		return &os.PathError{Op: "write", Path: path, Err: os.ErrExist}
+	}
+	m.files[path] = &memEntry{data: append([]byte(nil), data...), modTime: mtime}
+	return nil
+}
+
+// ReadFile returns the contents of the file at path
+func (m *Memfs) ReadFile(path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: path, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+// fail returns an injected error for op on path, if any. m.mu must be held.
+func (m *Memfs) fail(op string, path string) error {
+	if err, ok := m.fails[memOp{op, path}]; ok {
+		return err
+	}
+	return m.fails[memOp{op, ""}]
+}
+
+// Append opens the existing file at path for writing at its end
+func (m *Memfs) Append(path string) (File, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Append", path); err != nil {
+		return nil, err
+	}
+	e, ok := m.files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return &memFile{fs: m, path: path, entry: e}, nil
+}
+
+func (m *Memfs) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Chtimes", path); err != nil {
+		return err
+	}
+	if e, ok := m.files[path]; ok {
+		e.modTime = mtime
+		return nil
+	}
+	if _, ok := m.dirs[path]; ok {
+		m.dirs[path] = mtime
+		return nil
+	}
+	return &os.PathError{Op: "chtimes", Path: path, Err: os.ErrNotExist}
+}
+
+func (m *Memfs) Close() error {
+	return nil
+}
+
+func (m *Memfs) Create(path string) (File, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Create", path); err != nil {
+		return nil, err
+	}
+	if _, ok := m.dirs[filepath.Dir(path)]; !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	if _, ok := m.dirs[path]; ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
+	}
+	e := &memEntry{modTime: time.Now()}
+	m.files[path] = e
+	return &memFile{fs: m, path: path, entry: e}, nil
+}
+
+func (m *Memfs) Glob(pattern string) (matches []string, err error) {
+	// Check pattern syntax the same way filepath.Glob does
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Glob", filepath.Clean(pattern)); err != nil {
+		return nil, err
+	}
+	// filepath.Match never matches separators with wildcards, so matching
+	// each full path is equivalent to filepath.Glob matching one path
+	// element at a time
+	for path := range m.files {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			matches = append(matches, path)
+		}
+	}
+	for path := range m.dirs {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (m *Memfs) MkdirAll(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("MkdirAll", path); err != nil {
+		return err
+	}
+	for p := path; ; p = filepath.Dir(p) {
+		if _, ok := m.files[p]; ok {
+			return &os.PathError{Op: "mkdir", Path: p, Err: os.ErrExist}
+		}
+		if _, ok := m.dirs[p]; !ok {
+			m.dirs[p] = time.Now()
+		}
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memfs) Open(path string) (File, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Open", path); err != nil {
+		return nil, err
+	}
+	e, ok := m.files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return &memFile{
+		fs:     m,
+		path:   path,
+		entry:  e,
+		reader: bytes.NewReader(append([]byte(nil), e.data...)),
+	}, nil
+}
+
+func (m *Memfs) Remove(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Remove", path); err != nil {
+		return err
+	}
+	if _, ok := m.files[path]; ok {
+		delete(m.files, path)
+		return nil
+	}
+	if _, ok := m.dirs[path]; ok {
+		prefix := path + string(filepath.Separator)
+		for p := range m.files {
+			if strings.HasPrefix(p, prefix) {
+				return &os.PathError{Op: "remove", Path: path, Err: os.ErrExist}
+			}
+		}
+		for p := range m.dirs {
+			if strings.HasPrefix(p, prefix) {
+				return &os.PathError{Op: "remove", Path: path, Err: os.ErrExist}
+			}
+		}
+		delete(m.dirs, path)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+}
+
+func (m *Memfs) Rename(oldname, newname string) error {
+	return m.rename(oldname, newname, true)
+}
+
+// RenameNoReplace is like Rename but fails if newname exists
+func (m *Memfs) RenameNoReplace(oldname, newname string) error {
+	return m.rename(oldname, newname, false)
+}
+
+func (m *Memfs) rename(oldname, newname string, replace bool) error {
+	oldname = filepath.Clean(oldname)
+	newname = filepath.Clean(newname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Rename", oldname); err != nil {
+		return err
+	}
+	e, ok := m.files[oldname]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	if _, ok := m.dirs[filepath.Dir(newname)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	if _, ok := m.dirs[newname]; ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if _, ok := m.files[newname]; ok && !replace {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	delete(m.files, oldname)
+	m.files[newname] = e
+	return nil
+}
+
+func (m *Memfs) Stat(path string) (os.FileInfo, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("Stat", path); err != nil {
+		return nil, err
+	}
+	if e, ok := m.files[path]; ok {
+		return memFileInfo{name: filepath.Base(path), size: int64(len(e.data)), modTime: e.modTime}, nil
+	}
+	if modTime, ok := m.dirs[path]; ok {
+		return memFileInfo{name: filepath.Base(path), modTime: modTime, dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+// memFile is an open Memfs file. Files opened for reading read a snapshot of
+// the contents at the time of opening. Writes are visible immediately.
+type memFile struct {
+	fs     *Memfs
+	path   string
+	entry  *memEntry
+	reader *bytes.Reader // nil if opened for writing
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.reader == nil {
+		return 0, &os.PathError{Op: "read", Path: f.path, Err: os.ErrInvalid}
+	}
+	if f.reader.Len() < int(f.reader.Size()) {
+		// Fail mid-copy, after the first read
+		f.fs.mu.Lock()
+		err := f.fs.fail("Read", f.path)
+		f.fs.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return f.reader.Read(b)
+}
+
+// Seek sets the offset for the next Read of a file opened by Open
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.reader == nil {
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: os.ErrInvalid}
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	size := int64(len(f.entry.data))
+	if f.reader != nil {
+		size = f.reader.Size()
+	}
+	return memFileInfo{name: filepath.Base(f.path), size: size, modTime: f.entry.modTime}, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	if f.reader != nil {
+		return 0, &os.PathError{Op: "write", Path: f.path, Err: os.ErrInvalid}
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.fail("Write", f.path); err != nil {
+		return 0, err
+	}
+	f.entry.data = append(f.entry.data, b...)
+	f.entry.modTime = time.Now()
+	return len(b), nil
+}
+
+// memFileInfo implements os.FileInfo for Memfs files and directories
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
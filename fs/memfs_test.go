@@ -0,0 +1,1807 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemTransfer() (*Transfer, *Memfs, *Memfs) {
+	src, _ := NewMemfs()
+	dst, _ := NewMemfs()
+	return &Transfer{
+		Srcroot: "/src",
+		Dstroot: "/dst",
+		Srcfs:   src,
+		Dstfs:   dst,
+		Debug:   log.New(ioutil.Discard, "", 0),
+		Info:    log.New(ioutil.Discard, "", 0),
+		Error:   log.New(ioutil.Discard, "", 0),
+	}, src, dst
+}
+
+func TestMemfsGlob(t *testing.T) {
+	assert := assert.New(t)
+	m, _ := NewMemfs()
+	dir, err := ioutil.TempDir("", "seaflow-transfer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := []string{
+		filepath.Join("2016_133", "2016-05-12T17-00-02+00-00"),
+		filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl"),
+		filepath.Join("2016_134", "2016-05-13T00-00-35+00-00"),
+		filepath.Join("2016_134", "sub", "2016-05-13T00-00-35+00-00"),
+		"2016-05-13T00-00-35+00-00",
+	}
+	for _, f := range files {
+		assert.Nil(m.WriteFile(filepath.Join(dir, f), []byte("a"), time.Now()))
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0755)
+		makeFile(filepath.Join(dir, f), "a")
+	}
+
+	patterns := []string{DefaultSFLPattern, DefaultEVTPattern, "*", "????_???", "*/*", "*/*/*", "none"}
+	for _, p := range patterns {
+		want, _ := filepath.Glob(filepath.Join(dir, p))
+		got, err := m.Glob(filepath.Join(dir, p))
+		assert.Nil(err)
+		assert.Equal(want, got, p)
+	}
+
+	_, err = m.Glob("[")
+	assert.Equal(filepath.ErrBadPattern, err)
+}
+
+func TestMemfsChtimes(t *testing.T) {
+	assert := assert.New(t)
+	m, _ := NewMemfs()
+	assert.Nil(m.WriteFile("/a/b", []byte("b"), time.Now()))
+	mtime := time.Date(2016, 5, 12, 17, 0, 2, 0, time.UTC)
+
+	assert.Nil(m.Chtimes("/a/b", mtime, mtime))
+
+	info, err := m.Stat("/a/b")
+	assert.Nil(err)
+	assert.True(mtime.Equal(info.ModTime()))
+	assert.True(errors.Is(m.Chtimes("/a/c", mtime, mtime), os.ErrNotExist))
+}
+
+func TestMemfsCopyFile(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00")
+	mtime := time.Date(2016, 5, 12, 17, 0, 2, 0, time.UTC)
+	assert.Nil(src.WriteFile(a, []byte("a"), mtime))
+
+	err := tr.CopyFile(a, false)
+
+	assert.Nil(err)
+	b, err := dst.ReadFile(filepath.Join("/dst", "2016_133", "2016-05-12T17-00-02+00-00"))
+	assert.Nil(err)
+	assert.Equal("a", string(b))
+	info, _ := dst.Stat(filepath.Join("/dst", "2016_133", "2016-05-12T17-00-02+00-00"))
+	assert.True(mtime.Equal(info.ModTime()), "mtime preserved")
+}
+
+func TestMemfsWriteFailure(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00")
+	assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+	dst.FailOn("Write", "", errors.New("disk full"))
+
+	err := tr.CopyFile(a, true)
+
+	assert.NotNil(err)
+	matches, _ := dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Equal(0, len(matches), "no partial or temp file left behind")
+
+	// Copy succeeds once the failure clears
+	dst.FailOn("Write", "", nil)
+	err = tr.CopyFile(a, true)
+	assert.Nil(err)
+	matches, _ = dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Equal([]string{filepath.Join("/dst", "2016_133", "2016-05-12T17-00-02+00-00.gz")}, matches)
+}
+
+func TestMemfsRenameFailure(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+	dst.FailOn("Rename", "", errors.New("connection lost"))
+
+	err := tr.CopySFLFiles()
+
+	assert.NotNil(err)
+	matches, _ := dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Equal(0, len(matches), "no partial or temp file left behind")
+}
+
+func TestMemfsTransferError(t *testing.T) {
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00")
+	outpath := filepath.Join("/dst", "2016_133", "2016-05-12T17-00-02+00-00.gz")
+	tests := []struct {
+		srcOp string
+		dstOp string
+		want  Stage
+	}{
+		{srcOp: "Open", want: StageOpen},
+		{dstOp: "MkdirAll", want: StageCreate},
+		{dstOp: "Create", want: StageCreate},
+		{dstOp: "Write", want: StageCopy},
+		{dstOp: "Chtimes", want: StageChtimes},
+		{dstOp: "Rename", want: StageRename},
+	}
+	for _, tt := range tests {
+		t.Run(tt.srcOp+tt.dstOp, func(t *testing.T) {
+			assert := assert.New(t)
+			tr, src, dst := newMemTransfer()
+			assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+			failure := errors.New("injected")
+			if tt.srcOp != "" {
+				src.FailOn(tt.srcOp, "", failure)
+			} else {
+				dst.FailOn(tt.dstOp, "", failure)
+			}
+
+			err := tr.CopyFile(a, true)
+
+			var te *TransferError
+			if assert.True(errors.As(err, &te), "TransferError returned") {
+				assert.Equal(tt.want, te.Stage)
+				assert.Equal(a, te.Src)
+				assert.Equal(outpath, te.Dst)
+			}
+			assert.True(errors.Is(err, failure), "underlying error wrapped")
+		})
+	}
+}
+
+func TestMemfsFailedByStage(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.KeepGoing = true
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-00+00-00.sfl")
+	b := filepath.Join("/src", "2016_133", "2016-05-12T17-00-05+00-00.sfl")
+	assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+	assert.Nil(src.WriteFile(b, []byte("b"), time.Now()))
+	dst.FailOn("Rename", "", errors.New("connection lost"))
+
+	assert.True(errors.Is(tr.CopySFLFiles(), ErrFilesFailed))
+
+	sum := tr.Stats.Summary()
+	assert.Equal(2, sum.Failed)
+	assert.Equal(map[string]int{"rename": 2}, sum.FailedByStage)
+}
+
+// hangingFs is a Memfs whose Glob blocks until release is closed
+type hangingFs struct {
+	*Memfs
+	release chan struct{}
+}
+
+func (h hangingFs) Glob(pattern string) ([]string, error) {
+	<-h.release
+	return h.Memfs.Glob(pattern)
+}
+
+func TestMemfsGlobTimeout(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00")
+	assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+	release := make(chan struct{})
+	defer close(release)
+	tr.Srcfs = hangingFs{Memfs: src, release: release}
+	tr.GlobTimeout = 10 * time.Millisecond
+
+	err := tr.CopyEVTFiles()
+
+	assert.True(errors.Is(err, ErrScanTimeout), "hung glob times out")
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "source scan timed out")
+	}
+}
+
+func TestMemfsWriteRunLog(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-00+00-00.sfl")
+	assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+	start := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+
+	assert.Nil(tr.WriteRunLog(start, "v1", "/src"), "log created without any copies")
+	assert.Nil(tr.CopySFLFiles())
+	assert.Nil(tr.WriteRunLog(start.Add(time.Hour), "v1", "/src"), "log appended")
+
+	b, err := dst.ReadFile(filepath.Join("/dst", RunLogName))
+	assert.Nil(err)
+	want := "start=2016-05-12T17:00:00Z version=v1 source=/src copied=0 failed=0\n" +
+		"start=2016-05-12T18:00:00Z version=v1 source=/src copied=1 failed=0\n" +
+		"  2016_133/2016-05-12T17-00-00+00-00.sfl\n"
+	assert.Equal(want, string(b))
+
+	// The log isn't treated as a data file
+	tr.ConfirmDelete = true
+	assert.Nil(tr.DeleteOrphans())
+	_, err = dst.Stat(filepath.Join("/dst", RunLogName))
+	assert.Nil(err, "run log not deleted as an orphan")
+}
+
+// countingFs is a Memfs which counts calls to Glob
+type countingFs struct {
+	*Memfs
+	globs *int32
+}
+
+func (c countingFs) Glob(pattern string) ([]string, error) {
+	atomic.AddInt32(c.globs, 1)
+	return c.Memfs.Glob(pattern)
+}
+
+func TestMemfsCacheDestination(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	var globs int32
+	tr.Dstfs = countingFs{Memfs: dst, globs: &globs}
+	tr.CacheDestination = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("2016_133", "2016-05-12T17-00-08+00-00") // most recent, not copied
+	o := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.opp")
+	for _, path := range []string{a, b, c, o} {
+		assert.Nil(src.WriteFile(filepath.Join("/src", path), []byte("x"), time.Now()))
+	}
+	assert.Nil(dst.WriteFile(filepath.Join("/dst", a+".gz"), []byte("x"), time.Now()))
+
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Nil(tr.CopyOPPFiles())
+	assert.Equal(2, tr.Stats.Summary().Copied, "b and o copied, a already present")
+	assert.Equal(int32(1), atomic.LoadInt32(&globs), "destination listed once for both passes")
+
+	// Copied files are added to the cached listing
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Nil(tr.CopyOPPFiles())
+	assert.Equal(2, tr.Stats.Summary().Copied, "nothing copied again")
+	assert.Equal(int32(1), atomic.LoadInt32(&globs), "cached listing reused")
+
+	// and deleted files removed from it
+	assert.Nil(src.Remove(filepath.Join("/src", b)))
+	tr.ConfirmDelete = true
+	assert.Nil(tr.DeleteOrphans())
+	assert.Nil(src.WriteFile(filepath.Join("/src", b), []byte("x"), time.Now()))
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Equal(3, tr.Stats.Summary().Copied, "deleted file copied again")
+}
+
+func TestMemfsCrossDeviceRename(t *testing.T) {
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	outpath := filepath.Join("/dst", "2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	exdev := &os.LinkError{Op: "rename", Err: syscall.EXDEV}
+	tests := []struct {
+		name          string
+		err           error
+		requireAtomic bool
+		copied        bool
+	}{
+		{"exdev", exdev, false, true},
+		{"exdev requireAtomic", exdev, true, false},
+		{"other", errors.New("connection lost"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			tr, src, dst := newMemTransfer()
+			tr.TempDir = "/tmp"
+			tr.RequireAtomic = tt.requireAtomic
+			assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+			dst.FailOn("Rename", "", tt.err)
+
+			err := tr.CopySFLFiles()
+
+			if tt.copied {
+				assert.Nil(err)
+				b, err := dst.ReadFile(outpath)
+				assert.Nil(err)
+				assert.Equal("a", string(b))
+			} else {
+				assert.NotNil(err)
+				_, err := dst.Stat(outpath)
+				assert.True(errors.Is(err, os.ErrNotExist), "no fallback copy")
+			}
+			assert.Equal(tt.requireAtomic, errors.Is(err, ErrNotAtomic))
+			temps, _ := dst.Glob(filepath.Join("/tmp", "*"))
+			assert.Equal(0, len(temps), "temp file removed")
+		})
+	}
+}
+
+func TestMemfsNoTempFile(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.NoTempFile = true
+	tr.GzipFlushInterval = 10000
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00")
+	outpath := filepath.Join("/dst", "2016_133", "2016-05-12T17-00-02+00-00.gz")
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(data)
+	assert.Nil(src.WriteFile(a, data, time.Now()))
+	latest := filepath.Join("/src", "2016_133", "2016-05-12T17-00-05+00-00")
+	assert.Nil(src.WriteFile(latest, []byte("b"), time.Now()))
+	dst.FailOn("Rename", "", errors.New("rename not expected"))
+
+	assert.Nil(tr.CopyEVTFiles())
+
+	b, err := dst.ReadFile(outpath)
+	assert.Nil(err)
+	// Each flush ends with an empty stored block
+	assert.GreaterOrEqual(bytes.Count(b, []byte{0, 0, 0xff, 0xff}), len(data)/10000)
+	gzr, err := gzip.NewReader(bytes.NewReader(b))
+	if assert.Nil(err) {
+		got, err := ioutil.ReadAll(gzr)
+		assert.Nil(err)
+		assert.Equal(data, got)
+	}
+	matches, _ := dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Equal([]string{outpath}, matches, "no temp or marker file")
+
+	// A file left partial by an interrupted copy still has its marker and
+	// is copied again
+	for _, cache := range []bool{false, true} {
+		tr.CacheDestination = cache
+		assert.Nil(dst.WriteFile(outpath, b[:100], time.Now()))
+		assert.Nil(dst.WriteFile(outpath+PartialSuffix, nil, time.Now()))
+		assert.Nil(tr.CopyEVTFiles())
+		got, _ := dst.ReadFile(outpath)
+		assert.Equal(len(b), len(got), "partial file copied again")
+		_, err = dst.Stat(outpath + PartialSuffix)
+		assert.True(os.IsNotExist(err), "marker removed")
+	}
+}
+
+func TestMemfsNoTempFileRecopyFails(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.NoTempFile = true
+	tr.Force = true
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00")
+	outpath := filepath.Join("/dst", "2016_133", "2016-05-12T17-00-02+00-00.gz")
+	assert.Nil(src.WriteFile(a, []byte("new"), time.Now()))
+	latest := filepath.Join("/src", "2016_133", "2016-05-12T17-00-05+00-00")
+	assert.Nil(src.WriteFile(latest, []byte("b"), time.Now()))
+	assert.Nil(dst.WriteFile(outpath, []byte("complete"), time.Now()))
+	dst.FailOn("Write", "", errors.New("write failed"))
+
+	// A complete file is replaced through a temp file, so it's kept if the
+	// copy fails
+	assert.NotNil(tr.CopyEVTFiles())
+	got, err := dst.ReadFile(outpath)
+	assert.Nil(err)
+	assert.Equal("complete", string(got))
+	matches, _ := dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Equal([]string{outpath}, matches, "no temp or marker file")
+
+	dst.FailOn("Write", "", nil)
+	assert.Nil(tr.CopyEVTFiles())
+	got, _ = dst.ReadFile(outpath)
+	assert.NotEqual("complete", string(got), "replaced")
+}
+
+func TestMemfsValidateSFL(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.ValidateSFL = true
+	tr.KeepGoing = true
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-00+00-00.sfl")
+	b := filepath.Join("/src", "2016_133", "2016-05-12T17-00-05+00-00.sfl")
+	c := filepath.Join("/src", "2016_133", "2016-05-12T17-00-10+00-00.sfl")
+	assert.Nil(src.WriteFile(a, []byte("FILE\tDATE\na.evt\t2016-05-12\n"), time.Now()))
+	assert.Nil(src.WriteFile(b, []byte("FILE\tDATE\na.evt\t2016-"), time.Now()))
+	assert.Nil(src.WriteFile(c, []byte("FILE\tDATE\na.evt\t2016-"), time.Now()))
+
+	err := tr.CopySFLFiles()
+
+	assert.True(errors.Is(err, ErrFilesFailed))
+	_, err = dst.Stat(filepath.Join("/dst", "2016_133", filepath.Base(a)))
+	assert.Nil(err, "valid file copied")
+	_, err = dst.Stat(filepath.Join("/dst", "2016_133", filepath.Base(b)))
+	assert.True(errors.Is(err, os.ErrNotExist), "truncated file removed")
+	_, err = dst.Stat(filepath.Join("/dst", "2016_133", filepath.Base(c)))
+	assert.Nil(err, "latest file may still be written, not checked")
+	assert.Equal(map[string]int{"verify": 1}, tr.Stats.Summary().FailedByStage)
+}
+
+func TestMemfsProbeCompression(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	sizes := []int{1000, 30000, 20000, 50000} // last is the latest file, not probed
+	for i, size := range sizes {
+		path := filepath.Join("/src", "2016_133", fmt.Sprintf("2016-05-12T17-00-0%d+00-00", i))
+		data := bytes.Repeat([]byte(strconv.Itoa(i)+" seaflow "), size/10)
+		assert.Nil(src.WriteFile(path, data, time.Now()))
+	}
+	gz := filepath.Join("/src", "2016_133", "2016-05-12T16-00-00+00-00.gz")
+	assert.Nil(src.WriteFile(gz, bytes.Repeat([]byte("x"), 100000), time.Now()))
+
+	probes, err := tr.ProbeCompression(context.Background(), 2, ProbeLevels)
+
+	assert.Nil(err)
+	if assert.Equal(len(ProbeLevels), len(probes)) {
+		for i, p := range probes {
+			assert.Equal(ProbeLevels[i], p.Level)
+			assert.Equal(2, p.Files, "largest uncompressed files probed")
+			assert.Equal(int64(50000), p.Bytes)
+			assert.True(p.Ratio() > 0 && p.Ratio() < 1, "ratio %v", p.Ratio())
+		}
+		assert.LessOrEqual(probes[2].CompressedBytes, probes[0].CompressedBytes)
+	}
+	matches, _ := dst.Glob("/dst/*")
+	assert.Equal(0, len(matches), "nothing written")
+
+	_, err = tr.ProbeCompression(context.Background(), 2, []int{42})
+	assert.NotNil(err, "invalid level")
+}
+
+func TestMemfsLatestFromLastDir(t *testing.T) {
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-00+00-00")
+	b := filepath.Join("/src", "2016_133", "2016-05-12T17-00-05+00-00")
+	c := filepath.Join("/src", "2016_134", "2016-05-13T00-00-00+00-00")
+	tests := []struct {
+		name              string
+		files             []string
+		emptyDir          string
+		latestFromLastDir bool
+		want              []string
+	}{
+		{"single day", []string{a, b}, "", false, []string{a}},
+		{"single day lastDir", []string{a, b}, "", true, []string{a}},
+		{"multi-day", []string{a, b, c}, "", false, []string{a, b}},
+		{"multi-day lastDir", []string{a, b, c}, "", true, []string{a, b}},
+		{"empty last day", []string{a, b}, "/src/2016_134", false, []string{a}},
+		{"empty last day lastDir", []string{a, b}, "/src/2016_134", true, []string{a, b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			tr, src, _ := newMemTransfer()
+			tr.LatestFromLastDir = tt.latestFromLastDir
+			for _, path := range tt.files {
+				assert.Nil(src.WriteFile(path, []byte("a"), time.Now()))
+			}
+			if tt.emptyDir != "" {
+				assert.Nil(src.MkdirAll(tt.emptyDir))
+			}
+
+			files, err := tr.ListEVTFiles()
+
+			assert.Nil(err)
+			assert.Equal(tt.want, files)
+		})
+	}
+}
+
+func TestMemfsRecentDays(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	tr.RecentDays = 2
+	files := []string{
+		"/src/2015_365/2015-12-31T12-00-00+00-00",
+		"/src/2016_001/2016-01-01T12-00-00+00-00",
+		"/src/2016_002/2016-01-02T12-00-00+00-00",
+		"/src/2016_002/2016-01-02T12-03-00+00-00",
+		"/src/2016_999/2016-01-03T12-00-00+00-00",
+	}
+	for _, path := range files {
+		assert.Nil(src.WriteFile(path, []byte("a"), time.Now()))
+	}
+
+	got, err := tr.ListEVTFiles()
+
+	assert.Nil(err)
+	assert.Equal(files[1:3], got, "invalid day skipped, latest EVT within recent days skipped")
+
+	tr.RecentDays = 5
+	got, err = tr.ListEVTFiles()
+	assert.Nil(err)
+	assert.Equal(files[:3], got)
+}
+
+// syncingFs is a Memfs which counts file and directory syncs
+type syncingFs struct {
+	*Memfs
+	fileSyncs *int32
+	dirSyncs  *int32
+}
+
+type syncingFile struct {
+	File
+	syncs *int32
+}
+
+func (f syncingFile) Sync() error {
+	atomic.AddInt32(f.syncs, 1)
+	return nil
+}
+
+func (s syncingFs) Create(path string) (File, error) {
+	f, err := s.Memfs.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return syncingFile{File: f, syncs: s.fileSyncs}, nil
+}
+
+func (s syncingFs) SyncDir(path string) error {
+	atomic.AddInt32(s.dirSyncs, 1)
+	return nil
+}
+
+func TestMemfsFsync(t *testing.T) {
+	for _, fsync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fsync=%v", fsync), func(t *testing.T) {
+			assert := assert.New(t)
+			tr, src, dst := newMemTransfer()
+			var fileSyncs, dirSyncs int32
+			tr.Dstfs = syncingFs{Memfs: dst, fileSyncs: &fileSyncs, dirSyncs: &dirSyncs}
+			tr.Fsync = fsync
+			a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-00+00-00.sfl")
+			b := filepath.Join("/src", "2016_133", "2016-05-12T17-00-05+00-00.sfl")
+			assert.Nil(src.WriteFile(a, []byte("a"), time.Now()))
+			assert.Nil(src.WriteFile(b, []byte("b"), time.Now()))
+
+			assert.Nil(tr.CopySFLFiles())
+
+			want := int32(0)
+			if fsync {
+				want = 2
+			}
+			assert.Equal(want, atomic.LoadInt32(&fileSyncs), "each temp file synced before rename")
+			assert.Equal(want, atomic.LoadInt32(&dirSyncs), "directory synced after each rename")
+		})
+	}
+}
+
+func TestMemfsKindDstroots(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.SFLDstroot = "/sfl"
+	tr.EVTDstroot = "/evt"
+	tr.ConfirmDelete = true
+	evt := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	sfl := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	latest := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	opp := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00.opp")
+	assert.Nil(src.WriteFile(filepath.Join("/src", evt), []byte("evt"), time.Now()))
+	assert.Nil(src.WriteFile(filepath.Join("/src", sfl), []byte("sfl"), time.Now()))
+	assert.Nil(src.WriteFile(filepath.Join("/src", latest), []byte("latest"), time.Now()))
+	assert.Nil(src.WriteFile(filepath.Join("/src", opp), []byte("opp"), time.Now()))
+	orphan := filepath.Join("/evt", "2016_133", "2016-05-12T16-00-00+00-00.gz")
+	assert.Nil(dst.WriteFile(orphan, []byte("orphan"), time.Now()))
+
+	assert.Nil(tr.CopySFLFiles())
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Nil(tr.CopyOPPFiles())
+
+	_, err := dst.Stat(filepath.Join("/sfl", sfl))
+	assert.Nil(err, "SFL file in SFLDstroot")
+	_, err = dst.Stat(filepath.Join("/evt", evt+".gz"))
+	assert.Nil(err, "EVT file in EVTDstroot")
+	_, err = dst.Stat(filepath.Join("/dst", opp+".gz"))
+	assert.Nil(err, "OPP file in Dstroot")
+	matches, _ := dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Len(matches, 1, "only OPP file in Dstroot")
+
+	// Copied EVT files are found in EVTDstroot
+	dst.FailOn("Create", "", errors.New("copy not expected"))
+	assert.Nil(tr.CopyEVTFiles())
+	dst.FailOn("Create", "", nil)
+
+	assert.Nil(tr.DeleteOrphans())
+	_, err = dst.Stat(orphan)
+	assert.True(os.IsNotExist(err), "orphan deleted from EVTDstroot")
+	_, err = dst.Stat(filepath.Join("/evt", evt+".gz"))
+	assert.Nil(err, "EVT file kept")
+	_, err = dst.Stat(filepath.Join("/sfl", sfl))
+	assert.Nil(err, "SFL file kept")
+}
+
+func TestMemfsDryRunPlan(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.DryRun = true
+	tr.Force = true
+	a := filepath.Join("2016_133", "2016-05-12T17-00-02+00-00")
+	b := filepath.Join("2016_133", "2016-05-12T17-00-03+00-00")
+	latest := filepath.Join("2016_133", "2016-05-12T17-00-05+00-00")
+	for _, path := range []string{a, b, latest} {
+		assert.Nil(src.WriteFile(filepath.Join("/src", path), []byte("evt"), time.Now()))
+	}
+	assert.Nil(dst.WriteFile(filepath.Join("/dst", b+".gz"), []byte("old"), time.Now()))
+
+	assert.Nil(tr.CopyEVTFiles())
+
+	plan := tr.Plan()
+	assert.Equal([]string{filepath.Join("/dst", a+".gz")}, plan.Create)
+	assert.Equal([]string{filepath.Join("/dst", b+".gz")}, plan.Overwrite)
+	got, err := dst.ReadFile(filepath.Join("/dst", b+".gz"))
+	assert.Nil(err)
+	assert.Equal("old", string(got), "nothing written")
+	_, err = dst.Stat(filepath.Join("/dst", a+".gz"))
+	assert.True(os.IsNotExist(err), "nothing created")
+}
+
+// stallingFs is a Memfs whose files' reads are delayed by stall for the first
+// stalls opens, and by delay afterwards
+type stallingFs struct {
+	*Memfs
+	stalls *int32
+	delay  time.Duration
+	stall  time.Duration
+}
+
+type stallingFile struct {
+	File
+	delay time.Duration
+}
+
+func (f stallingFile) Read(p []byte) (int, error) {
+	time.Sleep(f.delay)
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return f.File.Read(p)
+}
+
+func (s stallingFs) Open(path string) (File, error) {
+	f, err := s.Memfs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.AddInt32(s.stalls, -1) >= 0 {
+		return stallingFile{File: f, delay: s.stall}, nil
+	}
+	return stallingFile{File: f, delay: s.delay}, nil
+}
+
+func TestMemfsStallTimeout(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	a := filepath.Join("/src", "2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	assert.Nil(src.WriteFile(a, []byte("0123456789"), time.Now()))
+	stalls := int32(1)
+	tr.Srcfs = stallingFs{Memfs: src, stalls: &stalls, delay: 10 * time.Millisecond, stall: 200 * time.Millisecond}
+	tr.StallTimeout = 50 * time.Millisecond
+
+	// Without retries the stalled copy fails, once the stalled read returns
+	// and its temp file is removed
+	err := tr.CopyFile(a, false)
+	assert.True(errors.Is(err, ErrStalled), "stalled copy fails")
+	matches, _ := dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Empty(matches, "temp file removed")
+
+	// A retry succeeds, though reading all of the file takes longer than
+	// StallTimeout
+	stalls = 1
+	tr.MaxRetries = 1
+	start := time.Now()
+	assert.Nil(tr.CopyFile(a, false))
+	assert.Greater(int64(time.Since(start)), int64(tr.StallTimeout)*2, "slow copy not cancelled")
+	b, err := dst.ReadFile(filepath.Join("/dst", "2016_133", filepath.Base(a)))
+	assert.Nil(err)
+	assert.Equal("0123456789", string(b))
+	matches, _ = dst.Glob(filepath.Join("/dst", "2016_133", "*"))
+	assert.Len(matches, 1, "stalled attempt's temp file removed")
+}
+
+func TestMemfsNormalizeNames(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.NormalizeNames = true
+	tr.EVTPattern = DefaultEVTPattern + "{,.gz}"
+	now := time.Now()
+	files := []string{
+		"/src/2016_133/2016-05-12T17-00-02-00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-06-02-00-00.gz",
+		"/src/2016_133/2016-05-12T17-09-02-00-00",
+	}
+	for _, path := range files {
+		assert.Nil(src.WriteFile(path, []byte(path), now))
+	}
+	assert.Nil(tr.CopyEVTFiles())
+
+	for _, path := range []string{
+		"/dst/2016_133/2016-05-12T17-00-02+00-00.gz",
+		"/dst/2016_133/2016-05-12T17-03-02+00-00.gz",
+		"/dst/2016_133/2016-05-12T17-06-02+00-00.gz",
+	} {
+		_, err := dst.Stat(path)
+		assert.Nil(err, path)
+	}
+	for _, path := range []string{
+		"/dst/2016_133/2016-05-12T17-00-02-00-00.gz",
+		"/dst/2016_133/2016-05-12T17-06-02-00-00.gz",
+	} {
+		_, err := dst.Stat(path)
+		assert.True(os.IsNotExist(err), path)
+	}
+	_, err := src.Stat(files[0])
+	assert.Nil(err, "source is untouched")
+
+	// Re-runs match normalized destination files
+	assert.Nil(dst.WriteFile("/dst/2016_133/2016-05-12T17-00-02+00-00.gz", []byte("kept"), now))
+	assert.Nil(tr.CopyEVTFiles())
+	got, err := dst.ReadFile("/dst/2016_133/2016-05-12T17-00-02+00-00.gz")
+	assert.Nil(err)
+	assert.Equal("kept", string(got), "not copied again")
+	copied, err := dst.Glob("/dst/2016_133/*")
+	assert.Nil(err)
+	assert.Len(copied, 3, "no duplicate variants")
+
+	// "-" separator
+	tr, src, dst = newMemTransfer()
+	tr.NormalizeNames = true
+	tr.TZSeparator = "-"
+	tr.EVTPattern = DefaultEVTPattern + "{,.gz}"
+	for _, path := range files {
+		assert.Nil(src.WriteFile(path, []byte(path), now))
+	}
+	assert.Nil(tr.CopyEVTFiles())
+	_, err = dst.Stat("/dst/2016_133/2016-05-12T17-03-02-00-00.gz")
+	assert.Nil(err)
+	_, err = dst.Stat("/dst/2016_133/2016-05-12T17-03-02+00-00.gz")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestMemfsActiveProgress(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	now := time.Now()
+	for _, path := range []string{
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-06-02+00-00",
+	} {
+		assert.Nil(src.WriteFile(path, []byte(path), now))
+	}
+	fail := "/src/2016_133/2016-05-12T17-03-02+00-00"
+	src.FailOn("Read", fail, errors.New("read failed"))
+	tr.KeepGoing = true
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	tr.Progress = func(path string, copied, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, p := range tr.Stats.Active() {
+			if p.Path == path && p.BytesCopied == copied && p.TotalBytes == total {
+				seen[path] = true
+			}
+		}
+		assert.Greater(tr.Stats.Remaining(), 0)
+	}
+
+	assert.True(errors.Is(tr.CopyEVTFiles(), ErrFilesFailed))
+	assert.True(seen["/src/2016_133/2016-05-12T17-00-02+00-00"])
+	assert.True(seen[fail])
+	assert.Empty(tr.Stats.Active(), "failed copies aren't left in progress")
+	assert.Equal(0, tr.Stats.Remaining())
+	assert.Equal(1, tr.Stats.Summary().Failed)
+}
+
+// writerFunc is an io.Writer calling a function for each write
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestMemfsRetryEndsProgress(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	path := "/src/2016_133/2016-05-12T17-00-02+00-00"
+	assert.Nil(src.WriteFile(path, []byte(path), time.Now()))
+	src.FailOn("Read", path, io.ErrUnexpectedEOF)
+	tr.MaxRetries = 1
+	tr.RetryDelay = time.Millisecond
+	tr.Progress = func(string, int64, int64) {}
+	retries := 0
+	tr.Error = log.New(writerFunc(func(p []byte) (int, error) {
+		if strings.Contains(string(p), "retrying") {
+			retries++
+			assert.Empty(tr.Stats.Active(), "abandoned attempt not in progress")
+		}
+		return len(p), nil
+	}), "", 0)
+
+	assert.NotNil(tr.CopyFile(path, false))
+	assert.Equal(1, retries)
+	assert.Empty(tr.Stats.Active())
+}
+
+func TestMemfsCancelledAdd(t *testing.T) {
+	assert := assert.New(t)
+	tr, _, _ := newMemTransfer()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := tr.newCopyPool(ctx, false)
+	for i := 0; i < 100; i++ {
+		p.add(fmt.Sprintf("/src/2016_133/2016-05-12T17-%02d-00+00-00", i))
+	}
+	_, err := p.wait()
+	assert.True(errors.Is(err, context.Canceled))
+	assert.Equal(0, tr.Stats.Remaining(), "files not taken by a worker aren't remaining")
+}
+
+func TestMemfsRequireSFLRecord(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.RequireSFLRecord = true
+	tr.IncludeLatest = true
+	tr.SFLPattern = DefaultSFLPattern + "{,.gz}"
+	now := time.Now()
+	evts := []string{
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-06-02+00-00",
+		"/src/2016_134/2016-05-13T17-00-02+00-00",
+		"/src/2016_134/2016-05-13T17-03-02+00-00",
+	}
+	for _, path := range evts {
+		assert.Nil(src.WriteFile(path, []byte(path), now))
+	}
+	// Current format, with the last row not finished
+	assert.Nil(src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00.sfl", []byte(
+		"FILE\tDATE\tFILE_DURATION\n"+
+			"2016_133/2016-05-12T17-00-02+00-00\t2016-05-12T17:00:02+00:00\t180\n"+
+			"2016_133/2016-05-12T17-03-02-00-00\t2016-05-12T17:03:02+00:00\t180\n"+
+			"2016_133/2016-05-12T17-06-02+00-00"), now))
+	// Older format with only a timestamp, gzipped
+	var b bytes.Buffer
+	gzw := gzip.NewWriter(&b)
+	_, _ = gzw.Write([]byte("DATE\tFILE_DURATION\n2016-05-13T17:00:02+00:00\t180\n"))
+	assert.Nil(gzw.Close())
+	assert.Nil(src.WriteFile("/src/2016_134/2016-05-13T17-00-02+00-00.sfl.gz", b.Bytes(), now))
+
+	assert.Nil(tr.CopyEVTFiles())
+	for i, path := range evts {
+		_, err := dst.Stat(strings.Replace(path, "/src", "/dst", 1) + ".gz")
+		if i == 2 || i == 4 {
+			assert.True(os.IsNotExist(err), "unrecorded %v", path)
+		} else {
+			assert.Nil(err, "recorded %v", path)
+		}
+	}
+	assert.Equal(2, tr.Stats.Summary().Skipped)
+
+	// SFL files without a FILE or DATE column fail the pass
+	assert.Nil(src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00.sfl", []byte("A\tB\n1\t2\n"), now))
+	err := tr.CopyEVTFiles()
+	assert.True(errors.Is(err, ErrInvalidSFL), "%v", err)
+}
+
+// mkdirCountingFs is a Memfs which counts MkdirAll calls
+type mkdirCountingFs struct {
+	*Memfs
+	n *int32
+}
+
+func (m mkdirCountingFs) MkdirAll(path string) error {
+	atomic.AddInt32(m.n, 1)
+	return m.Memfs.MkdirAll(path)
+}
+
+func TestMemfsMkdirAllOncePerPass(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	var n int32
+	tr.Dstfs = mkdirCountingFs{dst, &n}
+	tr.Workers = 2
+	now := time.Now()
+	for _, path := range []string{
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-06-02+00-00",
+		"/src/2016_133/2016-05-12T17-09-02+00-00",
+		"/src/2016_134/2016-05-13T17-00-02+00-00",
+		"/src/2016_134/2016-05-13T17-03-02+00-00",
+	} {
+		assert.Nil(src.WriteFile(path, []byte(path), now))
+	}
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Equal(int32(2), atomic.LoadInt32(&n), "one per destination directory")
+
+	// Directories removed between passes are made again
+	removed, err := dst.Glob("/dst/2016_133/*")
+	assert.Nil(err)
+	for _, path := range removed {
+		assert.Nil(dst.Remove(path))
+	}
+	assert.Nil(dst.Remove("/dst/2016_133"))
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Equal(int32(3), atomic.LoadInt32(&n))
+	_, err = dst.Stat("/dst/2016_133/2016-05-12T17-06-02+00-00.gz")
+	assert.Nil(err)
+}
+
+func TestMemfsListDays(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	tr.Srcroots = []string{"/src", "/src2"}
+	now := time.Now()
+	for _, path := range []string{
+		"/src/2016_134/2016-05-13T17-00-02+00-00",
+		"/src/2016_134/2016-05-13T17-00-02+00-00.sfl",
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-00-02+00-00.sfl",
+		"/src/2016_133/notes.txt",
+		"/src2/2016_133/2016-05-12T17-06-02+00-00",
+		"/src2/2016_135/2016-05-14T17-00-02+00-00.sfl",
+	} {
+		assert.Nil(src.WriteFile(path, []byte("x"), now))
+	}
+
+	days, err := tr.ListDays()
+	assert.Nil(err)
+	assert.Equal([]DayCount{
+		{Day: "2016_133", SFL: 1, EVT: 3},
+		{Day: "2016_134", SFL: 1, EVT: 1},
+		{Day: "2016_135", SFL: 1, EVT: 0},
+	}, days)
+}
+
+// chtimesIgnoringFs is a Memfs which silently ignores Chtimes
+type chtimesIgnoringFs struct {
+	*Memfs
+}
+
+func (m chtimesIgnoringFs) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return nil
+}
+
+func TestMemfsSelfTest(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	assert.Nil(src.MkdirAll("/src"))
+	assert.Nil(tr.SelfTest(context.Background()))
+	files, _ := src.Glob("/src/*")
+	assert.Equal(0, len(files), "source cleaned up")
+	files, _ = dst.Glob("/dst/*")
+	assert.Equal(0, len(files), "destination cleaned up")
+	assert.Equal(0, tr.Stats.Summary().Copied, "not recorded as a copy")
+
+	// A read-only source falls back to a local file
+	src.FailOn("Create", "", errors.New("read-only"))
+	assert.Nil(tr.SelfTest(context.Background()))
+	files, _ = dst.Glob("/dst/*")
+	assert.Equal(0, len(files))
+
+	tr.Dstfs = chtimesIgnoringFs{dst}
+	err := tr.SelfTest(context.Background())
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "modification time")
+		assert.NotContains(err.Error(), "read back")
+	}
+	files, _ = dst.Glob("/dst/*")
+	assert.Equal(0, len(files), "destination cleaned up after failure")
+
+	dst.FailOn("Rename", "", errors.New("rename failed"))
+	err = tr.SelfTest(context.Background())
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "copy: ")
+		assert.Contains(err.Error(), "rename failed")
+	}
+	files, _ = dst.Glob("/dst/*")
+	assert.Equal(0, len(files), "destination cleaned up after failed copy")
+}
+
+// racingFs is a Memfs where another writer creates path when the first file
+// is created, as if another run were copying the same file
+type racingFs struct {
+	*Memfs
+	path string
+}
+
+func (m racingFs) Create(path string) (File, error) {
+	_ = m.Memfs.WriteFile(m.path, []byte("other"), time.Now())
+	return m.Memfs.Create(path)
+}
+
+func TestMemfsNoClobber(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.NoClobber = true
+	tr.Force = true
+	now := time.Now()
+	for _, path := range []string{
+		"/src/2016_133/2016-05-12T17-00-02+00-00.sfl",
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-06-02+00-00",
+	} {
+		assert.Nil(src.WriteFile(path, []byte("new"), now))
+	}
+	for _, path := range []string{
+		"/dst/2016_133/2016-05-12T17-00-02+00-00.sfl",
+		"/dst/2016_133/2016-05-12T17-00-02+00-00.gz",
+	} {
+		assert.Nil(dst.WriteFile(path, []byte("old"), now))
+	}
+
+	assert.Nil(tr.CopySFLFiles())
+	assert.Nil(tr.CopyEVTFiles())
+	b, _ := dst.ReadFile("/dst/2016_133/2016-05-12T17-00-02+00-00.sfl")
+	assert.Equal("old", string(b), "SFL file not replaced")
+	b, _ = dst.ReadFile("/dst/2016_133/2016-05-12T17-00-02+00-00.gz")
+	assert.Equal("old", string(b), "EVT file not replaced with Force")
+	_, err := dst.Stat("/dst/2016_133/2016-05-12T17-03-02+00-00.gz")
+	assert.Nil(err, "new file copied")
+	assert.Equal(1, tr.Stats.Summary().Copied)
+
+	// Destination file written by someone else during the copy
+	racePath := "/dst/2016_133/2016-05-12T17-03-02+00-00.gz"
+	assert.Nil(dst.Remove(racePath))
+	tr.Dstfs = racingFs{dst, racePath}
+	err = tr.CopyFile("/src/2016_133/2016-05-12T17-03-02+00-00", true)
+	assert.True(errors.Is(err, ErrClobber), "copy fails rather than replace")
+	b, _ = dst.ReadFile(racePath)
+	assert.Equal("other", string(b), "other writer's file kept")
+	files, _ := dst.Glob("/dst/2016_133/" + DefaultTempPrefix + "*")
+	assert.Equal(0, len(files), "temp file removed")
+}
+
+func TestMemfsGzipName(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	gzw.Name = "renamed.sfl"
+	gzw.Comment = "comment"
+	gzw.Extra = []byte("extra")
+	_, _ = gzw.Write([]byte("sfl data"))
+	assert.Nil(gzw.Close())
+	mtime := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+	sfl := "/src/2016_133/2016-05-12T17-00-00+00-00.sfl.gz"
+	evt := "/src/2016_133/2016-05-12T17-03-00+00-00"
+	readHeader := func(fsys *Memfs, path string) gzip.Header {
+		b, err := fsys.ReadFile(path)
+		assert.Nil(err)
+		gzr, err := gzip.NewReader(bytes.NewReader(b))
+		if !assert.Nil(err) {
+			return gzip.Header{}
+		}
+		data, err := ioutil.ReadAll(gzr)
+		assert.Nil(err)
+		assert.Contains([]string{"sfl data", "evt data"}, string(data))
+		return gzr.Header
+	}
+
+	// Mismatches in gzipped source files are only logged
+	tr, src, dst := newMemTransfer()
+	tr.CheckGzipName = true
+	assert.Nil(src.WriteFile(sfl, buf.Bytes(), mtime))
+	assert.Nil(src.WriteFile(evt, []byte("evt data"), mtime))
+	assert.Nil(tr.CopyFile(sfl, true))
+	b, _ := dst.ReadFile("/dst/2016_133/2016-05-12T17-00-00+00-00.sfl.gz")
+	assert.Equal(buf.Bytes(), b, "copied as-is")
+	assert.Nil(tr.CopyFile(evt, true))
+	assert.Equal("2016-05-12T17-03-00+00-00", readHeader(dst, "/dst/2016_133/2016-05-12T17-03-00+00-00.gz").Name)
+
+	// or rewritten
+	tr, src, dst = newMemTransfer()
+	tr.CheckGzipName = true
+	tr.FixGzipName = true
+	tr.Verify = true
+	assert.Nil(src.WriteFile(sfl, buf.Bytes(), mtime))
+	assert.Nil(tr.CopyFile(sfl, true))
+	h := readHeader(dst, "/dst/2016_133/2016-05-12T17-00-00+00-00.sfl.gz")
+	assert.Equal("2016-05-12T17-00-00+00-00.sfl", h.Name)
+	assert.Equal("comment", h.Comment)
+	assert.Equal([]byte("extra"), h.Extra)
+
+	// Invalid headers fail
+	assert.Nil(src.WriteFile(sfl, []byte("not gzip"), mtime))
+	err := tr.CopyFile(sfl, true)
+	assert.True(errors.Is(err, ErrInvalidGzip), "ErrInvalidGzip returned")
+}
+
+func TestMemfsHeadBytes(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.HeadBytes = 4
+	mtime := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+	a := "2016_133/2016-05-12T17-00-00+00-00"
+	b := "2016_133/2016-05-12T17-03-00+00-00"
+	c := "2016_133/2016-05-12T17-06-00+00-00" // latest, not copied
+	s := "2016_133/2016-05-12T17-00-00+00-00.sfl"
+	for _, path := range []string{a, b, c, s} {
+		assert.Nil(src.WriteFile("/src/"+path, []byte("0123456789"), mtime))
+	}
+	assert.Nil(dst.WriteFile("/dst/"+a+".gz", []byte("complete"), mtime))
+
+	assert.Nil(tr.CopySFLFiles())
+	assert.Nil(tr.CopyEVTFiles())
+
+	got, _ := dst.ReadFile("/dst/" + s + ".head")
+	assert.Equal("0123", string(got))
+	for _, path := range []string{a, b} {
+		got, err := dst.ReadFile("/dst/" + path + ".gz.head")
+		if assert.Nil(err, path+" sampled even if already at destination") {
+			gzr, err := gzip.NewReader(bytes.NewReader(got))
+			assert.Nil(err)
+			data, _ := ioutil.ReadAll(gzr)
+			assert.Equal("0123", string(data))
+		}
+	}
+	got, _ = dst.ReadFile("/dst/" + a + ".gz")
+	assert.Equal("complete", string(got), "complete file not replaced")
+	files, _ := dst.Glob("/dst/2016_133/*")
+	assert.Len(files, 4)
+
+	// Partial files aren't seen as copies
+	tr.HeadBytes = 0
+	assert.Nil(tr.CopyEVTFiles())
+	got, _ = dst.ReadFile("/dst/" + a + ".gz")
+	assert.Equal("complete", string(got), "complete file not copied again")
+	_, err := dst.Stat("/dst/" + b + ".gz")
+	assert.Nil(err, "sampled file copied in full")
+}
+
+func TestMemfsHeadBytesMove(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.HeadBytes = 4
+	tr.Move = true
+	a := "2016_133/2016-05-12T17-00-00+00-00"
+	b := "2016_133/2016-05-12T17-03-00+00-00" // latest, not copied
+	for _, path := range []string{a, b} {
+		assert.Nil(src.WriteFile("/src/"+path, []byte("0123456789"), time.Now()))
+	}
+
+	assert.Nil(tr.CopyEVTFiles())
+
+	_, err := dst.Stat("/dst/" + a + ".gz.head")
+	assert.Nil(err, "sampled")
+	_, err = src.Stat("/src/" + a)
+	assert.Nil(err, "partially copied source not removed")
+	s := tr.Stats.Summary()
+	assert.Equal(1, s.Copied)
+	assert.Empty(s.Files, "partial copy not listed")
+	ts, err := tr.ResumeTime()
+	assert.Nil(err)
+	assert.True(ts.IsZero(), "partial copy doesn't advance resume time")
+}
+
+func TestMemfsEvents(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	events := make(chan FileResult, 10)
+	tr.Events = events
+	tr.KeepGoing = true
+	mtime := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+	a := "/src/2016_133/2016-05-12T17-00-00+00-00"
+	b := "/src/2016_133/2016-05-12T17-03-00+00-00" // empty
+	c := "/src/2016_133/2016-05-12T17-06-00+00-00" // fails
+	d := "/src/2016_133/2016-05-12T17-09-00+00-00" // latest, not copied
+	assert.Nil(src.WriteFile(a, []byte("aaaa"), mtime))
+	assert.Nil(src.WriteFile(b, nil, mtime))
+	assert.Nil(src.WriteFile(c, []byte("cccc"), mtime))
+	assert.Nil(src.WriteFile(d, []byte("dddd"), mtime))
+	src.FailOn("Open", c, os.ErrPermission)
+
+	err := tr.CopyEVTFiles()
+	assert.True(errors.Is(err, ErrFilesFailed))
+	close(events)
+	results := make(map[string]FileResult)
+	for r := range events {
+		results[r.Src] = r
+	}
+	assert.Len(results, 3)
+	assert.Equal("/dst/2016_133/2016-05-12T17-00-00+00-00.gz", results[a].Dst)
+	assert.Equal(int64(4), results[a].Bytes)
+	assert.True(results[a].Gzipped)
+	assert.Nil(results[a].Err)
+	assert.Equal("file is empty", results[b].Skipped)
+	assert.Equal("", results[b].Dst)
+	assert.True(errors.Is(results[c].Err, os.ErrPermission))
+
+	// Results are dropped rather than blocking
+	tr, src, _ = newMemTransfer()
+	tr.Events = make(chan FileResult)
+	assert.Nil(src.WriteFile(a, []byte("aaaa"), mtime))
+	assert.Nil(src.WriteFile(d, []byte("dddd"), mtime))
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Equal(1, tr.Stats.Summary().EventsDropped)
+}
+
+func TestMemfsRepair(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	mtime := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+	a := "2016_133/2016-05-12T17-00-00+00-00" // matches
+	b := "2016_133/2016-05-12T17-03-00+00-00" // corrupted
+	c := "2016_133/2016-05-12T17-06-00+00-00" // missing
+	d := "2016_133/2016-05-12T17-09-00+00-00" // mismatched, not gzipped
+	e := "2016_133/2016-05-12T17-12-00+00-00" // source unreadable during repair
+	f := "2016_133/2016-05-12T17-15-00+00-00" // latest, not copied
+	for _, path := range []string{a, b, c, d, e, f} {
+		assert.Nil(src.WriteFile("/src/"+path, []byte(path), mtime))
+	}
+	assert.Nil(tr.CopyEVTFiles())
+	var corrupt bytes.Buffer
+	gzw := gzip.NewWriter(&corrupt)
+	_, _ = gzw.Write([]byte(strings.Repeat("x", len(b))))
+	assert.Nil(gzw.Close())
+	assert.Nil(dst.WriteFile("/dst/"+b+".gz", corrupt.Bytes(), mtime))
+	assert.Nil(dst.Remove("/dst/" + c + ".gz"))
+	assert.Nil(dst.Remove("/dst/" + d + ".gz"))
+	assert.Nil(dst.WriteFile("/dst/"+d, []byte("old"), mtime))
+	src.FailOn("Open", "/src/"+e, os.ErrPermission)
+	files, err := tr.ListEVTFiles()
+	assert.Nil(err)
+	info, _ := dst.Stat("/dst/" + a + ".gz")
+
+	res, err := tr.Repair(context.Background(), files)
+
+	assert.Nil(err)
+	assert.Equal(RepairResult{Verified: 1, Repaired: 3, Failed: 1}, res)
+	assert.False(res.OK())
+	after, _ := dst.Stat("/dst/" + a + ".gz")
+	assert.Equal(info.ModTime(), after.ModTime(), "matching file not rewritten")
+	for _, path := range []string{b, c, d} {
+		got, err := dst.ReadFile("/dst/" + path + ".gz")
+		if assert.Nil(err, path) {
+			gzr, err := gzip.NewReader(bytes.NewReader(got))
+			assert.Nil(err)
+			data, _ := ioutil.ReadAll(gzr)
+			assert.Equal(path, string(data), path+" repaired")
+		}
+	}
+	_, err = dst.Stat("/dst/" + d)
+	assert.True(os.IsNotExist(err), "mismatched file with a different name removed")
+
+	src.FailOn("Open", "/src/"+e, nil)
+	res, err = tr.Repair(context.Background(), files)
+	assert.Nil(err)
+	assert.Equal(RepairResult{Verified: 5}, res)
+	assert.True(res.OK())
+}
+
+func TestMemfsDeleteOrphansMove(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.Move = true
+	tr.ConfirmDelete = true
+	mtime := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+	a := "2016_133/2016-05-12T17-00-00+00-00"
+	b := "2016_133/2016-05-12T17-03-00+00-00" // latest, not moved
+	assert.Nil(src.WriteFile("/src/"+a, []byte("a"), mtime))
+	assert.Nil(src.WriteFile("/src/"+b, []byte("b"), mtime))
+	assert.Nil(tr.CopyEVTFiles())
+	_, err := src.Stat("/src/" + a)
+	assert.True(os.IsNotExist(err), "source removed")
+
+	assert.NotNil(tr.DeleteOrphans())
+	_, err = dst.Stat("/dst/" + a + ".gz")
+	assert.Nil(err, "moved file not deleted")
+}
+
+func TestMemfsDestinationGlobFailure(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	assert.Nil(src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00", []byte("a"), time.Now()))
+	assert.Nil(src.WriteFile("/src/2016_133/2016-05-12T17-00-05+00-00", []byte("b"), time.Now()))
+	dst.FailOn("Glob", "", errors.New("connection lost"))
+
+	err := tr.CopyEVTFiles()
+
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "could not match destination files")
+	}
+	assert.Equal(0, tr.Stats.Summary().Copied)
+}
+
+func TestMemfsSourceGlobFailure(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	assert.Nil(src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00.sfl", []byte("a"), time.Now()))
+	assert.Nil(src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00", []byte("a"), time.Now()))
+	src.FailOn("Glob", "", errors.New("permission denied"))
+
+	err := tr.CopySFLFiles()
+	if assert.NotNil(err, "SFL glob error returned") {
+		assert.Contains(err.Error(), "could not match source")
+	}
+	err = tr.CopyEVTFiles()
+	assert.NotNil(err, "EVT glob error returned")
+	assert.Equal(0, tr.Stats.Summary().Copied)
+}
+
+func TestMemfsWorkersFailure(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	tr.Workers = 3
+	for i := 0; i < 9; i++ {
+		path := fmt.Sprintf("/src/2016_133/2016-05-12T17-00-%02d+00-00", i)
+		assert.Nil(src.WriteFile(path, []byte("a"), time.Now()))
+	}
+	src.FailOn("Open", "/src/2016_133/2016-05-12T17-00-00+00-00", os.ErrPermission)
+
+	err := tr.CopyEVTFiles()
+	assert.NotNil(err, "first failure stops the pass")
+	assert.Equal(1, tr.Stats.Summary().Failed)
+
+	tr.KeepGoing = true
+	err = tr.CopyEVTFiles()
+	assert.True(errors.Is(err, ErrFilesFailed))
+	assert.Equal(2, tr.Stats.Summary().Failed)
+	matches, _ := src.Glob("/src/2016_133/*")
+	assert.Equal(9, len(matches))
+	copied, _ := tr.Dstfs.Glob("/dst/2016_133/*.gz")
+	assert.Equal(7, len(copied), "other files copied")
+}
+
+func TestMemfsPostCopy(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	now := time.Now()
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00", []byte("a"), now)
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-05+00-00", []byte("b"), now)
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-08+00-00", []byte("c"), now) // latest
+	var records []FileRecord
+	hookErr := errors.New("hook error")
+	tr.PostCopy = func(ctx context.Context, r FileRecord) error {
+		records = append(records, r)
+		return hookErr
+	}
+
+	tr.DryRun = true
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Equal(0, len(records), "not called in dry run")
+
+	// Failures are ignored without PostCopyFatal
+	tr.DryRun = false
+	assert.Nil(tr.CopyEVTFiles())
+	if assert.Equal(2, len(records)) {
+		assert.Equal("/dst/2016_133/2016-05-12T17-00-02+00-00.gz", records[0].Dst)
+		assert.Equal(tr.Stats.Summary().Files, records)
+	}
+
+	// Failures stop the pass with PostCopyFatal, even with KeepGoing
+	records = nil
+	tr.Force = true
+	tr.KeepGoing = true
+	tr.PostCopyFatal = true
+	err := tr.CopyEVTFiles()
+	assert.True(errors.Is(err, ErrPostCopy))
+	assert.Equal(1, len(records), "hook not retried or called again")
+	s := tr.Stats.Summary()
+	assert.Equal(2, s.Copied, "failed hook's copy counted as failed, not copied")
+	assert.Equal(1, s.Failed)
+	assert.Len(s.Files, 2)
+}
+
+func TestMemfsCheckAccess(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	err := tr.CheckAccess()
+	if assert.NotNil(err, "missing roots") {
+		assert.Contains(err.Error(), "source")
+		assert.Contains(err.Error(), "destination")
+	}
+
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00", []byte("a"), time.Now())
+	_ = dst.WriteFile("/dst", []byte("a"), time.Now())
+	err = tr.CheckAccess()
+	if assert.NotNil(err, "destination is a file") {
+		assert.NotContains(err.Error(), "source")
+		assert.Contains(err.Error(), "not a directory")
+	}
+
+	_ = dst.Remove("/dst")
+	_ = dst.MkdirAll("/dst")
+	dst.FailOn("Create", "", os.ErrPermission)
+	err = tr.CheckAccess()
+	if assert.NotNil(err, "destination not writable") {
+		assert.Contains(err.Error(), "could not create")
+	}
+
+	dst.FailOn("Create", "", nil)
+	assert.Nil(tr.CheckAccess())
+	files, _ := dst.Glob("/dst/*")
+	assert.Equal(0, len(files), "test file removed")
+}
+
+// noChtimesfs is a Memfs which silently ignores Chtimes, like some SFTP
+// servers
+type noChtimesfs struct {
+	*Memfs
+}
+
+func (noChtimesfs) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return nil
+}
+
+func TestMemfsMtimeDrift(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	var errLog bytes.Buffer
+	tr.Error = log.New(&errLog, "", 0)
+	past := time.Now().Add(-time.Hour)
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00.sfl", []byte("a"), past)
+
+	assert.Nil(tr.CopySFLFiles())
+	assert.NotContains(errLog.String(), "modification time differs", "no warning if mtime is set")
+
+	tr.Dstfs = noChtimesfs{dst}
+	assert.Nil(tr.CopySFLFiles())
+	assert.Contains(errLog.String(), "warning: destination modification time differs from source")
+	assert.Equal(2, tr.Stats.Summary().Copied, "warning only")
+}
+
+func TestMemfsMaxFiles(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.MaxFiles = 2
+	tr.Workers = 2
+	for i := 0; i < 4; i++ {
+		path := fmt.Sprintf("/src/2016_13%v/2016-05-12T17-00-%02d+00-00", 3+i/2, i)
+		assert.Nil(src.WriteFile(path, []byte("a"), time.Now()))
+	}
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-00+00-00.sfl", []byte("a"), time.Now())
+
+	assert.Nil(tr.CopyEVTFiles())
+	copied, _ := dst.Glob("/dst/*/*.gz")
+	assert.Equal([]string{
+		"/dst/2016_133/2016-05-12T17-00-00+00-00.gz",
+		"/dst/2016_133/2016-05-12T17-00-01+00-00.gz",
+	}, copied, "oldest files copied first")
+
+	assert.Nil(tr.CopySFLFiles(), "limit is per pass")
+	assert.Equal(3, tr.Stats.Summary().Copied)
+
+	assert.Nil(tr.CopyEVTFiles())
+	copied, _ = dst.Glob("/dst/*/*.gz")
+	assert.Equal(3, len(copied), "remaining files copied by later runs, except the most recent")
+}
+
+func TestMemfsOrder(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.Order = OrderNewestFirst
+	tr.MaxFiles = 2
+	var order []string
+	tr.PostCopy = func(ctx context.Context, r FileRecord) error {
+		order = append(order, filepath.Base(r.Src))
+		return nil
+	}
+	for i := 0; i < 4; i++ {
+		path := fmt.Sprintf("/src/2016_13%v/2016-05-12T17-00-%02d+00-00", 3+i/2, i)
+		assert.Nil(src.WriteFile(path, []byte("a"), time.Now()))
+	}
+	for _, path := range []string{
+		"/src/2016_133/2016-05-12T17-00-00+00-00.sfl",
+		"/src/2016_133/notes.sfl", // no time in name
+		"/src/2016_134/2016-05-12T17-00-02+00-00.sfl",
+	} {
+		assert.Nil(src.WriteFile(path, []byte("a"), time.Now()))
+	}
+
+	assert.Nil(tr.CopyEVTFiles())
+	assert.Equal([]string{
+		"2016-05-12T17-00-02+00-00",
+		"2016-05-12T17-00-01+00-00",
+	}, order, "newest files copied first, except the most recent")
+	copied, _ := dst.Glob("/dst/*/*.gz")
+	assert.Equal(2, len(copied))
+
+	order = nil
+	tr.MaxFiles = 0
+	assert.Nil(tr.CopySFLFiles())
+	assert.Equal([]string{
+		"2016-05-12T17-00-02+00-00.sfl",
+		"notes.sfl",
+		"2016-05-12T17-00-00+00-00.sfl",
+	}, order, "SFL files in the same order")
+
+	order = nil
+	tr.Order = OrderOldestFirst
+	assert.Nil(tr.CopySFLFiles())
+	assert.Equal([]string{
+		"2016-05-12T17-00-00+00-00.sfl",
+		"notes.sfl",
+		"2016-05-12T17-00-02+00-00.sfl",
+	}, order)
+}
+
+func TestMemfsMissingSrcroot(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	err := tr.CopySFLFiles()
+	assert.True(errors.Is(err, os.ErrNotExist), "missing source root is an error")
+	err = tr.CopyEVTFiles()
+	assert.True(errors.Is(err, os.ErrNotExist), "missing source root is an error")
+
+	tr.AllowMissingSrc = true
+	assert.Nil(tr.CopySFLFiles())
+	assert.Nil(tr.CopyEVTFiles())
+	files, err := tr.ListEVTFiles()
+	assert.Nil(err)
+	assert.Equal(0, len(files))
+
+	_ = src.WriteFile("/src", []byte("a"), time.Now())
+	assert.NotNil(tr.CopyEVTFiles(), "source root which isn't a directory is an error")
+}
+
+func TestMemfsReadFailure(t *testing.T) {
+	data := make([]byte, 200000)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+	var gzData bytes.Buffer
+	gzw := gzip.NewWriter(&gzData)
+	_, _ = gzw.Write(data)
+	_ = gzw.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		data     []byte
+		gzipFlag bool
+		setup    func(tr *Transfer)
+	}{
+		{"gzip", "/src/2016_133/2016-05-12T17-00-02+00-00", data, true, nil},
+		{"plain", "/src/2016_133/2016-05-12T17-00-02+00-00.sfl", data, false, nil},
+		{"checkGzip", "/src/2016_133/2016-05-12T17-00-02+00-00.gz", gzData.Bytes(), true, func(tr *Transfer) { tr.CheckGzip = true }},
+		{"decompress", "/src/2016_133/2016-05-12T17-00-02+00-00.gz", gzData.Bytes(), true, func(tr *Transfer) { tr.Decompress = true }},
+		{"bufferSize", "/src/2016_133/2016-05-12T17-00-02+00-00", data, true, func(tr *Transfer) { tr.BufferSize = 1 << 20 }},
+		{"verify", "/src/2016_133/2016-05-12T17-00-02+00-00", data, true, func(tr *Transfer) { tr.Verify = true }},
+		{"tempDir", "/src/2016_133/2016-05-12T17-00-02+00-00", data, true, func(tr *Transfer) { tr.TempDir = "/dst/tmp" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			tr, src, dst := newMemTransfer()
+			if tt.setup != nil {
+				tt.setup(tr)
+			}
+			assert.Nil(src.WriteFile(tt.path, tt.data, time.Now()))
+			src.FailOn("Read", tt.path, errors.New("connection lost"))
+
+			err := tr.CopyFile(tt.path, tt.gzipFlag)
+			assert.NotNil(err)
+			for _, pattern := range []string{"/dst/*/*", "/dst/*"} {
+				matches, _ := dst.Glob(pattern)
+				for _, m := range matches {
+					info, _ := dst.Stat(m)
+					assert.True(info.IsDir(), "no partial or temp file %v left behind", m)
+				}
+			}
+
+			// Copy succeeds once the failure clears, with a valid file
+			src.FailOn("Read", tt.path, nil)
+			assert.Nil(tr.CopyFile(tt.path, tt.gzipFlag))
+			rec := tr.Stats.Summary().Files[0]
+			b, err := dst.ReadFile(rec.Dst)
+			assert.Nil(err)
+			if _, compressed := FileKind(rec.Dst); compressed {
+				gzr, err := gzip.NewReader(bytes.NewReader(b))
+				if assert.Nil(err) {
+					b, err = ioutil.ReadAll(gzr)
+					assert.Nil(err, "gzip trailer is valid")
+				}
+			}
+			assert.Equal(data, b)
+		})
+	}
+}
+
+func TestMemfsVerifyMirror(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	now := time.Now()
+	evts := []string{
+		"/src/2016_133/2016-05-12T17-00-02+00-00",
+		"/src/2016_133/2016-05-12T17-03-02+00-00",
+		"/src/2016_133/2016-05-12T17-06-02+00-00",
+		"/src/2016_133/2016-05-12T17-09-02+00-00",
+		"/src/2016_134/2016-05-13T17-00-02+00-00",
+	}
+	for _, path := range evts {
+		assert.Nil(src.WriteFile(path, []byte(path), now))
+	}
+	sfl := "/src/2016_133/2016-05-12T17-00-02+00-00.sfl"
+	assert.Nil(src.WriteFile(sfl, []byte("sfl"), now))
+	assert.Nil(tr.CopySFLFiles())
+	assert.Nil(tr.CopyEVTFiles())
+
+	files := append([]string{sfl}, evts...)
+	res, err := tr.VerifyMirror(context.Background(), files)
+	assert.Nil(err)
+	assert.Equal(AuditResult{Matched: 5, Missing: 1}, res, "latest EVT file wasn't copied")
+	assert.False(res.OK())
+
+	// Changed, corrupt, and removed destination files
+	assert.Nil(dst.WriteFile("/dst/2016_133/2016-05-12T17-00-02+00-00.sfl", []byte("changed"), now))
+	assert.Nil(dst.WriteFile("/dst/2016_133/2016-05-12T17-03-02+00-00.gz", []byte("not gzip"), now))
+	assert.Nil(dst.Remove("/dst/2016_133/2016-05-12T17-06-02+00-00.gz"))
+	res, err = tr.VerifyMirror(context.Background(), files)
+	assert.Nil(err)
+	assert.Equal(AuditResult{Matched: 2, Mismatched: 2, Missing: 2}, res)
+
+	// Only matched files
+	res, err = tr.VerifyMirror(context.Background(), []string{evts[0], evts[3]})
+	assert.Nil(err)
+	assert.True(res.OK())
+	assert.Equal(2, res.Matched)
+	_, err = dst.Stat("/dst/2016_134")
+	assert.True(os.IsNotExist(err), "nothing written to destination")
+}
+
+func TestMemfsCheckManifest(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	now := time.Now()
+	evts := []string{
+		"2016_133/2016-05-12T17-00-02+00-00",
+		"2016_133/2016-05-12T17-03-02+00-00",
+		"2016_133/2016-05-12T17-06-02+00-00",
+		"2016_133/2016-05-12T17-09-02+00-00",
+	}
+	for _, path := range evts {
+		assert.Nil(src.WriteFile("/src/"+path, []byte(path), now))
+	}
+	sfl := "2016_133/2016-05-12T17-00-02+00-00.sfl"
+	assert.Nil(src.WriteFile("/src/"+sfl, []byte("sfl"), now))
+	assert.Nil(tr.CopySFLFiles())
+	assert.Nil(tr.CopyEVTFiles())
+
+	var manifest bytes.Buffer
+	fmt.Fprintf(&manifest, "# instrument manifest\n\n")
+	fmt.Fprintf(&manifest, "%x  %v\n", sha256.Sum256([]byte("sfl")), sfl)
+	fmt.Fprintf(&manifest, "%x *%v\n", md5.Sum([]byte(evts[0])), evts[0])
+	fmt.Fprintf(&manifest, "%x  %v\n", sha256.Sum256([]byte("changed")), evts[1])
+	fmt.Fprintf(&manifest, "%x  %v\n", sha256.Sum256([]byte(evts[3])), evts[3])
+	res, err := tr.CheckManifest(context.Background(), &manifest)
+	assert.Nil(err)
+	assert.Equal(ManifestResult{Matched: 2, Mismatched: 1, Missing: 1, Extra: 1}, res, "latest EVT file wasn't copied, evts[2] isn't listed")
+	assert.False(res.OK())
+
+	manifest.Reset()
+	fmt.Fprintf(&manifest, "%x  %v\n", sha1.Sum([]byte("sfl")), sfl)
+	res, err = tr.CheckManifest(context.Background(), &manifest)
+	assert.Nil(err)
+	assert.Equal(ManifestResult{Matched: 1, Extra: 3}, res)
+
+	for _, bad := range []string{"abc  " + sfl, "zz  " + sfl, fmt.Sprintf("%x", md5.Sum(nil))} {
+		_, err = tr.CheckManifest(context.Background(), strings.NewReader(bad))
+		assert.NotNil(err, bad)
+	}
+	_, err = dst.Stat("/dst/2016_133/2016-05-12T17-09-02+00-00.gz")
+	assert.True(os.IsNotExist(err), "nothing written to destination")
+
+	// Files outside the destination are never read
+	dst.FailOn("Open", "", errors.New("file read"))
+	for _, path := range []string{"../src/" + sfl, "/src/" + sfl, "2016_133/../../src/" + sfl} {
+		manifest.Reset()
+		fmt.Fprintf(&manifest, "%x  %v\n", sha256.Sum256([]byte("sfl")), sfl)
+		fmt.Fprintf(&manifest, "%x  %v\n", sha256.Sum256([]byte("sfl")), path)
+		_, err = tr.CheckManifest(context.Background(), &manifest)
+		assert.True(errors.Is(err, ErrUnsafePath), path)
+	}
+}
+
+func TestTempNameConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	tr, _, _ := newMemTransfer()
+	const workers, perWorker = 16, 1000
+	names := make([][]string, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				names[i] = append(names[i], tr.tempName("2016-05-12T17-00-02+00-00.gz"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	prefix := DefaultTempPrefix + strconv.Itoa(os.Getpid()) + "-"
+	for _, worker := range names {
+		for _, name := range worker {
+			assert.False(seen[name], "duplicate temp name %v", name)
+			seen[name] = true
+			assert.True(strings.HasPrefix(name, prefix), "temp name %v includes process ID", name)
+		}
+	}
+	assert.Equal(workers*perWorker, len(seen))
+}
+
+func TestMemfsAppendSFL(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, dst := newMemTransfer()
+	tr.AppendSFL = true
+	tr.Verify = true
+	path := "/src/2016_133/2016-05-12T17-00-02+00-00.sfl"
+	outpath := "/dst/2016_133/2016-05-12T17-00-02+00-00.sfl"
+	assert.Nil(src.WriteFile(path, []byte("header\nline1\nline2\n"), time.Now()))
+	assert.Nil(dst.WriteFile(outpath, []byte("header\nline1\n"), time.Now()))
+
+	dst.FailOn("Write", outpath, errors.New("disk full"))
+	assert.NotNil(tr.CopySFLFiles())
+	dst.FailOn("Write", outpath, nil)
+	assert.Nil(tr.CopySFLFiles())
+	b, err := dst.ReadFile(outpath)
+	assert.Nil(err)
+	assert.Equal("header\nline1\nline2\n", string(b))
+	assert.Equal(int64(len("line2\n")), tr.Stats.Summary().Files[0].BytesWritten)
+}
+
+func TestMemfsCompressExisting(t *testing.T) {
+	assert := assert.New(t)
+	tr, _, dst := newMemTransfer()
+	mtime := time.Date(2016, 5, 12, 17, 0, 0, 0, time.UTC)
+	a := "/dst/2016_133/2016-05-12T17-00-02+00-00"
+	b := "/dst/2016_133/2016-05-12T17-03-02+00-00"
+	c := "/dst/2016_133/2016-05-12T17-06-02+00-00"
+	assert.Nil(dst.WriteFile(a, []byte("a"), mtime))
+	assert.Nil(dst.WriteFile(b, []byte("b"), mtime))
+	assert.Nil(dst.WriteFile(c, []byte("c"), mtime))
+	assert.Nil(dst.WriteFile(c+".gz", []byte("existing"), mtime))
+
+	tr.DryRun = true
+	assert.Nil(tr.CompressExisting())
+	_, err := dst.Stat(a + ".gz")
+	assert.True(os.IsNotExist(err), "nothing compressed in dry run")
+
+	tr.DryRun = false
+	dst.FailOn("Rename", "", errors.New("rename failed"))
+	assert.NotNil(tr.CompressExisting())
+	_, err = dst.Stat(a)
+	assert.Nil(err, "original kept on failure")
+	matches, _ := dst.Glob("/dst/2016_133/*")
+	assert.Equal([]string{a, b, c, c + ".gz"}, matches, "no temp files left behind")
+
+	dst.FailOn("Rename", "", nil)
+	assert.Nil(tr.CompressExisting())
+	for path, want := range map[string]string{a: "a", b: "b"} {
+		_, err := dst.Stat(path)
+		assert.True(os.IsNotExist(err), "original %v removed", path)
+		data, err := dst.ReadFile(path + ".gz")
+		assert.Nil(err)
+		gzr, err := gzip.NewReader(bytes.NewReader(data))
+		if assert.Nil(err) {
+			assert.Equal(mtime.Unix(), gzr.Header.ModTime.Unix(), "gzip header has original mtime")
+			content, _ := ioutil.ReadAll(gzr)
+			assert.Equal(want, string(content))
+		}
+		info, _ := dst.Stat(path + ".gz")
+		assert.Equal(mtime.Unix(), info.ModTime().Unix(), "gzip file has original mtime")
+	}
+	data, _ := dst.ReadFile(c + ".gz")
+	assert.Equal("existing", string(data), "existing gzip file untouched")
+	_, err = dst.Stat(c)
+	assert.Nil(err, "file with existing gzip copy not removed")
+}
@@ -0,0 +1,60 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// metricKinds are the file kind labels used by WriteMetrics, in output order
+var metricKinds = []Kind{KindSFL, KindEVT, KindOPP, KindVCT, KindUnknown}
+
+// WriteMetrics writes transfer statistics in s for a run which took elapsed to
+// w in the Prometheus text exposition format, e.g. for the node_exporter
+// textfile collector. Copied files and bytes are labeled by file kind.
+func WriteMetrics(w io.Writer, s Summary, elapsed time.Duration) error {
+	files := make(map[Kind]int)
+	bytesRead := make(map[Kind]int64)
+	bytesWritten := make(map[Kind]int64)
+	var newest time.Time
+	for _, f := range s.Files {
+		kind, _ := FileKind(f.Src)
+		files[kind]++
+		bytesRead[kind] += f.Size
+		bytesWritten[kind] += f.BytesWritten
+		if ts, err := timeFromFilename(f.Src); err == nil && ts.After(newest) {
+			newest = ts
+		}
+	}
+
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP seaflow_transfer_%v %v\n", name, help)
+		fmt.Fprintf(&b, "# TYPE seaflow_transfer_%v %v\n", name, typ)
+	}
+	byKind := func(name string, value func(Kind) int64) {
+		for _, kind := range metricKinds {
+			fmt.Fprintf(&b, "seaflow_transfer_%v{kind=%q} %v\n", name, strings.ToLower(kind.String()), value(kind))
+		}
+	}
+	metric("files_copied_total", "counter", "Files copied by the last run.")
+	byKind("files_copied_total", func(k Kind) int64 { return int64(files[k]) })
+	metric("bytes_read_total", "counter", "Source bytes copied by the last run.")
+	byKind("bytes_read_total", func(k Kind) int64 { return bytesRead[k] })
+	metric("bytes_written_total", "counter", "Destination bytes written by the last run.")
+	byKind("bytes_written_total", func(k Kind) int64 { return bytesWritten[k] })
+	metric("files_skipped_total", "counter", "Files skipped by the last run.")
+	fmt.Fprintf(&b, "seaflow_transfer_files_skipped_total %v\n", s.Skipped)
+	metric("files_failed_total", "counter", "Files which failed to copy in the last run.")
+	fmt.Fprintf(&b, "seaflow_transfer_files_failed_total %v\n", s.Failed)
+	if !newest.IsZero() {
+		metric("newest_file_timestamp_seconds", "gauge", "Filename timestamp of the newest file copied by the last run.")
+		fmt.Fprintf(&b, "seaflow_transfer_newest_file_timestamp_seconds %v\n", newest.Unix())
+	}
+	metric("run_duration_seconds", "gauge", "Duration of the last run.")
+	fmt.Fprintf(&b, "seaflow_transfer_run_duration_seconds %v\n", elapsed.Seconds())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
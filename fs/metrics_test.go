@@ -0,0 +1,43 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	assert := assert.New(t)
+	s := Summary{
+		Skipped: 2,
+		Failed:  1,
+		Files: []FileRecord{
+			{Src: "/src/2016_133/2016-05-12T17-00-02+00-00", Size: 100, BytesWritten: 10},
+			{Src: "/src/2016_133/2016-05-12T17-00-05+00-00", Size: 200, BytesWritten: 20},
+			{Src: "/src/2016_133/2016-05-12T17-00-00+00-00.sfl", Size: 5, BytesWritten: 5},
+			{Src: "/src/2016_133/instrument.json", Size: 1, BytesWritten: 1},
+		},
+	}
+	var b bytes.Buffer
+	assert.Nil(WriteMetrics(&b, s, 1500*time.Millisecond))
+	out := b.String()
+	assert.Contains(out, "# TYPE seaflow_transfer_files_copied_total counter\n")
+	assert.Contains(out, `seaflow_transfer_files_copied_total{kind="evt"} 2`+"\n")
+	assert.Contains(out, `seaflow_transfer_files_copied_total{kind="sfl"} 1`+"\n")
+	assert.Contains(out, `seaflow_transfer_files_copied_total{kind="opp"} 0`+"\n")
+	assert.Contains(out, `seaflow_transfer_files_copied_total{kind="unknown"} 1`+"\n")
+	assert.Contains(out, `seaflow_transfer_bytes_read_total{kind="evt"} 300`+"\n")
+	assert.Contains(out, `seaflow_transfer_bytes_written_total{kind="evt"} 30`+"\n")
+	assert.Contains(out, "seaflow_transfer_files_skipped_total 2\n")
+	assert.Contains(out, "seaflow_transfer_files_failed_total 1\n")
+	ts := time.Date(2016, 5, 12, 17, 0, 5, 0, time.UTC).Unix()
+	assert.Contains(out, "seaflow_transfer_newest_file_timestamp_seconds "+fmt.Sprint(ts)+"\n")
+	assert.Contains(out, "seaflow_transfer_run_duration_seconds 1.5\n")
+
+	b.Reset()
+	assert.Nil(WriteMetrics(&b, Summary{}, time.Second))
+	assert.NotContains(b.String(), "newest_file_timestamp", "no newest file without copies")
+}
@@ -0,0 +1,69 @@
+package fs
+
+import "time"
+
+// TransferOptions configures a Transfer created by NewTransfer
+type TransferOptions struct {
+	Srcroot  string
+	Srcroots []string // additional source roots, see Transfer.Srcroots
+	Dstroot  string
+	// Src and Dst, if set, configure SFTP connections for the source and
+	// destination. Otherwise the local filesystem is used.
+	Src *SftpConfig
+	Dst *SftpConfig
+	// Srcfs and Dstfs, if set, are used as-is instead of Src and Dst, e.g.
+	// for a custom Fs backend
+	Srcfs Fs
+	Dstfs Fs
+	// Log receives log messages, which are discarded if nil
+	Log      Logger
+	Earliest time.Time // earliest file time to transfer
+	Latest   time.Time // transfer files before this time
+}
+
+// NewTransfer creates a Transfer configured by opts, connecting to any SFTP
+// servers. Other Transfer fields can be set before use, and Close should be
+// called when done. If connecting to the destination fails the source
+// connection is closed.
+func NewTransfer(opts TransferOptions) (*Transfer, error) {
+	t := &Transfer{
+		Srcroot:  opts.Srcroot,
+		Srcroots: opts.Srcroots,
+		Dstroot:  opts.Dstroot,
+		Srcfs:    opts.Srcfs,
+		Dstfs:    opts.Dstfs,
+		Log:      opts.Log,
+		Earliest: opts.Earliest,
+		Latest:   opts.Latest,
+	}
+	var err error
+	if t.Srcfs == nil {
+		t.Srcfs, err = newFs(opts.Src, opts.Log)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.Dstfs == nil {
+		t.Dstfs, err = newFs(opts.Dst, opts.Log)
+		if err != nil {
+			if opts.Srcfs == nil {
+				_ = t.Srcfs.Close()
+			}
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// newFs returns an Sftpfs for cfg, logging to log unless cfg.Log is set, or a
+// Localfs if cfg is nil
+func newFs(cfg *SftpConfig, log Logger) (Fs, error) {
+	if cfg == nil {
+		return NewLocalfs()
+	}
+	c := *cfg
+	if c.Log == nil {
+		c.Log = log
+	}
+	return NewSftpfs(c)
+}
@@ -0,0 +1,34 @@
+package fs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransfer(t *testing.T) {
+	assert := assert.New(t)
+	earliest := time.Date(2016, 5, 12, 0, 0, 0, 0, time.UTC)
+	tr, err := NewTransfer(TransferOptions{Srcroot: "/src", Dstroot: "/dst", Earliest: earliest})
+	assert.Nil(err)
+	assert.IsType(Localfs{}, tr.Srcfs, "local source by default")
+	assert.IsType(Localfs{}, tr.Dstfs, "local destination by default")
+	assert.Equal("/src", tr.Srcroot)
+	assert.Equal("/dst", tr.Dstroot)
+	assert.Equal(earliest, tr.Earliest)
+	assert.Nil(tr.Close())
+
+	src, _ := NewMemfs()
+	dst, _ := NewMemfs()
+	assert.Nil(src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00.sfl", []byte("sfl"), time.Now()))
+	tr, err = NewTransfer(TransferOptions{Srcroot: "/src", Dstroot: "/dst", Srcfs: src, Dstfs: dst})
+	assert.Nil(err)
+	assert.Nil(tr.CopySFLFiles(), "Transfer is usable with default logging")
+	b, err := dst.ReadFile("/dst/2016_133/2016-05-12T17-00-02+00-00.sfl")
+	assert.Nil(err)
+	assert.Equal("sfl", string(b))
+
+	_, err = NewTransfer(TransferOptions{Srcfs: src, Dst: &SftpConfig{Addr: "127.0.0.1:0", Timeout: time.Second}})
+	assert.NotNil(err, "destination connection failure")
+}
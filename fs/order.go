@@ -0,0 +1,81 @@
+package fs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Order is the order in which copy passes copy files
+type Order int
+
+// Copy orders. Files are ordered by the time in their names, with files whose
+// names have no time ordered by name after those which do, or before them for
+// OrderNewestFirst.
+const (
+	OrderOldestFirst Order = iota
+	OrderNewestFirst
+)
+
+func (o Order) String() string {
+	if o == OrderNewestFirst {
+		return "newest-first"
+	}
+	return "oldest-first"
+}
+
+// ParseOrder returns the Order named s, as returned by Order.String
+func ParseOrder(s string) (Order, error) {
+	for _, o := range []Order{OrderOldestFirst, OrderNewestFirst} {
+		if s == o.String() {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown order %q, must be oldest-first or newest-first", s)
+}
+
+// orderDirs returns source directories, sorted by sourceDirs, in the order
+// they should be copied
+func (t *Transfer) orderDirs(dirs []string) []string {
+	if t.Order != OrderNewestFirst {
+		return dirs
+	}
+	reversed := make([]string, len(dirs))
+	for i, dir := range dirs {
+		reversed[len(dirs)-1-i] = dir
+	}
+	return reversed
+}
+
+// orderFiles sorts source files in place in the order they should be copied.
+// Times in names are read by timeFromFilename, so as UTC.
+func (t *Transfer) orderFiles(files []string) {
+	type fileTime struct {
+		path  string
+		time  time.Time
+		timed bool
+	}
+	keys := make([]fileTime, len(files))
+	for i, path := range files {
+		ts, err := timeFromFilename(path)
+		keys[i] = fileTime{path, ts, err == nil}
+	}
+	before := func(a, b fileTime) bool {
+		if a.timed != b.timed {
+			return a.timed
+		}
+		if a.timed && !a.time.Equal(b.time) {
+			return a.time.Before(b.time)
+		}
+		return a.path < b.path
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if t.Order == OrderNewestFirst {
+			return before(keys[j], keys[i])
+		}
+		return before(keys[i], keys[j])
+	})
+	for i, k := range keys {
+		files[i] = k.path
+	}
+}
@@ -0,0 +1,52 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// DryRunPlan lists the destination files a DryRun would write, split by
+// whether they already exist
+type DryRunPlan struct {
+	Create    []string // destination paths which don't exist yet
+	Overwrite []string // destination paths which exist and would be replaced
+}
+
+// Plan returns the destination files planned so far by DryRun copies in all
+// passes, sorted
+func (t *Transfer) Plan() DryRunPlan {
+	t.planMu.Lock()
+	defer t.planMu.Unlock()
+	plan := DryRunPlan{
+		Create:    append([]string(nil), t.plan.Create...),
+		Overwrite: append([]string(nil), t.plan.Overwrite...),
+	}
+	sort.Strings(plan.Create)
+	sort.Strings(plan.Overwrite)
+	return plan
+}
+
+// planCopy records a DryRun copy of size bytes from source path to outpath,
+// checking whether outpath already exists
+func (t *Transfer) planCopy(path, outpath string, size int64) error {
+	action := "create"
+	if _, err := t.Dstfs.Stat(outpath); err == nil {
+		action = "overwrite"
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return transferError(StageCreate, path, outpath, fmt.Errorf("could not stat output file %v: %w", outpath, err))
+	}
+	t.logger().Info("would copy", "path", path, "dst", outpath, "bytes", size, "action", action)
+	t.planMu.Lock()
+	defer t.planMu.Unlock()
+	t.plannedFiles++
+	t.plannedBytes += size
+	if action == "overwrite" {
+		t.plannedOverwrites++
+		t.plan.Overwrite = append(t.plan.Overwrite, outpath)
+	} else {
+		t.plan.Create = append(t.plan.Create, outpath)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package fs
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+)
+
+// copyPool copies files with up to Transfer.Workers concurrent copies. The
+// first error which should stop the pass, see Transfer.fileFailed, cancels
+// remaining copies. Files beyond Transfer.MaxFiles are counted but not copied.
+// With Transfer.Flatten, files with the same destination name as an earlier
+// file are skipped. Files added more than once, e.g. through overlapping
+// source roots, are only copied once.
+type copyPool struct {
+	t         *Transfer
+	parent    context.Context
+	ctx       context.Context
+	cancel    context.CancelFunc
+	gzipFlag  bool
+	paths     chan string
+	wg        sync.WaitGroup
+	queued    int               // files added for copying
+	remaining int               // files not added because of MaxFiles
+	flat      map[string]string // source paths by destination name with Flatten
+	added     map[string]bool   // source paths added, see srcKey
+	dups      int               // files added more than once
+
+	mu     sync.Mutex
+	failed int
+	err    error
+}
+
+func (t *Transfer) newCopyPool(ctx context.Context, gzipFlag bool) *copyPool {
+	t.cacheDirs(true)
+	workers := t.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	p := &copyPool{t: t, parent: ctx, gzipFlag: gzipFlag, paths: make(chan string), flat: make(map[string]string), added: make(map[string]bool)}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *copyPool) work() {
+	defer p.wg.Done()
+	for path := range p.paths {
+		// Files are only selected once a worker takes them, so an add
+		// cancelled while waiting for a worker isn't left in Remaining
+		p.t.Stats.addSelected()
+		if p.ctx.Err() != nil {
+			p.t.Stats.addFinished()
+			continue // drain
+		}
+		err := p.t.CopyFileContext(p.ctx, path, p.gzipFlag)
+		p.t.Stats.addFinished()
+		if err == nil {
+			continue
+		}
+		p.mu.Lock()
+		if p.err == nil {
+			// Copies cancelled because another failed aren't failures
+			if err = p.t.fileFailed(path, err); err != nil {
+				p.err = err
+				p.cancel()
+			} else {
+				p.failed++
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// add queues path to be copied, blocking until a worker is free. It returns
+// false if the pool has stopped and no more files should be added.
+func (p *copyPool) add(path string) bool {
+	key := p.t.srcKey(path)
+	if p.added[key] {
+		p.dups++
+		return true
+	}
+	p.added[key] = true
+	if p.t.Flatten {
+		// Files from different directories may have the same destination
+		name := canonicalName(path)
+		if prev, ok := p.flat[name]; ok {
+			p.t.logger().Error("warning: skipping file with same name as another when flattened", "path", path, "other", prev)
+			p.t.Stats.addSkipped(1)
+			return true
+		}
+		p.flat[name] = path
+	}
+	if p.t.MaxFiles > 0 && p.queued >= p.t.MaxFiles {
+		p.remaining++
+		return true
+	}
+	p.queued++
+	select {
+	case p.paths <- path:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// logCounts logs the number of duplicate source files collapsed, and the
+// number of files left for a later run if MaxFiles was reached
+func (p *copyPool) logCounts(kind string) {
+	if p.dups > 0 {
+		p.t.logger().Debug("collapsed duplicate source files", "kind", kind, "count", p.dups)
+	}
+	if p.remaining > 0 {
+		p.t.logger().Info("reached max files, leaving remaining files for next run", "kind", kind, "selected", p.queued, "remaining", p.remaining, "maxFiles", p.t.MaxFiles)
+	}
+}
+
+// srcKey returns the cleaned path of source file path, absolute for local
+// sources so a file matched through relative and absolute source roots has
+// one key
+func (t *Transfer) srcKey(path string) string {
+	if _, ok := t.Srcfs.(Localfs); ok {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+	}
+	return filepath.Clean(path)
+}
+
+// wait waits for queued copies to finish and returns the number of files which
+// failed with KeepGoing set, and any error which stopped the pool. It must be
+// called once for every pool.
+func (p *copyPool) wait() (int, error) {
+	close(p.paths)
+	p.wg.Wait()
+	p.cancel()
+	// Directories may be removed by anything else before the next pass
+	p.t.cacheDirs(false)
+	if p.err != nil {
+		return p.failed, p.err
+	}
+	return p.failed, p.parent.Err()
+}
+
+// acquire takes a slot in semaphore sem, waiting until one is free or ctx is
+// done. A nil sem is unlimited. The returned function releases the slot.
+func acquire(ctx context.Context, sem chan struct{}) (func(), error) {
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func() { <-sem }, nil
+}
+
+// semaphores returns semaphores limiting concurrent source reads and
+// destination writes to SrcWorkers and DstWorkers, nil if unlimited
+func (t *Transfer) semaphores() (src chan struct{}, dst chan struct{}) {
+	t.semOnce.Do(func() {
+		if t.SrcWorkers > 0 {
+			t.srcSem = make(chan struct{}, t.SrcWorkers)
+		}
+		if t.DstWorkers > 0 {
+			t.dstSem = make(chan struct{}, t.DstWorkers)
+		}
+	})
+	return t.srcSem, t.dstSem
+}
@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ProbeLevels are the gzip levels compared by default by ProbeCompression,
+// fastest, default, and smallest
+var ProbeLevels = []int{1, 6, 9}
+
+// CompressionProbe is the result of gzipping sample files at one level
+type CompressionProbe struct {
+	Level           int
+	Files           int
+	Bytes           int64 // uncompressed bytes
+	CompressedBytes int64
+	Duration        time.Duration // time spent compressing
+}
+
+// Ratio returns the ratio of compressed to uncompressed size, or 0 if nothing
+// was compressed
+func (p CompressionProbe) Ratio() float64 {
+	if p.Bytes == 0 {
+		return 0
+	}
+	return float64(p.CompressedBytes) / float64(p.Bytes)
+}
+
+// Throughput returns uncompressed bytes compressed per second
+func (p CompressionProbe) Throughput() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Duration.Seconds()
+}
+
+// ProbeCompression gzips the n largest uncompressed source EVT files which
+// CopyEVTFiles would consider at each of levels, in memory with the same
+// encoder as a transfer, and returns the size and time taken at each level to
+// help choose GzipLevel. Files are read once before compressing so source
+// read time isn't counted. The destination is not accessed.
+func (t *Transfer) ProbeCompression(ctx context.Context, n int, levels []int) ([]CompressionProbe, error) {
+	files, err := t.ListEVTFiles()
+	if err != nil {
+		return nil, err
+	}
+	type sample struct {
+		path string
+		size int64
+	}
+	var samples []sample
+	for _, path := range files {
+		if _, compressed := FileKind(filepath.Base(path)); compressed {
+			continue
+		}
+		info, err := t.Srcfs.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not stat %v: %w", path, err)
+		}
+		samples = append(samples, sample{path, info.Size()})
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no uncompressed EVT files to probe")
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].size > samples[j].size })
+	if len(samples) > n {
+		samples = samples[:n]
+	}
+
+	probes := make([]CompressionProbe, len(levels))
+	for i, level := range levels {
+		probes[i].Level = level
+	}
+	for _, s := range samples {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := t.readSrc(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %v: %w", s.path, err)
+		}
+		for i, level := range levels {
+			counter := &countingWriter{w: ioutil.Discard}
+			start := time.Now()
+			gzw, err := t.newGzipWriter(counter, filepath.Base(s.path), time.Now(), int64(len(data)), level)
+			if err != nil {
+				return nil, fmt.Errorf("gzip level %v: %w", level, err)
+			}
+			if _, err := gzw.Write(data); err != nil {
+				return nil, err
+			}
+			if err := gzw.Close(); err != nil {
+				return nil, err
+			}
+			probes[i].Duration += time.Since(start)
+			probes[i].Files++
+			probes[i].Bytes += int64(len(data))
+			probes[i].CompressedBytes += counter.n
+		}
+		t.logger().Debug("probed compression", "path", s.path, "bytes", len(data))
+	}
+	return probes, nil
+}
+
+// readSrc returns the contents of source file path
+func (t *Transfer) readSrc(path string) ([]byte, error) {
+	in, err := t.Srcfs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return ioutil.ReadAll(in)
+}
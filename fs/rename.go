@@ -0,0 +1,113 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrNotAtomic is wrapped by errors for copies which couldn't be completed
+// with an atomic rename when Transfer.RequireAtomic is set
+var ErrNotAtomic = errors.New("atomic rename not possible")
+
+// ErrClobber is wrapped by errors for copies which would have replaced an
+// existing destination file when Transfer.NoClobber is set
+var ErrClobber = errors.New("destination file exists")
+
+// AtomicRenamer is implemented by Fs backends which can report whether Rename
+// atomically replaces an existing file
+type AtomicRenamer interface {
+	AtomicRename() bool
+}
+
+// AtomicRename returns true since rename(2) within a filesystem is atomic.
+// Renames between filesystems fail with EXDEV.
+func (l Localfs) AtomicRename() bool {
+	return true
+}
+
+// ExclusiveRenamer is implemented by Fs backends which can atomically rename a
+// file only if the new name doesn't exist
+type ExclusiveRenamer interface {
+	// RenameNoReplace renames oldname to newname, failing with an error
+	// wrapping os.ErrExist if newname exists
+	RenameNoReplace(oldname, newname string) error
+}
+
+// RenameNoReplace links newname to oldname and removes oldname, since link(2)
+// fails if newname exists. If the filesystem doesn't support hard links, the
+// rename is made after checking newname doesn't exist, which isn't atomic.
+func (l Localfs) RenameNoReplace(oldname, newname string) error {
+	err := os.Link(oldname, newname)
+	if err == nil {
+		return os.Remove(oldname)
+	}
+	if os.IsExist(err) {
+		return err
+	}
+	if _, statErr := os.Lstat(newname); statErr == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	return os.Rename(oldname, newname)
+}
+
+// CheckAtomicRename returns an error wrapping ErrNotAtomic if Dstfs reports
+// that its renames aren't atomic, e.g. an SFTP server without the
+// posix-rename@openssh.com extension
+func (t *Transfer) CheckAtomicRename() error {
+	if renamer, ok := t.Dstfs.(AtomicRenamer); ok && !renamer.AtomicRename() {
+		return fmt.Errorf("destination server doesn't support posix-rename@openssh.com: %w", ErrNotAtomic)
+	}
+	return nil
+}
+
+// crossDevice returns true if err from Rename may mean the old and new paths
+// are on different filesystems. Local renames fail with EXDEV. SFTP servers
+// don't report the cause, OpenSSH returns a generic failure, so any generic
+// failure counts.
+func crossDevice(err error) bool {
+	if errors.Is(err, syscall.EXDEV) {
+		return true
+	}
+	var status *sftp.StatusError
+	return errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxFailure
+}
+
+// renameFallback completes a rename of temp file from to to which failed with
+// err because they're on different filesystems, by copying and removing from.
+// The copy isn't atomic, so with RequireAtomic it fails instead.
+func (t *Transfer) renameFallback(ctx context.Context, from, to string, mtime time.Time, err error) error {
+	if t.RequireAtomic {
+		return fmt.Errorf("%v, not copying instead: %w", err, ErrNotAtomic)
+	}
+	if t.NoClobber {
+		if _, statErr := t.Dstfs.Stat(to); statErr == nil {
+			return fmt.Errorf("not replacing %v: %w", to, ErrClobber)
+		}
+	}
+	t.logger().Error("warning: could not rename temp file, copying instead, final write is not atomic", "path", from, "dst", to, "error", err)
+	return t.copyRemove(ctx, from, to, mtime)
+}
+
+// renameNoClobber renames temp file from to to within Dstfs for NoClobber,
+// failing with an error wrapping ErrClobber if to exists. The check is atomic
+// if Dstfs is an ExclusiveRenamer.
+func (t *Transfer) renameNoClobber(from, to string) error {
+	renamer, ok := t.Dstfs.(ExclusiveRenamer)
+	if !ok {
+		if _, err := t.Dstfs.Stat(to); err == nil {
+			return fmt.Errorf("not replacing %v: %w", to, ErrClobber)
+		}
+		return t.Dstfs.Rename(from, to)
+	}
+	err := renamer.RenameNoReplace(from, to)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("not replacing %v: %w", to, ErrClobber)
+	}
+	return err
+}
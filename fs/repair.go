@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RepairResult counts source files checked by Repair
+type RepairResult struct {
+	Verified int // destination file already had the same contents
+	Repaired int // destination file was missing or mismatched and copied again
+	Failed   int // destination file still missing or mismatched
+}
+
+// OK returns true if every source file has a matching destination file
+func (r RepairResult) OK() bool {
+	return r.Failed == 0
+}
+
+// Repair compares each source file in files, e.g. from ListSFLFiles and
+// ListEVTFiles, to its destination file as VerifyMirror does, and copies
+// again only files which are missing or don't match, e.g. after corruption
+// or partial writes, leaving matching files untouched. SFL files are gzipped
+// in transit if GzipSFL is set and EVT files always are. A copied file is
+// checked again, bypassing ChecksumCache, and a mismatched destination file
+// with a different name than the new copy, e.g. "x" replaced by "x.gz", is
+// removed once the copy matches. Files are repaired one at a time, with
+// retries as for copy passes, and count as failed if the copy fails, is
+// skipped, e.g. with NoClobber or SkipUnchanged, or still doesn't match.
+// Failures are logged. The returned error is only for failures to list
+// destination files, cancellation, or use in DryRun mode or with HeadBytes,
+// which Repair doesn't support.
+func (t *Transfer) Repair(ctx context.Context, files []string) (RepairResult, error) {
+	var res RepairResult
+	if t.DryRun {
+		return res, errors.New("repair can't be used in dry run mode")
+	}
+	if t.HeadBytes > 0 {
+		return res, errors.New("repair can't be used with HeadBytes")
+	}
+	index := dstIndex{t: t}
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		dst, ok, err := index.find(path)
+		if err != nil {
+			return res, err
+		}
+		_, srcCompressed := FileKind(path)
+		srcSum, err := checksum(t.Srcfs, path, srcCompressed)
+		if err != nil {
+			t.logger().Error("could not read source file", "path", path, "error", err)
+			res.Failed++
+			continue
+		}
+		if ok {
+			_, dstCompressed := FileKind(dst)
+			dstSum, err := t.dstChecksum(dst, dstCompressed)
+			if err == nil && bytes.Equal(srcSum, dstSum) {
+				t.logger().Debug("verified", "path", path, "dst", dst)
+				res.Verified++
+				continue
+			}
+			t.logger().Info("repairing mismatched file", "path", path, "dst", dst)
+		} else {
+			t.logger().Info("repairing missing file", "path", path)
+		}
+
+		if err := t.repairFile(ctx, path, dst, srcSum); err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return res, err
+			}
+			t.logger().Error("could not repair", "path", path, "error", err)
+			res.Failed++
+			continue
+		}
+		res.Repaired++
+	}
+	return res, nil
+}
+
+// repairFile copies source file path, whose checksum is srcSum, again and
+// checks the copy. old is the mismatched destination file, if any, which is
+// removed if the copy has a different name.
+func (t *Transfer) repairFile(ctx context.Context, path, old string, srcSum []byte) error {
+	kind, _ := FileKind(path)
+	gzipFlag := kind == KindEVT || t.GzipSFL
+	copyCtx, copied := withResult(ctx)
+	if err := t.CopyFileContext(copyCtx, path, gzipFlag); err != nil {
+		return err
+	}
+	rec, ok := copied.get()
+	if !ok {
+		return errors.New("copy was skipped")
+	}
+	_, dstCompressed := FileKind(rec.Dst)
+	// The cache may hold the old file's checksum if its size and
+	// modification time were unchanged
+	dstSum, err := checksum(t.Dstfs, rec.Dst, dstCompressed)
+	if err != nil {
+		return fmt.Errorf("could not compute checksum for %v: %w", rec.Dst, err)
+	}
+	t.cacheChecksum(rec.Dst, dstSum)
+	if !bytes.Equal(srcSum, dstSum) {
+		return fmt.Errorf("checksum mismatch between %v and %v after copying again", path, rec.Dst)
+	}
+	if old != "" && old != rec.Dst {
+		if err := t.Dstfs.Remove(old); err != nil {
+			t.logger().Error("warning: could not remove mismatched destination file", "dst", old, "error", err)
+		} else {
+			t.updateDestination(old, false)
+		}
+	}
+	t.logger().Info("repaired", "path", path, "dst", rec.Dst)
+	return nil
+}
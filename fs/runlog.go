@@ -0,0 +1,83 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RunLogName is the name of the run log written in Dstroot by WriteRunLog.
+// It's hidden by the leading dot and doesn't match SFL or EVT patterns.
+const RunLogName = ".seaflow-transfer.log"
+
+// WriteRunLog appends a record of the run to RunLogName in Dstroot, as a
+// provenance trail kept with the data. The record is a line with the run's
+// start time, the program version, source, which describes the source, e.g.
+// "host:/data", and counts of copied and failed files, followed by the
+// destination path of each file copied so far relative to Dstroot, indented.
+func (t *Transfer) WriteRunLog(start time.Time, version string, source string) error {
+	s := t.Stats.Summary()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "start=%v version=%v source=%v copied=%v failed=%v\n", start.UTC().Format(time.RFC3339), version, source, s.Copied, s.Failed)
+	for _, f := range s.Files {
+		rel, err := filepath.Rel(t.Dstroot, f.Dst)
+		if err != nil {
+			rel = f.Dst
+		}
+		fmt.Fprintf(&b, "  %v\n", rel)
+	}
+
+	path := filepath.Join(t.Dstroot, RunLogName)
+	if err := t.mkdirAll(t.Dstroot); err != nil {
+		return fmt.Errorf("could not create dir %v: %w", t.Dstroot, err)
+	}
+	if err := t.appendRunLog(path, b.Bytes()); err != nil {
+		return fmt.Errorf("could not write run log %v: %w", path, err)
+	}
+	return nil
+}
+
+// appendRunLog appends p to the file at path in Dstfs, creating it if needed.
+// If Dstfs isn't an Appender the file is rewritten with p added.
+func (t *Transfer) appendRunLog(path string, p []byte) error {
+	_, err := t.Dstfs.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	exists := err == nil
+	var out File
+	if appender, ok := t.Dstfs.(Appender); ok && exists {
+		out, err = appender.Append(path)
+	} else {
+		if exists {
+			old, err := t.readDst(path)
+			if err != nil {
+				return err
+			}
+			p = append(old, p...)
+		}
+		out, err = t.Dstfs.Create(path)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(p)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readDst returns the contents of destination file path
+func (t *Transfer) readDst(path string) ([]byte, error) {
+	in, err := t.Dstfs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return ioutil.ReadAll(in)
+}
@@ -0,0 +1,184 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selfTestPayload is the contents of the file copied by SelfTest
+var selfTestPayload = []byte(strings.Repeat("seaflow-transfer self-test\n", 100))
+
+// SelfTest copies a small EVT file from a temp directory in Srcroot to
+// Dstroot, gzipping it in transit, and checks that it reads back with the
+// same contents and modification time, exercising the create, write, chtimes,
+// and rename steps of a real copy on Srcfs and Dstfs. If a file can't be
+// written to the source, one in a local temp directory is copied instead.
+// Copy options which affect how files are written, e.g. TempDir, Fsync, and
+// RequireAtomic, are used, but nothing is recorded in Stats, Manifest, or
+// PostCopy. All files and directories created are removed. Each step which
+// passes is logged. The returned error describes all failed steps.
+func (t *Transfer) SelfTest(ctx context.Context) error {
+	st := &Transfer{
+		Srcroot:           t.Srcroot,
+		Dstroot:           t.Dstroot,
+		Srcfs:             t.Srcfs,
+		Dstfs:             t.Dstfs,
+		Log:               t.logger(),
+		GzipLevel:         t.GzipLevel,
+		ParallelGzip:      t.ParallelGzip,
+		ParallelGzipSize:  t.ParallelGzipSize,
+		GzipFlushInterval: t.GzipFlushInterval,
+		NoTempFile:        t.NoTempFile,
+		TempDir:           t.TempDir,
+		TempPrefix:        t.TempPrefix,
+		RequireAtomic:     t.RequireAtomic,
+		Fsync:             t.Fsync,
+		DirMode:           t.DirMode,
+		BufferSize:        t.BufferSize,
+		RateLimit:         t.RateLimit,
+		Verify:            true,
+	}
+	var failed []string
+	step := func(name string, err error) bool {
+		if err != nil {
+			failed = append(failed, name+": "+err.Error())
+			return false
+		}
+		st.logger().Info("self-test step ok", "step", name)
+		return true
+	}
+
+	// Whole seconds, since SFTP servers don't keep sub-second times
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	name := mtime.UTC().Format("2006-01-02T15-04-05") + "+00-00"
+	dirName := st.tempName("self-test")
+	srcDir := filepath.Join(st.Srcroot, dirName)
+	path, err := writeSelfTestFile(st.Srcfs, srcDir, name, mtime)
+	if err != nil {
+		st.logger().Error("warning: could not write self-test file to source, using a local file", "path", srcDir, "error", err)
+		localRoot, tempErr := ioutil.TempDir("", "seaflow-transfer-self-test")
+		if tempErr != nil {
+			return fmt.Errorf("self-test failed: could not create local temp directory: %w", tempErr)
+		}
+		defer os.RemoveAll(localRoot)
+		st.Srcroot, st.Srcfs = localRoot, Localfs{}
+		srcDir = filepath.Join(localRoot, dirName)
+		path, err = writeSelfTestFile(st.Srcfs, srcDir, name, mtime)
+		if err != nil {
+			return fmt.Errorf("self-test failed: could not write local file: %w", err)
+		}
+	} else {
+		step("source write", nil)
+		defer func() {
+			err := st.Srcfs.Remove(path)
+			if err == nil {
+				err = st.Srcfs.Remove(srcDir)
+			}
+			step("source cleanup", err)
+		}()
+	}
+
+	dstDir := st.dstDir(srcDir, KindEVT)
+	dst := filepath.Join(dstDir, name+".gz")
+	defer func() {
+		// Either may not exist if the copy failed
+		err := st.Dstfs.Remove(dst)
+		if err == nil || os.IsNotExist(err) {
+			err = st.Dstfs.Remove(dstDir)
+		}
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		step("destination cleanup", err)
+	}()
+
+	if step("copy", st.copyFile(ctx, path, true)) {
+		step("read back", checkSelfTestFile(st.Dstfs, dst))
+		step("modification time", checkSelfTestMtime(st.Dstfs, dst, mtime))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("self-test failed: %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// writeSelfTestFile writes the self-test payload to file name in new
+// directory dir in fsys, with modification time mtime, and returns its path.
+// Anything written is removed on failure.
+func writeSelfTestFile(fsys Fs, dir, name string, mtime time.Time) (string, error) {
+	if err := fsys.MkdirAll(dir); err != nil {
+		return "", fmt.Errorf("could not create directory %v: %w", dir, err)
+	}
+	path := filepath.Join(dir, name)
+	cleanup := func() {
+		_ = fsys.Remove(path)
+		_ = fsys.Remove(dir)
+	}
+	f, err := fsys.Create(path)
+	if err != nil {
+		cleanup()
+		return "", fmt.Errorf("could not create file %v: %w", path, err)
+	}
+	_, err = f.Write(selfTestPayload)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Chtimes(path, mtime, mtime)
+	}
+	if err != nil {
+		cleanup()
+		return "", fmt.Errorf("could not write file %v: %w", path, err)
+	}
+	return path, nil
+}
+
+// checkSelfTestFile returns an error if gzip file path in fsys doesn't
+// decompress to the self-test payload
+func checkSelfTestFile(fsys Fs, path string) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("could not decompress %v: %w", path, err)
+	}
+	b, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		return fmt.Errorf("could not decompress %v: %w", path, err)
+	}
+	if !bytes.Equal(b, selfTestPayload) {
+		return fmt.Errorf("contents of %v differ from the source", path)
+	}
+	return nil
+}
+
+// checkSelfTestMtime returns an error if the modification time of gzip file
+// path in fsys, or the time in its gzip header, isn't mtime
+func checkSelfTestMtime(fsys Fs, path string, mtime time.Time) error {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().Equal(mtime) {
+		return fmt.Errorf("modification time of %v is %v, want %v, destination may ignore chtimes", path, info.ModTime(), mtime)
+	}
+	gzTime, err := gzipHeaderTime(fsys, path)
+	if err != nil {
+		return err
+	}
+	if !gzTime.Equal(mtime) {
+		return fmt.Errorf("gzip header time of %v is %v, want %v", path, gzTime, mtime)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidSFL is wrapped by errors for destination SFL files which fail
+// Transfer.ValidateSFL validation
+var ErrInvalidSFL = errors.New("invalid SFL file")
+
+// sflTailSize is the number of bytes at the end of an SFL file read by
+// validateSFL to find its last line
+const sflTailSize = 4096
+
+// validateSFL returns an error wrapping ErrInvalidSFL if the SFL file at path
+// in fsys doesn't end with a newline or its last line has a different number
+// of tab-separated columns than its header, which usually means it was
+// truncated. Empty files are valid. Only the header and the last sflTailSize
+// bytes are kept, and uncompressed files are read from there if they can
+// seek, so a header or last line longer than that is invalid.
+func validateSFL(fsys Fs, path string, gzipped bool) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gzr, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	br := bufio.NewReaderSize(r, sflTailSize)
+	line, err := br.ReadSlice('\n')
+	header := string(line)
+	if err == io.EOF {
+		if header == "" {
+			return nil
+		}
+		return fmt.Errorf("%v: %w: no newline at end of file", path, ErrInvalidSFL)
+	}
+	if err == bufio.ErrBufferFull {
+		return fmt.Errorf("%v: %w: header is longer than %d bytes", path, ErrInvalidSFL, sflTailSize)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Skip to the end of uncompressed files rather than reading them
+	var rest io.Reader = br
+	skipped := false
+	if s, ok := f.(io.Seeker); ok && !gzipped {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if offset := info.Size() - sflTailSize; offset > int64(len(header)) {
+			if _, err := s.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			rest, skipped = f, true
+		}
+	}
+	tail, dropped, err := readTail(rest, sflTailSize)
+	if err != nil {
+		return err
+	}
+	if len(tail) == 0 {
+		return nil // header only
+	}
+	if tail[len(tail)-1] != '\n' {
+		return fmt.Errorf("%v: %w: no newline at end of file", path, ErrInvalidSFL)
+	}
+	i := bytes.LastIndexByte(tail[:len(tail)-1], '\n')
+	if i < 0 && (skipped || dropped) {
+		return fmt.Errorf("%v: %w: last line is longer than %d bytes", path, ErrInvalidSFL, sflTailSize)
+	}
+	last := string(tail[i+1:])
+	want, got := sflColumns(header), sflColumns(last)
+	if got != want {
+		return fmt.Errorf("%v: %w: last line has %d columns, header has %d", path, ErrInvalidSFL, got, want)
+	}
+	return nil
+}
+
+// readTail reads r to the end and returns its last n bytes. dropped is true if
+// r had more than n bytes.
+func readTail(r io.Reader, n int) (tail []byte, dropped bool, err error) {
+	buf := make([]byte, 32*1024)
+	for {
+		m, err := r.Read(buf)
+		tail = append(tail, buf[:m]...)
+		if len(tail) > n {
+			tail = append(tail[:0], tail[len(tail)-n:]...)
+			dropped = true
+		}
+		if err == io.EOF {
+			return tail, dropped, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// sflColumns returns the number of tab-separated columns in SFL line
+func sflColumns(line string) int {
+	return strings.Count(strings.TrimRight(line, "\r\n"), "\t") + 1
+}
+
+// checkSFL validates destination SFL file outpath, copied from source path,
+// for ValidateSFL. An invalid file is removed. The latest SFL file, which may
+// still be written and end with a partial line, isn't checked.
+func (t *Transfer) checkSFL(path, outpath string, gzipped bool) error {
+	if t.live[path] {
+		t.logger().Debug("not validating SFL file which may still be written", "path", path, "dst", outpath)
+		return nil
+	}
+	if err := validateSFL(t.Dstfs, outpath, gzipped); err != nil {
+		if errors.Is(err, ErrInvalidSFL) {
+			_ = t.Dstfs.Remove(outpath) // bad file is worse than no file
+			t.updateDestination(outpath, false)
+		}
+		return transferError(StageVerify, path, outpath, fmt.Errorf("could not validate %v: %w", outpath, err))
+	}
+	return nil
+}
+
+// sflRecords adds the canonical names of EVT files recorded in the SFL file at
+// path in fsys to records. EVT files are recorded in the FILE column, e.g.
+// 2016_133/2016-05-12T17-00-02+00-00, or in older files only as a timestamp in
+// the DATE column, e.g. 2016-05-12T17:00:02+00:00. Lines which are
+// unterminated or have a different number of columns than the header aren't
+// finished records.
+func sflRecords(fsys Fs, path string, gzipped bool, records map[string]bool) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gzr, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	br := bufio.NewReader(r)
+	var header []string
+	col, isDate := -1, false
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return nil // any partial last line isn't a finished record
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+		if header == nil {
+			header = fields
+			for i, name := range fields {
+				if name == "FILE" {
+					col, isDate = i, false
+					break
+				}
+				if name == "DATE" {
+					col, isDate = i, true
+				}
+			}
+			if col < 0 {
+				return fmt.Errorf("%v: %w: no FILE or DATE column", path, ErrInvalidSFL)
+			}
+			continue
+		}
+		if len(fields) != len(header) {
+			continue
+		}
+		name := fields[col]
+		if isDate {
+			name = strings.Replace(name, ":", "-", -1)
+		}
+		records[canonicalName(filepath.FromSlash(name))] = true
+	}
+}
+
+// recordedEVT returns the canonical names of EVT files recorded in source SFL
+// files in dir, for RequireSFLRecord
+func (t *Transfer) recordedEVT(dir string) (map[string]bool, error) {
+	files, err := t.globDir(dir, t.sflPatterns())
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]bool)
+	for _, path := range files {
+		_, compressed := FileKind(path)
+		if err := sflRecords(t.Srcfs, path, compressed, records); err != nil {
+			return nil, fmt.Errorf("could not read SFL file %v: %w", path, err)
+		}
+	}
+	return records, nil
+}
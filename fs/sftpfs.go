@@ -0,0 +1,670 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Sftpfs provides methods to manipulate files on an SFTP server
+type Sftpfs struct {
+	c *sftpConn
+}
+
+// sftpConn is the connection shared by copies of an Sftpfs. It's replaced
+// when reconnecting.
+type sftpConn struct {
+	cfg    SftpConfig
+	mu     sync.Mutex
+	client *sftp.Client
+	conn   *ssh.Client
+	jump   *ssh.Client   // nil if not using a jump host
+	stop   chan struct{} // closed to stop keepalives
+	closed bool
+	shut   bool // closed by Sftpfs.Close, so not reconnected
+}
+
+// SftpAddr returns a host:port address for SftpConfig.Addr. host may be a
+// hostname, an IPv4 address, or an IPv6 address with or without brackets.
+func SftpAddr(host, port string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// MaxSftpPacketSize is the largest SFTP packet data size all servers should
+// support
+const MaxSftpPacketSize = 32768
+
+// SftpConfig holds options for connecting to an SFTP server
+type SftpConfig struct {
+	Addr     string // host:port
+	User     string
+	Password string
+	// PasswordFile, if set, is a file whose first line is the password, used
+	// instead of Password. It's read on each connection, so a changed
+	// password is used on reconnecting. A warning is logged if all users can
+	// read it.
+	PasswordFile string
+	// PublicKeys are private key files offered to the server before
+	// Password, if set
+	PublicKeys []string
+	KnownHosts string // OpenSSH known_hosts file, host keys are not checked if empty
+	// HostKeyAlgorithms are the host key algorithms accepted from the
+	// server, in order of preference, e.g. "ssh-rsa" for older servers which
+	// only offer RSA host keys. The golang.org/x/crypto/ssh defaults are used
+	// if empty.
+	HostKeyAlgorithms []string
+	// Passphrase is called to get the passphrase for an encrypted private key
+	// file. If nil, encrypted keys can't be used.
+	Passphrase func(keyfile string) ([]byte, error)
+	// Timeout is the dial timeout, 10 seconds if 0
+	Timeout time.Duration
+	// Keepalive is the interval between keepalive requests, disabled if 0
+	Keepalive time.Duration
+	// Concurrency is the maximum number of concurrent SFTP requests for one
+	// file read or write, which can speed up transfers over high latency
+	// links. Only reads and writes larger than PacketSize, e.g. with a large
+	// Transfer.BufferSize, are split into concurrent requests. Concurrent
+	// writes are enabled if > 1. The pkg/sftp default of 64 is used if 0.
+	Concurrency int
+	// PacketSize is the maximum SFTP packet data size, from 1 to
+	// MaxSftpPacketSize bytes. MaxSftpPacketSize is used if 0.
+	PacketSize int
+	// Reconnect re-dials the server and retries an operation once if it
+	// fails because the connection was lost. Files opened before the
+	// connection was lost can't be recovered and fail as usual.
+	Reconnect bool
+	// Jump, if set, is a jump host, or bastion, through which the connection
+	// to Addr is tunneled. Only its Addr, User, Password, PasswordFile,
+	// PublicKeys, KnownHosts, HostKeyAlgorithms, Passphrase, and Timeout are
+	// used.
+	Jump *SftpConfig
+	// Log receives debug messages for each dial, keepalive, and reconnect,
+	// which are discarded if nil. NewTransfer sets it to
+	// TransferOptions.Log if not set.
+	Log Logger
+}
+
+// NewSftpfs creates a new Sftpfs struct
+func NewSftpfs(cfg SftpConfig) (Sftpfs, error) {
+	c := &sftpConn{cfg: cfg}
+	if err := c.dial(); err != nil {
+		return Sftpfs{}, err
+	}
+	return Sftpfs{c: c}, nil
+}
+
+// dial connects to the server and starts keepalives. c.mu must be held or c
+// not yet shared.
+func (c *sftpConn) dial() error {
+	conn, jump, client, err := newSftpClient(c.cfg, c.logger())
+	if err != nil {
+		return err
+	}
+	c.conn, c.jump, c.client, c.stop, c.closed = conn, jump, client, make(chan struct{}), false
+	if !posixRename(client) {
+		c.logger().Debug("SFTP server doesn't support posix-rename@openssh.com, renames can't replace files", "addr", c.cfg.Addr)
+	}
+	c.logger().Debug("SFTP server-side fsync", "addr", c.cfg.Addr, "available", fsyncExtension(client))
+	if c.cfg.Keepalive > 0 {
+		go keepalive(conn, c.cfg.Keepalive, c.stop, c.logger(), c.cfg.Addr)
+	}
+	return nil
+}
+
+// logger returns cfg.Log, or a Logger which discards messages if it's nil
+func (c *sftpConn) logger() Logger {
+	if c.cfg.Log != nil {
+		return c.cfg.Log
+	}
+	return NewStdLogger(nil, nil, nil)
+}
+
+// close closes the current connection if it's not already closed. c.mu must
+// be held.
+func (c *sftpConn) close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.stop)
+	// Close in reverse order of opening, tunneled connection before the jump
+	// host connection
+	err := c.client.Close()
+	if connErr := c.conn.Close(); err == nil {
+		err = connErr
+	}
+	if c.jump != nil {
+		if jumpErr := c.jump.Close(); err == nil {
+			err = jumpErr
+		}
+	}
+	return err
+}
+
+// do calls op with the current client. If Reconnect is set and op fails
+// because the connection was lost, op is retried once on a new connection.
+func (s Sftpfs) do(op func(*sftp.Client) error) error {
+	s.c.mu.Lock()
+	client := s.c.client
+	s.c.mu.Unlock()
+	err := op(client)
+	if !s.c.cfg.Reconnect || !connLost(err) {
+		return err
+	}
+	s.c.logger().Debug("SFTP connection lost, reconnecting", "addr", s.c.cfg.Addr, "error", err)
+	client, dialErr := s.c.reconnect(client)
+	if dialErr != nil {
+		return fmt.Errorf("%w, could not reconnect: %v", err, dialErr)
+	}
+	s.c.logger().Debug("retrying SFTP operation after reconnect", "addr", s.c.cfg.Addr)
+	return op(client)
+}
+
+// connLost returns true if err means the connection to the server was lost
+func connLost(err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF)
+}
+
+// reconnect replaces the connection for old with a new one, unless another
+// caller already has, and returns the current client
+func (c *sftpConn) reconnect(old *sftp.Client) (*sftp.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shut {
+		return nil, errors.New("connection was closed")
+	}
+	if c.client != old {
+		c.logger().Debug("SFTP connection already replaced", "addr", c.cfg.Addr)
+		return c.client, nil
+	}
+	_ = c.close() // already broken, don't care about errors
+	if err := c.dial(); err != nil {
+		// Leave a closed client so later operations fail and retry the dial
+		c.logger().Debug("SFTP reconnect failed", "addr", c.cfg.Addr, "error", err)
+		return nil, err
+	}
+	c.logger().Debug("SFTP reconnected", "addr", c.cfg.Addr)
+	return c.client, nil
+}
+
+// Chtimes sets the access and modification times of path. SFTP version 3
+// times are whole seconds, so sub-second precision is lost.
+func (s Sftpfs) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return s.do(func(client *sftp.Client) error {
+		return client.Chtimes(path, atime, mtime)
+	})
+}
+
+// Close closes the connection, which isn't reconnected afterwards. Closing an
+// Sftpfs more than once, or a zero Sftpfs, does nothing.
+func (s Sftpfs) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	s.c.shut = true
+	return s.c.close()
+}
+
+func (s Sftpfs) Create(path string) (f File, err error) {
+	err = s.do(func(client *sftp.Client) error {
+		f, err = client.Create(path)
+		return err
+	})
+	return f, err
+}
+
+// Append opens the existing file at path for writing at its end. The offset is
+// set explicitly since some servers ignore the append flag.
+func (s Sftpfs) Append(path string) (f File, err error) {
+	err = s.do(func(client *sftp.Client) error {
+		sf, err := client.OpenFile(path, os.O_WRONLY|os.O_APPEND)
+		if err != nil {
+			return err
+		}
+		if _, err := sf.Seek(0, io.SeekEnd); err != nil {
+			sf.Close()
+			return err
+		}
+		f = sf
+		return nil
+	})
+	return f, err
+}
+
+// maxSymlinks is the most symlinks EvalSymlinks follows before giving up
+const maxSymlinks = 40
+
+// EvalSymlinks returns p with any symlinks in its last element resolved.
+// Links are read one at a time with ReadLink rather than with the server's
+// realpath, which some servers don't resolve links with.
+func (s Sftpfs) EvalSymlinks(p string) (real string, err error) {
+	err = s.do(func(client *sftp.Client) error {
+		real = p
+		for i := 0; i < maxSymlinks; i++ {
+			info, err := client.Lstat(real)
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+			target, err := client.ReadLink(real)
+			if err != nil {
+				return err
+			}
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(real), target)
+			}
+			real = target
+		}
+		return fmt.Errorf("too many links resolving %v", p)
+	})
+	return real, err
+}
+
+// FreeSpace returns the number of bytes available to unprivileged users on
+// the filesystem containing path. It returns an error wrapping ErrNotSupported
+// if the server doesn't support the statvfs@openssh.com extension.
+func (s Sftpfs) FreeSpace(path string) (free int64, err error) {
+	err = s.do(func(client *sftp.Client) error {
+		if _, ok := client.HasExtension("statvfs@openssh.com"); !ok {
+			return fmt.Errorf("statvfs@openssh.com: %w", ErrNotSupported)
+		}
+		st, err := client.StatVFS(path)
+		if err != nil {
+			return err
+		}
+		free = int64(st.Frsize * st.Bavail)
+		return nil
+	})
+	return free, err
+}
+
+func (s Sftpfs) Glob(pattern string) (matches []string, err error) {
+	err = s.do(func(client *sftp.Client) error {
+		matches, err = client.Glob(pattern)
+		return err
+	})
+	return matches, err
+}
+
+func (s Sftpfs) MkdirAll(path string) error {
+	return s.do(func(client *sftp.Client) error {
+		return client.MkdirAll(path)
+	})
+}
+
+// MkdirAllMode is like MkdirAll but sets the permissions of directories it
+// creates to mode. The SFTP mkdir request's attributes aren't used since
+// servers commonly ignore them or apply a umask.
+func (s Sftpfs) MkdirAllMode(path string, mode os.FileMode) error {
+	return mkdirAllMode(path, mode, s.Stat, s.MkdirAll, func(path string, mode os.FileMode) error {
+		return s.do(func(client *sftp.Client) error {
+			return client.Chmod(path, mode)
+		})
+	})
+}
+
+func (s Sftpfs) Open(path string) (f File, err error) {
+	err = s.do(func(client *sftp.Client) error {
+		f, err = client.Open(path)
+		return err
+	})
+	return f, err
+}
+
+func (s Sftpfs) Remove(path string) error {
+	return s.do(func(client *sftp.Client) error {
+		return client.Remove(path)
+	})
+}
+
+// Rename renames oldname to newname, atomically replacing any existing
+// newname if the server supports posix-rename@openssh.com. Otherwise a plain
+// SFTP rename is used, which fails if newname exists.
+func (s Sftpfs) Rename(oldname, newname string) error {
+	return s.do(func(client *sftp.Client) error {
+		if posixRename(client) {
+			return client.PosixRename(oldname, newname)
+		}
+		return client.Rename(oldname, newname)
+	})
+}
+
+// RenameNoReplace renames oldname to newname with a plain SFTP rename, which
+// servers following the SFTP spec, e.g. OpenSSH, refuse if newname exists.
+// Servers report this as a generic failure, so newname is checked after a
+// failure. Some servers replace newname anyway, so it's also checked before
+// renaming, which isn't atomic.
+func (s Sftpfs) RenameNoReplace(oldname, newname string) error {
+	exists := &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	if _, err := s.Stat(newname); err == nil {
+		return exists
+	}
+	err := s.do(func(client *sftp.Client) error {
+		return client.Rename(oldname, newname)
+	})
+	if err != nil {
+		if _, statErr := s.Stat(newname); statErr == nil {
+			return exists
+		}
+	}
+	return err
+}
+
+// AtomicRename returns true if the server supports posix-rename@openssh.com.
+// Renames between filesystems on the server still fail.
+func (s Sftpfs) AtomicRename() bool {
+	s.c.mu.Lock()
+	client := s.c.client
+	s.c.mu.Unlock()
+	return posixRename(client)
+}
+
+// posixRename returns true if the server advertised posix-rename@openssh.com
+// when client connected
+func posixRename(client *sftp.Client) bool {
+	_, ok := client.HasExtension("posix-rename@openssh.com")
+	return ok
+}
+
+// SyncFiles returns true if the server supports fsync@openssh.com, so File
+// Sync flushes files to stable storage on the server
+func (s Sftpfs) SyncFiles() bool {
+	s.c.mu.Lock()
+	client := s.c.client
+	s.c.mu.Unlock()
+	return fsyncExtension(client)
+}
+
+// fsyncExtension returns true if the server advertised fsync@openssh.com when
+// client connected
+func fsyncExtension(client *sftp.Client) bool {
+	_, ok := client.HasExtension("fsync@openssh.com")
+	return ok
+}
+
+func (s Sftpfs) Stat(path string) (info os.FileInfo, err error) {
+	err = s.do(func(client *sftp.Client) error {
+		info, err = client.Stat(path)
+		return err
+	})
+	return info, err
+}
+
+// newSftpClient connects to the server in cfg, through cfg.Jump if set. jump
+// is nil if there's no jump host. Each dial is logged to log.
+func newSftpClient(cfg SftpConfig, log Logger) (conn *ssh.Client, jump *ssh.Client, client *sftp.Client, err error) {
+	sshConfig, err := newSSHConfig(cfg, log)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if cfg.Jump != nil {
+		jumpConfig, err := newSSHConfig(*cfg.Jump, log)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("jump host: %w", err)
+		}
+		start := time.Now()
+		jump, err = ssh.Dial("tcp", cfg.Jump.Addr, jumpConfig)
+		logDial(log, cfg.Jump.Addr, "", start, err)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not connect to jump host %v: %w", cfg.Jump.Addr, handshakeError(err))
+		}
+		start = time.Now()
+		conn, err = dialThrough(jump, cfg.Addr, sshConfig)
+		logDial(log, cfg.Addr, cfg.Jump.Addr, start, err)
+		if err != nil {
+			jump.Close()
+			return nil, nil, nil, fmt.Errorf("could not connect to %v through jump host %v: %w", cfg.Addr, cfg.Jump.Addr, handshakeError(err))
+		}
+	} else {
+		start := time.Now()
+		conn, err = ssh.Dial("tcp", cfg.Addr, sshConfig)
+		logDial(log, cfg.Addr, "", start, err)
+		if err != nil {
+			return nil, nil, nil, handshakeError(err)
+		}
+	}
+	var opts []sftp.ClientOption
+	if cfg.Concurrency > 0 {
+		opts = append(opts,
+			sftp.MaxConcurrentRequestsPerFile(cfg.Concurrency),
+			sftp.UseConcurrentWrites(cfg.Concurrency > 1),
+		)
+	}
+	if cfg.PacketSize != 0 {
+		opts = append(opts, sftp.MaxPacketChecked(cfg.PacketSize))
+	}
+	client, err = sftp.NewClient(conn, opts...)
+	if err != nil {
+		conn.Close()
+		if jump != nil {
+			jump.Close()
+		}
+		return nil, nil, nil, err
+	}
+	return conn, jump, client, nil
+}
+
+// logDial logs an SSH dial to addr, through jump if not empty, which started
+// at start and failed if err is not nil
+func logDial(log Logger, addr string, jump string, start time.Time, err error) {
+	keyvals := []interface{}{"addr", addr, "duration", time.Since(start)}
+	if jump != "" {
+		keyvals = append(keyvals, "jump", jump)
+	}
+	if err != nil {
+		log.Debug("SSH dial failed", append(keyvals, "error", err)...)
+		return
+	}
+	log.Debug("SSH dial succeeded", keyvals...)
+}
+
+// newSSHConfig returns SSH client config for the authentication and host key
+// options in cfg
+func newSSHConfig(cfg SftpConfig, log Logger) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if len(cfg.PublicKeys) > 0 {
+		signers, err := loadSigners(cfg.PublicKeys, cfg.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	password := cfg.Password
+	if cfg.PasswordFile != "" {
+		var err error
+		password, err = readPasswordFile(cfg.PasswordFile, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("must provide SSH password of public key")
+	}
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if cfg.KnownHosts != "" {
+		var err error
+		hostKeyCallback, err = newKnownHostsCallback(cfg.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	// SSH transport compression isn't available, golang.org/x/crypto/ssh only
+	// negotiates "none". GzipSFL compresses SFL files on slow links instead.
+	config := &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}
+	// An empty but non-nil list would disable every algorithm
+	if len(cfg.HostKeyAlgorithms) > 0 {
+		config.HostKeyAlgorithms = cfg.HostKeyAlgorithms
+	}
+	return config, nil
+}
+
+// readPasswordFile returns the first line of password file path, without its
+// line ending, warning on log if all users can read the file
+func readPasswordFile(path string, log Logger) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read password file: %w", err)
+	}
+	if info.Mode().Perm()&0004 != 0 {
+		log.Error("warning: SSH password file is readable by all users", "path", path, "mode", info.Mode().Perm().String())
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read password file: %w", err)
+	}
+	password := string(b)
+	if i := strings.IndexByte(password, '\n'); i >= 0 {
+		password = password[:i]
+	}
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("password file %v is empty", path)
+	}
+	return password, nil
+}
+
+// handshakeError explains SSH handshake errors caused by failed algorithm
+// negotiation, which otherwise look like a generic failure. Some servers
+// close the connection rather than report the mismatch, leaving only an EOF.
+func handshakeError(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "no common algorithm for host key"):
+		return fmt.Errorf("no mutual host key algorithm, the server may need different host key algorithms: %w", err)
+	case strings.Contains(msg, "handshake failed: EOF"):
+		return fmt.Errorf("server closed the connection during the SSH handshake, possibly no mutual host key or key exchange algorithm: %w", err)
+	}
+	return err
+}
+
+// dialThrough opens an SSH connection to addr tunneled through jump
+func dialThrough(jump *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	netConn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// keepalive sends an OpenSSH keepalive request over conn to addr every
+// interval until stop is closed or a request fails. Each request is logged to
+// log.
+func keepalive(conn *ssh.Client, interval time.Duration, stop <-chan struct{}, log Logger, addr string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			if err != nil {
+				log.Debug("SSH keepalive failed", "addr", addr, "error", err)
+				return
+			}
+			log.Debug("sent SSH keepalive", "addr", addr, "rtt", time.Since(start))
+		case <-stop:
+			return
+		}
+	}
+}
+
+// loadSigners reads and parses private key files
+func loadSigners(keyfiles []string, passphrase func(string) ([]byte, error)) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(keyfiles))
+	for _, keyfile := range keyfiles {
+		key, err := ioutil.ReadFile(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key: %v", err)
+		}
+		signer, err := parsePrivateKey(keyfile, key, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// parsePrivateKey parses PEM encoded private key data read from keyfile. If
+// the key is encrypted, passphrase is called to get the key's passphrase.
+func parsePrivateKey(keyfile string, key []byte, passphrase func(string) ([]byte, error)) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) {
+		if passphrase == nil {
+			return nil, fmt.Errorf("private key %v is passphrase protected", keyfile)
+		}
+		pass, err := passphrase(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get passphrase for private key %v: %v", keyfile, err)
+		}
+		if len(pass) == 0 {
+			return nil, fmt.Errorf("empty passphrase for passphrase protected private key %v", keyfile)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, pass)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key %v with passphrase: %v", keyfile, err)
+		}
+		return signer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key %v: %v", keyfile, err)
+	}
+	return signer, nil
+}
+
+// newKnownHostsCallback creates a host key callback which checks keys against
+// an OpenSSH known_hosts file. Unknown hosts produce an error which includes
+// the server's key fingerprint so it can be verified and added to the file.
+func newKnownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	cb, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known_hosts file: %v", err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf(
+				"host key for %v not found in %v, server presented %v key with fingerprint %v",
+				hostname, path, key.Type(), ssh.FingerprintSHA256(key),
+			)
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,762 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func Test_newKnownHostsCallback(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	knownKey := newPublicKey()
+	unknownKey := newPublicKey()
+	path := filepath.Join(tmpDir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("example.com:22")}, knownKey)
+	err = ioutil.WriteFile(path, []byte(line+"\n"), 0600)
+	if err != nil {
+		panic(err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	cb, err := newKnownHostsCallback(path)
+	assert.Nil(err)
+	assert.Nil(cb("example.com:22", remote, knownKey), "known host key accepted")
+	err = cb("example.com:22", remote, unknownKey)
+	assert.NotNil(err, "changed host key rejected")
+	err = cb("other.example.com:22", remote, unknownKey)
+	if assert.NotNil(err, "unknown host rejected") {
+		assert.True(strings.Contains(err.Error(), ssh.FingerprintSHA256(unknownKey)), "fingerprint reported for unknown host")
+	}
+
+	_, err = newKnownHostsCallback(filepath.Join(tmpDir, "missing"))
+	assert.NotNil(err, "missing known_hosts file is an error")
+}
+
+func TestSftpAddr(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("example.com:22", SftpAddr("example.com", "22"))
+	assert.Equal("192.0.2.1:22", SftpAddr("192.0.2.1", "22"))
+	assert.Equal("[2001:db8::1]:22", SftpAddr("2001:db8::1", "22"))
+	assert.Equal("[2001:db8::1]:22", SftpAddr("[2001:db8::1]", "22"))
+
+	// Address can be dialed on IPv6 loopback
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	for _, host := range []string{"::1", "[::1]"} {
+		conn, err := net.Dial("tcp", SftpAddr(host, port))
+		if assert.Nil(err, "dial %v", host) {
+			conn.Close()
+		}
+	}
+}
+
+func newPublicKey() ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func Test_parsePrivateKey(t *testing.T) {
+	assert := assert.New(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	der := x509.MarshalPKCS1PrivateKey(rsaKey)
+	plain := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der})
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		panic(err)
+	}
+	encrypted := pem.EncodeToMemory(block)
+	passphrase := func(p string) func(string) ([]byte, error) {
+		return func(string) ([]byte, error) { return []byte(p), nil }
+	}
+
+	_, err = parsePrivateKey("plain", plain, nil)
+	assert.Nil(err, "unencrypted key parsed without passphrase")
+	_, err = parsePrivateKey("encrypted", encrypted, passphrase("secret"))
+	assert.Nil(err, "encrypted key parsed with correct passphrase")
+	_, err = parsePrivateKey("encrypted", encrypted, passphrase("wrong"))
+	assert.NotNil(err, "encrypted key not parsed with wrong passphrase")
+	_, err = parsePrivateKey("encrypted", encrypted, passphrase(""))
+	assert.NotNil(err, "encrypted key not parsed with empty passphrase")
+	_, err = parsePrivateKey("encrypted", encrypted, nil)
+	assert.NotNil(err, "encrypted key not parsed without passphrase callback")
+}
+
+func Test_loadSigners(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	var keyfiles []string
+	for _, name := range []string{"id_rsa", "id_rsa2"} {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			panic(err)
+		}
+		der := x509.MarshalPKCS1PrivateKey(rsaKey)
+		path := filepath.Join(tmpDir, name)
+		err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), 0600)
+		if err != nil {
+			panic(err)
+		}
+		keyfiles = append(keyfiles, path)
+	}
+	bad := filepath.Join(tmpDir, "bad")
+	if err := ioutil.WriteFile(bad, []byte("not a key"), 0600); err != nil {
+		panic(err)
+	}
+
+	signers, err := loadSigners(keyfiles, nil)
+	assert.Nil(err)
+	assert.Equal(2, len(signers), "one signer per key file")
+
+	_, err = loadSigners(append(keyfiles, bad), nil)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), bad, "error names bad key file")
+	}
+	_, err = loadSigners([]string{filepath.Join(tmpDir, "missing")}, nil)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "missing", "error names missing key file")
+	}
+}
+
+func TestSftpfsReconnect(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "a")
+	if err := ioutil.WriteFile(path, []byte("a"), 0644); err != nil {
+		panic(err)
+	}
+	server := newTestSftpServer()
+	defer server.Close()
+
+	for _, reconnect := range []bool{false, true} {
+		sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test", Reconnect: reconnect})
+		if !assert.Nil(err) {
+			return
+		}
+		_, err = sftpfs.Stat(path)
+		assert.Nil(err)
+		server.Drop()
+		_, err = sftpfs.Stat(path)
+		if reconnect {
+			assert.Nil(err, "reconnected after connection lost")
+			_, err = sftpfs.Stat(path)
+			assert.Nil(err, "reconnected connection is used")
+		} else {
+			assert.True(connLost(err), "connection lost without Reconnect")
+		}
+		closeErr := sftpfs.Close()
+		if reconnect {
+			assert.Nil(closeErr)
+		}
+	}
+}
+
+func TestSftpfsClose(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(Sftpfs{}.Close(), "zero Sftpfs")
+
+	server := newTestSftpServer()
+	defer server.Close()
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test", Reconnect: true})
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Nil(sftpfs.Close())
+	assert.Nil(sftpfs.Close(), "second close")
+	_, err = sftpfs.Stat(".")
+	assert.NotNil(err, "closed connection not reconnected")
+	assert.Nil(sftpfs.Close(), "close after failed operation")
+}
+
+func TestSftpfsJump(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "a")
+	if err := ioutil.WriteFile(path, []byte("a"), 0644); err != nil {
+		panic(err)
+	}
+	jump := newTestSftpServer()
+	defer jump.Close()
+	server := newTestSftpServer()
+	defer server.Close()
+
+	sftpfs, err := NewSftpfs(SftpConfig{
+		Addr:     server.Addr(),
+		User:     "test",
+		Password: "test",
+		Jump:     &SftpConfig{Addr: jump.Addr(), User: "jump", Password: "jump"},
+	})
+	if !assert.Nil(err) {
+		return
+	}
+	_, err = sftpfs.Stat(path)
+	assert.Nil(err, "file read through jump host")
+	jump.mu.Lock()
+	assert.Equal(1, jump.forwards, "connection tunneled through jump host")
+	jump.mu.Unlock()
+	assert.Nil(sftpfs.Close())
+
+	_, err = NewSftpfs(SftpConfig{
+		Addr:     server.Addr(),
+		User:     "test",
+		Password: "test",
+		Jump:     &SftpConfig{Addr: jump.Addr(), User: "jump"},
+	})
+	if assert.NotNil(err, "jump host without credentials") {
+		assert.Contains(err.Error(), "jump host")
+	}
+}
+
+func TestSftpfsHostKeyAlgorithms(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestSftpServer()
+	defer server.Close()
+
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test", HostKeyAlgorithms: []string{ssh.KeyAlgoED25519}})
+	if assert.Nil(err, "server host key algorithm accepted") {
+		assert.Nil(sftpfs.Close())
+	}
+
+	_, err = NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test", HostKeyAlgorithms: []string{ssh.KeyAlgoRSA}})
+	if assert.NotNil(err, "server host key algorithm not accepted") {
+		assert.Contains(err.Error(), "no mutual host key algorithm")
+	}
+}
+
+func Test_handshakeError(t *testing.T) {
+	assert := assert.New(t)
+	err := errors.New("ssh: handshake failed: EOF")
+	assert.Contains(handshakeError(err).Error(), "server closed the connection")
+	assert.True(errors.Is(handshakeError(err), err), "original error wrapped")
+	err = errors.New("dial tcp: connection refused")
+	assert.Equal(err, handshakeError(err), "other errors unchanged")
+}
+
+func TestSftpfsMkdirAllMode(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	server := newTestSftpServer()
+	defer server.Close()
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test"})
+	if !assert.Nil(err) {
+		return
+	}
+	defer sftpfs.Close()
+
+	assert.Nil(sftpfs.MkdirAllMode(filepath.Join(tmpDir, "a", "b"), 0775))
+	for _, dir := range []string{filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "a", "b")} {
+		info, err := os.Stat(dir)
+		if assert.Nil(err) {
+			assert.Equal(os.FileMode(0775), info.Mode().Perm(), dir)
+		}
+	}
+	info, err := os.Stat(tmpDir)
+	if assert.Nil(err) {
+		assert.Equal(os.FileMode(0700), info.Mode().Perm(), "existing directory unchanged")
+	}
+}
+
+// TestMtimePrecision documents which modification time precision survives
+// Chtimes on each Fs
+func TestMtimePrecision(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	server := newTestSftpServer()
+	defer server.Close()
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test"})
+	if !assert.Nil(err) {
+		return
+	}
+	defer sftpfs.Close()
+	mtime := time.Date(2016, 5, 12, 17, 0, 2, 123456789, time.UTC)
+
+	tests := []struct {
+		name string
+		fsys Fs
+		want time.Time
+	}{
+		{"Localfs", Localfs{}, mtime},                   // nanoseconds kept
+		{"Sftpfs", sftpfs, mtime.Truncate(time.Second)}, // whole seconds
+	}
+	for _, tt := range tests {
+		path := filepath.Join(tmpDir, tt.name)
+		if err := ioutil.WriteFile(path, []byte("a"), 0644); err != nil {
+			panic(err)
+		}
+		assert.Nil(tt.fsys.Chtimes(path, mtime, mtime))
+		info, err := tt.fsys.Stat(path)
+		if assert.Nil(err) {
+			assert.True(tt.want.Equal(info.ModTime()), "%v kept %v of %v", tt.name, info.ModTime(), mtime)
+		}
+	}
+
+	// Copies record the exact source time when the destination can't
+	src := filepath.Join(tmpDir, "src", "2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		panic(err)
+	}
+	makeFile(src, "a")
+	chtimes(src, mtime, mtime)
+	tr := &Transfer{Srcfs: Localfs{}, Srcroot: filepath.Join(tmpDir, "src"), Dstfs: sftpfs, Dstroot: filepath.Join(tmpDir, "dst")}
+	assert.Nil(tr.CopySFLFiles())
+	if files := tr.Stats.Summary().Files; assert.Equal(1, len(files)) {
+		assert.True(mtime.Equal(files[0].ModTime), "exact source mtime recorded")
+		assert.False(files[0].ModTimeExact, "destination truncated mtime")
+	}
+}
+
+func TestSftpfsDebugLog(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	server := newTestSftpServer()
+	defer server.Close()
+
+	var buf lockedBuffer
+	sftpfs, err := NewSftpfs(SftpConfig{
+		Addr:      server.Addr(),
+		User:      "test",
+		Password:  "test",
+		Keepalive: 10 * time.Millisecond,
+		Reconnect: true,
+		Log:       NewTextLogger(&buf, LevelDebug),
+	})
+	if !assert.Nil(err) {
+		return
+	}
+	time.Sleep(50 * time.Millisecond)
+	server.Drop()
+	_, err = sftpfs.Stat(tmpDir)
+	assert.Nil(err, "reconnected after connection lost")
+	assert.Nil(sftpfs.Close())
+
+	out := buf.String()
+	assert.Contains(out, "SSH dial succeeded addr="+server.Addr()+" duration=")
+	assert.Contains(out, "sent SSH keepalive addr="+server.Addr()+" rtt=")
+	assert.Contains(out, "SFTP connection lost, reconnecting addr="+server.Addr())
+	assert.Contains(out, "SFTP reconnected addr="+server.Addr())
+	assert.Equal(2, strings.Count(out, "SSH dial succeeded"), "dial logged for reconnect")
+}
+
+// lockedBuffer is a bytes.Buffer which is safe for concurrent use
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSftpfsAtomicRename(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	server := newTestSftpServer()
+	defer server.Close()
+
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test"})
+	if !assert.Nil(err) {
+		return
+	}
+	defer sftpfs.Close()
+	assert.True(sftpfs.AtomicRename(), "test server supports posix-rename@openssh.com")
+
+	// Rename replaces an existing file
+	a, b := filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b")
+	assert.Nil(ioutil.WriteFile(a, []byte("a"), 0644))
+	assert.Nil(ioutil.WriteFile(b, []byte("b"), 0644))
+	assert.Nil(sftpfs.Rename(a, b))
+	got, err := ioutil.ReadFile(b)
+	assert.Nil(err)
+	assert.Equal("a", string(got))
+
+	// RenameNoReplace doesn't
+	c := filepath.Join(tmpDir, "c")
+	assert.Nil(ioutil.WriteFile(c, []byte("c"), 0644))
+	err = sftpfs.RenameNoReplace(b, c)
+	assert.True(errors.Is(err, os.ErrExist))
+	got, err = ioutil.ReadFile(c)
+	assert.Nil(err)
+	assert.Equal("c", string(got))
+	assert.Nil(sftpfs.RenameNoReplace(b, a))
+	got, err = ioutil.ReadFile(a)
+	assert.Nil(err)
+	assert.Equal("a", string(got))
+
+	tr := &Transfer{Dstfs: sftpfs}
+	assert.Nil(tr.CheckAtomicRename())
+}
+
+func TestSftpfsSyncFiles(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	server := newTestSftpServer()
+	defer server.Close()
+
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test"})
+	if !assert.Nil(err) {
+		return
+	}
+	defer sftpfs.Close()
+	assert.False(sftpfs.SyncFiles(), "test server doesn't support fsync@openssh.com")
+
+	// Fsync copies still succeed without server-side fsync
+	src := filepath.Join(tmpDir, "src", "2016_133", "2016-05-12T17-00-02+00-00.sfl")
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		panic(err)
+	}
+	makeFile(src, "a")
+	tr := &Transfer{Srcfs: Localfs{}, Srcroot: filepath.Join(tmpDir, "src"), Dstfs: sftpfs, Dstroot: filepath.Join(tmpDir, "dst"), Fsync: true}
+	assert.Nil(tr.CopySFLFiles())
+	assert.Equal("a", readFile(filepath.Join(tmpDir, "dst", "2016_133", filepath.Base(src))))
+}
+
+func TestSftpfsPasswordFile(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	server := newTestSftpServer()
+	defer server.Close()
+
+	passwordFile := filepath.Join(tmpDir, "password")
+	assert.Nil(ioutil.WriteFile(passwordFile, []byte("test\n"), 0600))
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", PasswordFile: passwordFile})
+	if assert.Nil(err) {
+		assert.Nil(sftpfs.Close())
+	}
+	_, err = NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test", PasswordFile: filepath.Join(tmpDir, "missing")})
+	assert.NotNil(err, "missing password file fails even with Password")
+}
+
+func Test_readPasswordFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tests := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+		want    string
+		wantErr bool
+		warn    bool
+	}{
+		{"plain", "secret", 0600, "secret", false, false},
+		{"newline", "secret\n", 0600, "secret", false, false},
+		{"crlf", "secret\r\n", 0600, "secret", false, false},
+		{"first line", "secret\nother\n", 0600, "secret", false, false},
+		{"spaces kept", " secret \n", 0600, " secret ", false, false},
+		{"empty", "", 0600, "", true, false},
+		{"empty line", "\nsecret\n", 0600, "", true, false},
+		{"world readable", "secret\n", 0644, "secret", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, strings.ReplaceAll(tt.name, " ", "_"))
+			if err := ioutil.WriteFile(path, []byte(tt.content), tt.mode); err != nil {
+				panic(err)
+			}
+			if err := os.Chmod(path, tt.mode); err != nil {
+				panic(err)
+			}
+			var buf bytes.Buffer
+			got, err := readPasswordFile(path, NewTextLogger(&buf, LevelDebug))
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+			assert.Equal(t, tt.warn, strings.Contains(buf.String(), "readable by all users"))
+		})
+	}
+	_, err = readPasswordFile(filepath.Join(tmpDir, "missing"), NewTextLogger(ioutil.Discard, LevelDebug))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestSftpfsEvalSymlinks(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "2016_133")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		panic(err)
+	}
+	// Absolute and relative links, and a link to a link
+	if err := os.Symlink(dir, filepath.Join(tmpDir, "abs")); err != nil {
+		panic(err)
+	}
+	if err := os.Symlink("2016_133", filepath.Join(tmpDir, "rel")); err != nil {
+		panic(err)
+	}
+	if err := os.Symlink("abs", filepath.Join(tmpDir, "current")); err != nil {
+		panic(err)
+	}
+	server := newTestSftpServer()
+	defer server.Close()
+
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test"})
+	if !assert.Nil(err) {
+		return
+	}
+	defer sftpfs.Close()
+	for _, name := range []string{"2016_133", "abs", "rel", "current"} {
+		real, err := sftpfs.EvalSymlinks(filepath.Join(tmpDir, name))
+		assert.Nil(err)
+		assert.Equal(dir, real, name+" resolved")
+	}
+	_, err = sftpfs.EvalSymlinks(filepath.Join(tmpDir, "missing"))
+	assert.True(errors.Is(err, os.ErrNotExist), "missing file")
+}
+
+func TestSftpfsConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "fs-test-dir")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	server := newTestSftpServer()
+	defer server.Close()
+
+	_, err = NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test", PacketSize: MaxSftpPacketSize + 1})
+	assert.NotNil(err, "packet size too large")
+
+	sftpfs, err := NewSftpfs(SftpConfig{Addr: server.Addr(), User: "test", Password: "test", Concurrency: 4, PacketSize: 1024})
+	if !assert.Nil(err) {
+		return
+	}
+	defer sftpfs.Close()
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	path := filepath.Join(tmpDir, "a")
+	f, err := sftpfs.Create(path)
+	if assert.Nil(err) {
+		_, err = f.Write(data)
+		assert.Nil(err)
+		assert.Nil(f.Close())
+	}
+	f, err = sftpfs.Open(path)
+	if assert.Nil(err) {
+		b := make([]byte, len(data))
+		_, err = io.ReadFull(f, b)
+		assert.Nil(err)
+		assert.Equal(data, b, "data written and read with concurrent requests")
+		f.Close()
+	}
+}
+
+// testSftpServer is an SFTP server for the local filesystem which accepts any
+// password
+type testSftpServer struct {
+	l        net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+	forwards int // direct-tcpip channels opened
+}
+
+func newTestSftpServer() *testSftpServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &testSftpServer{l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testSftpServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() == "direct-tcpip" {
+			go s.forward(newChan)
+			continue
+		}
+		if newChan.ChannelType() != "session" {
+			_ = newChan.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range chReqs {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(ch)
+					if err == nil {
+						_ = server.Serve()
+						server.Close()
+					}
+				}
+			}
+		}()
+	}
+}
+
+// forward serves a direct-tcpip channel, as a jump host does
+func (s *testSftpServer) forward(newChan ssh.NewChannel) {
+	var req struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &req); err != nil {
+		_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(req.Host, fmt.Sprint(req.Port)))
+	if err != nil {
+		_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	s.mu.Lock()
+	s.conns = append(s.conns, target)
+	s.forwards++
+	s.mu.Unlock()
+	go func() {
+		_, _ = io.Copy(ch, target)
+		ch.Close()
+	}()
+	_, _ = io.Copy(target, ch)
+	target.Close()
+}
+
+func (s *testSftpServer) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Drop closes all open connections, as if the network failed
+func (s *testSftpServer) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSftpServer) Close() {
+	s.l.Close()
+	s.Drop()
+}
@@ -0,0 +1,123 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is wrapped by errors for copies cancelled because no source
+// bytes were read for Transfer.StallTimeout
+var ErrStalled = errors.New("copy stalled")
+
+// stallKey is the context key for a copy's stallWatchdog
+type stallKey struct{}
+
+// stallWatchdog cancels a copy attempt if it stops reading source bytes for
+// timeout. It's armed when the copy starts reading, so waiting for a worker
+// or connection doesn't count, and disarmed once the source is read to the
+// end, so closing, renaming, and verifying don't either.
+type stallWatchdog struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	last    int64 // UnixNano of the last progress, 0 until armed
+	state   int32 // watchArmed, watchDone, or watchStalled
+	stop    chan struct{}
+}
+
+const (
+	watchIdle int32 = iota
+	watchArmed
+	watchDone
+	watchStalled
+)
+
+// watchStall returns a context for one copy attempt which is cancelled if the
+// copy stalls for StallTimeout, and the watchdog, which must be stopped.
+func (t *Transfer) watchStall(ctx context.Context) (context.Context, *stallWatchdog) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &stallWatchdog{timeout: t.StallTimeout, cancel: cancel, stop: make(chan struct{})}
+	go w.run()
+	return context.WithValue(ctx, stallKey{}, w), w
+}
+
+func (w *stallWatchdog) run() {
+	interval := w.timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&w.state) != watchArmed {
+				continue
+			}
+			last := time.Unix(0, atomic.LoadInt64(&w.last))
+			if time.Since(last) >= w.timeout && atomic.CompareAndSwapInt32(&w.state, watchArmed, watchStalled) {
+				w.cancel()
+				return
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// progress records that source bytes were read
+func (w *stallWatchdog) progress() {
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+}
+
+// arm starts timing stalls
+func (w *stallWatchdog) arm() {
+	w.progress()
+	atomic.CompareAndSwapInt32(&w.state, watchIdle, watchArmed)
+}
+
+// finish stops timing stalls once the source has been read
+func (w *stallWatchdog) finish() {
+	atomic.CompareAndSwapInt32(&w.state, watchArmed, watchDone)
+}
+
+// stalled returns true if the watchdog cancelled the copy
+func (w *stallWatchdog) stalled() bool {
+	return atomic.LoadInt32(&w.state) == watchStalled
+}
+
+// close stops the watchdog and releases its context
+func (w *stallWatchdog) close() {
+	close(w.stop)
+	w.cancel()
+}
+
+// stallReader reports reads from r to a stallWatchdog
+type stallReader struct {
+	r io.Reader
+	w *stallWatchdog
+}
+
+func (r stallReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.w.progress()
+	}
+	if err == io.EOF {
+		r.w.finish()
+	}
+	return n, err
+}
+
+// watchReader returns r reporting reads to the stallWatchdog of ctx, arming
+// it, or r itself if ctx has none
+func watchReader(ctx context.Context, r io.Reader) io.Reader {
+	w, ok := ctx.Value(stallKey{}).(*stallWatchdog)
+	if !ok {
+		return r
+	}
+	w.arm()
+	return stallReader{r: r, w: w}
+}
@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReadState returns the timestamp recorded in the local state file at path by
+// WriteState. The zero time is returned if path doesn't exist, e.g. on a first
+// run.
+func ReadState(path string) (time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read state file: %w", err)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse state file %v: %w", path, err)
+	}
+	return ts, nil
+}
+
+// WriteState records ts in the local state file at path. The file is written
+// to a temp file and renamed into place so a crash never leaves a partial
+// state file.
+func WriteState(path string, ts time.Time) error {
+	if err := writeTimeFile(path, ts); err != nil {
+		return fmt.Errorf("could not write state file: %w", err)
+	}
+	return nil
+}
+
+// WriteNewest records ts, e.g. from NewestTime, in the local file at path as
+// an RFC3339 timestamp, or an empty line if ts is zero. Like WriteState, the
+// file is replaced atomically.
+func WriteNewest(path string, ts time.Time) error {
+	if err := writeTimeFile(path, ts); err != nil {
+		return fmt.Errorf("could not write newest file timestamp: %w", err)
+	}
+	return nil
+}
+
+// writeTimeFile writes ts as an RFC3339 line, empty if ts is zero, to a temp
+// file and renames it to path
+func writeTimeFile(path string, ts time.Time) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(formatTime(ts) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// formatTime returns ts in UTC as RFC3339 with any fractional seconds, or ""
+// if ts is zero
+func formatTime(ts time.Time) string {
+	if ts.IsZero() {
+		return ""
+	}
+	return ts.UTC().Format(time.RFC3339Nano)
+}
+
+// ResumeTime returns a time to use as Earliest for the next incremental run,
+// the newest filename timestamp of the files copied so far. It's capped at
+// the timestamp of the most recent SFL source file in each source root, which
+// is copied on every run since it may still be appended to. The zero time is
+// returned if no copied file has a timestamp.
+func (t *Transfer) ResumeTime() (time.Time, error) {
+	var copied []string
+	for _, f := range t.Stats.Summary().Files {
+		copied = append(copied, f.Src)
+	}
+	newest := newestTime(copied)
+	if newest.IsZero() {
+		return newest, nil
+	}
+	patterns := t.sflPatterns()
+	dirs, err := t.sourceDirs(patterns)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest, err := t.latestFiles(dirs, patterns, false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for path := range latest {
+		ts, err := timeFromFilename(path)
+		if err == nil && ts.Before(newest) {
+			newest = ts
+		}
+	}
+	return newest, nil
+}
+
+// NewestTime returns the newest filename timestamp of the files copied so
+// far, or if none were copied, of the SFL and EVT files at the destination,
+// i.e. how current the destination is. The zero time is returned if there
+// are no timestamped files.
+func (t *Transfer) NewestTime() (time.Time, error) {
+	var copied []string
+	for _, f := range t.Stats.Summary().Files {
+		copied = append(copied, f.Src)
+	}
+	if newest := newestTime(copied); !newest.IsZero() {
+		return newest, nil
+	}
+	files, err := t.dstFiles()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return newestTime(files), nil
+}
+
+// newestTime returns the newest filename timestamp of paths, or the zero time
+// if none have one
+func newestTime(paths []string) time.Time {
+	var newest time.Time
+	for _, path := range paths {
+		ts, err := timeFromFilename(path)
+		if err == nil && ts.After(newest) {
+			newest = ts
+		}
+	}
+	return newest
+}
@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "fs-test-state")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	ts, err := ReadState(path)
+	assert.Nil(err, "missing state file is not an error")
+	assert.True(ts.IsZero())
+
+	want := time.Date(2016, 5, 12, 17, 0, 2, 0, time.UTC)
+	assert.Nil(WriteState(path, want))
+	ts, err = ReadState(path)
+	assert.Nil(err)
+	assert.True(want.Equal(ts))
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(1, len(files), "no temp files left behind")
+
+	assert.Nil(ioutil.WriteFile(path, []byte("garbage\n"), 0644))
+	_, err = ReadState(path)
+	assert.NotNil(err)
+}
+
+func TestResumeTime(t *testing.T) {
+	assert := assert.New(t)
+	tr, src, _ := newMemTransfer()
+	now := time.Now()
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00", []byte("a"), now)
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-05+00-00", []byte("b"), now)
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-08+00-00", []byte("c"), now) // latest
+
+	ts, err := tr.ResumeTime()
+	assert.Nil(err)
+	assert.True(ts.IsZero(), "zero before any copies")
+
+	assert.Nil(tr.CopyEVTFiles())
+	ts, err = tr.ResumeTime()
+	assert.Nil(err)
+	assert.Equal(time.Date(2016, 5, 12, 17, 0, 5, 0, time.UTC), ts, "newest copied file")
+
+	// Capped so the growing SFL file is still copied next time
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-04+00-00.sfl", []byte("sfl"), now)
+	ts, err = tr.ResumeTime()
+	assert.Nil(err)
+	assert.Equal(time.Date(2016, 5, 12, 17, 0, 4, 0, time.UTC), ts, "capped at latest SFL file")
+}
+
+func TestNewestTime(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "fs-test-newest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "newest")
+
+	tr, src, dst := newMemTransfer()
+	assert.Nil(dst.MkdirAll("/dst"))
+	ts, err := tr.NewestTime()
+	assert.Nil(err)
+	assert.True(ts.IsZero(), "zero with no files")
+	assert.Nil(WriteNewest(path, ts))
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal("\n", string(b), "empty marker")
+
+	now := time.Now()
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-02+00-00", []byte("a"), now)
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-05+00-00", []byte("b"), now)
+	_ = src.WriteFile("/src/2016_133/2016-05-12T17-00-08+00-00", []byte("c"), now) // latest
+	_ = dst.WriteFile("/dst/2016_133/2016-05-12T17-00-01+00-00.sfl", []byte("sfl"), now)
+	assert.Nil(tr.CopyEVTFiles())
+	ts, err = tr.NewestTime()
+	assert.Nil(err)
+	assert.Equal(time.Date(2016, 5, 12, 17, 0, 5, 0, time.UTC), ts, "newest copied file")
+	assert.Nil(WriteNewest(path, ts))
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal("2016-05-12T17:00:05Z\n", string(b))
+
+	// Nothing copied by a later run
+	tr.Stats = Stats{}
+	_ = dst.WriteFile("/dst/2016_133/2016-05-12T17-00-09+00-00.gz", []byte("d"), now)
+	ts, err = tr.NewestTime()
+	assert.Nil(err)
+	assert.Equal(time.Date(2016, 5, 12, 17, 0, 9, 0, time.UTC), ts, "newest destination file")
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(1, len(files), "no temp files left behind")
+}
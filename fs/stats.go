@@ -0,0 +1,194 @@
+package fs
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats accumulates statistics for a transfer. Its methods are safe for
+// concurrent use.
+type Stats struct {
+	mu sync.Mutex
+	s  Summary
+	// Copies in progress by source path, and counts of files selected for
+	// copying and of those whose copy has finished, for monitoring a run
+	active   map[string]FileProgress
+	selected int
+	finished int
+}
+
+// FileProgress is the progress of a copy in progress
+type FileProgress struct {
+	Path        string `json:"path"`
+	BytesCopied int64  `json:"bytesCopied"` // bytes read from the source
+	TotalBytes  int64  `json:"totalBytes"`  // source file size
+}
+
+// Summary is a point-in-time copy of transfer statistics
+type Summary struct {
+	Copied       int   `json:"copied"`
+	Skipped      int   `json:"skipped"`
+	Failed       int   `json:"failed"`
+	Deleted      int   `json:"deleted"`
+	BytesRead    int64 `json:"bytesRead"`
+	BytesWritten int64 `json:"bytesWritten"`
+	// Gzipped counts files gzipped in transit, GzipBytesIn their total
+	// uncompressed size and GzipBytesOut their total compressed size
+	Gzipped      int   `json:"gzipped"`
+	GzipBytesIn  int64 `json:"gzipBytesIn"`
+	GzipBytesOut int64 `json:"gzipBytesOut"`
+	// FailedByStage counts failed files by the Stage of their
+	// TransferError, e.g. "rename"
+	FailedByStage map[string]int `json:"failedByStage,omitempty"`
+	// EventsDropped counts FileResults not sent on Transfer.Events because
+	// the channel was full
+	EventsDropped int          `json:"eventsDropped,omitempty"`
+	Files         []FileRecord `json:"files"`
+}
+
+// FileRecord describes one successfully copied file
+type FileRecord struct {
+	Src          string `json:"src"`
+	Dst          string `json:"dst"`
+	Size         int64  `json:"size"` // source file size
+	BytesWritten int64  `json:"bytesWritten"`
+	Gzipped      bool   `json:"gzipped"`      // gzipped in transit
+	Decompressed bool   `json:"decompressed"` // decompressed in transit
+	// CompressedSize is the size of the gzipped temp file before it was
+	// renamed into place, if Gzipped
+	CompressedSize int64 `json:"compressedSize,omitempty"`
+	// ModTime is the modification time set on the destination file, the
+	// source file's or for decompressed files the gzip header's, with full
+	// precision. ModTimeExact is false if the destination didn't keep it
+	// exactly, e.g. SFTP servers only set whole seconds.
+	ModTime      time.Time `json:"modTime"`
+	ModTimeExact bool      `json:"modTimeExact"`
+}
+
+// GzipRatio returns the ratio of compressed to uncompressed size for files
+// gzipped in transit, or 0 if none were
+func (s Summary) GzipRatio() float64 {
+	if s.GzipBytesIn == 0 {
+		return 0
+	}
+	return float64(s.GzipBytesOut) / float64(s.GzipBytesIn)
+}
+
+// Summary returns a copy of the current statistics
+func (s *Stats) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := s.s
+	sum.Files = make([]FileRecord, len(s.s.Files))
+	copy(sum.Files, s.s.Files)
+	if s.s.FailedByStage != nil {
+		sum.FailedByStage = make(map[string]int, len(s.s.FailedByStage))
+		for stage, n := range s.s.FailedByStage {
+			sum.FailedByStage[stage] = n
+		}
+	}
+	return sum
+}
+
+// addCopied counts a copy. Partial copies, e.g. with HeadBytes, are counted
+// but not added to Files, which only lists complete copies.
+func (s *Stats) addCopied(r FileRecord, partial bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.Copied++
+	s.s.BytesRead += r.Size
+	s.s.BytesWritten += r.BytesWritten
+	if r.Gzipped {
+		s.s.Gzipped++
+		s.s.GzipBytesIn += r.Size
+		s.s.GzipBytesOut += r.CompressedSize
+	}
+	if !partial {
+		s.s.Files = append(s.s.Files, r)
+	}
+}
+
+func (s *Stats) addSkipped(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.Skipped += n
+}
+
+func (s *Stats) addFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.Failed++
+	var te *TransferError
+	if errors.As(err, &te) {
+		if s.s.FailedByStage == nil {
+			s.s.FailedByStage = make(map[string]int)
+		}
+		s.s.FailedByStage[te.Stage.String()]++
+	}
+}
+
+func (s *Stats) addEventDropped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.EventsDropped++
+}
+
+func (s *Stats) addDeleted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.Deleted++
+}
+
+// Active returns copies in progress, sorted by path. Progress is only
+// tracked if Transfer.Progress is set.
+func (s *Stats) Active() []FileProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := make([]FileProgress, 0, len(s.active))
+	for _, p := range s.active {
+		active = append(active, p)
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Path < active[j].Path })
+	return active
+}
+
+// Remaining returns the number of files selected for copying so far whose
+// copy hasn't finished. Files are selected one source directory at a time,
+// so this grows as directories are listed.
+func (s *Stats) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.selected - s.finished
+}
+
+func (s *Stats) addSelected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selected++
+}
+
+func (s *Stats) setProgress(path string, copied, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		s.active = make(map[string]FileProgress)
+	}
+	s.active[path] = FileProgress{Path: path, BytesCopied: copied, TotalBytes: total}
+}
+
+// endCopy records that the copy of path is no longer in progress
+func (s *Stats) endCopy(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, path)
+}
+
+// addFinished records that the copy of a selected file finished, whether it
+// succeeded, failed, was skipped, or was cancelled
+func (s *Stats) addFinished() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished++
+}
@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// SymlinkEvaluator is implemented by Fs backends which can resolve symbolic
+// links
+type SymlinkEvaluator interface {
+	// EvalSymlinks returns path after evaluating symbolic links, like
+	// filepath.EvalSymlinks. Only links in the last element of path need
+	// to be resolved.
+	EvalSymlinks(path string) (string, error)
+}
+
+// EvalSymlinks returns path after evaluating any symbolic links
+func (l Localfs) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+// linkedDirs returns paths in source root matching the parent of dirPattern
+// which don't match its last element themselves but are symlinks to
+// directories whose names do, e.g. a "current" symlink to the active
+// day-of-year directory, for FollowSymlinks. Each is recorded so dstDir uses
+// the directory's real name.
+func (t *Transfer) linkedDirs(root string, dirPattern string) ([]string, error) {
+	evaluator, ok := t.Srcfs.(SymlinkEvaluator)
+	if !ok {
+		t.symlinkOnce.Do(func() {
+			t.logger().Error("warning: source can't resolve symlinks, not following them")
+		})
+		return nil, nil
+	}
+	parent, last := filepath.Split(filepath.Clean(dirPattern))
+	entries, err := t.glob("source", t.Srcfs, filepath.Join(root, parent, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("could not match source symlinks with %v: %w", dirPattern, err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(last, filepath.Base(entry)); ok {
+			continue // already matched
+		}
+		target, err := evaluator.EvalSymlinks(entry)
+		if err != nil {
+			t.logger().Debug("could not resolve source path", "path", entry, "error", err)
+			continue
+		}
+		if ok, _ := filepath.Match(last, filepath.Base(target)); !ok {
+			continue
+		}
+		if info, err := t.Srcfs.Stat(entry); err != nil || !info.IsDir() {
+			continue
+		}
+		t.symlinkMu.Lock()
+		if t.symlinkDirs == nil {
+			t.symlinkDirs = make(map[string]string)
+		}
+		t.symlinkDirs[entry] = filepath.Join(filepath.Dir(entry), filepath.Base(target))
+		t.symlinkMu.Unlock()
+		t.logger().Debug("following symlinked source directory", "path", entry, "target", target)
+		dirs = append(dirs, entry)
+	}
+	return dirs, nil
+}
+
+// realDir returns source directory dir, or for a symlink found by linkedDirs
+// the same path with the symlink's name replaced by its target's name
+func (t *Transfer) realDir(dir string) string {
+	t.symlinkMu.Lock()
+	defer t.symlinkMu.Unlock()
+	if real, ok := t.symlinkDirs[filepath.Clean(dir)]; ok {
+		return real
+	}
+	return dir
+}
@@ -3,12 +3,15 @@ module github.com/armbrustlab/seaflow-transfer
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/klauspost/compress v1.13.1
+	github.com/klauspost/pgzip v1.2.5
 	github.com/pkg/sftp v1.13.0
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
 	golang.org/x/sys v0.0.0-20210316164454-77fc1eacc6aa // indirect
 	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )